@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/antonholmquist/jason"
 	"github.com/gorilla/http"
@@ -13,11 +15,14 @@ const (
 	GET_SCOPES_PATH   = "/admin/scopes"
 	GET_FEATURES_PATH = "/admin/features"
 	SET_FEATURE_PATH  = "/admin/features/"
+	COPY_SCOPE_PATH   = "/admin/scopes/%s/copy"
+	SCHEDULES_PATH    = "/admin/schedules"
 )
 
 type IFlippyHttpClient interface {
 	GetJson(string) (*jason.Object, error)
 	PostJson(string, []byte) (*jason.Object, error)
+	Delete(string) error
 }
 
 type FlippyHttpClient struct{}
@@ -35,10 +40,10 @@ func (fhc FlippyHttpClient) GetJson(reqUrl string) (*jason.Object, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer rc.Close()
 	if rs.Code >= 400 {
-		return nil, fmt.Errorf("%s", rs.String())
+		return nil, decodeAPIError(rs.Code, rc)
 	}
-	defer rc.Close()
 	return jason.NewObjectFromReader(rc)
 }
 
@@ -51,13 +56,25 @@ func (fhc FlippyHttpClient) PostJson(reqUrl string, postBody []byte) (*jason.Obj
 	if err != nil {
 		return nil, err
 	}
+	defer rc.Close()
 	if rs.Code >= 400 {
-		return nil, fmt.Errorf("%s", rs.String())
+		return nil, decodeAPIError(rs.Code, rc)
 	}
-	defer rc.Close()
 	return jason.NewObjectFromReader(rc)
 }
 
+func (fhc FlippyHttpClient) Delete(reqUrl string) error {
+	rs, _, rc, err := http.DefaultClient.Do("DELETE", reqUrl, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if rs.Code >= 400 {
+		return decodeAPIError(rs.Code, rc)
+	}
+	return nil
+}
+
 func NewFlippyClient(flipadelphiaUrl string) FlippyClient {
 	if !strings.HasPrefix(flipadelphiaUrl, "http://") && !strings.HasPrefix(flipadelphiaUrl, "https://") {
 		flipadelphiaUrl = "http://" + flipadelphiaUrl
@@ -108,3 +125,36 @@ func (fc FlippyClient) SetFeature(scope, key, value string) (*jason.Object, erro
 	body := fmt.Sprintf(`{"scope": "%v", "value": "%v"}`, scope, value)
 	return fc.postJson(SET_FEATURE_PATH+key, []byte(body))
 }
+
+func (fc FlippyClient) CopyScope(source, target string, overwrite bool, features []string) (*jason.Object, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"target":    target,
+		"overwrite": overwrite,
+		"features":  features,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fc.postJson(fmt.Sprintf(COPY_SCOPE_PATH, source), body)
+}
+
+func (fc FlippyClient) GetSchedules() (*jason.Object, error) {
+	return fc.getJson(SCHEDULES_PATH)
+}
+
+func (fc FlippyClient) AddSchedule(scope, key, value string, runAt time.Time) (*jason.Object, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"scope":   scope,
+		"feature": key,
+		"value":   value,
+		"run_at":  runAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fc.postJson(SCHEDULES_PATH, body)
+}
+
+func (fc FlippyClient) DeleteSchedule(id string) error {
+	return fc.httpClient.Delete(fc.getUrl(SCHEDULES_PATH + "/" + id))
+}
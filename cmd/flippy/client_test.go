@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/antonholmquist/jason"
 )
@@ -13,6 +14,7 @@ import (
 type MockFlippyHttpClient struct {
 	OnGetJson  func(string) (*jason.Object, error)
 	OnPostJson func(string, []byte) (*jason.Object, error)
+	OnDelete   func(string) error
 }
 
 func (mClient MockFlippyHttpClient) GetJson(reqUrl string) (*jason.Object, error) {
@@ -23,6 +25,10 @@ func (mClient MockFlippyHttpClient) PostJson(reqUrl string, postBody []byte) (*j
 	return mClient.OnPostJson(reqUrl, postBody)
 }
 
+func (mClient MockFlippyHttpClient) Delete(reqUrl string) error {
+	return mClient.OnDelete(reqUrl)
+}
+
 func assertEqual(actual, target string, t *testing.T) {
 	if actual != target {
 		t.Logf("Target: %s", target)
@@ -214,3 +220,91 @@ func TestFlippyClient_SetFeature(t *testing.T) {
 		assertEqual(target[k], vs, t)
 	}
 }
+
+func TestFlippyClient_CopyScope(t *testing.T) {
+	client := FlippyClient{
+		flipadelphiaUrl: "localhost:3006",
+		httpClient: MockFlippyHttpClient{
+			OnPostJson: func(reqUrl string, postBody []byte) (*jason.Object, error) {
+				assertEqual(reqUrl, "localhost:3006/admin/scopes/template/copy", t)
+				assertEqual(string(postBody), `{"features":["feature1"],"overwrite":true,"target":"venue-1"}`, t)
+				jsonBody := []byte(`{"data": [
+					{"name": "feature1", "value": "on", "data": "true"}
+				]}`)
+				return jason.NewObjectFromBytes(jsonBody)
+			},
+		},
+	}
+	copied, err := client.CopyScope("template", "venue-1", true, []string{"feature1"})
+	assertNil(err, t)
+	features, _ := copied.GetObjectArray("data")
+	if len(features) != 1 {
+		t.Fatalf("Expected 1 copied feature, got %d", len(features))
+	}
+	name, _ := features[0].GetString("name")
+	assertEqual(name, "feature1", t)
+}
+
+func TestFlippyClient_AddSchedule(t *testing.T) {
+	client := FlippyClient{
+		flipadelphiaUrl: "localhost:3006",
+		httpClient: MockFlippyHttpClient{
+			OnPostJson: func(reqUrl string, postBody []byte) (*jason.Object, error) {
+				assertEqual(reqUrl, "localhost:3006/admin/schedules", t)
+				assertEqual(string(postBody), `{"feature":"launch","run_at":"2017-06-01T09:00:00Z","scope":"venue-1","value":"on"}`, t)
+				return jason.NewObjectFromBytes([]byte(`{"data": {"id": "1"}}`))
+			},
+		},
+	}
+	data, err := client.AddSchedule("venue-1", "launch", "on", time.Date(2017, 6, 1, 9, 0, 0, 0, time.UTC))
+	assertNil(err, t)
+	id, _ := data.GetString("data", "id")
+	assertEqual(id, "1", t)
+}
+
+func TestFlippyClient_DeleteSchedule(t *testing.T) {
+	client := FlippyClient{
+		flipadelphiaUrl: "localhost:3006",
+		httpClient: MockFlippyHttpClient{
+			OnDelete: func(reqUrl string) error {
+				assertEqual(reqUrl, "localhost:3006/admin/schedules/1", t)
+				return nil
+			},
+		},
+	}
+	assertNil(client.DeleteSchedule("1"), t)
+}
+
+func TestDecodeAPIError(t *testing.T) {
+	err := decodeAPIError(404, strings.NewReader(`{"error": {"code": "not_found", "message": "scope not found", "request_id": "abc"}}`))
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("Expected an *APIError, got %T", err)
+	}
+	assertEqual(fmt.Sprint(apiErr.StatusCode), "404", t)
+	assertEqual(apiErr.Code, ErrCodeNotFound, t)
+	assertEqual(apiErr.Message, "scope not found", t)
+	assertEqual(apiErr.RequestID, "abc", t)
+	assertEqual(err.Error(), "flipadelphia: 404 not_found: scope not found (request id abc)", t)
+	if !IsNotFound(err) || IsConflict(err) || IsInvalid(err) || IsTemporary(err) {
+		t.Errorf("Expected %s to only be a not found error", err)
+	}
+
+	err = decodeAPIError(422, strings.NewReader(`{"error": {"code": "invalid_entity", "message": "Invalid ttl: -1"}}`))
+	if !IsInvalid(err) {
+		t.Errorf("Expected %s to be an invalid request error", err)
+	}
+	err = decodeAPIError(503, strings.NewReader(`{"error": {"code": "unavailable", "message": "persistence store unavailable"}}`))
+	if !IsTemporary(err) {
+		t.Errorf("Expected %s to be a temporary error", err)
+	}
+
+	err = decodeAPIError(502, strings.NewReader("Bad Gateway\n"))
+	assertEqual(err.Error(), "flipadelphia: 502: Bad Gateway", t)
+	if IsNotFound(err) || IsTemporary(err) {
+		t.Errorf("Expected %s to have no code", err)
+	}
+	if IsNotFound(fmt.Errorf("not found")) {
+		t.Error("Expected an error that is not an *APIError to have no code")
+	}
+}
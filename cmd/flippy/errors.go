@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// The codes of Flipadelphia's error responses.
+const (
+	ErrCodeInvalidQuery   = "invalid_query"
+	ErrCodeMalformedJSON  = "malformed_json"
+	ErrCodeInvalidEntity  = "invalid_entity"
+	ErrCodeInvalidRequest = "invalid_request"
+	ErrCodeNotFound       = "not_found"
+	ErrCodeConflict       = "conflict"
+	ErrCodeForbidden      = "forbidden"
+	ErrCodeRateLimited    = "rate_limited"
+	ErrCodeUnavailable    = "unavailable"
+	ErrCodeCanceled       = "canceled"
+	ErrCodeUnimplemented  = "unimplemented"
+	ErrCodeInternal       = "internal_error"
+)
+
+// APIError is an error response from Flipadelphia.
+type APIError struct {
+	StatusCode int
+	// Code is one of the ErrCode constants, or empty if the response was not an error response from
+	// Flipadelphia, such as one from a proxy in front of it.
+	Code    string
+	Message string
+	// RequestID finds the request in Flipadelphia's logs.
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("flipadelphia: %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request id %s)", e.RequestID)
+	}
+	return msg
+}
+
+// hasCode reports whether err is an APIError with one of the codes.
+func hasCode(err error, codes ...string) bool {
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		return false
+	}
+	for _, code := range codes {
+		if apiErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound reports whether err says the scope, feature or other thing asked for does not exist.
+func IsNotFound(err error) bool {
+	return hasCode(err, ErrCodeNotFound)
+}
+
+// IsConflict reports whether err says the change would leave Flipadelphia inconsistent, such as a
+// prerequisite cycle.
+func IsConflict(err error) bool {
+	return hasCode(err, ErrCodeConflict)
+}
+
+// IsInvalid reports whether err says the request was malformed, and will fail again as it is.
+func IsInvalid(err error) bool {
+	return hasCode(err, ErrCodeInvalidQuery, ErrCodeMalformedJSON, ErrCodeInvalidEntity, ErrCodeInvalidRequest)
+}
+
+// IsTemporary reports whether err says the request may succeed if retried later.
+func IsTemporary(err error) bool {
+	return hasCode(err, ErrCodeUnavailable, ErrCodeRateLimited)
+}
+
+// decodeAPIError reads the error response with the status code. A body that is not an error response
+// from Flipadelphia becomes the message as it is.
+func decodeAPIError(statusCode int, body io.Reader) error {
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return &APIError{StatusCode: statusCode, Message: err.Error()}
+	}
+	var envelope struct {
+		Error *struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(raw, &envelope) != nil || envelope.Error == nil {
+		return &APIError{StatusCode: statusCode, Message: strings.TrimSpace(string(raw))}
+	}
+	return &APIError{
+		StatusCode: statusCode,
+		Code:       envelope.Error.Code,
+		Message:    envelope.Error.Message,
+		RequestID:  envelope.Error.RequestID,
+	}
+}
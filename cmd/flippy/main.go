@@ -3,8 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
-	"github.com/samdfonseca/flipadelphia/utils"
 	"github.com/urfave/cli"
 )
 
@@ -37,9 +37,12 @@ func main() {
 			Usage:   "Fetch all the existing scopes",
 			Action: func(c *cli.Context) error {
 				client := NewFlippyClient(c.GlobalString("url"))
-				data, _ := client.GetScopes()
+				data, err := client.GetScopes()
+				if err != nil {
+					return err
+				}
 				scopes, _ := data.GetStringArray("data")
-				utils.Output("get-scopes", "flippy")
+				fmt.Println("flippy: get-scopes")
 				for _, v := range scopes {
 					fmt.Println(v)
 				}
@@ -52,9 +55,12 @@ func main() {
 			Usage:   "Fetch all the existing features",
 			Action: func(c *cli.Context) error {
 				client := NewFlippyClient(c.GlobalString("url"))
-				data, _ := client.GetFeatures()
+				data, err := client.GetFeatures()
+				if err != nil {
+					return err
+				}
 				features, _ := data.GetStringArray("data")
-				utils.Output("get-features", "flippy")
+				fmt.Println("flippy: get-features")
 				for _, v := range features {
 					fmt.Println(v)
 				}
@@ -76,11 +82,101 @@ func main() {
 					return err
 				}
 				feature, _ := data.GetObject("data")
-				utils.Output("set-feature", "flippy")
+				fmt.Println("flippy: set-feature")
 				fmt.Printf("%s\n", feature)
 				return nil
 			},
 		},
+		{
+			Name:      "copy-scope",
+			Aliases:   []string{"cs"},
+			Usage:     "Copy the features set on one scope to another",
+			ArgsUsage: "<source> <target>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "overwrite",
+					Usage: "Overwrite features already set on the target scope",
+				},
+				cli.StringSliceFlag{
+					Name:  "feature",
+					Usage: "Only copy this feature. May be repeated.",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				client := NewFlippyClient(c.GlobalString("url"))
+				if len(c.Args()) != 2 {
+					return fmt.Errorf("Wrong number of args. See usage.")
+				}
+				data, err := client.CopyScope(c.Args().Get(0), c.Args().Get(1), c.Bool("overwrite"), c.StringSlice("feature"))
+				if err != nil {
+					return err
+				}
+				features, _ := data.GetObjectArray("data")
+				fmt.Println("flippy: copy-scope")
+				for _, feature := range features {
+					fmt.Printf("%s\n", feature)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "schedule",
+			Usage: "Manage scheduled feature changes",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "Fetch the pending scheduled changes",
+					Action: func(c *cli.Context) error {
+						client := NewFlippyClient(c.GlobalString("url"))
+						data, err := client.GetSchedules()
+						if err != nil {
+							return err
+						}
+						changes, _ := data.GetObjectArray("data")
+						fmt.Println("flippy: schedule list")
+						for _, change := range changes {
+							fmt.Printf("%s\n", change)
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "add",
+					Usage:     "Schedule a feature's value to be set on the scope at the given time",
+					ArgsUsage: "<key> <scope> <value> <run-at, RFC 3339>",
+					Action: func(c *cli.Context) error {
+						client := NewFlippyClient(c.GlobalString("url"))
+						if len(c.Args()) != 4 {
+							return fmt.Errorf("Wrong number of args. See usage.")
+						}
+						runAt, err := time.Parse(time.RFC3339, c.Args().Get(3))
+						if err != nil {
+							return err
+						}
+						data, err := client.AddSchedule(c.Args().Get(1), c.Args().Get(0), c.Args().Get(2), runAt)
+						if err != nil {
+							return err
+						}
+						change, _ := data.GetObject("data")
+						fmt.Println("flippy: schedule add")
+						fmt.Printf("%s\n", change)
+						return nil
+					},
+				},
+				{
+					Name:      "delete",
+					Usage:     "Cancel a pending scheduled change",
+					ArgsUsage: "<id>",
+					Action: func(c *cli.Context) error {
+						client := NewFlippyClient(c.GlobalString("url"))
+						if len(c.Args()) != 1 {
+							return fmt.Errorf("Wrong number of args. See usage.")
+						}
+						return client.DeleteSchedule(c.Args().Get(0))
+					},
+				},
+			},
+		},
 	}
 
 	app.Run(os.Args)
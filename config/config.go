@@ -12,52 +12,117 @@ import (
 )
 
 type FlipadelphiaConfig struct {
-	EnvironmentName      string
+	EnvironmentName      string `json:"-"`
 	PersistenceStoreType string `json:"persistence_store_type"`
 	DBFile               string `json:"db_file"`
 	RedisHost            string `json:"redis_host"`
-	RedisPassword        string `json:"redis_password"`
+	RedisPassword        string `json:"redis_password" secret:"true"`
 	RedisDB              int    `json:"redis_db"`
-	LogFile              string `json:"log_file"`
+	LogFile              string `json:"log_file" reload:"true"`
+	LogFormat            string `json:"log_format" reload:"true"`
+	LogLevel             string `json:"log_level" reload:"true"`
+	LogMaxSize           int    `json:"log_max_size" reload:"true"`
+	LogMaxBackups        int    `json:"log_max_backups" reload:"true"`
+	LogMaxAge            int    `json:"log_max_age" reload:"true"`
 	ListenOnPort         int    `json:"port"`
+	// TLSCertFile and TLSKeyFile serve HTTPS when set. With TLSClientCAFile also set, /admin routes
+	// require a client certificate signed by one of its CAs.
+	TLSCertFile         string `json:"tls_cert_file"`
+	TLSKeyFile          string `json:"tls_key_file"`
+	TLSClientCAFile     string `json:"tls_client_ca_file"`
+	ReadTimeout         int    `json:"read_timeout"`
+	WriteTimeout        int    `json:"write_timeout"`
+	IdleTimeout         int    `json:"idle_timeout"`
+	ShutdownTimeout     int    `json:"shutdown_timeout"`
+	ScheduleInterval    int    `json:"schedule_interval"`
+	ExpirySweepInterval int    `json:"expiry_sweep_interval"`
+	// ImpressionsSink is "ndjson", "http" or "redis", or empty to not record impressions.
+	// ImpressionsTarget is the file, url or stream the sink writes to.
+	ImpressionsSink          string `json:"impressions_sink"`
+	ImpressionsTarget        string `json:"impressions_target"`
+	ImpressionsBufferSize    int    `json:"impressions_buffer_size"`
+	ImpressionsBatchSize     int    `json:"impressions_batch_size"`
+	ImpressionsFlushInterval int    `json:"impressions_flush_interval"`
+	// UsageSampleRate counts one in every UsageSampleRate feature checks.
+	UsageSampleRate    int `json:"usage_sample_rate"`
+	UsageFlushInterval int `json:"usage_flush_interval"`
+	// ConfigWatchInterval is how often, in seconds, the config file is checked for changes to reload.
+	ConfigWatchInterval int `json:"config_watch_interval"`
+	// The CORS policies of the public check routes and of the /admin routes. Origins and headers are
+	// comma separated lists, see SplitList, in which "*" stands for anything.
+	CORSPublicAllowedOrigins   string `json:"cors_public_allowed_origins" reload:"true"`
+	CORSPublicAllowedHeaders   string `json:"cors_public_allowed_headers" reload:"true"`
+	CORSPublicAllowCredentials bool   `json:"cors_public_allow_credentials" reload:"true"`
+	CORSPublicMaxAge           int    `json:"cors_public_max_age" reload:"true"`
+	CORSAdminAllowedOrigins    string `json:"cors_admin_allowed_origins" reload:"true"`
+	CORSAdminAllowedHeaders    string `json:"cors_admin_allowed_headers" reload:"true"`
+	CORSAdminAllowCredentials  bool   `json:"cors_admin_allow_credentials" reload:"true"`
+	CORSAdminMaxAge            int    `json:"cors_admin_max_age" reload:"true"`
+	// RateLimitStore is "memory" to limit requests on each instance, or "redis" to share the limits
+	// through redis_host. The rate limits of the public check routes and of the /admin routes allow
+	// per_minute requests a minute, in bursts of up to burst, from each client told apart by key: "ip",
+	// "api_key" or "header:<name>". A per_minute of 0 does not limit requests.
+	RateLimitStore           string `json:"rate_limit_store"`
+	RateLimitPublicPerMinute int    `json:"rate_limit_public_per_minute" reload:"true"`
+	RateLimitPublicBurst     int    `json:"rate_limit_public_burst" reload:"true"`
+	RateLimitPublicKey       string `json:"rate_limit_public_key" reload:"true"`
+	RateLimitAdminPerMinute  int    `json:"rate_limit_admin_per_minute" reload:"true"`
+	RateLimitAdminBurst      int    `json:"rate_limit_admin_burst" reload:"true"`
+	RateLimitAdminKey        string `json:"rate_limit_admin_key" reload:"true"`
+}
+
+// SplitList splits a comma separated setting into its trimmed, non-empty items.
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 var Config FlipadelphiaConfig
 
-func getStoredFilePath(fileName string) string {
+func getStoredFilePath(fileName string) (string, error) {
 	homeDir := os.Getenv("HOME")
 	if homeDir == "" {
-		err := fmt.Errorf("")
-		utils.FailOnError(err, "$HOME not set", false)
+		return "", fmt.Errorf("$HOME not set, so %q can not be found in $HOME/.flipadelphia", fileName)
 	}
-	return fmt.Sprintf("%s/.flipadelphia/%s", homeDir, fileName)
+	return fmt.Sprintf("%s/.flipadelphia/%s", homeDir, fileName), nil
 }
 
-func getFullFilePath(filePath string) string {
+func getFullFilePath(filePath string) (string, error) {
 	if filePath == "" {
-		return ""
+		return "", nil
 	}
 	if path.IsAbs(filePath) {
-		return filePath
+		return filePath, nil
 	}
 	if strings.HasPrefix(filePath, "./") {
-		cwd, _ := os.Getwd()
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("Unable to find the working directory: %s", err)
+		}
 		fullFilePath := fmt.Sprintf("%s/%s", path.Clean(cwd), path.Clean(filePath))
-		return fullFilePath
+		return fullFilePath, nil
 	}
 	return getStoredFilePath(filePath)
 }
 
-func readConfigFile(configFilePath string) []byte {
+func readConfigFile(configFilePath string) ([]byte, error) {
 	configData, err := ioutil.ReadFile(configFilePath)
-	utils.FailOnError(err, "Unable to read config file", true)
-	return configData
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read config file: %s", err)
+	}
+	return configData, nil
 }
 
-func parseConfigFile(rawConfigData []byte) (parsedConfig map[string]FlipadelphiaConfig) {
-	err := json.Unmarshal(rawConfigData, &parsedConfig)
-	utils.FailOnError(err, "Unable to parse config file", true)
-	return parsedConfig
+func parseConfigFile(rawConfigData []byte) (parsedConfig map[string]FlipadelphiaConfig, err error) {
+	if err := json.Unmarshal(rawConfigData, &parsedConfig); err != nil {
+		return nil, fmt.Errorf("Unable to parse config file: %s", err)
+	}
+	return parsedConfig, nil
 }
 
 // Gets the config for a named env from a flipadelphia config file.
@@ -71,20 +136,45 @@ func parseConfigFile(rawConfigData []byte) (parsedConfig map[string]Flipadelphia
 //   /etc/flipadelphia/config.json -> /etc/flipadelphia/config.json
 //   ./flipadelphia_config.json -> $PWD/flipadelphia_config.json
 //   config.json -> $HOME/.flipadelphia/config.json
-func getRuntimeEnv(configFilePath string, envName string) FlipadelphiaConfig {
-	fullConfigFilePath := getFullFilePath(configFilePath)
-	configData := parseConfigFile(readConfigFile(fullConfigFilePath))
+func getRuntimeEnv(configFilePath string, envName string) (FlipadelphiaConfig, error) {
+	var runtimeEnv FlipadelphiaConfig
+	fullConfigFilePath, err := getFullFilePath(configFilePath)
+	if err != nil {
+		return runtimeEnv, err
+	}
+	rawConfigData, err := readConfigFile(fullConfigFilePath)
+	if err != nil {
+		return runtimeEnv, err
+	}
+	if rawConfigData, err = decodeConfigFile(fullConfigFilePath, rawConfigData); err != nil {
+		return runtimeEnv, err
+	}
+	configData, err := parseConfigFile(rawConfigData)
+	if err != nil {
+		return runtimeEnv, err
+	}
 	runtimeEnv, envExists := configData[envName]
 	if !envExists {
-		utils.FailOnError(fmt.Errorf(""),
-			fmt.Sprintf("Runtime environment %q not found in %q", envName, configFilePath), false)
+		return runtimeEnv, fmt.Errorf("Runtime environment %q not found in %q", envName, configFilePath)
 	}
 	runtimeEnv.EnvironmentName = envName
-	runtimeEnv.DBFile = getFullFilePath(runtimeEnv.DBFile)
-	runtimeEnv.LogFile = getFullFilePath(runtimeEnv.LogFile)
-	return runtimeEnv
+	return runtimeEnv, runtimeEnv.resolveFilePaths()
 }
 
-func NewFlipadelphiaConfig(configFilePath, envName string) FlipadelphiaConfig {
-	return getRuntimeEnv(configFilePath, envName)
+// NewFlipadelphiaConfig reads the named runtime environment from the config file, overrides it with
+// the FLIPADELPHIA_* environment variables, and validates it.
+func NewFlipadelphiaConfig(configFilePath, envName string) (FlipadelphiaConfig, error) {
+	return Load(Sources{File: configFilePath, EnvName: envName, Environ: os.Environ()})
+}
+
+// LogOptions returns the logging settings of the config.
+func (c FlipadelphiaConfig) LogOptions() utils.LogOptions {
+	return utils.LogOptions{
+		File:       c.LogFile,
+		Format:     c.LogFormat,
+		Level:      c.LogLevel,
+		MaxSize:    c.LogMaxSize,
+		MaxBackups: c.LogMaxBackups,
+		MaxAge:     c.LogMaxAge,
+	}
 }
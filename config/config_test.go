@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -17,14 +18,20 @@ func checkResult(actual, target interface{}, t *testing.T) {
 
 func TestGetFullFilePathAbsolutePath(t *testing.T) {
 	target := `/tmp/config.json`
-	actual := getFullFilePath(target)
+	actual, err := getFullFilePath(target)
+	if err != nil {
+		t.Fatal(err)
+	}
 	checkResult(actual, target, t)
 }
 
 func TestGetFullFilePathRelativePath(t *testing.T) {
 	cwd, _ := os.Getwd()
 	target := fmt.Sprintf("%s/config.json", cwd)
-	actual := getFullFilePath("./config.json")
+	actual, err := getFullFilePath("./config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
 	checkResult(actual, target, t)
 }
 
@@ -38,7 +45,10 @@ func TestGetFullFilePathStoredFilePath(t *testing.T) {
 		defer os.Setenv("HOME", "")
 	}
 	target := fmt.Sprintf("%s/.flipadelphia/config.json", homeDir)
-	actual := getFullFilePath("config.json")
+	actual, err := getFullFilePath("config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
 	checkResult(actual, target, t)
 }
 
@@ -55,14 +65,17 @@ func TestReadFileContent(t *testing.T) {
 	if err := tmpfile.Close(); err != nil {
 		t.Fatal(err)
 	}
-	actual := readConfigFile(tmpfile.Name())
+	actual, err := readConfigFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
 	checkResult(string(actual), string(content), t)
 }
 
 func TestParseConfigFile(t *testing.T) {
 	targetPersistenceStoreType := "bolt"
-	targetDBFile := getFullFilePath("test.db")
-	targetLogFile := getFullFilePath("test.log")
+	targetDBFile, _ := getFullFilePath("test.db")
+	targetLogFile, _ := getFullFilePath("test.log")
 	targetRedisHost := "localhost:6379"
 	targetRedisPassword := "password"
 	targetRedisDB := 0
@@ -82,7 +95,10 @@ func TestParseConfigFile(t *testing.T) {
 		targetRedisPassword,
 		targetRedisDB,
 		targetPort))
-	parsedContent := parseConfigFile(content)
+	parsedContent, err := parseConfigFile(content)
+	if err != nil {
+		t.Fatal(err)
+	}
 	configData := parsedContent["test"]
 	checkResult(configData.PersistenceStoreType, targetPersistenceStoreType, t)
 	checkResult(configData.DBFile, targetDBFile, t)
@@ -95,8 +111,8 @@ func TestParseConfigFile(t *testing.T) {
 
 func TestParseConfigFileWithoutRedis(t *testing.T) {
 	targetPersistenceStoreType := "bolt"
-	targetDBFile := getFullFilePath("test.db")
-	targetLogFile := getFullFilePath("test.log")
+	targetDBFile, _ := getFullFilePath("test.db")
+	targetLogFile, _ := getFullFilePath("test.log")
 	targetPort := 3006
 	content := []byte(fmt.Sprintf(`{"test": {
 	"persistence_store_type": %q,
@@ -107,7 +123,10 @@ func TestParseConfigFileWithoutRedis(t *testing.T) {
 		targetDBFile,
 		targetLogFile,
 		targetPort))
-	parsedContent := parseConfigFile(content)
+	parsedContent, err := parseConfigFile(content)
+	if err != nil {
+		t.Fatal(err)
+	}
 	configData := parsedContent["test"]
 	checkResult(configData.PersistenceStoreType, targetPersistenceStoreType, t)
 	checkResult(configData.DBFile, targetDBFile, t)
@@ -120,7 +139,7 @@ func TestParseConfigFileWithoutRedis(t *testing.T) {
 
 func TestParseConfigFileWithoutDBFile(t *testing.T) {
 	targetPersistenceStoreType := "bolt"
-	targetLogFile := getFullFilePath("test.log")
+	targetLogFile, _ := getFullFilePath("test.log")
 	targetRedisHost := "localhost:6379"
 	targetRedisPassword := "password"
 	targetRedisDB := 0
@@ -138,7 +157,10 @@ func TestParseConfigFileWithoutDBFile(t *testing.T) {
 		targetRedisPassword,
 		targetRedisDB,
 		targetPort))
-	parsedContent := parseConfigFile(content)
+	parsedContent, err := parseConfigFile(content)
+	if err != nil {
+		t.Fatal(err)
+	}
 	configData := parsedContent["test"]
 	checkResult(configData.PersistenceStoreType, targetPersistenceStoreType, t)
 	checkResult(configData.DBFile, "", t)
@@ -151,8 +173,8 @@ func TestParseConfigFileWithoutDBFile(t *testing.T) {
 
 func TestGetRuntimeEnv(t *testing.T) {
 	targetPersistenceStoreType := "bolt"
-	targetDBFile := getFullFilePath("test.db")
-	targetLogFile := getFullFilePath("test.log")
+	targetDBFile, _ := getFullFilePath("test.db")
+	targetLogFile, _ := getFullFilePath("test.log")
 	targetRedisHost := "localhost:6379"
 	targetRedisPassword := "password"
 	targetRedisDB := 0
@@ -183,7 +205,10 @@ func TestGetRuntimeEnv(t *testing.T) {
 	if err := tmpfile.Close(); err != nil {
 		t.Fatal(err)
 	}
-	configData := getRuntimeEnv(tmpfile.Name(), "test")
+	configData, err := getRuntimeEnv(tmpfile.Name(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
 	checkResult(configData.PersistenceStoreType, targetPersistenceStoreType, t)
 	checkResult(configData.DBFile, targetDBFile, t)
 	checkResult(configData.LogFile, targetLogFile, t)
@@ -192,3 +217,108 @@ func TestGetRuntimeEnv(t *testing.T) {
 	checkResult(configData.RedisDB, targetRedisDB, t)
 	checkResult(configData.ListenOnPort, targetPort, t)
 }
+
+func writeConfigFile(content string, t *testing.T) string {
+	tmpfile, err := ioutil.TempFile("", "flipadelphia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tmpfile.Name()
+}
+
+func TestGetRuntimeEnvErrors(t *testing.T) {
+	configFile := writeConfigFile(`{"test": {"persistence_store_type": "bolt"`, t)
+	defer os.Remove(configFile)
+	if _, err := getRuntimeEnv(configFile, "test"); err == nil || !strings.HasPrefix(err.Error(), "Unable to parse config file") {
+		t.Errorf("Expected a parse error, got %v", err)
+	}
+
+	configFile = writeConfigFile(`{"test": {}}`, t)
+	defer os.Remove(configFile)
+	if _, err := getRuntimeEnv(configFile, "missing"); err == nil || !strings.Contains(err.Error(), `"missing" not found`) {
+		t.Errorf("Expected a missing environment error, got %v", err)
+	}
+
+	if _, err := getRuntimeEnv("/nonexistent/config.json", "test"); err == nil || !strings.HasPrefix(err.Error(), "Unable to read config file") {
+		t.Errorf("Expected a read error, got %v", err)
+	}
+}
+
+func TestNewFlipadelphiaConfigValidates(t *testing.T) {
+	configFile := writeConfigFile(`{"test": {"persistence_store_type": "bolt", "db_file": "/tmp/test.db", "port": 3006}}`, t)
+	defer os.Remove(configFile)
+	c, err := NewFlipadelphiaConfig(configFile, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(c.EnvironmentName, "test", t)
+
+	configFile = writeConfigFile(`{"test": {"persistence_store_type": "bolt", "port": 0}}`, t)
+	defer os.Remove(configFile)
+	_, err = NewFlipadelphiaConfig(configFile, "test")
+	if _, ok := err.(ValidationError); !ok {
+		t.Errorf("Expected a ValidationError, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := FlipadelphiaConfig{EnvironmentName: "test", PersistenceStoreType: "bolt", DBFile: "/tmp/test.db", ListenOnPort: 3006}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*FlipadelphiaConfig)
+		problem string
+	}{
+		{"missing store type", func(c *FlipadelphiaConfig) { c.PersistenceStoreType = "" }, "persistence_store_type not set"},
+		{"unknown store type", func(c *FlipadelphiaConfig) { c.PersistenceStoreType = "mongo" }, `Unknown persistence_store_type: "mongo"`},
+		{"bolt without db file", func(c *FlipadelphiaConfig) { c.DBFile = "" }, "db_file not set"},
+		{"redis without host", func(c *FlipadelphiaConfig) { c.PersistenceStoreType = "redisv2" }, "redis_host not set"},
+		{"port out of range", func(c *FlipadelphiaConfig) { c.ListenOnPort = 70000 }, "port must be between 1 and 65535, not 70000"},
+		{"unknown log format", func(c *FlipadelphiaConfig) { c.LogFormat = "xml" }, `Unknown log format: "xml"`},
+		{"unknown log level", func(c *FlipadelphiaConfig) { c.LogLevel = "loud" }, `Unknown log level: "loud"`},
+		{"unknown impressions sink", func(c *FlipadelphiaConfig) { c.ImpressionsSink = "kafka" }, `Unknown impressions_sink: "kafka"`},
+		{"sink without target", func(c *FlipadelphiaConfig) { c.ImpressionsSink = "ndjson" }, "impressions_target not set"},
+		{"impressions stream among the scopes", func(c *FlipadelphiaConfig) {
+			c.PersistenceStoreType, c.RedisHost, c.ImpressionsSink, c.ImpressionsTarget = "redisv2", "localhost:6379", "redis", "impressions"
+		}, `impressions_target "impressions" must start with "flipadelphia:"`},
+		{"negative timeout", func(c *FlipadelphiaConfig) { c.ShutdownTimeout = -1 }, "shutdown_timeout must not be negative"},
+		{"cert without key", func(c *FlipadelphiaConfig) { c.TLSCertFile = "/tmp/cert.pem" }, "tls_cert_file and tls_key_file must be set together"},
+		{"client CA without cert", func(c *FlipadelphiaConfig) { c.TLSClientCAFile = "/tmp/ca.pem" }, "tls_client_ca_file needs tls_cert_file"},
+		{"origin without scheme", func(c *FlipadelphiaConfig) { c.CORSPublicAllowedOrigins = "https://app.example.com, example.com" }, `Invalid origin in cors_public_allowed_origins: "example.com"`},
+		{"credentials for every origin", func(c *FlipadelphiaConfig) {
+			c.CORSAdminAllowedOrigins, c.CORSAdminAllowCredentials = "*", true
+		}, "cors_admin_allow_credentials can not be used with every origin allowed"},
+		{"unknown rate limit store", func(c *FlipadelphiaConfig) { c.RateLimitStore = "memcached" }, `Unknown rate_limit_store: "memcached"`},
+		{"redis rate limits without host", func(c *FlipadelphiaConfig) { c.RateLimitStore = "redis" }, "redis_host not set, it is required by the redis rate_limit_store"},
+		{"unknown rate limit key", func(c *FlipadelphiaConfig) { c.RateLimitPublicKey = "cookie" }, `Invalid rate_limit_public_key "cookie"`},
+		{"rate limit header without name", func(c *FlipadelphiaConfig) { c.RateLimitAdminKey = "header:" }, `Invalid rate_limit_admin_key "header:"`},
+		{"negative rate limit", func(c *FlipadelphiaConfig) { c.RateLimitPublicPerMinute = -1 }, "rate_limit_public_per_minute must not be negative"},
+	}
+	for _, test := range tests {
+		c := valid
+		test.modify(&c)
+		err := c.Validate()
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("%s: expected %q in the error, got %v", test.name, test.problem, err)
+		}
+	}
+
+	invalid := FlipadelphiaConfig{EnvironmentName: "test"}
+	err := invalid.Validate()
+	validationErr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+	if len(validationErr.Problems) != 2 {
+		t.Errorf("Expected every problem to be reported, got %q", validationErr.Problems)
+	}
+}
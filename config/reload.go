@@ -0,0 +1,136 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// DefaultWatchInterval is how often the config file is checked for changes when no
+// config_watch_interval is configured.
+const DefaultWatchInterval = 10 * time.Second
+
+// Reloader holds the running config, and reloads it from the sources it was loaded from.
+//
+// Only the settings tagged reload:"true" can change while running. A reload changing any other
+// setting, such as persistence_store_type or port, is rejected as a whole, as is one that does not
+// validate, and the running config is kept.
+type Reloader struct {
+	sources  Sources
+	mu       sync.Mutex
+	current  FlipadelphiaConfig
+	appliers []func(FlipadelphiaConfig)
+}
+
+// NewReloader returns a Reloader for a config loaded from the sources.
+func NewReloader(sources Sources, current FlipadelphiaConfig) *Reloader {
+	return &Reloader{sources: sources, current: current}
+}
+
+// OnReload registers a function applying a reloaded config. Functions are called in the order they
+// were registered, and only with a config that has been validated.
+func (r *Reloader) OnReload(apply func(FlipadelphiaConfig)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appliers = append(r.appliers, apply)
+}
+
+// Current returns the running config.
+func (r *Reloader) Current() FlipadelphiaConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload loads the config from its sources again and, if only reloadable settings have changed,
+// applies it. It returns an error if the config could not be loaded, or was rejected.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next, err := Load(r.sources)
+	if err != nil {
+		return err
+	}
+	if changed := r.current.unreloadableChanges(next); len(changed) > 0 {
+		for _, key := range changed {
+			utils.Log.WithField("setting", key).Error("Unable to reload setting, restart to change it")
+		}
+		return fmt.Errorf("Config not reloaded, settings that need a restart changed: %v", changed)
+	}
+	if reflect.DeepEqual(r.current, next) {
+		return nil
+	}
+	for _, apply := range r.appliers {
+		apply(next)
+	}
+	r.current = next
+	utils.Log.WithField("env", next.EnvironmentName).Info("Reloaded config")
+	return nil
+}
+
+// unreloadableChanges returns the keys of the settings not tagged reload:"true" that differ in next.
+func (c FlipadelphiaConfig) unreloadableChanges(next FlipadelphiaConfig) []string {
+	var changed []string
+	current, updated := reflect.ValueOf(c), reflect.ValueOf(next)
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		if field.Tag.Get("reload") == "true" {
+			continue
+		}
+		if !reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			key := settingKey(field)
+			if key == "" {
+				key = field.Name
+			}
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// Watch reloads the config whenever the config file's size or modification time changes, checking
+// every interval until the context is done. Failed reloads are logged.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	configFilePath, err := getFullFilePath(r.sources.File)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to watch the config file")
+		return
+	}
+	last := fileVersion(configFilePath)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			version := fileVersion(configFilePath)
+			if version == last {
+				continue
+			}
+			last = version
+			utils.Log.WithField("config", configFilePath).Info("Config file changed, reloading")
+			if err := r.Reload(); err != nil {
+				utils.Log.WithError(err).Error("Unable to reload config")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fileVersion identifies the contents of a file by its size and modification time, or is empty if
+// the file can not be found.
+func fileVersion(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+}
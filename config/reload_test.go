@@ -0,0 +1,91 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const reloadTestConfig = `{"test": {"persistence_store_type": "bolt", "db_file": "/tmp/test.db", "port": %PORT%, "log_level": "%LEVEL%"}}`
+
+func writeReloadTestConfig(configFile, port, level string, t *testing.T) {
+	content := strings.NewReplacer("%PORT%", port, "%LEVEL%", level).Replace(reloadTestConfig)
+	if err := ioutil.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestReloader(t *testing.T) (*Reloader, string, *[]FlipadelphiaConfig) {
+	configFile := writeNamedConfigFile("config.json", "", t)
+	writeReloadTestConfig(configFile, "3006", "info", t)
+	sources := Sources{File: configFile, EnvName: "test"}
+	c, err := Load(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader := NewReloader(sources, c)
+	var applied []FlipadelphiaConfig
+	reloader.OnReload(func(c FlipadelphiaConfig) { applied = append(applied, c) })
+	return reloader, configFile, &applied
+}
+
+func TestReload(t *testing.T) {
+	reloader, configFile, applied := newTestReloader(t)
+	defer os.RemoveAll(filepath.Dir(configFile))
+
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	checkResult(len(*applied), 0, t)
+
+	writeReloadTestConfig(configFile, "3006", "debug", t)
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	checkResult(len(*applied), 1, t)
+	checkResult((*applied)[0].LogLevel, "debug", t)
+	checkResult(reloader.Current().LogLevel, "debug", t)
+}
+
+func TestReloadRejectsUnreloadableChanges(t *testing.T) {
+	reloader, configFile, applied := newTestReloader(t)
+	defer os.RemoveAll(filepath.Dir(configFile))
+
+	writeReloadTestConfig(configFile, "4000", "debug", t)
+	err := reloader.Reload()
+	if err == nil || !strings.Contains(err.Error(), "[port]") {
+		t.Errorf("Expected the port change to be rejected, got %v", err)
+	}
+	checkResult(len(*applied), 0, t)
+	checkResult(reloader.Current().LogLevel, "info", t)
+	checkResult(reloader.Current().ListenOnPort, 3006, t)
+
+	writeReloadTestConfig(configFile, "3006", "loud", t)
+	if _, ok := reloader.Reload().(ValidationError); !ok {
+		t.Error("Expected an invalid config to be rejected")
+	}
+	checkResult(len(*applied), 0, t)
+}
+
+func TestWatch(t *testing.T) {
+	reloader, configFile, _ := newTestReloader(t)
+	defer os.RemoveAll(filepath.Dir(configFile))
+	reloaded := make(chan FlipadelphiaConfig, 1)
+	reloader.OnReload(func(c FlipadelphiaConfig) { reloaded <- c })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	writeReloadTestConfig(configFile, "3006", "warning", t)
+	select {
+	case c := <-reloaded:
+		checkResult(c.LogLevel, "warning", t)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the changed config file to be reloaded")
+	}
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvVarPrefix starts the name of every environment variable overriding a setting, followed by the
+// setting's key in upper case. Ex: FLIPADELPHIA_REDIS_HOST overrides redis_host.
+const EnvVarPrefix = "FLIPADELPHIA_"
+
+// redacted replaces the value of secret settings in Redacted.
+const redacted = "[REDACTED]"
+
+// Sources are where the settings of a runtime environment are read from. Each source overrides the
+// ones before it: the config file, then the environment variables, then the flags.
+type Sources struct {
+	// File is a JSON, YAML or TOML config file, told apart by its extension: .yaml or .yml for YAML,
+	// .toml for TOML and anything else for JSON. It is found the way getRuntimeEnv finds it.
+	File string
+	// FileOptional starts from an empty config when File does not exist, rather than failing.
+	FileOptional bool
+	EnvName      string
+	// Environ is the environment, as returned by os.Environ.
+	Environ []string
+	// Flags holds setting values by key, as given on the command line.
+	Flags map[string]string
+}
+
+// Load merges the sources into a runtime environment, and validates it.
+func Load(sources Sources) (FlipadelphiaConfig, error) {
+	c, err := loadFile(sources)
+	if err != nil {
+		return c, err
+	}
+	c.EnvironmentName = sources.EnvName
+	if err := c.applyEnviron(sources.Environ); err != nil {
+		return c, err
+	}
+	for _, key := range Keys() {
+		if value, ok := sources.Flags[key]; ok {
+			if err := c.Set(key, value); err != nil {
+				return c, fmt.Errorf("--%s: %s", strings.Replace(key, "_", "-", -1), err)
+			}
+		}
+	}
+	if err := c.resolveFilePaths(); err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+func loadFile(sources Sources) (FlipadelphiaConfig, error) {
+	if sources.FileOptional {
+		fullConfigFilePath, err := getFullFilePath(sources.File)
+		if err != nil {
+			return FlipadelphiaConfig{}, err
+		}
+		if _, err := os.Stat(fullConfigFilePath); os.IsNotExist(err) {
+			return FlipadelphiaConfig{}, nil
+		}
+	}
+	return getRuntimeEnv(sources.File, sources.EnvName)
+}
+
+// applyEnviron sets every setting that has an environment variable.
+func (c *FlipadelphiaConfig) applyEnviron(environ []string) error {
+	vars := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+	for _, key := range Keys() {
+		name := EnvVarPrefix + strings.ToUpper(key)
+		if value, ok := vars[name]; ok {
+			if err := c.Set(key, value); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveFilePaths makes the paths of the db, log and TLS files absolute.
+func (c *FlipadelphiaConfig) resolveFilePaths() error {
+	for _, file := range []struct {
+		key  string
+		path *string
+	}{
+		{"db_file", &c.DBFile},
+		{"log_file", &c.LogFile},
+		{"tls_cert_file", &c.TLSCertFile},
+		{"tls_key_file", &c.TLSKeyFile},
+		{"tls_client_ca_file", &c.TLSClientCAFile},
+	} {
+		fullFilePath, err := getFullFilePath(*file.path)
+		if err != nil {
+			return fmt.Errorf("Invalid %s: %s", file.key, err)
+		}
+		*file.path = fullFilePath
+	}
+	return nil
+}
+
+// settingKey returns the key a field is set by in a config file, or "" if it can not be set.
+func settingKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("json"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// Keys returns the key of every setting, in the order they are declared.
+func Keys() []string {
+	var keys []string
+	t := reflect.TypeOf(FlipadelphiaConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := settingKey(t.Field(i)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Set parses the value into the setting with the given key.
+func (c *FlipadelphiaConfig) Set(key, value string) error {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if settingKey(v.Type().Field(i)) != key {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("Invalid %s %q, must be a whole number", key, value)
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("Invalid %s %q, must be true or false", key, value)
+			}
+			field.SetBool(b)
+		default:
+			return fmt.Errorf("%s can not be set from a string", key)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown setting: %q", key)
+}
+
+// Redacted returns a copy of the config with its secrets, the fields tagged secret:"true", replaced.
+func (c FlipadelphiaConfig) Redacted() FlipadelphiaConfig {
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if v.Type().Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String && field.String() != "" {
+			field.SetString(redacted)
+		}
+	}
+	return c
+}
+
+// decodeConfigFile converts a YAML or TOML config file to JSON, so every format is parsed by
+// parseConfigFile with the same keys. JSON is returned as is.
+func decodeConfigFile(configFilePath string, rawConfigData []byte) ([]byte, error) {
+	var parsed interface{}
+	switch strings.ToLower(filepath.Ext(configFilePath)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(rawConfigData, &parsed); err != nil {
+			return nil, fmt.Errorf("Unable to parse config file: %s", err)
+		}
+		parsed = stringKeys(parsed)
+	case ".toml":
+		var tables map[string]interface{}
+		if _, err := toml.Decode(string(rawConfigData), &tables); err != nil {
+			return nil, fmt.Errorf("Unable to parse config file: %s", err)
+		}
+		parsed = tables
+	default:
+		return rawConfigData, nil
+	}
+	return json.Marshal(parsed)
+}
+
+// stringKeys replaces the map[interface{}]interface{} maps YAML decodes to with maps json can encode.
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = stringKeys(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = stringKeys(value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeNamedConfigFile(name, content string, t *testing.T) string {
+	dir, err := ioutil.TempDir("", "flipadelphia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return configFile
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{"test": {"persistence_store_type": "redis", "redis_host": "localhost:6379", "port": 3006}}`,
+		"config.yaml": "test:\n  persistence_store_type: redis\n  redis_host: localhost:6379\n  port: 3006\n",
+		"config.yml":  "test:\n  persistence_store_type: redis\n  redis_host: localhost:6379\n  port: 3006\n",
+		"config.toml": "[test]\npersistence_store_type = \"redis\"\nredis_host = \"localhost:6379\"\nport = 3006\n",
+	}
+	for name, content := range files {
+		configFile := writeNamedConfigFile(name, content, t)
+		defer os.RemoveAll(filepath.Dir(configFile))
+		c, err := Load(Sources{File: configFile, EnvName: "test"})
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		checkResult(c.PersistenceStoreType, "redis", t)
+		checkResult(c.RedisHost, "localhost:6379", t)
+		checkResult(c.ListenOnPort, 3006, t)
+		checkResult(c.EnvironmentName, "test", t)
+	}
+
+	configFile := writeNamedConfigFile("config.yaml", "test: [", t)
+	defer os.RemoveAll(filepath.Dir(configFile))
+	if _, err := Load(Sources{File: configFile, EnvName: "test"}); err == nil || !strings.HasPrefix(err.Error(), "Unable to parse config file") {
+		t.Errorf("Expected a parse error, got %v", err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	configFile := writeNamedConfigFile("config.json",
+		`{"test": {"persistence_store_type": "redis", "redis_host": "file:6379", "redis_db": 1, "port": 3006}}`, t)
+	defer os.RemoveAll(filepath.Dir(configFile))
+	c, err := Load(Sources{
+		File:    configFile,
+		EnvName: "test",
+		Environ: []string{"FLIPADELPHIA_REDIS_HOST=env:6379", "FLIPADELPHIA_PORT=4000", "HOME=/tmp"},
+		Flags:   map[string]string{"port": "5000"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(c.RedisDB, 1, t)
+	checkResult(c.RedisHost, "env:6379", t)
+	checkResult(c.ListenOnPort, 5000, t)
+}
+
+func TestLoadOptionalFile(t *testing.T) {
+	sources := Sources{
+		File:    "/nonexistent/config.json",
+		EnvName: "bolt",
+		Environ: []string{"FLIPADELPHIA_PERSISTENCE_STORE_TYPE=bolt", "FLIPADELPHIA_DB_FILE=/tmp/test.db", "FLIPADELPHIA_PORT=3006"},
+	}
+	if _, err := Load(sources); err == nil {
+		t.Error("Expected an error for a missing config file")
+	}
+	sources.FileOptional = true
+	c, err := Load(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(c.DBFile, "/tmp/test.db", t)
+	checkResult(c.ListenOnPort, 3006, t)
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	sources := Sources{FileOptional: true, File: "/nonexistent/config.json", EnvName: "test",
+		Environ: []string{"FLIPADELPHIA_PORT=http"}}
+	if _, err := Load(sources); err == nil || err.Error() != `FLIPADELPHIA_PORT: Invalid port "http", must be a whole number` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	sources.Environ = nil
+	sources.Flags = map[string]string{"redis_db": "one"}
+	if _, err := Load(sources); err == nil || err.Error() != `--redis-db: Invalid redis_db "one", must be a whole number` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	var c FlipadelphiaConfig
+	if err := c.Set("redis_host", "localhost:6379"); err != nil {
+		t.Fatal(err)
+	}
+	checkResult(c.RedisHost, "localhost:6379", t)
+	if err := c.Set("EnvironmentName", "test"); err == nil {
+		t.Error("Expected an error setting a field without a key")
+	}
+	if err := c.Set("unknown", "value"); err == nil {
+		t.Error("Expected an error for an unknown key")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := FlipadelphiaConfig{RedisHost: "localhost:6379", RedisPassword: "secret"}
+	redactedConfig := c.Redacted()
+	checkResult(redactedConfig.RedisPassword, redacted, t)
+	checkResult(redactedConfig.RedisHost, "localhost:6379", t)
+	checkResult(c.RedisPassword, "secret", t)
+	checkResult(FlipadelphiaConfig{}.Redacted().RedisPassword, "", t)
+}
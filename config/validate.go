@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// redisKeyPrefix starts the keys the redis persistence stores keep next to the scope hashes.
+const redisKeyPrefix = "flipadelphia:"
+
+// ValidationError lists every problem found in a runtime environment.
+type ValidationError struct {
+	EnvironmentName string
+	Problems        []string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("Invalid config for runtime environment %q: %s", e.EnvironmentName,
+		strings.Join(e.Problems, "; "))
+}
+
+// Validate checks the whole config up front, so a typo is reported when the server starts rather than
+// when the setting is first used. It returns a ValidationError listing every problem found.
+func (c FlipadelphiaConfig) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch c.PersistenceStoreType {
+	case "":
+		problem("persistence_store_type not set")
+	case "bolt":
+		if c.DBFile == "" {
+			problem("db_file not set, it is required by the bolt persistence store")
+		}
+	case "redis", "redisv2":
+		if c.RedisHost == "" {
+			problem("redis_host not set, it is required by the %s persistence store", c.PersistenceStoreType)
+		}
+	default:
+		problem("Unknown persistence_store_type: %q", c.PersistenceStoreType)
+	}
+	if c.RedisDB < 0 {
+		problem("redis_db must not be negative")
+	}
+
+	if c.ListenOnPort < 1 || c.ListenOnPort > 65535 {
+		problem("port must be between 1 and 65535, not %d", c.ListenOnPort)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problem("tls_cert_file and tls_key_file must be set together")
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		problem("tls_client_ca_file needs tls_cert_file and tls_key_file to be set")
+	}
+
+	validateCORS := func(group, origins string, allowCredentials bool) {
+		for _, origin := range SplitList(origins) {
+			if origin == "*" {
+				if allowCredentials {
+					problem("cors_%s_allow_credentials can not be used with every origin allowed", group)
+				}
+				continue
+			}
+			if !strings.Contains(origin, "://") || strings.Count(origin, "*") > 1 {
+				problem("Invalid origin in cors_%s_allowed_origins: %q", group, origin)
+			}
+		}
+	}
+	validateCORS("public", c.CORSPublicAllowedOrigins, c.CORSPublicAllowCredentials)
+	validateCORS("admin", c.CORSAdminAllowedOrigins, c.CORSAdminAllowCredentials)
+
+	switch c.RateLimitStore {
+	case "", "memory":
+	case "redis":
+		if c.RedisHost == "" {
+			problem("redis_host not set, it is required by the redis rate_limit_store")
+		}
+	default:
+		problem("Unknown rate_limit_store: %q", c.RateLimitStore)
+	}
+	for _, limit := range []struct{ group, key string }{
+		{"public", c.RateLimitPublicKey},
+		{"admin", c.RateLimitAdminKey},
+	} {
+		switch {
+		case limit.key == "", limit.key == "ip", limit.key == "api_key":
+		case strings.HasPrefix(limit.key, "header:") && limit.key != "header:":
+		default:
+			problem("Invalid rate_limit_%s_key %q, must be ip, api_key or header:<name>", limit.group, limit.key)
+		}
+	}
+
+	if err := c.LogOptions().Validate(); err != nil {
+		problem("%s", err)
+	}
+
+	switch c.ImpressionsSink {
+	case "":
+	case "ndjson", "http", "redis":
+		if c.ImpressionsTarget == "" {
+			problem("impressions_target not set, it is required by the %s impressions sink", c.ImpressionsSink)
+		}
+		if c.ImpressionsSink == "redis" && c.RedisHost == "" {
+			problem("redis_host not set, it is required by the redis impressions sink")
+		}
+		// The redis persistence stores take every key in the database outside the prefix for a scope.
+		if c.ImpressionsSink == "redis" && (c.PersistenceStoreType == "redis" || c.PersistenceStoreType == "redisv2") &&
+			!strings.HasPrefix(c.ImpressionsTarget, redisKeyPrefix) {
+			problem("impressions_target %q must start with %q, or the %s persistence store will take it for a scope",
+				c.ImpressionsTarget, redisKeyPrefix, c.PersistenceStoreType)
+		}
+	default:
+		problem("Unknown impressions_sink: %q", c.ImpressionsSink)
+	}
+
+	for _, setting := range []struct {
+		name  string
+		value int
+	}{
+		{"log_max_size", c.LogMaxSize},
+		{"log_max_backups", c.LogMaxBackups},
+		{"log_max_age", c.LogMaxAge},
+		{"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"schedule_interval", c.ScheduleInterval},
+		{"expiry_sweep_interval", c.ExpirySweepInterval},
+		{"impressions_buffer_size", c.ImpressionsBufferSize},
+		{"impressions_batch_size", c.ImpressionsBatchSize},
+		{"impressions_flush_interval", c.ImpressionsFlushInterval},
+		{"usage_sample_rate", c.UsageSampleRate},
+		{"usage_flush_interval", c.UsageFlushInterval},
+		{"config_watch_interval", c.ConfigWatchInterval},
+		{"cors_public_max_age", c.CORSPublicMaxAge},
+		{"cors_admin_max_age", c.CORSAdminMaxAge},
+		{"rate_limit_public_per_minute", c.RateLimitPublicPerMinute},
+		{"rate_limit_public_burst", c.RateLimitPublicBurst},
+		{"rate_limit_admin_per_minute", c.RateLimitAdminPerMinute},
+		{"rate_limit_admin_burst", c.RateLimitAdminBurst},
+	} {
+		if setting.value < 0 {
+			problem("%s must not be negative", setting.name)
+		}
+	}
+
+	if len(problems) > 0 {
+		return ValidationError{EnvironmentName: c.EnvironmentName, Problems: problems}
+	}
+	return nil
+}
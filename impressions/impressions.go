@@ -0,0 +1,152 @@
+// Package impressions records which scopes checked which features, and what they were given, for
+// analysing experiments.
+package impressions
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// Defaults used when the recorder is not configured.
+const (
+	DefaultBufferSize    = 10000
+	DefaultBatchSize     = 500
+	DefaultFlushInterval = 5 * time.Second
+)
+
+// Impression is a single check of a feature by a scope. Value is the value the scope was given, and
+// Variant the experiment variant, for experiment checks.
+type Impression struct {
+	Feature string    `json:"feature"`
+	Scope   string    `json:"scope"`
+	Value   string    `json:"value,omitempty"`
+	Variant string    `json:"variant,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Serialize returns the Impression as json.
+func (impression Impression) Serialize() []byte {
+	serializedImpression, err := json.Marshal(impression)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize impression")
+		return []byte("")
+	}
+	return serializedImpression
+}
+
+// Sink is where a Recorder flushes batches of impressions to.
+type Sink interface {
+	Write(context.Context, []Impression) error
+	Close() error
+}
+
+// Recorder buffers impressions in memory and flushes them to a sink in batches. Record never blocks:
+// when the buffer is full, the impression is dropped and counted instead, so memory stays bounded and
+// the check path is not slowed down by a slow sink.
+type Recorder struct {
+	sink      Sink
+	queue     chan Impression
+	batchSize int
+	interval  time.Duration
+	dropped   uint64
+}
+
+// NewRecorder returns a Recorder holding up to bufferSize impressions, and flushing them to the sink
+// batchSize at a time, or every interval if fewer are waiting. Sizes and intervals of zero or less use
+// the defaults.
+func NewRecorder(sink Sink, bufferSize, batchSize int, interval time.Duration) *Recorder {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	return &Recorder{
+		sink:      sink,
+		queue:     make(chan Impression, bufferSize),
+		batchSize: batchSize,
+		interval:  interval,
+	}
+}
+
+// Record queues the impression to be flushed, or drops it if the buffer is full.
+func (r *Recorder) Record(impression Impression) {
+	if impression.Time.IsZero() {
+		impression.Time = time.Now()
+	}
+	select {
+	case r.queue <- impression:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+	}
+}
+
+// Dropped returns how many impressions have been dropped because the buffer was full.
+func (r *Recorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Run flushes queued impressions until the context is done, then flushes whatever is left and closes
+// the sink.
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	batch := make([]Impression, 0, r.batchSize)
+	for {
+		select {
+		case impression := <-r.queue:
+			batch = append(batch, impression)
+			if len(batch) >= r.batchSize {
+				batch = r.flush(ctx, batch)
+			}
+		case <-ticker.C:
+			batch = r.flush(ctx, batch)
+		case <-ctx.Done():
+			r.drain(batch)
+			return
+		}
+	}
+}
+
+// drain flushes the batch and everything still queued, with a fresh context since the one Run was
+// given is done.
+func (r *Recorder) drain(batch []Impression) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+	defer cancel()
+	for {
+		select {
+		case impression := <-r.queue:
+			batch = append(batch, impression)
+			if len(batch) >= r.batchSize {
+				batch = r.flush(ctx, batch)
+			}
+		default:
+			r.flush(ctx, batch)
+			if err := r.sink.Close(); err != nil {
+				utils.Log.WithError(err).Error("Unable to close impression sink")
+			}
+			return
+		}
+	}
+}
+
+// flush writes the batch to the sink and returns it emptied. A batch the sink fails to write is
+// dropped rather than retried, to keep memory bounded.
+func (r *Recorder) flush(ctx context.Context, batch []Impression) []Impression {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := r.sink.Write(ctx, batch); err != nil {
+		atomic.AddUint64(&r.dropped, uint64(len(batch)))
+		utils.Log.WithError(err).WithField("impressions", len(batch)).Error("Unable to write impressions")
+	}
+	return batch[:0]
+}
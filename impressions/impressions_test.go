@@ -0,0 +1,151 @@
+package impressions
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memorySink struct {
+	mu      sync.Mutex
+	batches [][]Impression
+	closed  bool
+}
+
+func (s *memorySink) Write(ctx context.Context, impressions []Impression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]Impression(nil), impressions...))
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func testImpression(i int) Impression {
+	return Impression{Feature: "feature1", Scope: fmt.Sprintf("user-%d", i), Value: "on"}
+}
+
+func TestRecorder_FlushesBatches(t *testing.T) {
+	sink := &memorySink{}
+	recorder := NewRecorder(sink, 10, 2, time.Hour)
+	for i := 0; i < 5; i++ {
+		recorder.Record(testImpression(i))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		recorder.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	if !sink.closed {
+		t.Error("Expected sink to be closed")
+	}
+	var total int
+	for _, batch := range sink.batches {
+		if len(batch) > 2 {
+			t.Errorf("Expected batches of at most 2, got %d", len(batch))
+		}
+		total += len(batch)
+	}
+	if total != 5 {
+		t.Errorf("Expected 5 impressions, got %d", total)
+	}
+	if sink.batches[0][0].Time.IsZero() {
+		t.Error("Expected impression time to be set")
+	}
+}
+
+func TestRecorder_DropsWhenFull(t *testing.T) {
+	recorder := NewRecorder(&memorySink{}, 2, 1, time.Hour)
+	for i := 0; i < 5; i++ {
+		recorder.Record(testImpression(i))
+	}
+	if recorder.Dropped() != 3 {
+		t.Errorf("Expected 3 dropped impressions, got %d", recorder.Dropped())
+	}
+}
+
+func TestNDJSONSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "impressions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "impressions.ndjson")
+
+	sink, err := NewNDJSONSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(context.Background(), []Impression{testImpression(1), testImpression(2)}); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var scopes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var impression Impression
+		if err := json.Unmarshal(scanner.Bytes(), &impression); err != nil {
+			t.Fatal(err)
+		}
+		scopes = append(scopes, impression.Scope)
+	}
+	if strings.Join(scopes, ",") != "user-1,user-2" {
+		t.Errorf("Expected one line per impression, got %v", scopes)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		if r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		}
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL)
+	if err := sink.Write(context.Background(), []Impression{testImpression(1), testImpression(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(body, "\n"); lines != 2 {
+		t.Errorf("Expected 2 lines, got %d", lines)
+	}
+}
+
+func TestHTTPSink_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := NewHTTPSink(server.URL).Write(context.Background(), []Impression{testImpression(1)}); err == nil {
+		t.Error("Expected an error for a 500 response")
+	}
+}
@@ -0,0 +1,148 @@
+package impressions
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/samdfonseca/flipadelphia/config"
+	"github.com/samdfonseca/flipadelphia/store"
+)
+
+// NewSink returns the sink named by the config's impressions_sink, writing to impressions_target. It
+// returns nil if impression tracking is not enabled.
+func NewSink(c config.FlipadelphiaConfig) (Sink, error) {
+	switch c.ImpressionsSink {
+	case "":
+		return nil, nil
+	case "ndjson":
+		return NewNDJSONSink(c.ImpressionsTarget)
+	case "http":
+		return NewHTTPSink(c.ImpressionsTarget), nil
+	case "redis":
+		if c.RedisHost == "" {
+			return nil, fmt.Errorf("redis_host not set")
+		}
+		return NewRedisStreamSink(store.NewRedisPool(c.RedisHost, c.RedisPassword, c.RedisDB), c.ImpressionsTarget), nil
+	default:
+		return nil, fmt.Errorf("Unknown impressions_sink: %q", c.ImpressionsSink)
+	}
+}
+
+// NDJSONSink appends impressions to a local file, one json object per line.
+type NDJSONSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewNDJSONSink opens the file at path for appending, creating it if needed.
+func NewNDJSONSink(path string) (*NDJSONSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &NDJSONSink{file: file}, nil
+}
+
+// Write appends the impressions to the file.
+func (s *NDJSONSink) Write(ctx context.Context, impressions []Impression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.file.Write(ndjson(impressions))
+	return err
+}
+
+// Close closes the file.
+func (s *NDJSONSink) Close() error {
+	return s.file.Close()
+}
+
+// HTTPSink posts batches of impressions to an endpoint as newline delimited json.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink returns a sink posting to the url.
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Write posts the impressions, and fails unless the endpoint responds with a 2xx status.
+func (s *HTTPSink) Write(ctx context.Context, impressions []Impression) error {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(ndjson(impressions)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("impressions endpoint responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Close does nothing, there is no connection to close.
+func (s *HTTPSink) Close() error {
+	return nil
+}
+
+// RedisStreamMaxLen is roughly how many impressions a Redis stream keeps before trimming the oldest.
+const RedisStreamMaxLen = 1000000
+
+// RedisStreamSink adds impressions to a Redis stream, as entries with a single "impression" field
+// holding the json.
+type RedisStreamSink struct {
+	pool   *redis.Pool
+	stream string
+}
+
+// NewRedisStreamSink returns a sink adding to the stream through connections from the pool.
+func NewRedisStreamSink(pool *redis.Pool, stream string) *RedisStreamSink {
+	return &RedisStreamSink{pool: pool, stream: stream}
+}
+
+// Write adds the impressions to the stream in a single pipeline.
+func (s *RedisStreamSink) Write(ctx context.Context, impressions []Impression) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	for _, impression := range impressions {
+		err := conn.Send("XADD", s.stream, "MAXLEN", "~", RedisStreamMaxLen, "*", "impression", impression.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for range impressions {
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the pool's connections.
+func (s *RedisStreamSink) Close() error {
+	return s.pool.Close()
+}
+
+// ndjson encodes the impressions as newline delimited json.
+func ndjson(impressions []Impression) []byte {
+	var buf bytes.Buffer
+	for _, impression := range impressions {
+		buf.Write(impression.Serialize())
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
@@ -1,14 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/samdfonseca/flipadelphia/config"
+	"github.com/samdfonseca/flipadelphia/impressions"
+	"github.com/samdfonseca/flipadelphia/metrics"
+	"github.com/samdfonseca/flipadelphia/ratelimit"
+	"github.com/samdfonseca/flipadelphia/scheduler"
 	"github.com/samdfonseca/flipadelphia/server"
 	"github.com/samdfonseca/flipadelphia/store"
+	"github.com/samdfonseca/flipadelphia/usage"
 	"github.com/samdfonseca/flipadelphia/utils"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
@@ -40,15 +55,271 @@ func main() {
 			EnvVar: "FLIPADELPHIA_CONFIG",
 		},
 	}
-	app.Action = func(c *cli.Context) {
-		config.Config = config.NewFlipadelphiaConfig(c.String("config"), c.String("env"))
-		flipDB := store.NewPersistenceStore(config.Config)
-		defer flipDB.Close()
-		utils.Output(fmt.Sprintf("Listening on port %d", config.Config.ListenOnPort))
-		err := http.ListenAndServe(fmt.Sprintf(":%d", config.Config.ListenOnPort),
-			server.App(flipDB, server.ClassicNegroniStack()))
-		utils.FailOnError(err, "Something went wrong", true)
+	for _, key := range flagSettings {
+		app.Flags = append(app.Flags, cli.StringFlag{
+			Name:  settingFlag(key),
+			Usage: fmt.Sprintf("Overrides %s in the config file and $%s%s", key, config.EnvVarPrefix, strings.ToUpper(key)),
+		})
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:  "config",
+			Usage: "Inspect the configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:  "print",
+					Usage: "Print the config merged from the config file, environment variables and flags, with secrets redacted",
+					Action: func(c *cli.Context) error {
+						// An invalid config is still printed, followed by its problems.
+						flipConfig, loadErr := loadConfig(c)
+						if _, invalid := loadErr.(config.ValidationError); loadErr != nil && !invalid {
+							return exitError(loadErr)
+						}
+						out, err := json.MarshalIndent(flipConfig.Redacted(), "", "  ")
+						if err != nil {
+							return exitError(err)
+						}
+						fmt.Fprintln(c.App.Writer, string(out))
+						if loadErr != nil {
+							return exitError(loadErr)
+						}
+						return nil
+					},
+				},
+			},
+		},
+	}
+	app.Action = func(c *cli.Context) error {
+		sources := configSources(c)
+		var err error
+		config.Config, err = config.Load(sources)
+		if err != nil {
+			return exitError(err)
+		}
+		if err := utils.ConfigureLogging(config.Config.LogOptions()); err != nil {
+			return exitError(fmt.Errorf("Unable to configure logging: %s", err))
+		}
+		reloader := config.NewReloader(sources, config.Config)
+		reloader.OnReload(func(c config.FlipadelphiaConfig) {
+			if err := utils.ConfigureLogging(c.LogOptions()); err != nil {
+				utils.Log.WithError(err).Error("Unable to reconfigure logging")
+			}
+		})
+		flipDB, err := store.NewPersistenceStoreV2(config.Config)
+		if err != nil {
+			return exitError(fmt.Errorf("Unable to open the persistence store: %s", err))
+		}
+		if err := serve(config.Config, reloader, flipDB); err != nil {
+			return exitError(err)
+		}
+		return nil
 	}
 
 	app.Run(os.Args)
 }
+
+// flagSettings are the settings that can be overridden by a flag of their own.
+var flagSettings = []string{
+	"persistence_store_type",
+	"db_file",
+	"redis_host",
+	"redis_db",
+	"port",
+	"log_file",
+	"log_format",
+	"log_level",
+}
+
+// settingFlag returns the name of the flag overriding the setting. Ex: redis_host -> redis-host
+func settingFlag(key string) string {
+	return strings.Replace(key, "_", "-", -1)
+}
+
+// configSources returns the config file, FLIPADELPHIA_* environment variables and flags the config is
+// merged from. The config file may be missing unless --config or $FLIPADELPHIA_CONFIG names it, so a
+// container can be configured by environment variables alone.
+func configSources(c *cli.Context) config.Sources {
+	flags := make(map[string]string)
+	for _, key := range flagSettings {
+		if c.GlobalIsSet(settingFlag(key)) {
+			flags[key] = c.GlobalString(settingFlag(key))
+		}
+	}
+	return config.Sources{
+		File:         c.GlobalString("config"),
+		FileOptional: !c.GlobalIsSet("config"),
+		EnvName:      c.GlobalString("env"),
+		Environ:      os.Environ(),
+		Flags:        flags,
+	}
+}
+
+// loadConfig merges the config from its sources.
+func loadConfig(c *cli.Context) (config.FlipadelphiaConfig, error) {
+	return config.Load(configSources(c))
+}
+
+// exitError makes the CLI print the error and exit with status 1.
+func exitError(err error) error {
+	return cli.NewExitError(fmt.Sprintf("flipadelphia: %s", err), 1)
+}
+
+// corsPolicies returns the CORS policies of the config.
+func corsPolicies(c config.FlipadelphiaConfig) server.CORSPolicies {
+	return server.CORSPolicies{
+		Public: server.CORSPolicy{
+			AllowedOrigins:   config.SplitList(c.CORSPublicAllowedOrigins),
+			AllowedHeaders:   config.SplitList(c.CORSPublicAllowedHeaders),
+			AllowCredentials: c.CORSPublicAllowCredentials,
+			MaxAge:           c.CORSPublicMaxAge,
+		},
+		Admin: server.CORSPolicy{
+			AllowedOrigins:   config.SplitList(c.CORSAdminAllowedOrigins),
+			AllowedHeaders:   config.SplitList(c.CORSAdminAllowedHeaders),
+			AllowCredentials: c.CORSAdminAllowCredentials,
+			MaxAge:           c.CORSAdminMaxAge,
+		},
+	}
+}
+
+// rateLimitPolicies returns the rate limit policies of the config.
+func rateLimitPolicies(c config.FlipadelphiaConfig) server.RateLimitPolicies {
+	return server.RateLimitPolicies{
+		Public: server.RateLimitPolicy{
+			Limit: ratelimit.Limit{PerMinute: c.RateLimitPublicPerMinute, Burst: c.RateLimitPublicBurst},
+			KeyBy: c.RateLimitPublicKey,
+		},
+		Admin: server.RateLimitPolicy{
+			Limit: ratelimit.Limit{PerMinute: c.RateLimitAdminPerMinute, Burst: c.RateLimitAdminBurst},
+			KeyBy: c.RateLimitAdminKey,
+		},
+	}
+}
+
+// defaultShutdownTimeout is how long in-flight requests get to finish on shutdown when no
+// shutdown_timeout is configured.
+const defaultShutdownTimeout = 30 * time.Second
+
+// seconds converts a config value in seconds to a Duration.
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// serve runs the background jobs and serves the App until SIGINT or SIGTERM, reloading the config on
+// SIGHUP or when the config file changes. It then stops accepting connections, lets in-flight requests
+// and the background jobs finish, and closes the store, so the Bolt file lock and Redis connections are
+// released. It returns an error if the server could not be started or stopped serving on its own.
+func serve(c config.FlipadelphiaConfig, reloader *config.Reloader, flipDB store.PersistenceStoreV2) error {
+	sink, err := impressions.NewSink(c)
+	if err != nil {
+		flipDB.Close()
+		return fmt.Errorf("Unable to open impressions sink: %s", err)
+	}
+	var tlsConfig *tls.Config
+	if c.TLSCertFile != "" {
+		if tlsConfig, err = server.NewTLSConfig(c.TLSCertFile, c.TLSKeyFile, c.TLSClientCAFile); err != nil {
+			flipDB.Close()
+			return err
+		}
+	}
+
+	rateLimits, err := ratelimit.NewStore(c)
+	if err != nil {
+		flipDB.Close()
+		return fmt.Errorf("Unable to open rate limit store: %s", err)
+	}
+
+	m := metrics.New()
+	m.RegisterStore(flipDB, c.PersistenceStoreType)
+	db := store.NewInstrumentedStore(flipDB, m.StoreObserver(c.PersistenceStoreType))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	run := func(job func(context.Context)) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job(ctx)
+		}()
+	}
+	run(func(ctx context.Context) { reloader.Watch(ctx, seconds(c.ConfigWatchInterval)) })
+	run(scheduler.NewScheduler(db, seconds(c.ScheduleInterval)).Run)
+	if sweeper, ok := flipDB.(store.ExpirySweeper); ok {
+		run(scheduler.NewSweeper(sweeper, seconds(c.ExpirySweepInterval)).Run)
+	}
+	cors := server.NewCORS(corsPolicies(c))
+	reloader.OnReload(func(c config.FlipadelphiaConfig) { cors.Update(corsPolicies(c)) })
+	limiter := server.NewRateLimiter(rateLimits, rateLimitPolicies(c))
+	reloader.OnReload(func(c config.FlipadelphiaConfig) { limiter.Update(rateLimitPolicies(c)) })
+	opts := server.Options{Metrics: m, RequireAdminClientCert: c.TLSClientCAFile != "", CORS: cors,
+		RateLimiter: limiter}
+	tracker := usage.NewTracker(db, c.UsageSampleRate, seconds(c.UsageFlushInterval))
+	run(tracker.Run)
+	opts.Usage = tracker
+	if sink != nil {
+		recorder := impressions.NewRecorder(sink, c.ImpressionsBufferSize, c.ImpressionsBatchSize,
+			seconds(c.ImpressionsFlushInterval))
+		run(recorder.Run)
+		opts.Impressions = recorder
+	}
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", c.ListenOnPort),
+		Handler:      server.NewApp(db, server.ClassicNegroniStack(), opts),
+		ReadTimeout:  seconds(c.ReadTimeout),
+		WriteTimeout: seconds(c.WriteTimeout),
+		IdleTimeout:  seconds(c.IdleTimeout),
+		TLSConfig:    tlsConfig,
+	}
+	serveErrs := make(chan error, 1)
+	go func() {
+		utils.Log.WithFields(logrus.Fields{"port": c.ListenOnPort, "tls": tlsConfig != nil}).Info("Listening")
+		if tlsConfig != nil {
+			serveErrs <- srv.ListenAndServeTLS("", "")
+		} else {
+			serveErrs <- srv.ListenAndServe()
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	var serveErr error
+wait:
+	for {
+		select {
+		case serveErr = <-serveErrs:
+			utils.Log.WithError(serveErr).Error("Server stopped")
+			break wait
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if err := reloader.Reload(); err != nil {
+					utils.Log.WithError(err).Error("Unable to reload config")
+				}
+				continue
+			}
+			utils.Log.WithField("signal", sig.String()).Info("Shutting down")
+			break wait
+		}
+	}
+
+	shutdownTimeout := seconds(c.ShutdownTimeout)
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		utils.Log.WithError(err).Error("Unable to finish in-flight requests")
+	}
+	cancel()
+	jobs.Wait()
+	if err := flipDB.Close(); err != nil {
+		utils.Log.WithError(err).Error("Unable to close the persistence store")
+	}
+	if closer, ok := rateLimits.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			utils.Log.WithError(err).Error("Unable to close the rate limit store")
+		}
+	}
+	utils.Log.Info("Stopped")
+	return serveErr
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samdfonseca/flipadelphia/store"
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// sizeTimeout bounds how long a scrape waits on the store to count features, scopes and segments.
+const sizeTimeout = 10 * time.Second
+
+// sizeCollector reports the number of features, scopes and segments in a store when scraped.
+type sizeCollector struct {
+	db       store.PersistenceStoreV2
+	features *prometheus.Desc
+	scopes   *prometheus.Desc
+	segments *prometheus.Desc
+}
+
+func newSizeCollector(db store.PersistenceStoreV2, backend string) *sizeCollector {
+	labels := prometheus.Labels{"backend": backend}
+	return &sizeCollector{
+		db:       db,
+		features: prometheus.NewDesc(namespace+"_features", "Features set on at least one scope.", nil, labels),
+		scopes:   prometheus.NewDesc(namespace+"_scopes", "Scopes with at least one feature set.", nil, labels),
+		segments: prometheus.NewDesc(namespace+"_segments", "Segments defined.", nil, labels),
+	}
+}
+
+func (c *sizeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.features
+	ch <- c.scopes
+	ch <- c.segments
+}
+
+// Collect counts the sets in the store. A set that can not be counted is left out of the scrape.
+func (c *sizeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), sizeTimeout)
+	defer cancel()
+	if features, err := c.db.GetFeatures(ctx); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.features, prometheus.GaugeValue, float64(len(features)))
+	} else {
+		utils.Log.WithError(err).Error("Unable to count features")
+	}
+	if scopes, err := c.db.GetScopes(ctx); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.scopes, prometheus.GaugeValue, float64(len(scopes)))
+	} else {
+		utils.Log.WithError(err).Error("Unable to count scopes")
+	}
+	if segments, err := c.db.GetSegments(ctx); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.segments, prometheus.GaugeValue, float64(len(segments)))
+	} else {
+		utils.Log.WithError(err).Error("Unable to count segments")
+	}
+}
+
+// poolCollector reports the connections in a store's connection pool.
+type poolCollector struct {
+	pooler store.ConnectionPooler
+	active *prometheus.Desc
+	idle   *prometheus.Desc
+}
+
+func newPoolCollector(pooler store.ConnectionPooler, backend string) *poolCollector {
+	labels := prometheus.Labels{"backend": backend}
+	return &poolCollector{
+		pooler: pooler,
+		active: prometheus.NewDesc(namespace+"_redis_pool_active_connections",
+			"Connections in the Redis pool, including idle ones.", nil, labels),
+		idle: prometheus.NewDesc(namespace+"_redis_pool_idle_connections",
+			"Idle connections in the Redis pool.", nil, labels),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.active
+	ch <- c.idle
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pooler.PoolStats()
+	ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(stats.Active))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+}
+
+// boltStatser is implemented by store.FlipadelphiaBoltDB.
+type boltStatser interface {
+	Stats() bolt.Stats
+}
+
+// boltStat is one of the BoltDB statistics reported by boltCollector.
+type boltStat struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(bolt.Stats) float64
+}
+
+// boltCollector reports the transaction statistics of a BoltDB store.
+type boltCollector struct {
+	db    boltStatser
+	stats []boltStat
+}
+
+func newBoltCollector(db boltStatser) *boltCollector {
+	stat := func(name, help string, valueType prometheus.ValueType, value func(bolt.Stats) float64) boltStat {
+		return boltStat{prometheus.NewDesc(namespace+"_bolt_"+name, help, nil, nil), valueType, value}
+	}
+	return &boltCollector{
+		db: db,
+		stats: []boltStat{
+			stat("read_tx_total", "Read transactions started.", prometheus.CounterValue,
+				func(s bolt.Stats) float64 { return float64(s.TxN) }),
+			stat("open_read_tx", "Read transactions currently open.", prometheus.GaugeValue,
+				func(s bolt.Stats) float64 { return float64(s.OpenTxN) }),
+			stat("free_pages", "Free pages on the freelist.", prometheus.GaugeValue,
+				func(s bolt.Stats) float64 { return float64(s.FreePageN) }),
+			stat("tx_page_allocations_total", "Pages allocated by transactions.", prometheus.CounterValue,
+				func(s bolt.Stats) float64 { return float64(s.TxStats.PageCount) }),
+			stat("tx_cursors_total", "Cursors created by transactions.", prometheus.CounterValue,
+				func(s bolt.Stats) float64 { return float64(s.TxStats.CursorCount) }),
+			stat("tx_node_splits_total", "Nodes split by transactions.", prometheus.CounterValue,
+				func(s bolt.Stats) float64 { return float64(s.TxStats.Split) }),
+			stat("tx_node_spills_total", "Nodes spilled by transactions.", prometheus.CounterValue,
+				func(s bolt.Stats) float64 { return float64(s.TxStats.Spill) }),
+			stat("tx_spill_seconds_total", "Time spent spilling nodes.", prometheus.CounterValue,
+				func(s bolt.Stats) float64 { return s.TxStats.SpillTime.Seconds() }),
+			stat("tx_rebalance_seconds_total", "Time spent rebalancing nodes.", prometheus.CounterValue,
+				func(s bolt.Stats) float64 { return s.TxStats.RebalanceTime.Seconds() }),
+			stat("tx_writes_total", "Writes to disk by transactions.", prometheus.CounterValue,
+				func(s bolt.Stats) float64 { return float64(s.TxStats.Write) }),
+			stat("tx_write_seconds_total", "Time spent writing to disk.", prometheus.CounterValue,
+				func(s bolt.Stats) float64 { return s.TxStats.WriteTime.Seconds() }),
+		},
+	}
+}
+
+func (c *boltCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, stat := range c.stats {
+		ch <- stat.desc
+	}
+}
+
+func (c *boltCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+	for _, stat := range c.stats {
+		ch <- prometheus.MustNewConstMetric(stat.desc, stat.valueType, stat.value(stats))
+	}
+}
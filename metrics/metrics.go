@@ -0,0 +1,92 @@
+// Package metrics collects request, store and backend metrics, and serves them in the Prometheus text
+// format.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/samdfonseca/flipadelphia/store"
+)
+
+const namespace = "flipadelphia"
+
+// Metrics holds the collectors Flipadelphia reports, in a registry of its own.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	storeDuration   *prometheus.HistogramVec
+	storeErrors     *prometheus.CounterVec
+}
+
+// New returns Metrics with the request and store operation collectors, and the Go runtime collector,
+// registered.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests served, by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve HTTP requests, by route, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Time taken by persistence store operations, by backend and method.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+		}, []string{"backend", "method"}),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_operation_errors_total",
+			Help:      "Persistence store operations that failed, by backend and method. Missing scopes, features and the like are not counted.",
+		}, []string{"backend", "method"}),
+	}
+	m.registry.MustRegister(m.requests, m.requestDuration, m.storeDuration, m.storeErrors, prometheus.NewGoCollector())
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest counts a request served by the route, and how long it took.
+func (m *Metrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	labels := prometheus.Labels{"route": route, "method": method, "status": strconv.Itoa(status)}
+	m.requests.With(labels).Inc()
+	m.requestDuration.With(labels).Observe(duration.Seconds())
+}
+
+// StoreObserver returns an OperationObserver timing the operations of the backend, and counting those
+// that fail. Pass it to store.NewInstrumentedStore.
+func (m *Metrics) StoreObserver(backend string) store.OperationObserver {
+	return func(method string, duration time.Duration, err error) {
+		m.storeDuration.WithLabelValues(backend, method).Observe(duration.Seconds())
+		if err != nil && !store.IsNotFound(err) {
+			m.storeErrors.WithLabelValues(backend, method).Inc()
+		}
+	}
+}
+
+// RegisterStore adds collectors for the number of features, scopes and segments in the store, and for
+// its connection pool or BoltDB statistics when it has them. Pass the store itself, not an instrumented
+// wrapper, so scrapes are not counted as store operations.
+func (m *Metrics) RegisterStore(db store.PersistenceStoreV2, backend string) {
+	m.registry.MustRegister(newSizeCollector(db, backend))
+	if pooler, ok := db.(store.ConnectionPooler); ok {
+		m.registry.MustRegister(newPoolCollector(pooler, backend))
+	}
+	if bolt, ok := db.(boltStatser); ok {
+		m.registry.MustRegister(newBoltCollector(bolt))
+	}
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/samdfonseca/flipadelphia/store"
+)
+
+func scrape(m *Metrics, t *testing.T) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status scraping metrics: %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+func assertContains(body, line string, t *testing.T) {
+	if !strings.Contains(body, line+"\n") {
+		t.Errorf("Expected metrics to contain %q", line)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.RegisterStore(store.MockPersistenceStoreV2{
+		OnGetFeatures: func(ctx context.Context) ([]string, error) {
+			return []string{"feature1", "feature2"}, nil
+		},
+		OnGetScopes: func(ctx context.Context) ([]string, error) {
+			return []string{"user-1"}, nil
+		},
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return nil, errors.New("unavailable")
+		},
+	}, "mock")
+	m.ObserveRequest("/features/{feature_name}", "GET", http.StatusOK, time.Millisecond)
+	observe := m.StoreObserver("mock")
+	observe("Get", time.Millisecond, nil)
+	observe("Get", time.Millisecond, store.ErrFeatureNotFound)
+	observe("Set", time.Millisecond, store.ErrStoreUnavailable)
+
+	body := scrape(m, t)
+	assertContains(body, `flipadelphia_http_requests_total{method="GET",route="/features/{feature_name}",status="200"} 1`, t)
+	assertContains(body, `flipadelphia_store_operation_duration_seconds_count{backend="mock",method="Get"} 2`, t)
+	assertContains(body, `flipadelphia_store_operation_errors_total{backend="mock",method="Set"} 1`, t)
+	if strings.Contains(body, `flipadelphia_store_operation_errors_total{backend="mock",method="Get"}`) {
+		t.Error("Expected a missing feature not to be counted as an error")
+	}
+	assertContains(body, `flipadelphia_features{backend="mock"} 2`, t)
+	assertContains(body, `flipadelphia_scopes{backend="mock"} 1`, t)
+	if strings.Contains(body, "flipadelphia_segments") {
+		t.Error("Expected segments to be left out when they can not be counted")
+	}
+}
+
+func TestMetrics_Bolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flipadelphia_metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m := New()
+	m.RegisterStore(store.NewFlipadelphiaBoltDB(db), "bolt")
+	body := scrape(m, t)
+	assertContains(body, `flipadelphia_bolt_open_read_tx 0`, t)
+	if !strings.Contains(body, "flipadelphia_bolt_tx_writes_total ") {
+		t.Error("Expected BoltDB transaction stats")
+	}
+	if strings.Contains(body, "flipadelphia_redis_pool") {
+		t.Error("Expected no Redis pool stats for BoltDB")
+	}
+}
@@ -0,0 +1,113 @@
+// Package ratelimit limits how often a client may make requests, with token buckets kept in memory or
+// shared between instances in Redis.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket: it holds up to Burst tokens and refills at PerMinute tokens a minute. Each
+// request takes a token. A PerMinute of zero or less means no limit.
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+// Unlimited reports whether the limit lets every request through.
+func (limit Limit) Unlimited() bool {
+	return limit.PerMinute <= 0
+}
+
+// rate returns how many tokens are added a second.
+func (limit Limit) rate() float64 {
+	return float64(limit.PerMinute) / 60
+}
+
+// burst returns the bucket's size, a minute's worth of tokens when Burst is not set.
+func (limit Limit) burst() float64 {
+	if limit.Burst <= 0 {
+		return float64(limit.PerMinute)
+	}
+	return float64(limit.Burst)
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// RetryAfter is how long until a token is available, when the request was not allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take takes a token from the key's bucket, filling it first with the tokens added since it was
+	// last taken from.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// take takes a token from a bucket holding tokens, last filled elapsed seconds ago. It returns the
+// tokens left and the result.
+func take(tokens, elapsed float64, limit Limit) (float64, Result) {
+	tokens = math.Min(limit.burst(), tokens+math.Max(0, elapsed)*limit.rate())
+	if tokens >= 1 {
+		return tokens - 1, Result{Allowed: true}
+	}
+	wait := (1 - tokens) / limit.rate()
+	return tokens, Result{RetryAfter: time.Duration(wait * float64(time.Second))}
+}
+
+// bucket is a token bucket held in memory.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will be full again, after which it can be forgotten.
+	full time.Time
+}
+
+// sweepEvery is how many takes pass between sweeps of the buckets that have refilled.
+const sweepEvery = 10000
+
+// MemoryStore keeps the token buckets in memory, so each instance limits requests on its own.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+	now     func() time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Take takes a token from the key's bucket. Buckets that have refilled are forgotten now and then, so
+// memory is only held for clients that made requests recently.
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.takes++
+	if s.takes%sweepEvery == 0 {
+		for key, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, key)
+			}
+		}
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.burst(), updated: now}
+		s.buckets[key] = b
+	}
+	var result Result
+	b.tokens, result = take(b.tokens, now.Sub(b.updated).Seconds(), limit)
+	b.updated = now
+	b.full = now.Add(time.Duration((limit.burst() - b.tokens) / limit.rate() * float64(time.Second)))
+	return result, nil
+}
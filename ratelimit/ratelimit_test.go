@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/config"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	limit := Limit{PerMinute: 60, Burst: 2}
+	take := func(key string) Result {
+		result, err := s.Take(context.Background(), key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	for i := 0; i < 2; i++ {
+		if result := take("client1"); !result.Allowed {
+			t.Fatalf("take %d was not allowed", i)
+		}
+	}
+	result := take("client1")
+	if result.Allowed {
+		t.Fatal("take over the burst was allowed")
+	}
+	if result.RetryAfter != time.Second {
+		t.Errorf("Expected a RetryAfter of 1s, got %s", result.RetryAfter)
+	}
+	if result := take("client2"); !result.Allowed {
+		t.Error("another client's take was not allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if result := take("client1"); result.Allowed || result.RetryAfter != 500*time.Millisecond {
+		t.Errorf("Expected a take half a token in to wait 500ms, got %+v", result)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if result := take("client1"); !result.Allowed {
+		t.Error("take after the bucket refilled a token was not allowed")
+	}
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if result := take("client1"); !result.Allowed {
+			t.Fatalf("take %d after the bucket refilled was not allowed", i)
+		}
+	}
+	if result := take("client1"); result.Allowed {
+		t.Error("bucket refilled past its burst")
+	}
+}
+
+func TestMemoryStore_Unlimited(t *testing.T) {
+	s := NewMemoryStore()
+	for i := 0; i < 100; i++ {
+		result, err := s.Take(context.Background(), "client1", Limit{})
+		if err != nil || !result.Allowed {
+			t.Fatalf("Expected an unlimited take to be allowed, got %+v, %v", result, err)
+		}
+	}
+	if len(s.buckets) != 0 {
+		t.Errorf("Expected no buckets for an unlimited limit, got %d", len(s.buckets))
+	}
+}
+
+func TestMemoryStore_Sweep(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	limit := Limit{PerMinute: 60}
+	s.Take(context.Background(), "client1", limit)
+	now = now.Add(2 * time.Second)
+	for i := 1; i < sweepEvery; i++ {
+		s.Take(context.Background(), "client2", limit)
+	}
+	if _, ok := s.buckets["client1"]; ok {
+		t.Error("Expected the refilled bucket to be swept")
+	}
+	if _, ok := s.buckets["client2"]; !ok {
+		t.Error("Expected the bucket in use to be kept")
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	if _, err := NewStore(config.FlipadelphiaConfig{RateLimitStore: "redis"}); err == nil {
+		t.Error("Expected an error for the redis store without redis_host")
+	}
+	if _, err := NewStore(config.FlipadelphiaConfig{RateLimitStore: "carrier-pigeon"}); err == nil {
+		t.Error("Expected an error for an unknown store")
+	}
+	if s, err := NewStore(config.FlipadelphiaConfig{}); err != nil {
+		t.Error(err)
+	} else if _, ok := s.(*MemoryStore); !ok {
+		t.Errorf("Expected a MemoryStore by default, got %T", s)
+	}
+}
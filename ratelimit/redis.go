@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/samdfonseca/flipadelphia/config"
+	"github.com/samdfonseca/flipadelphia/store"
+)
+
+// NewStore returns the store named by the config's rate_limit_store: a MemoryStore when it is empty
+// or "memory", and a RedisStore on redis_host when it is "redis".
+func NewStore(c config.FlipadelphiaConfig) (Store, error) {
+	switch c.RateLimitStore {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "redis":
+		if c.RedisHost == "" {
+			return nil, fmt.Errorf("redis_host not set")
+		}
+		return NewRedisStore(store.NewRedisPool(c.RedisHost, c.RedisPassword, c.RedisDB)), nil
+	default:
+		return nil, fmt.Errorf("Unknown rate_limit_store: %q", c.RateLimitStore)
+	}
+}
+
+// redisKeyPrefix starts the key of every bucket kept in Redis.
+const redisKeyPrefix = "flipadelphia:ratelimit:"
+
+// redisTakeScript takes a token from a bucket kept in a hash, which expires once the bucket would be
+// full again.
+//
+// KEYS[1] - bucket hash
+// ARGV[1] - tokens added a second, ARGV[2] - bucket size, ARGV[3] - now in unix seconds
+const redisTakeScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(bucket[1]) or burst
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = (1 - tokens) / rate
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'updated', ARGV[3])
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(wait)}`
+
+// RedisStore keeps the token buckets in Redis, so every instance sharing it counts towards the same
+// limits. Buckets are filled by the clock of the instance taking from them, so the instances' clocks
+// should agree.
+type RedisStore struct {
+	pool *redis.Pool
+	now  func() time.Time
+}
+
+// NewRedisStore returns a RedisStore using connections from the pool.
+func NewRedisStore(pool *redis.Pool) *RedisStore {
+	return &RedisStore{pool: pool, now: time.Now}
+}
+
+// Take takes a token from the key's bucket in a single script, so instances taking at the same time
+// do not both get the last token.
+func (s *RedisStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	conn := s.pool.Get()
+	defer conn.Close()
+	now := float64(s.now().UnixNano()) / float64(time.Second)
+	reply, err := redis.Values(conn.Do("EVAL", redisTakeScript, 1, redisKeyPrefix+key,
+		strconv.FormatFloat(limit.rate(), 'f', -1, 64),
+		strconv.FormatFloat(limit.burst(), 'f', -1, 64),
+		strconv.FormatFloat(now, 'f', 6, 64)))
+	if err != nil {
+		return Result{}, err
+	}
+	var allowed int
+	var wait string
+	if _, err := redis.Scan(reply, &allowed, &wait); err != nil {
+		return Result{}, err
+	}
+	seconds, err := strconv.ParseFloat(wait, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Allowed: allowed == 1, RetryAfter: time.Duration(seconds * float64(time.Second))}, nil
+}
+
+// Close closes the pool's connections.
+func (s *RedisStore) Close() error {
+	return s.pool.Close()
+}
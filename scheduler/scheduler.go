@@ -0,0 +1,85 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/store"
+	"github.com/samdfonseca/flipadelphia/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultInterval is how often the scheduler checks for due changes when no interval is configured.
+const DefaultInterval = 10 * time.Second
+
+// Scheduler applies the scheduled changes kept in a store once they are due. Pending changes live in
+// the store, so they survive restarts and are applied by the next scheduler to run.
+type Scheduler struct {
+	db       store.PersistenceStoreV2
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewScheduler returns a Scheduler checking the store for due changes every interval. An interval of
+// zero or less uses DefaultInterval.
+func NewScheduler(db store.PersistenceStoreV2, interval time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Scheduler{
+		db:       db,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Run applies due changes every interval until the context is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.ApplyDue(ctx); err != nil {
+			utils.Log.WithError(err).Error("Unable to apply scheduled changes")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ApplyDue sets the value of every change whose run-at time has passed, soonest first, through the
+// store's Set, then removes it. It returns the number of changes applied.
+//
+// A change is removed only after it is set, so a crash in between applies it again on the next run
+// rather than losing it.
+func (s *Scheduler) ApplyDue(ctx context.Context) (int, error) {
+	changes, err := s.db.GetSchedules(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := s.now()
+	applied := 0
+	for _, change := range changes {
+		if change.RunAt.After(now) {
+			break
+		}
+		_, err := s.db.Set(ctx, []byte(change.Scope), []byte(change.Feature), []byte(change.Value))
+		if err != nil {
+			return applied, err
+		}
+		err = s.db.DeleteSchedule(ctx, []byte(change.ID))
+		if err != nil && err != store.ErrScheduleNotFound {
+			return applied, err
+		}
+		applied++
+		utils.Log.WithFields(logrus.Fields{
+			"schedule_id": change.ID,
+			"scope":       change.Scope,
+			"feature":     change.Feature,
+			"value":       change.Value,
+		}).Info("Applied scheduled change")
+	}
+	return applied, nil
+}
@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/store"
+)
+
+func checkResult(actual, target string, t *testing.T) {
+	if actual != target {
+		t.Logf("Target: %s", target)
+		t.Logf("Actual: %s", actual)
+		t.Errorf("Actual value did not match target value")
+	}
+}
+
+func TestApplyDue(t *testing.T) {
+	now := time.Date(2017, 6, 1, 9, 0, 0, 0, time.UTC)
+	pending := store.ScheduledChanges{
+		{ID: "1", Scope: "venue-1", Feature: "launch", Value: "on", RunAt: now.Add(-time.Minute)},
+		{ID: "2", Scope: "venue-1", Feature: "promo", Value: "on", RunAt: now},
+		{ID: "3", Scope: "venue-1", Feature: "promo", Value: "off", RunAt: now.Add(time.Hour)},
+	}
+	var set, deleted []string
+	fdb := store.MockPersistenceStoreV2{
+		OnGetSchedules: func(ctx context.Context) (store.ScheduledChanges, error) {
+			return pending, nil
+		},
+		OnSet: func(ctx context.Context, scope, key, value []byte) (store.FlipadelphiaFeature, error) {
+			set = append(set, fmt.Sprintf("%s/%s=%s", scope, key, value))
+			return store.NewFlipadelphiaFeature(key, value), nil
+		},
+		OnDeleteSchedule: func(ctx context.Context, id []byte) error {
+			deleted = append(deleted, string(id))
+			return nil
+		},
+	}
+	s := NewScheduler(fdb, time.Minute)
+	s.now = func() time.Time { return now }
+
+	applied, err := s.ApplyDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(fmt.Sprint(applied), "2", t)
+	checkResult(fmt.Sprint(set), "[venue-1/launch=on venue-1/promo=on]", t)
+	checkResult(fmt.Sprint(deleted), "[1 2]", t)
+}
+
+func TestApplyDue_SetFails(t *testing.T) {
+	now := time.Now()
+	fdb := store.MockPersistenceStoreV2{
+		OnGetSchedules: func(ctx context.Context) (store.ScheduledChanges, error) {
+			return store.ScheduledChanges{{ID: "1", Scope: "venue-1", Feature: "launch", Value: "on", RunAt: now}}, nil
+		},
+		OnSet: func(ctx context.Context, scope, key, value []byte) (store.FlipadelphiaFeature, error) {
+			return store.FlipadelphiaFeature{}, store.ErrStoreUnavailable
+		},
+		OnDeleteSchedule: func(ctx context.Context, id []byte) error {
+			t.Errorf("Deleted scheduled change %s that was not applied", id)
+			return nil
+		},
+	}
+	s := NewScheduler(fdb, 0)
+	s.now = func() time.Time { return now }
+
+	applied, err := s.ApplyDue(context.Background())
+	checkResult(fmt.Sprint(applied), "0", t)
+	checkResult(fmt.Sprint(err), store.ErrStoreUnavailable.Error(), t)
+}
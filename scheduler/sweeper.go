@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/store"
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// DefaultSweepInterval is how often the sweeper removes expired values when no interval is configured.
+const DefaultSweepInterval = 60 * time.Second
+
+// Sweeper removes expired values from stores that do not expire them natively, and corrects the
+// feature summaries of those that do. Expired values are already treated as unset, so sweeping only
+// reclaims space and keeps the summaries accurate.
+type Sweeper struct {
+	db       store.ExpirySweeper
+	interval time.Duration
+}
+
+// NewSweeper returns a Sweeper removing expired values from the store every interval. An interval of
+// zero or less uses DefaultSweepInterval.
+func NewSweeper(db store.ExpirySweeper, interval time.Duration) *Sweeper {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	return &Sweeper{
+		db:       db,
+		interval: interval,
+	}
+}
+
+// Run removes expired values every interval until the context is done.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := s.db.DeleteExpired(ctx)
+		if err != nil {
+			utils.Log.WithError(err).Error("Unable to delete expired values")
+		} else if deleted > 0 {
+			utils.Log.WithField("deleted", deleted).Info("Deleted expired values")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
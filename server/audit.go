@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// auditLog records an admin change, and who made it, in the server log with audit set.
+func auditLog(r *http.Request, action, details string) {
+	logger(r).WithFields(logrus.Fields{
+		"audit":   true,
+		"action":  action,
+		"actor":   requestActor(r),
+		"details": details,
+	}).Info("AUDIT")
+}
+
+// requestActor identifies who made the request: by the subject of its client certificate, if it was
+// made with one, or else by the address it came from.
+func requestActor(r *http.Request) string {
+	if subject := clientCertSubject(r); subject != "" {
+		return subject
+	}
+	return r.RemoteAddr
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/samdfonseca/flipadelphia/utils"
@@ -23,9 +24,11 @@ type Authenticator interface {
 	AuthenticateRequest(*http.Request) (bool, error)
 }
 
-func NewAuthSettings(url, method, header, successCode string) Authenticator {
+// NewAuthSettings returns an Authenticator asking the url whether a request is authorized, or NoAuth
+// when no settings are given. It returns an error for a method other than GET, HEAD, POST or PUT.
+func NewAuthSettings(url, method, header, successCode string) (Authenticator, error) {
 	if strings.EqualFold(url, "") && strings.EqualFold(method, "") && strings.EqualFold(header, "") && strings.EqualFold(successCode, "") {
-		return NoAuth{}
+		return NoAuth{}, nil
 	}
 	var Method string
 	for _, m := range []string{"GET", "HEAD", "POST", "PUT"} {
@@ -34,9 +37,9 @@ func NewAuthSettings(url, method, header, successCode string) Authenticator {
 		}
 	}
 	if strings.EqualFold(Method, "") {
-		utils.FailOnError(fmt.Errorf(""), "Invalid request method %q", false)
+		return nil, fmt.Errorf("Invalid auth request method %q, must be GET, HEAD, POST or PUT", method)
 	}
-	return AuthSettings{Url: url, Method: Method, Header: header, SuccessStatusCode: successCode}
+	return AuthSettings{Url: url, Method: Method, Header: header, SuccessStatusCode: successCode}, nil
 }
 
 func (noAuth NoAuth) AuthenticateRequest(r *http.Request) (bool, error) {
@@ -47,21 +50,21 @@ func (auth AuthSettings) AuthenticateRequest(r *http.Request) (bool, error) {
 	client := http.Client{}
 	req, err := http.NewRequest(auth.Method, auth.Url, nil)
 	if err != nil {
-		utils.LogOnError(err, "FAILED AUTH", true)
+		utils.Log.WithError(err).Error("FAILED AUTH")
 		return false, err
 	}
 	header := r.Header.Get(auth.Header)
 	if header == "" {
 		err = fmt.Errorf("Missing %q header", auth.Header)
-		utils.LogOnError(err, "FAILED AUTH", true)
+		utils.Log.WithError(err).Error("FAILED AUTH")
 		return false, err
 	}
 	req.Header.Set(auth.Header, header)
 	resp, err := client.Do(req)
 	if err != nil {
-		utils.LogOnError(err, "FAILED AUTH", true)
+		utils.Log.WithError(err).Error("FAILED AUTH")
 		return false, err
 	}
-	isAuthorized := string(resp.StatusCode) == auth.SuccessStatusCode
+	isAuthorized := strconv.Itoa(resp.StatusCode) == auth.SuccessStatusCode
 	return isAuthorized, nil
 }
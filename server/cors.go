@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSPolicy says which cross-origin requests browsers may make to a group of routes.
+type CORSPolicy struct {
+	// AllowedOrigins are the origins allowed to read responses. An origin may hold one "*" standing
+	// for anything, such as "https://*.example.com", and "*" alone allows every origin.
+	AllowedOrigins []string
+	// AllowedHeaders are the request headers a cross-origin request may send, besides the ones browsers
+	// always allow. "*" allows any header.
+	AllowedHeaders []string
+	// AllowCredentials lets browsers send cookies and client certificates, and read the response.
+	AllowCredentials bool
+	// MaxAge is how long, in seconds, browsers may cache a preflight. Zero leaves it to the browser.
+	MaxAge int
+}
+
+// allowsOrigin reports whether the policy allows the origin.
+func (policy CORSPolicy) allowsOrigin(origin string) bool {
+	for _, allowed := range policy.AllowedOrigins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin reports whether the origin matches the pattern, in which "*" stands for anything.
+func matchOrigin(pattern, origin string) bool {
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return pattern == origin
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	return len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+// CORSPolicies are the policies of the public check routes and of the /admin routes.
+type CORSPolicies struct {
+	Public CORSPolicy
+	Admin  CORSPolicy
+}
+
+// CORS applies CORSPolicies to every request, including the preflights answered by the OPTIONS
+// routes. The policies can be replaced while serving.
+type CORS struct {
+	policies atomic.Value
+}
+
+// NewCORS returns CORS applying the policies.
+func NewCORS(policies CORSPolicies) *CORS {
+	cors := &CORS{}
+	cors.Update(policies)
+	return cors
+}
+
+// Update replaces the policies. Requests already being served keep the policies they started with.
+func (cors *CORS) Update(policies CORSPolicies) {
+	cors.policies.Store(policies)
+}
+
+func (cors *CORS) policy(r *http.Request) CORSPolicy {
+	policies := cors.policies.Load().(CORSPolicies)
+	if isAdminRoute(r.URL.Path) {
+		return policies.Admin
+	}
+	return policies.Public
+}
+
+// ServeHTTP is middleware adding the CORS headers the policy of the route gives the request's origin.
+// A request from an origin the policy does not allow gets none, so browsers keep the response from it.
+func (cors *CORS) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	origin := r.Header.Get("Origin")
+	policy := cors.policy(r)
+	w.Header().Add("Vary", "Origin")
+	if origin == "" || !policy.allowsOrigin(origin) {
+		next(w, r)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+		if headers := allowedHeaders(policy, r.Header.Get("Access-Control-Request-Headers")); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		if policy.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+		}
+	}
+	next(w, r)
+}
+
+// allowedHeaders returns the Access-Control-Allow-Headers of a preflight asking for the requested
+// headers.
+func allowedHeaders(policy CORSPolicy, requested string) string {
+	for _, header := range policy.AllowedHeaders {
+		if header == "*" {
+			return requested
+		}
+	}
+	return strings.Join(policy.AllowedHeaders, ", ")
+}
+
+// preflightMethods are the methods preflightHandler looks for routes serving.
+var preflightMethods = []string{"GET", "POST", "PUT", "DELETE"}
+
+// isOptions matches OPTIONS requests. Unlike Methods("OPTIONS"), it does not make a request with
+// another method that no route matches a method mismatch, so it still gets the router's NotFoundHandler.
+func isOptions(r *http.Request, rm *mux.RouteMatch) bool {
+	return r.Method == "OPTIONS"
+}
+
+// preflightHandler answers OPTIONS on any route with the methods the route serves. The CORS
+// middleware adds the rest of a preflight response from the route's policy.
+func preflightHandler(router *mux.Router) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var methods []string
+		for _, method := range preflightMethods {
+			req := r.WithContext(r.Context())
+			req.Method = method
+			var match mux.RouteMatch
+			// With a NotFoundHandler set, Match also succeeds for a path no route serves, so only a
+			// match on a route counts.
+			if router.Match(req, &match) && match.MatchErr == nil && match.Route != nil {
+				methods = append(methods, method)
+			}
+		}
+		if len(methods) == 0 {
+			notFoundHandler(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(append(methods, "OPTIONS"), ", "))
+	})
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/samdfonseca/flipadelphia/store"
+)
+
+// The codes of error responses, which clients can tell errors apart by. The message may change, the
+// code does not.
+const (
+	// ErrCodeInvalidQuery is a query with params the route does not take, or with values it can not parse.
+	ErrCodeInvalidQuery = "invalid_query"
+	// ErrCodeMalformedJSON is a request body that is not JSON.
+	ErrCodeMalformedJSON = "malformed_json"
+	// ErrCodeInvalidEntity is a request body that is JSON, but not of the fields and values the route takes.
+	ErrCodeInvalidEntity = "invalid_entity"
+	// ErrCodeInvalidRequest is any other request the route can not serve, such as a bad page token.
+	ErrCodeInvalidRequest = "invalid_request"
+	ErrCodeNotFound       = "not_found"
+	// ErrCodeConflict is a change that would leave the store inconsistent, such as a scope hierarchy cycle.
+	ErrCodeConflict = "conflict"
+	// ErrCodeForbidden is a request the client is not allowed to make, such as to /admin without a
+	// client certificate.
+	ErrCodeForbidden   = "forbidden"
+	ErrCodeRateLimited = "rate_limited"
+	// ErrCodeUnavailable is a request that could not be served because the store could not be reached.
+	// It may succeed if retried.
+	ErrCodeUnavailable = "unavailable"
+	// ErrCodeCanceled is a request the client gave up on before it was served. The client is usually
+	// gone by the time it is written.
+	ErrCodeCanceled      = "canceled"
+	ErrCodeUnimplemented = "unimplemented"
+	ErrCodeInternal      = "internal_error"
+)
+
+// statusClientClosedRequest is the status of a request the client gave up on, as logged by nginx. The
+// client does not see it, but the access log does.
+const statusClientClosedRequest = 499
+
+// ErrorResponse is the body of every error response.
+//
+// Ex:
+//
+//	{"error": {"code": "not_found", "message": "scope not found", "request_id": "..."}}
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes what went wrong. RequestID is the request's X-Request-Id, which its log lines
+// carry too.
+type ErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// WriteError writes the status code and an ErrorResponse with the code and message.
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	body, _ := json.Marshal(ErrorResponse{ErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: w.Header().Get(requestIDHeader),
+	}})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// isAny reports whether err is, or wraps, one of the targets.
+func isAny(err error, targets ...error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteStoreError writes the status code and error response for an error returned by a
+// PersistenceStoreV2. Errors that are not the client's fault are also logged, with the request's
+// fields, and only those the client can act on are described in the response.
+func WriteStoreError(err error, w http.ResponseWriter, r *http.Request) {
+	switch {
+	case isAny(err, store.ErrScopeNotFound, store.ErrFeatureNotFound, store.ErrSegmentNotFound, store.ErrScheduleNotFound,
+		store.ErrOverrideNotFound, store.ErrExperimentNotFound):
+		WriteError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case isAny(err, context.Canceled):
+		logger(r).WithError(err).Info("Request canceled by the client")
+		WriteError(w, statusClientClosedRequest, ErrCodeCanceled, "Request canceled")
+	case isAny(err, store.ErrStoreUnavailable, context.DeadlineExceeded):
+		logger(r).WithError(err).Warn("Store unavailable")
+		WriteError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, store.ErrStoreUnavailable.Error())
+	case isAny(err, store.ErrInvalidPageToken, store.ErrInvalidPageLimit, store.ErrInvalidSearch):
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+	case isAny(err, store.ErrScopeCycle, store.ErrPrerequisiteCycle):
+		WriteError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+	case isAny(err, store.ErrUnimplemented):
+		WriteError(w, http.StatusNotImplemented, ErrCodeUnimplemented, err.Error())
+	default:
+		logger(r).WithError(err).Error("Store error")
+		WriteError(w, http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+	}
+}
+
+// writeUnrecognizedQuery writes the error response for a query the route does not take.
+func writeUnrecognizedQuery(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, fmt.Sprintf("Unrecognized query: %q", r.Form.Encode()))
+}
+
+// writeInvalidEntity writes the error response for a request body with a field the route can not take.
+func writeInvalidEntity(w http.ResponseWriter, format string, args ...interface{}) {
+	WriteError(w, http.StatusUnprocessableEntity, ErrCodeInvalidEntity, fmt.Sprintf(format, args...))
+}
+
+// readJSONBody decodes the JSON request body into v. If it can not, it writes a 400 for a body that is
+// not JSON, or a 422 for JSON of the wrong types, and returns false.
+func readJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "Error reading request body")
+		return false
+	}
+	err = json.Unmarshal(body, v)
+	switch err.(type) {
+	case nil:
+		return true
+	case *json.UnmarshalTypeError:
+		writeInvalidEntity(w, "Unprocessable entity: %s", err)
+	default:
+		WriteError(w, http.StatusBadRequest, ErrCodeMalformedJSON, fmt.Sprintf("Malformed JSON: %s", err))
+	}
+	return false
+}
+
+// notFoundHandler answers requests no route matches.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("No route for %s %s", r.Method, r.URL.Path))
+}
@@ -1,50 +1,223 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"log"
 	"net/http"
+	"regexp"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/codegangsta/negroni"
 	"github.com/gorilla/mux"
+	"github.com/samdfonseca/flipadelphia/impressions"
+	"github.com/samdfonseca/flipadelphia/metrics"
 	"github.com/samdfonseca/flipadelphia/store"
 	"github.com/samdfonseca/flipadelphia/utils"
+	"github.com/sirupsen/logrus"
 )
 
 func ClassicNegroniStack() *negroni.Negroni {
-	return negroni.Classic()
+	recovery := negroni.NewRecovery()
+	recovery.Logger = log.New(utils.Log.WriterLevel(logrus.ErrorLevel), "", 0)
+	return negroni.New(recovery, negroni.NewStatic(http.Dir("public")))
 }
 
-func App(db store.PersistenceStore, n *negroni.Negroni) http.Handler {
+// ImpressionRecorder is given an impression for every feature check the App serves.
+type ImpressionRecorder interface {
+	Record(impressions.Impression)
+}
+
+// impressionRecorders passes each impression on to every recorder in the list.
+type impressionRecorders []ImpressionRecorder
+
+func (recorders impressionRecorders) Record(impression impressions.Impression) {
+	for _, rec := range recorders {
+		rec.Record(impression)
+	}
+}
+
+// Options configures the optional parts of the App.
+type Options struct {
+	// Impressions records feature checks, if set.
+	Impressions ImpressionRecorder
+	// Usage counts feature checks, if set.
+	Usage ImpressionRecorder
+	// Metrics are served at /metrics, and count every request, if set.
+	Metrics *metrics.Metrics
+	// RequireAdminClientCert refuses requests to /admin routes made without a verified client
+	// certificate, if set. The server's TLS config must ask for one, see NewTLSConfig.
+	RequireAdminClientCert bool
+	// CORS applies the CORS policies of the public and /admin routes. Without it, no cross-origin
+	// request is allowed.
+	CORS *CORS
+	// RateLimiter refuses requests from clients over the rate limit of the route, if set.
+	RateLimiter *RateLimiter
+}
+
+// App returns the Flipadelphia routes served through the negroni stack, with the default Options.
+func App(db store.PersistenceStoreV2, n *negroni.Negroni) http.Handler {
+	return NewApp(db, n, Options{})
+}
+
+// NewApp returns the Flipadelphia routes served through the negroni stack.
+func NewApp(db store.PersistenceStoreV2, n *negroni.Negroni, opts Options) http.Handler {
+	var rec impressionRecorders
+	for _, r := range []ImpressionRecorder{opts.Impressions, opts.Usage} {
+		if r != nil {
+			rec = append(rec, r)
+		}
+	}
 	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
 	router.HandleFunc("/", homeHandler)
-
-	// c := cors.New(cors.Options{
-	// 	AllowedOrigins: "*",
+	// GET /healthz
+	router.HandleFunc("/healthz", healthzHandler).
+		Methods("GET")
+	// GET /readyz
+	router.HandleFunc("/readyz", readyzHandler(db)).
+		Methods("GET")
+	n.Use(requestLogging(router))
+	if opts.Metrics != nil {
+		// GET /metrics
+		router.Handle("/metrics", opts.Metrics.Handler()).
+			Methods("GET")
+		n.Use(requestMetrics(router, opts.Metrics))
+	}
+	cors := opts.CORS
+	if cors == nil {
+		cors = NewCORS(CORSPolicies{})
+	}
+	n.Use(cors)
+	if opts.RateLimiter != nil {
+		n.Use(opts.RateLimiter)
+	}
+	if opts.RequireAdminClientCert {
+		n.UseFunc(requireAdminClientCert)
+	}
 
 	// GET /features?scope=...&value=...
-	router.HandleFunc("/features", checkScopeFeaturesForValueHandler(db)).
+	router.HandleFunc("/features", checkScopeFeaturesForValueHandler(db, rec)).
 		Methods("GET").
 		Queries("scope", "{scope:[0-9A-Za-z_-]+}", "value", "{value:[0-9A-Za-z_-]+}")
 	// GET /features?scope=...
-	router.HandleFunc("/features", checkAllScopeFeaturesHandler(db)).
+	router.HandleFunc("/features", checkAllScopeFeaturesHandler(db, rec)).
 		Methods("GET").
 		Queries("scope", "{scope:[0-9A-Za-z_-]+}")
 	// GET /scopes/{scope_name}
-	router.HandleFunc("/scopes/{scope_name}", checkAllScopeFeaturesHandler(db)).
+	router.HandleFunc("/scopes/{scope_name}", checkAllScopeFeaturesHandler(db, rec)).
 		Methods("GET").
 		Queries("scope", "{scope_name:[0-9A-Za-z_-]+}")
 	// GET /features/{feature_name}?scope=...
-	router.HandleFunc("/features/{feature_name}", checkFeatureHandler(db)).
+	router.HandleFunc("/features/{feature_name}", checkFeatureHandler(db, rec)).
+		Methods("GET").
+		Queries("scope", "{scope:[0-9A-Za-z_-]+}")
+	// GET /experiments/{feature_name}?scope=...
+	router.HandleFunc("/experiments/{feature_name}", assignVariantHandler(db, rec)).
 		Methods("GET").
 		Queries("scope", "{scope:[0-9A-Za-z_-]+}")
 
 	// POST /admin/features/{feature_name}
 	router.HandleFunc("/admin/features/{feature_name}", setFeatureHandler(db)).
 		Methods("POST")
+	// POST /admin/scopes/{scope}/copy
+	router.HandleFunc("/admin/scopes/{scope:[0-9A-Za-z_-]+}/copy", copyScopeHandler(db)).
+		Methods("POST")
+	// GET /admin/scopes/{scope}/parents
+	router.HandleFunc("/admin/scopes/{scope:[0-9A-Za-z_-]+}/parents", getScopeParentsHandler(db)).
+		Methods("GET")
+	// POST /admin/scopes/{scope}/parents
+	router.HandleFunc("/admin/scopes/{scope:[0-9A-Za-z_-]+}/parents", setScopeParentsHandler(db)).
+		Methods("POST")
+	// GET /admin/scopes/{scope}/effective
+	router.HandleFunc("/admin/scopes/{scope:[0-9A-Za-z_-]+}/effective", getEffectiveScopeFeaturesHandler(db)).
+		Methods("GET")
+	// GET /admin/segments
+	router.HandleFunc("/admin/segments", getSegmentsHandler(db)).
+		Methods("GET")
+	// GET /admin/segments/{segment}
+	router.HandleFunc("/admin/segments/{segment:[0-9A-Za-z_-]+}", getSegmentHandler(db)).
+		Methods("GET")
+	// POST /admin/segments/{segment}
+	router.HandleFunc("/admin/segments/{segment:[0-9A-Za-z_-]+}", setSegmentHandler(db)).
+		Methods("POST")
+	// DELETE /admin/segments/{segment}
+	router.HandleFunc("/admin/segments/{segment:[0-9A-Za-z_-]+}", deleteSegmentHandler(db)).
+		Methods("DELETE")
+	// POST /admin/segments/{segment}/features/{feature_name}
+	router.HandleFunc("/admin/segments/{segment:[0-9A-Za-z_-]+}/features/{feature_name}", setSegmentFeatureHandler(db)).
+		Methods("POST")
+	// GET /admin/schedules
+	router.HandleFunc("/admin/schedules", getSchedulesHandler(db)).
+		Methods("GET")
+	// POST /admin/schedules
+	router.HandleFunc("/admin/schedules", addScheduleHandler(db)).
+		Methods("POST")
+	// DELETE /admin/schedules/{schedule_id}
+	router.HandleFunc("/admin/schedules/{schedule_id}", deleteScheduleHandler(db)).
+		Methods("DELETE")
+	// GET /admin/experiments/{feature_name}
+	router.HandleFunc("/admin/experiments/{feature_name}", getExperimentHandler(db)).
+		Methods("GET")
+	// POST /admin/experiments/{feature_name}
+	router.HandleFunc("/admin/experiments/{feature_name}", setExperimentHandler(db)).
+		Methods("POST")
+	// DELETE /admin/experiments/{feature_name}
+	router.HandleFunc("/admin/experiments/{feature_name}", deleteExperimentHandler(db)).
+		Methods("DELETE")
+	// POST /admin/experiments/{feature_name}/reshuffle
+	router.HandleFunc("/admin/experiments/{feature_name}/reshuffle", reshuffleExperimentHandler(db)).
+		Methods("POST")
+	// GET /admin/features/{feature_name}/prerequisites
+	router.HandleFunc("/admin/features/{feature_name}/prerequisites", getPrerequisitesHandler(db)).
+		Methods("GET")
+	// POST /admin/features/{feature_name}/prerequisites
+	router.HandleFunc("/admin/features/{feature_name}/prerequisites", setPrerequisitesHandler(db)).
+		Methods("POST")
+	// GET /admin/features/{feature_name}/override
+	router.HandleFunc("/admin/features/{feature_name}/override", getOverrideHandler(db)).
+		Methods("GET")
+	// POST /admin/features/{feature_name}/override
+	router.HandleFunc("/admin/features/{feature_name}/override", setOverrideHandler(db)).
+		Methods("POST")
+	// DELETE /admin/features/{feature_name}/override
+	router.HandleFunc("/admin/features/{feature_name}/override", deleteOverrideHandler(db)).
+		Methods("DELETE")
+	// GET /admin/features/{feature_name}/summary
+	router.HandleFunc("/admin/features/{feature_name}/summary", featureSummaryHandler(db)).
+		Methods("GET")
+	// GET /admin/features/{feature_name}/usage
+	router.HandleFunc("/admin/features/{feature_name}/usage", featureUsageHandler(db)).
+		Methods("GET")
+	// GET /admin/features/stale?days=...
+	router.HandleFunc("/admin/features/stale", staleFeaturesHandler(db)).
+		Methods("GET")
+	// GET /admin/features?match=...&regex=...&contains=...&value=...&scope_prefix=...
+	router.HandleFunc("/admin/features", searchFeaturesHandler(db)).
+		Methods("GET").
+		MatcherFunc(hasAnyQuery("match", "regex", "contains", "value", "scope_prefix"))
+	// GET /admin/scopes?match=...&regex=...&contains=...&feature=...&value=...
+	router.HandleFunc("/admin/scopes", searchScopesHandler(db)).
+		Methods("GET").
+		MatcherFunc(hasAnyQuery("match", "regex", "contains", "value"))
+	// GET /admin/scopes?feature=...&limit=...&after=...
+	router.HandleFunc("/admin/scopes", getScopesWithFeaturePageHandler(db)).
+		Methods("GET").
+		Queries("feature", "{feature:.+}", "limit", "{limit:[0-9]+}")
+	// GET /admin/scopes?limit=...&after=...
+	router.HandleFunc("/admin/scopes", getScopesPageHandler(db)).
+		Methods("GET").
+		Queries("limit", "{limit:[0-9]+}")
+	// GET /admin/features?limit=...&after=...
+	router.HandleFunc("/admin/features", getFeaturesPageHandler(db)).
+		Methods("GET").
+		Queries("limit", "{limit:[0-9]+}")
+	// GET /admin/scopes/{scope}/features?limit=...&after=...
+	router.HandleFunc("/admin/scopes/{scope:[0-9A-Za-z_-]+}/features", getScopeFeaturesFullPageHandler(db)).
+		Methods("GET").
+		Queries("limit", "{limit:[0-9]+}")
 	// GET /admin/scopes?prefix=...
 	router.HandleFunc("/admin/scopes", getScopesWithPrefixHandler(db)).
 		Methods("GET").
@@ -75,18 +248,11 @@ func App(db store.PersistenceStore, n *negroni.Negroni) http.Handler {
 	router.HandleFunc("/admin/scopes/{scope:[0-9A-Za-z_-]+}/features", getScopeFeaturesFullHandler(db)).
 		Methods("GET")
 
-	router.HandleFunc("/features", allowCORSHandler("GET", "OPTIONS")).
-		Methods("OPTIONS")
-	router.HandleFunc("/features/{feature_name}", allowCORSHandler("GET", "OPTIONS")).
-		Methods("OPTIONS")
-	router.HandleFunc("/admin/features/{feature_name}", allowCORSHandler("POST", "OPTIONS")).
-		Methods("OPTIONS")
-	router.HandleFunc("/admin/scopes", allowCORSHandler("GET", "OPTIONS")).
-		Methods("OPTIONS")
-	router.HandleFunc("/admin/features", allowCORSHandler("GET", "OPTIONS")).
-		Methods("OPTIONS")
-
-	n.UseFunc(allowCORSOnRequestOrigin)
+	addV2Routes(router, db, rec)
+
+	// OPTIONS on every route
+	router.MatcherFunc(isOptions).HandlerFunc(preflightHandler(router))
+
 	n.UseFunc(responseContentTypeJson)
 	n.UseHandler(router)
 	return n
@@ -95,7 +261,21 @@ func App(db store.PersistenceStore, n *negroni.Negroni) http.Handler {
 func WriteResponseBody(s store.Serializable, w http.ResponseWriter) {
 	body, err := json.Marshal(map[string]store.Serializable{"data": s})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, http.StatusInternalServerError, ErrCodeInternal, "Unable to encode response")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// WritePageResponseBody writes one page of a cursor paginated listing along with the token for the next page.
+func WritePageResponseBody(s store.Serializable, next string, w http.ResponseWriter) {
+	body, err := json.Marshal(struct {
+		Data store.Serializable `json:"data"`
+		Next string             `json:"next,omitempty"`
+	}{s, next})
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, ErrCodeInternal, "Unable to encode response")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -106,199 +286,703 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("flipadelphia flips your features"))
 }
 
-func responseContentTypeJson(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	next(w, r)
+// readinessTimeout bounds how long a readiness check waits on the store.
+const readinessTimeout = 5 * time.Second
+
+// healthStatus is the body of the health and readiness checks.
+type healthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
 
-func allowCORSOnRequestOrigin(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	if origin := r.Header.Get("Origin"); origin != "" {
-		w.Header().Set("Access-Control-Allow-Origin", origin)
+// writeHealthStatus writes the status code and a healthStatus for the error, if any.
+func writeHealthStatus(err error, w http.ResponseWriter) {
+	status, code := healthStatus{Status: "ok"}, http.StatusOK
+	if err != nil {
+		status, code = healthStatus{Status: "unavailable", Error: err.Error()}, http.StatusServiceUnavailable
 	}
+	body, _ := json.Marshal(status)
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// Handler for GET to "/healthz". The process is up if it can answer.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthStatus(nil, w)
+}
+
+// Handler for GET to "/readyz". The server is ready when its store can be reached.
+func readyzHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		err := db.Ping(ctx)
+		if err != nil {
+			logger(r).WithError(err).Warn("Readiness check failed")
+		}
+		writeHealthStatus(err, w)
+	})
+}
+
+func responseContentTypeJson(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	next(w, r)
 }
 
 // Handler for GET to "/features/{feature_name}?scope=..."
-func checkFeatureHandler(db store.PersistenceStore) http.HandlerFunc {
+func checkFeatureHandler(db store.PersistenceStoreV2, rec ImpressionRecorder) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		defer r.Body.Close()
 		if len(r.Form) != 1 {
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte(fmt.Sprintf("Unrecognized query: %q", r.Form.Encode())))
+			writeUnrecognizedQuery(w, r)
 			return
 		}
 		vars := mux.Vars(r)
 		scope := r.FormValue("scope")
 		feature_name := vars["feature_name"]
-		if featureHasScope := db.CheckFeatureHasScope([]byte(scope), []byte(feature_name)); !featureHasScope {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		feature, err := db.Get([]byte(scope), []byte(feature_name))
+		feature, err := store.ResolveFeature(r.Context(), db, []byte(scope), []byte(feature_name))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			WriteStoreError(err, w, r)
 			return
 		}
+		rec.Record(impressions.Impression{Feature: feature_name, Scope: scope, Value: feature.Value, Source: feature.Source})
 		WriteResponseBody(feature, w)
 	})
 }
 
 // Handler for GET to "/features?scope=..." and "/scopes/{scopes_name}"
-func checkAllScopeFeaturesHandler(db store.PersistenceStore) http.HandlerFunc {
+func checkAllScopeFeaturesHandler(db store.PersistenceStoreV2, rec ImpressionRecorder) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		defer r.Body.Close()
 		if len(r.Form) != 1 {
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte(fmt.Sprintf("Unrecognized query: %q", r.Form.Encode())))
+			writeUnrecognizedQuery(w, r)
 			return
 		}
 		scope := r.FormValue("scope")
-		if scopeExists := db.CheckScopeExists([]byte(scope)); !scopeExists {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		features, err := db.GetScopeFeatures([]byte(scope))
+		features, err := resolveScopeFeatures(r.Context(), db, scope, "")
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			WriteStoreError(err, w, r)
 			return
 		}
-		WriteResponseBody(features, w)
+		for _, feature := range features {
+			rec.Record(impressions.Impression{Feature: feature.Name, Scope: scope, Value: feature.Value, Source: feature.Source})
+		}
+		WriteResponseBody(featureNames(features), w)
 	})
 }
 
 // Handler for GET to "/features?scope=...&value=..."
-func checkScopeFeaturesForValueHandler(db store.PersistenceStore) http.HandlerFunc {
+func checkScopeFeaturesForValueHandler(db store.PersistenceStoreV2, rec ImpressionRecorder) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		defer r.Body.Close()
 		if len(r.Form) != 2 {
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte(fmt.Sprintf("Unrecognized query: %q", r.Form.Encode())))
+			writeUnrecognizedQuery(w, r)
 			return
 		}
 		scope := r.FormValue("scope")
 		value := r.FormValue("value")
-		if scopeExists := db.CheckScopeExists([]byte(scope)); !scopeExists {
-			w.WriteHeader(http.StatusNotFound)
+		features, err := resolveScopeFeatures(r.Context(), db, scope, value)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		for _, feature := range features {
+			rec.Record(impressions.Impression{Feature: feature.Name, Scope: scope, Value: feature.Value, Source: feature.Source})
+		}
+		WriteResponseBody(featureNames(features), w)
+	})
+}
+
+// resolveScopeFeatures returns every feature the scope sets or inherits, with the value a check of it
+// would return. When value is not empty, only the features resolving to it are kept.
+func resolveScopeFeatures(ctx context.Context, db store.PersistenceStoreV2, scope, value string) (store.ResolvedFeatures, error) {
+	features, err := store.ResolveScopeFeatures(ctx, db, []byte(scope))
+	if err != nil {
+		return nil, err
+	}
+	matching := store.ResolvedFeatures{}
+	for _, feature := range features {
+		if value == "" || feature.Value == value {
+			matching = append(matching, feature)
+		}
+	}
+	return matching, nil
+}
+
+// featureNames returns the names of the features.
+func featureNames(features store.ResolvedFeatures) store.FlipadelphiaScopeFeatures {
+	names := store.FlipadelphiaScopeFeatures{}
+	for _, feature := range features {
+		names = append(names, feature.Name)
+	}
+	return names
+}
+
+// Handler for POST to "/admin/features/{feature_name}"
+func setFeatureHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		var setFeatureOptions store.FlipadelphiaSetFeatureOptions
+		if !readJSONBody(w, r, &setFeatureOptions) {
+			return
+		}
+		setFeatureOptions.Key = vars["feature_name"]
+		if scope, ok := vars["scope"]; ok {
+			setFeatureOptions.Scope = scope
+		}
+		var expiresAt time.Time
+		switch {
+		case setFeatureOptions.TTL != 0 && setFeatureOptions.ExpiresAt != nil:
+			writeInvalidEntity(w, "Only one of ttl and expires_at may be set")
+			return
+		case setFeatureOptions.TTL < 0:
+			writeInvalidEntity(w, "Invalid ttl: %d", setFeatureOptions.TTL)
 			return
+		case setFeatureOptions.TTL > 0:
+			expiresAt = time.Now().Add(time.Duration(setFeatureOptions.TTL) * time.Second)
+		case setFeatureOptions.ExpiresAt != nil:
+			if !setFeatureOptions.ExpiresAt.After(time.Now()) {
+				writeInvalidEntity(w, "Invalid expires_at: %s is not in the future", setFeatureOptions.ExpiresAt.Format(time.RFC3339))
+				return
+			}
+			expiresAt = *setFeatureOptions.ExpiresAt
+		}
+		var set setFeatureResponse
+		var err error
+		if expiresAt.IsZero() {
+			set.FlipadelphiaFeature, err = db.Set(r.Context(), []byte(setFeatureOptions.Scope), []byte(setFeatureOptions.Key), []byte(setFeatureOptions.Value))
+		} else {
+			set.FlipadelphiaFeature, err = db.SetWithExpiry(r.Context(), []byte(setFeatureOptions.Scope), []byte(setFeatureOptions.Key), []byte(setFeatureOptions.Value), expiresAt)
 		}
-		features, err := db.GetScopeFeaturesFilterByValue([]byte(scope), []byte(value))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			WriteStoreError(err, w, r)
+			return
+		}
+		override, err := db.GetOverride(r.Context(), []byte(setFeatureOptions.Key))
+		switch err {
+		case nil:
+			set.Override = &override.Value
+		case store.ErrOverrideNotFound:
+		default:
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(set, w)
+	})
+}
+
+// setFeatureResponse is a feature as it was set on a scope. Override is the value of the feature's
+// override, if it has one, which checks of the feature return instead.
+type setFeatureResponse struct {
+	store.FlipadelphiaFeature
+	Override *string `json:"override,omitempty"`
+}
+
+// Serialize returns the setFeatureResponse as json.
+func (set setFeatureResponse) Serialize() []byte {
+	serialized, err := json.Marshal(set)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize set feature")
+		return []byte("")
+	}
+	return serialized
+}
+
+// validScopeName matches the scope names accepted by the scope routes.
+var validScopeName = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// Handler for POST to "/admin/scopes/{scope}/copy"
+func copyScopeHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		var copyOptions store.CopyScopeOptions
+		if !readJSONBody(w, r, &copyOptions) {
+			return
+		}
+		if !validScopeName.MatchString(copyOptions.Target) || copyOptions.Target == vars["scope"] {
+			writeInvalidEntity(w, "Invalid target scope: %q", copyOptions.Target)
+			return
+		}
+		copied, err := db.CopyScope(r.Context(), []byte(vars["scope"]), copyOptions)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(copied, w)
+	})
+}
+
+// Handler for GET to "/admin/scopes/{scope}/parents"
+func getScopeParentsHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		defer r.Body.Close()
+		if len(r.Form) != 0 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		vars := mux.Vars(r)
+		parents, err := db.GetScopeParents(r.Context(), []byte(vars["scope"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(append(store.FlipadelphiaScopeList{}, parents...), w)
+	})
+}
+
+// Handler for POST to "/admin/scopes/{scope}/parents"
+func setScopeParentsHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		var setParentsOptions struct {
+			Parents []string `json:"parents"`
+		}
+		if !readJSONBody(w, r, &setParentsOptions) {
+			return
+		}
+		for _, parent := range setParentsOptions.Parents {
+			if !validScopeName.MatchString(parent) {
+				writeInvalidEntity(w, "Invalid parent scope: %q", parent)
+				return
+			}
+		}
+		err := db.SetScopeParents(r.Context(), []byte(vars["scope"]), setParentsOptions.Parents)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(append(store.FlipadelphiaScopeList{}, setParentsOptions.Parents...), w)
+	})
+}
+
+// Handler for GET to "/admin/scopes/{scope}/effective"
+func getEffectiveScopeFeaturesHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		defer r.Body.Close()
+		if len(r.Form) != 0 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		vars := mux.Vars(r)
+		features, err := store.ResolveScopeFeatures(r.Context(), db, []byte(vars["scope"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
 			return
 		}
 		WriteResponseBody(features, w)
 	})
 }
 
-// Handler for POST to "/admin/features/{feature_name}"
-func setFeatureHandler(db store.PersistenceStore) http.HandlerFunc {
+// Handler for GET to "/admin/segments"
+func getSegmentsHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		defer r.Body.Close()
+		if len(r.Form) != 0 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		segments, err := db.GetSegments(r.Context())
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(segments, w)
+	})
+}
+
+// Handler for GET to "/admin/segments/{segment}"
+func getSegmentHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		defer r.Body.Close()
+		if len(r.Form) != 0 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		vars := mux.Vars(r)
+		segment, err := db.GetSegment(r.Context(), []byte(vars["segment"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(segment, w)
+	})
+}
+
+// Handler for POST to "/admin/segments/{segment}"
+func setSegmentHandler(db store.PersistenceStoreV2) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		defer r.Body.Close()
-		body, err := ioutil.ReadAll(r.Body)
+		var segment store.Segment
+		if !readJSONBody(w, r, &segment) {
+			return
+		}
+		if len(segment.Members) == 0 && segment.Prefix == "" {
+			writeInvalidEntity(w, "Segment needs members or a prefix")
+			return
+		}
+		for _, member := range segment.Members {
+			if !validScopeName.MatchString(member) {
+				writeInvalidEntity(w, "Invalid member scope: %q", member)
+				return
+			}
+		}
+		segment.Name = vars["segment"]
+		err := db.SetSegment(r.Context(), segment)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Error reading request body"))
+			WriteStoreError(err, w, r)
 			return
 		}
-		var setFeatureOptions store.FlipadelphiaSetFeatureOptions
-		err = json.Unmarshal(body, &setFeatureOptions)
+		segment, err = db.GetSegment(r.Context(), []byte(segment.Name))
 		if err != nil {
-			w.WriteHeader(http.StatusNotAcceptable)
-			errMsg := fmt.Sprintf("Unprocessable entity: %s", err.Error())
-			w.Write([]byte(errMsg))
+			WriteStoreError(err, w, r)
 			return
 		}
-		setFeatureOptions.Key = vars["feature_name"]
-		_, err = db.Set([]byte(setFeatureOptions.Scope), []byte(setFeatureOptions.Key), []byte(setFeatureOptions.Value))
+		WriteResponseBody(segment, w)
+	})
+}
+
+// Handler for DELETE to "/admin/segments/{segment}"
+func deleteSegmentHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		err := db.DeleteSegment(r.Context(), []byte(vars["segment"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Handler for POST to "/admin/segments/{segment}/features/{feature_name}"
+func setSegmentFeatureHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		var setFeatureOptions store.FlipadelphiaSetFeatureOptions
+		if !readJSONBody(w, r, &setFeatureOptions) {
+			return
+		}
+		feature, err := db.SetSegmentFeature(r.Context(), []byte(vars["segment"]), []byte(vars["feature_name"]), []byte(setFeatureOptions.Value))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			WriteStoreError(err, w, r)
 			return
 		}
-		feature, _ := db.Get([]byte(setFeatureOptions.Scope), []byte(setFeatureOptions.Key))
 		WriteResponseBody(feature, w)
 	})
 }
 
+// Handler for GET to "/admin/schedules"
+func getSchedulesHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		defer r.Body.Close()
+		if len(r.Form) != 0 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		changes, err := db.GetSchedules(r.Context())
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(changes, w)
+	})
+}
+
+// Handler for POST to "/admin/schedules"
+func addScheduleHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var change store.ScheduledChange
+		if !readJSONBody(w, r, &change) {
+			return
+		}
+		if !validScopeName.MatchString(change.Scope) || change.Feature == "" || change.RunAt.IsZero() {
+			writeInvalidEntity(w, "Scheduled change needs a scope, feature and run_at")
+			return
+		}
+		change, err := db.AddSchedule(r.Context(), change)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(change, w)
+	})
+}
+
+// Handler for DELETE to "/admin/schedules/{schedule_id}"
+func deleteScheduleHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		err := db.DeleteSchedule(r.Context(), []byte(vars["schedule_id"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Handler for GET to "/experiments/{feature_name}?scope=..."
+func assignVariantHandler(db store.PersistenceStoreV2, rec ImpressionRecorder) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		defer r.Body.Close()
+		if len(r.Form) != 1 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		vars := mux.Vars(r)
+		assignment, err := db.AssignVariant(r.Context(), []byte(vars["feature_name"]), []byte(r.FormValue("scope")))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		rec.Record(impressions.Impression{Feature: assignment.Feature, Scope: assignment.Scope, Variant: assignment.Variant})
+		WriteResponseBody(assignment, w)
+	})
+}
+
+// Handler for GET to "/admin/experiments/{feature_name}"
+func getExperimentHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		experiment, err := db.GetExperiment(r.Context(), []byte(vars["feature_name"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(experiment, w)
+	})
+}
+
+// Handler for POST to "/admin/experiments/{feature_name}"
+func setExperimentHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		var experiment store.Experiment
+		if !readJSONBody(w, r, &experiment) {
+			return
+		}
+		if err := experiment.Validate(); err != nil {
+			writeInvalidEntity(w, "Invalid experiment: %s", err)
+			return
+		}
+		experiment.Feature = vars["feature_name"]
+		experiment, err := db.SetExperiment(r.Context(), experiment)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(experiment, w)
+	})
+}
+
+// Handler for DELETE to "/admin/experiments/{feature_name}"
+func deleteExperimentHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		err := db.DeleteExperiment(r.Context(), []byte(vars["feature_name"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Handler for POST to "/admin/experiments/{feature_name}/reshuffle"
+func reshuffleExperimentHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		experiment, err := db.ReshuffleExperiment(r.Context(), []byte(vars["feature_name"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(experiment, w)
+	})
+}
+
+// Handler for GET to "/admin/features/{feature_name}/prerequisites"
+func getPrerequisitesHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		defer r.Body.Close()
+		if len(r.Form) != 0 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		vars := mux.Vars(r)
+		prerequisites, err := db.GetPrerequisites(r.Context(), []byte(vars["feature_name"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(append(store.StringSlice{}, prerequisites...), w)
+	})
+}
+
+// Handler for POST to "/admin/features/{feature_name}/prerequisites"
+func setPrerequisitesHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		var setPrerequisitesOptions struct {
+			Prerequisites []string `json:"prerequisites"`
+		}
+		if !readJSONBody(w, r, &setPrerequisitesOptions) {
+			return
+		}
+		for _, prerequisite := range setPrerequisitesOptions.Prerequisites {
+			if prerequisite == "" {
+				writeInvalidEntity(w, "Invalid prerequisite: %q", prerequisite)
+				return
+			}
+		}
+		err := db.SetPrerequisites(r.Context(), []byte(vars["feature_name"]), setPrerequisitesOptions.Prerequisites)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(append(store.StringSlice{}, setPrerequisitesOptions.Prerequisites...), w)
+	})
+}
+
+// Handler for GET to "/admin/features/{feature_name}/override"
+func getOverrideHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		override, err := db.GetOverride(r.Context(), []byte(vars["feature_name"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(override, w)
+	})
+}
+
+// Handler for POST to "/admin/features/{feature_name}/override"
+func setOverrideHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		var setFeatureOptions store.FlipadelphiaSetFeatureOptions
+		if !readJSONBody(w, r, &setFeatureOptions) {
+			return
+		}
+		override, err := db.SetOverride(r.Context(), []byte(vars["feature_name"]), []byte(setFeatureOptions.Value))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		auditLog(r, "SET OVERRIDE", fmt.Sprintf("%s=%q", override.Name, override.Value))
+		WriteResponseBody(override, w)
+	})
+}
+
+// Handler for DELETE to "/admin/features/{feature_name}/override"
+func deleteOverrideHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		defer r.Body.Close()
+		err := db.DeleteOverride(r.Context(), []byte(vars["feature_name"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		auditLog(r, "DELETE OVERRIDE", vars["feature_name"])
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
 // Handler for GET to "/admin/scopes"
-func getScopesHandler(db store.PersistenceStore) http.HandlerFunc {
+func getScopesHandler(db store.PersistenceStoreV2) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		if len(r.Form) != 0 {
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte(fmt.Sprintf("Unrecognized query: %q", r.Form.Encode())))
+			writeUnrecognizedQuery(w, r)
 			return
 		}
-		scopes, err := db.GetScopes()
+		scopes, err := db.GetScopes(r.Context())
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("%s", err)))
+			WriteStoreError(err, w, r)
 			return
 		}
-		WriteResponseBody(scopes, w)
+		WriteResponseBody(store.FlipadelphiaScopeList(scopes), w)
 	})
 }
 
 // Handler for GET to "/admin/scopes?prefix=..."
-func getScopesWithPrefixHandler(db store.PersistenceStore) http.HandlerFunc {
+func getScopesWithPrefixHandler(db store.PersistenceStoreV2) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if len(r.Form) != 1 {
 			r.Form.Del("prefix")
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte(fmt.Sprintf("Unrecognized query: %q", r.Form.Encode())))
+			writeUnrecognizedQuery(w, r)
 			return
 		}
 		vars := mux.Vars(r)
 		prefix := vars["prefix"]
-		scopes, err := db.GetScopesWithPrefix([]byte(prefix))
+		scopes, err := db.GetScopesWithPrefix(r.Context(), []byte(prefix))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("%s", err)))
+			WriteStoreError(err, w, r)
 			return
 		}
-		WriteResponseBody(scopes, w)
+		WriteResponseBody(store.FlipadelphiaScopeList(scopes), w)
 	})
 }
 
 // Handler for GET to "/admin/scopes?feature=..."
-func getScopesWithFeatureHandler(db store.PersistenceStore) http.HandlerFunc {
+func getScopesWithFeatureHandler(db store.PersistenceStoreV2) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		if len(r.Form) != 1 {
 			r.Form.Del("feature")
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte(fmt.Sprintf("Unrecognized query: %q", r.Form.Encode())))
+			writeUnrecognizedQuery(w, r)
 			return
 		}
 		vars := mux.Vars(r)
 		feature := vars["feature"]
-		scopes, err := db.GetScopesWithFeature([]byte(feature))
+		scopes, err := db.GetScopesWithFeature(r.Context(), []byte(feature))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("%s", err)))
+			WriteStoreError(err, w, r)
 			return
 		}
-		WriteResponseBody(scopes, w)
+		WriteResponseBody(store.FlipadelphiaScopeList(scopes), w)
 	})
 }
 
-func getScopesPaginatedHandler(db store.PersistenceStore) http.HandlerFunc {
+func getScopesPaginatedHandler(db store.PersistenceStoreV2) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		if len(r.Form) != 1 && len(r.Form) != 2 {
-			utils.Output(fmt.Sprintf("len(r.Form) = %s", len(r.Form)))
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte(fmt.Sprintf("Unrecognized query: %q", r.Form.Encode())))
+			logger(r).WithField("params", len(r.Form)).Debug("Unexpected number of query params")
+			writeUnrecognizedQuery(w, r)
 			return
 		}
 
 		count, err := strconv.Atoi(r.FormValue("count"))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("Unable to parse 'count' param in query: %q", r.Form.Encode())))
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, fmt.Sprintf("Unable to parse 'count' param in query: %q", r.Form.Encode()))
 			return
 		}
 
@@ -306,30 +990,27 @@ func getScopesPaginatedHandler(db store.PersistenceStore) http.HandlerFunc {
 		if err != nil {
 			offset = 0
 		}
-		scopes, err := db.GetScopesPaginated(offset, count)
+		scopes, err := db.GetScopesPaginated(r.Context(), offset, count)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("%s", err)))
+			WriteStoreError(err, w, r)
 			return
 		}
-		WriteResponseBody(scopes, w)
+		WriteResponseBody(store.StringSlice(scopes), w)
 	})
 }
 
-func getFeaturesPaginatedHandler(db store.PersistenceStore) http.HandlerFunc {
+func getFeaturesPaginatedHandler(db store.PersistenceStoreV2) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		if len(r.Form) != 1 && len(r.Form) != 2 {
-			utils.Output(fmt.Sprintf("len(r.Form) = %s", len(r.Form)))
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte(fmt.Sprintf("Unrecognized query: %q", r.Form.Encode())))
+			logger(r).WithField("params", len(r.Form)).Debug("Unexpected number of query params")
+			writeUnrecognizedQuery(w, r)
 			return
 		}
 
 		count, err := strconv.Atoi(r.FormValue("count"))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("Unable to parse 'count' param in query: %q", r.Form.Encode())))
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, fmt.Sprintf("Unable to parse 'count' param in query: %q", r.Form.Encode()))
 			return
 		}
 
@@ -337,68 +1018,313 @@ func getFeaturesPaginatedHandler(db store.PersistenceStore) http.HandlerFunc {
 		if err != nil {
 			offset = 0
 		}
-		features, err := db.GetFeaturesPaginated(offset, count)
+		features, err := db.GetFeaturesPaginated(r.Context(), offset, count)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("%s", err)))
+			WriteStoreError(err, w, r)
 			return
 		}
-		WriteResponseBody(features, w)
+		WriteResponseBody(store.StringSlice(features), w)
+	})
+}
+
+// hasOnlyParams reports whether every query param on the request is one of names.
+func hasOnlyParams(r *http.Request, names ...string) bool {
+	for param := range r.Form {
+		found := false
+		for _, name := range names {
+			found = found || param == name
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePageParams returns the "after" page token and the "limit" page size from the query.
+func parsePageParams(r *http.Request) (string, int, error) {
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit < 1 {
+		return "", 0, fmt.Errorf("Unable to parse 'limit' param in query: %q", r.Form.Encode())
+	}
+	return r.FormValue("after"), limit, nil
+}
+
+// Handler for GET to "/admin/scopes?limit=...&after=..."
+func getScopesPageHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if !hasOnlyParams(r, "limit", "after") {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		after, limit, err := parsePageParams(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, err.Error())
+			return
+		}
+		page, err := db.GetScopesPage(r.Context(), after, limit)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WritePageResponseBody(store.FlipadelphiaScopeList(page.Items), page.Next, w)
+	})
+}
+
+// Handler for GET to "/admin/features?limit=...&after=..."
+func getFeaturesPageHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if !hasOnlyParams(r, "limit", "after") {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		after, limit, err := parsePageParams(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, err.Error())
+			return
+		}
+		page, err := db.GetFeaturesPage(r.Context(), after, limit)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WritePageResponseBody(store.FlipadelphiaScopeFeatures(page.Items), page.Next, w)
+	})
+}
+
+// Handler for GET to "/admin/scopes?feature=...&limit=...&after=..."
+func getScopesWithFeaturePageHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if !hasOnlyParams(r, "feature", "limit", "after") {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		after, limit, err := parsePageParams(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, err.Error())
+			return
+		}
+		page, err := db.GetScopesWithFeaturePage(r.Context(), []byte(r.FormValue("feature")), after, limit)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WritePageResponseBody(store.FlipadelphiaScopeList(page.Items), page.Next, w)
+	})
+}
+
+// Handler for GET to "/admin/scopes/{scope}/features?limit=...&after=..."
+func getScopeFeaturesFullPageHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		defer r.Body.Close()
+		if !hasOnlyParams(r, "limit", "after") {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		after, limit, err := parsePageParams(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, err.Error())
+			return
+		}
+		vars := mux.Vars(r)
+		page, err := db.GetScopeFeaturesFullPage(r.Context(), []byte(vars["scope"]), after, limit)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WritePageResponseBody(page.Items, page.Next, w)
+	})
+}
+
+// defaultSearchLimit is the page size for searches that do not set "limit".
+const defaultSearchLimit = 100
+
+// hasAnyQuery matches requests with at least one of the query params set.
+func hasAnyQuery(names ...string) mux.MatcherFunc {
+	return func(r *http.Request, rm *mux.RouteMatch) bool {
+		query := r.URL.Query()
+		for _, name := range names {
+			if _, ok := query[name]; ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// parseSearchParams returns the search query, page token and page size from the query params.
+func parseSearchParams(r *http.Request) (store.SearchQuery, string, int, error) {
+	q := store.SearchQuery{
+		Glob:        r.FormValue("match"),
+		Regexp:      r.FormValue("regex"),
+		Contains:    r.FormValue("contains"),
+		Prefix:      r.FormValue("prefix"),
+		Value:       r.FormValue("value"),
+		ScopePrefix: r.FormValue("scope_prefix"),
+		Feature:     r.FormValue("feature"),
+	}
+	after, limit, err := parseOptionalPageParams(r)
+	return q, after, limit, err
+}
+
+// parseOptionalPageParams returns the page params like parsePageParams, with a page size of
+// defaultSearchLimit when "limit" is not set.
+func parseOptionalPageParams(r *http.Request) (string, int, error) {
+	if r.FormValue("limit") == "" {
+		return r.FormValue("after"), defaultSearchLimit, nil
+	}
+	return parsePageParams(r)
+}
+
+// Handler for GET to "/admin/features?match=...&regex=...&contains=...&value=...&scope_prefix=..."
+func searchFeaturesHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if !hasOnlyParams(r, "match", "regex", "contains", "value", "scope_prefix", "limit", "after") {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		q, after, limit, err := parseSearchParams(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, err.Error())
+			return
+		}
+		page, err := db.SearchFeatures(r.Context(), q, after, limit)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WritePageResponseBody(store.FlipadelphiaScopeFeatures(page.Items), page.Next, w)
+	})
+}
+
+// Handler for GET to "/admin/scopes?match=...&regex=...&contains=...&feature=...&value=..."
+func searchScopesHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if !hasOnlyParams(r, "match", "regex", "contains", "feature", "value", "limit", "after") {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		q, after, limit, err := parseSearchParams(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, err.Error())
+			return
+		}
+		page, err := db.SearchScopes(r.Context(), q, after, limit)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WritePageResponseBody(store.FlipadelphiaScopeList(page.Items), page.Next, w)
 	})
 }
 
 // Handler for GET to "/admin/features"
-func getAllFeaturesHandler(db store.PersistenceStore) http.HandlerFunc {
+func getAllFeaturesHandler(db store.PersistenceStoreV2) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		if len(r.Form) != 0 {
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte(fmt.Sprintf("Unrecognized query: %q", r.Form.Encode())))
+			writeUnrecognizedQuery(w, r)
 			return
 		}
-		features, err := db.GetFeatures()
+		features, err := db.GetFeatures(r.Context())
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("%s", err)))
+			WriteStoreError(err, w, r)
 			return
 		}
-		WriteResponseBody(features, w)
+		WriteResponseBody(store.FlipadelphiaScopeFeatures(features), w)
 	})
 }
 
 // Handler for GET to "/admin/scopes/{scope}/features"
-func getScopeFeaturesFullHandler(db store.PersistenceStore) http.HandlerFunc {
+func getScopeFeaturesFullHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		defer r.Body.Close()
+		if len(r.Form) != 0 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		vars := mux.Vars(r)
+		features, err := db.GetScopeFeaturesFull(r.Context(), []byte(vars["scope"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(features, w)
+	})
+}
+
+// Handler for GET to "/admin/features/{feature}/summary"
+func featureSummaryHandler(db store.PersistenceStoreV2) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		defer r.Body.Close()
 		if len(r.Form) != 0 {
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte(fmt.Sprintf("Unrecognized query: %q", r.Form.Encode())))
+			writeUnrecognizedQuery(w, r)
 			return
 		}
 		vars := mux.Vars(r)
-		if scopeExists := db.CheckScopeExists([]byte(vars["scope"])); !scopeExists {
-			w.WriteHeader(http.StatusNotFound)
+		summary, err := db.GetFeatureSummary(r.Context(), []byte(vars["feature_name"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
 			return
 		}
-		features, err := db.GetScopeFeaturesFull([]byte(vars["scope"]))
+		WriteResponseBody(summary, w)
+	})
+}
+
+// Handler for GET to "/admin/features/{feature}/usage"
+func featureUsageHandler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		defer r.Body.Close()
+		if len(r.Form) != 0 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		vars := mux.Vars(r)
+		usage, err := store.GetFeatureUsage(r.Context(), db, []byte(vars["feature_name"]))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("%s", err)))
+			WriteStoreError(err, w, r)
 			return
 		}
-		WriteResponseBody(features, w)
+		WriteResponseBody(usage, w)
 	})
 }
 
-// Handler for OPTIONS on all endpoints
-func allowCORSHandler(allowMethods ...string) http.HandlerFunc {
+// defaultStaleDays is how long a feature must go unchecked and unset to be stale, when no days param
+// is given.
+const defaultStaleDays = 30
+
+// Handler for GET to "/admin/features/stale?days=..."
+func staleFeaturesHandler(db store.PersistenceStoreV2) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if len(allowMethods) == 0 {
-			allowMethods = append(allowMethods, "GET", "OPTIONS", "POST")
+		r.ParseForm()
+		defer r.Body.Close()
+		if !hasOnlyParams(r, "days") {
+			writeUnrecognizedQuery(w, r)
+			return
 		}
-		methods := strings.Join(allowMethods, ", ")
-		w.Header().Set("Access-Control-Allow-Methods", methods)
-		return
+		days := defaultStaleDays
+		if r.FormValue("days") != "" {
+			var err error
+			if days, err = strconv.Atoi(r.FormValue("days")); err != nil || days < 0 {
+				WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, fmt.Sprintf("Unable to parse 'days' param in query: %q", r.Form.Encode()))
+				return
+			}
+		}
+		since := time.Now().AddDate(0, 0, -days)
+		stale, err := store.GetStaleFeatures(r.Context(), db, since)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WriteResponseBody(stale, w)
 	})
 }
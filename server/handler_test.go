@@ -1,16 +1,38 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/codegangsta/negroni"
+	"github.com/samdfonseca/flipadelphia/impressions"
+	"github.com/samdfonseca/flipadelphia/metrics"
+	"github.com/samdfonseca/flipadelphia/ratelimit"
 	"github.com/samdfonseca/flipadelphia/store"
+	"github.com/samdfonseca/flipadelphia/utils"
+	"github.com/sirupsen/logrus"
 )
 
 func checkResult(actual, target string, t *testing.T) {
@@ -37,108 +59,337 @@ func getCheckScopeFeaturesForValueURL(server, scope, value string) string {
 	return fmt.Sprintf("%s/features?scope=%s&value=%s", server, scope, value)
 }
 
+// noOverride is an OnGetOverride for features without an override.
+func noOverride(ctx context.Context, feature []byte) (store.FlipadelphiaFeature, error) {
+	return store.FlipadelphiaFeature{}, store.ErrOverrideNotFound
+}
+
+// noPrerequisites is an OnGetPrerequisites for features without prerequisites.
+func noPrerequisites(ctx context.Context, feature []byte) ([]string, error) {
+	return nil, nil
+}
+
 func TestCheckFeatureHandler_ValidRequest_PresetFeature(t *testing.T) {
-	fdb := store.MockPersistenceStore{
-		OnGet: func(scope, key []byte) (store.Serializable, error) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
 			return store.FlipadelphiaFeature{
 				Name:  fmt.Sprintf("%s", key),
 				Value: "on",
 				Data:  "true",
 			}, nil
 		},
-		OnCheckFeatureExists: func(feature []byte) bool {
-			return true
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(getCheckFeatureURL(server.URL, "feature1", "user-1"))
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	target := `{"data":{"name":"feature1","value":"on","data":"true","source":"user-1"}}`
+	checkResult(string(body), target, t)
+}
+
+type impressionLog []impressions.Impression
+
+func (l *impressionLog) Record(impression impressions.Impression) {
+	*l = append(*l, impression)
+}
+
+func TestCheckFeatureHandler_RecordsImpression(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			return store.NewFlipadelphiaFeature(key, []byte("on")), nil
+		},
+	}
+	var recorded impressionLog
+	server := httptest.NewServer(NewApp(fdb, negroni.New(negroni.NewRecovery()), Options{Impressions: &recorded}))
+	defer server.Close()
+
+	resp, err := http.Get(getCheckFeatureURL(server.URL, "feature1", "user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(recorded) != 1 {
+		t.Fatalf("Expected 1 impression, got %d", len(recorded))
+	}
+	impression := recorded[0]
+	checkResult(fmt.Sprintf("%s %s %s %s", impression.Feature, impression.Scope, impression.Value, impression.Source),
+		"feature1 user-1 on user-1", t)
+}
+
+func TestCheckScopeFeaturesHandler_RecordsImpressions(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetPrerequisites: noPrerequisites,
+		OnGetScopeFeaturesFull: func(ctx context.Context, scope []byte) (store.FlipadelphiaFeatures, error) {
+			if string(scope) != "org-1" {
+				return nil, store.ErrScopeNotFound
+			}
+			return store.FlipadelphiaFeatures{store.NewFlipadelphiaFeature([]byte("feature1"), []byte("on"))}, nil
+		},
+		OnGetScopeParents: func(ctx context.Context, scope []byte) ([]string, error) {
+			if string(scope) == "user-1" {
+				return []string{"org-1"}, nil
+			}
+			return nil, nil
 		},
-		OnCheckFeatureHasScope: func(scope, feature []byte) bool {
-			return true
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return nil, nil
 		},
-		OnCheckScopeExists: func(scope []byte) bool {
-			return true
+		OnGetOverrides: func(ctx context.Context) (store.FlipadelphiaFeatures, error) {
+			return nil, nil
 		},
-		OnCheckScopeHasFeature: func(scope, feature []byte) bool {
-			return true
+	}
+	var recorded impressionLog
+	server := httptest.NewServer(NewApp(fdb, negroni.New(negroni.NewRecovery()), Options{Impressions: &recorded}))
+	defer server.Close()
+
+	resp, err := http.Get(getCheckAllScopeFeaturesURL(server.URL, "user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(recorded) != 1 {
+		t.Fatalf("Expected 1 impression, got %d", len(recorded))
+	}
+	impression := recorded[0]
+	checkResult(fmt.Sprintf("%s %s %s %s", impression.Feature, impression.Scope, impression.Value, impression.Source),
+		"feature1 user-1 on org-1", t)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			return store.NewFlipadelphiaFeature(key, []byte("on")), nil
 		},
 	}
-	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	server := httptest.NewServer(NewApp(fdb, negroni.New(negroni.NewRecovery()), Options{Metrics: metrics.New()}))
 	defer server.Close()
 
 	resp, err := http.Get(getCheckFeatureURL(server.URL, "feature1", "user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
-
 	if err != nil {
 		t.Error(err)
 	}
 
-	target := `{"data":{"name":"feature1","value":"on","data":"true"}}`
-	checkResult(string(body), target, t)
+	target := `flipadelphia_http_requests_total{method="GET",route="/features/{feature_name}",status="200"} 1`
+	if !strings.Contains(string(body), target) {
+		t.Errorf("Expected metrics to contain %q", target)
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	var logged bytes.Buffer
+	out, formatter := utils.Log.Out, utils.Log.Formatter
+	utils.Log.Out, utils.Log.Formatter = &logged, &logrus.JSONFormatter{}
+	defer func() { utils.Log.Out, utils.Log.Formatter = out, formatter }()
+
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			return store.FlipadelphiaFeature{}, errors.New("disk on fire")
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", getCheckFeatureURL(server.URL, "feature1", "user-1"), nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkResult(resp.Header.Get("X-Request-Id"), "req-1", t)
+
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logged.String()), "\n") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("Unable to parse log line %q: %s", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("Expected the store error and the request to be logged, got %v", lines)
+	}
+	checkResult(fmt.Sprint(lines[0]["error"]), "disk on fire", t)
+	checkResult(fmt.Sprint(lines[0]["request_id"]), "req-1", t)
+	checkResult(fmt.Sprint(lines[1]["route"]), "/features/{feature_name}", t)
+	checkResult(fmt.Sprint(lines[1]["scope"]), "user-1", t)
+	checkResult(fmt.Sprint(lines[1]["feature"]), "feature1", t)
+	checkResult(fmt.Sprint(lines[1]["status"]), "500", t)
+	if _, ok := lines[1]["latency_ms"]; !ok {
+		t.Error("Expected the request latency to be logged")
+	}
 }
 
 func TestCheckFeatureHandler_ValidRequest_UnsetFeature(t *testing.T) {
-	fdb := store.MockPersistenceStore{
-		OnGet: func(scope, key []byte) (store.Serializable, error) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
 			return store.FlipadelphiaFeature{
 				Name:  fmt.Sprintf("%s", key),
 				Value: "",
 				Data:  "false",
 			}, nil
 		},
-		OnCheckFeatureExists: func(feature []byte) bool {
-			return true
-		},
-		OnCheckFeatureHasScope: func(scope, feature []byte) bool {
-			return true
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(getCheckFeatureURL(server.URL, "feature1", "user-1"))
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	target := `{"data":{"name":"feature1","value":"","data":"false","source":"user-1"}}`
+	checkResult(string(body), target, t)
+}
+
+func TestCheckFeatureHandler_InheritedFeature(t *testing.T) {
+	parents := map[string][]string{"user-1": {"venue-3"}, "venue-3": {"org-1"}}
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			if string(scope) != "org-1" {
+				return store.FlipadelphiaFeature{}, store.ErrFeatureNotFound
+			}
+			return store.NewFlipadelphiaFeature(key, []byte("on")), nil
 		},
-		OnCheckScopeExists: func(scope []byte) bool {
-			return true
+		OnGetScopeParents: func(ctx context.Context, scope []byte) ([]string, error) {
+			return parents[string(scope)], nil
 		},
-		OnCheckScopeHasFeature: func(scope, feature []byte) bool {
-			return true
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return nil, nil
 		},
 	}
 	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
 	defer server.Close()
 
 	resp, err := http.Get(getCheckFeatureURL(server.URL, "feature1", "user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), `{"data":{"name":"feature1","value":"on","data":"true","source":"org-1"}}`, t)
+}
+
+func TestCheckScopeFeaturesHandler_InheritedFeatures(t *testing.T) {
+	parents := map[string][]string{"user-1": {"org-1"}}
+	features := map[string]store.FlipadelphiaFeatures{
+		"user-1": {store.NewFlipadelphiaFeature([]byte("feature2"), []byte("off"))},
+		"org-1":  {store.NewFlipadelphiaFeature([]byte("feature1"), []byte("on"))},
+	}
+	fdb := store.MockPersistenceStoreV2{
+		OnGetPrerequisites: noPrerequisites,
+		OnGetScopeFeaturesFull: func(ctx context.Context, scope []byte) (store.FlipadelphiaFeatures, error) {
+			return features[string(scope)], nil
+		},
+		OnGetScopeParents: func(ctx context.Context, scope []byte) ([]string, error) {
+			return parents[string(scope)], nil
+		},
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return nil, nil
+		},
+		OnGetOverrides: func(ctx context.Context) (store.FlipadelphiaFeatures, error) {
+			return nil, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	for url, target := range map[string]string{
+		getCheckAllScopeFeaturesURL(server.URL, "user-1"):            `{"data":["feature1","feature2"]}`,
+		getCheckScopeFeaturesForValueURL(server.URL, "user-1", "on"): `{"data":["feature1"]}`,
+		getCheckScopeFeaturesForValueURL(server.URL, "user-1", "1"):  `{"data":[]}`,
+		server.URL + "/v2/scopes/user-1/features?value=on":           `{"data":[{"name":"feature1","value":"on","data":"true","source":"org-1"}]}`,
+	} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		checkResult(string(body), target, t)
+	}
+}
+
+func TestCheckFeatureHandler_SegmentFeature(t *testing.T) {
+	beta := store.NewSegment("beta", []string{"user-2"}, "venue-")
+	beta.Features["feature1"] = "on"
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			return store.FlipadelphiaFeature{}, store.ErrScopeNotFound
+		},
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return store.Segments{beta}, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
 
+	resp, err := http.Get(getCheckFeatureURL(server.URL, "feature1", "venue-7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
 	if err != nil {
 		t.Error(err)
 	}
 
-	target := `{"data":{"name":"feature1","value":"","data":"false"}}`
-	checkResult(string(body), target, t)
+	checkResult(string(body), `{"data":{"name":"feature1","value":"on","data":"true","source":"venue-7","segment":"beta"}}`, t)
 }
 
 func TestSetFeatureHandler_ValidRequest(t *testing.T) {
-	fdb := store.MockPersistenceStore{
-		OnGet: func(scope, key []byte) (store.Serializable, error) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride: noOverride,
+		OnSet: func(ctx context.Context, scope, key, value []byte) (store.FlipadelphiaFeature, error) {
 			return store.FlipadelphiaFeature{
 				Name:  fmt.Sprintf("%s", key),
 				Value: "on",
 				Data:  "true",
 			}, nil
 		},
-		OnSet: func(scope, key, value []byte) (store.Serializable, error) {
-			return store.FlipadelphiaFeature{
-				Name:  fmt.Sprintf("%s", key),
-				Value: "on",
-				Data:  "true",
-			}, nil
-		},
-		OnCheckFeatureExists: func(feature []byte) bool {
-			return true
-		},
-		OnCheckFeatureHasScope: func(scope, feature []byte) bool {
-			return true
-		},
-		OnCheckScopeExists: func(scope []byte) bool {
-			return true
-		},
-		OnCheckScopeHasFeature: func(scope, feature []byte) bool {
-			return true
-		},
 	}
 	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
 	defer server.Close()
@@ -156,38 +407,1128 @@ func TestSetFeatureHandler_ValidRequest(t *testing.T) {
 	checkResult(string(body), target, t)
 }
 
-func TestGetScopesPaginatedWithoutOffset_ValidRequest(t *testing.T) {
-	testScopes := store.StringSlice{"scope0", "scope1", "scope2", "scope3", "scope4"}
-	fdb := store.MockPersistenceStore{
-		OnGetScopesPaginated: func(offset, count int) (store.Serializable, error) {
-			return testScopes, nil
+func TestSetFeatureHandler_TTL(t *testing.T) {
+	var expiry time.Time
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride: noOverride,
+		OnSetWithExpiry: func(ctx context.Context, scope, key, value []byte, expiresAt time.Time) (store.FlipadelphiaFeature, error) {
+			expiry = expiresAt
+			return store.NewFlipadelphiaFeature(key, value), nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	reqBody := `{"scope":"user-1","value":"on","ttl":60}`
+	resp, err := http.Post(getSetFeatureURL(server.URL, "feature1"), "application/json", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusOK), t)
+	if remaining := time.Until(expiry); remaining <= 0 || remaining > time.Minute {
+		t.Errorf("Expected the value to expire within a minute, got %s", expiry)
+	}
+}
+
+func TestSetFeatureHandler_ConflictingExpiry(t *testing.T) {
+	server := httptest.NewServer(App(store.MockPersistenceStoreV2{}, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	reqBody := `{"scope":"user-1","value":"on","ttl":60,"expires_at":"2030-01-01T00:00:00Z"}`
+	resp, err := http.Post(getSetFeatureURL(server.URL, "feature1"), "application/json", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusUnprocessableEntity), t)
+}
+
+func TestCheckFeatureHandler_Override(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride: func(ctx context.Context, feature []byte) (store.FlipadelphiaFeature, error) {
+			return store.NewFlipadelphiaFeature(feature, []byte("off")), nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(getCheckFeatureURL(server.URL, "feature1", "user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), `{"data":{"name":"feature1","value":"off","data":"true","source":"user-1","override":true}}`, t)
+}
+
+func TestSetFeatureHandler_Override(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride: func(ctx context.Context, feature []byte) (store.FlipadelphiaFeature, error) {
+			return store.NewFlipadelphiaFeature(feature, []byte("off")), nil
+		},
+		OnSet: func(ctx context.Context, scope, key, value []byte) (store.FlipadelphiaFeature, error) {
+			return store.NewFlipadelphiaFeature(key, value), nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Post(getSetFeatureURL(server.URL, "feature1"), "application/json", strings.NewReader(`{"scope":"user-1","value":"on"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), `{"data":{"name":"feature1","value":"on","data":"true","override":"off"}}`, t)
+}
+
+func TestCheckScopeFeaturesHandler_Override(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetScopeFeaturesFull: func(ctx context.Context, scope []byte) (store.FlipadelphiaFeatures, error) {
+			return store.FlipadelphiaFeatures{store.NewFlipadelphiaFeature([]byte("feature1"), []byte("on"))}, nil
 		},
-		OnCheckFeatureExists: func(feature []byte) bool {
-			return true
+		OnGetScopeParents: func(ctx context.Context, scope []byte) ([]string, error) {
+			return nil, nil
 		},
-		OnCheckFeatureHasScope: func(scope, feature []byte) bool {
-			return true
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return nil, nil
 		},
-		OnCheckScopeExists: func(scope []byte) bool {
-			return true
+		OnGetOverrides: func(ctx context.Context) (store.FlipadelphiaFeatures, error) {
+			return store.FlipadelphiaFeatures{store.NewFlipadelphiaFeature([]byte("feature1"), []byte("off"))}, nil
 		},
-		OnCheckScopeHasFeature: func(scope, feature []byte) bool {
-			return true
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	for url, target := range map[string]string{
+		getCheckScopeFeaturesForValueURL(server.URL, "user-1", "on"):  `{"data":[]}`,
+		getCheckScopeFeaturesForValueURL(server.URL, "user-1", "off"): `{"data":["feature1"]}`,
+		server.URL + "/v2/scopes/user-1/features?value=on":            `{"data":[]}`,
+		server.URL + "/v2/scopes/user-1/features?value=off": `{"data":[{"name":"feature1","value":"off","data":"true",` +
+			`"source":"user-1","override":true}]}`,
+	} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		checkResult(string(body), target, t)
+	}
+}
+
+func TestSetOverrideHandler_ValidRequest(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnSetOverride: func(ctx context.Context, feature, value []byte) (store.FlipadelphiaFeature, error) {
+			return store.NewFlipadelphiaFeature(feature, value), nil
 		},
 	}
 	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
 	defer server.Close()
 
-	resp, err := http.Get(fmt.Sprintf("%s/admin/scopes?count=5", server.URL))
+	resp, err := http.Post(fmt.Sprintf("%s/admin/features/feature1/override", server.URL), "application/json", strings.NewReader(`{"value":"off"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), `{"data":{"name":"feature1","value":"off","data":"true"}}`, t)
+}
+
+func TestCheckFeatureHandler_PrerequisiteOff(t *testing.T) {
+	values := map[string]string{"checkout-v2-tips": "on", "checkout-v2": "off"}
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride: noOverride,
+		OnGetPrerequisites: func(ctx context.Context, feature []byte) ([]string, error) {
+			if string(feature) == "checkout-v2-tips" {
+				return []string{"checkout-v2"}, nil
+			}
+			return nil, nil
+		},
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			return store.NewFlipadelphiaFeature(key, []byte(values[string(key)])), nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(getCheckFeatureURL(server.URL, "checkout-v2-tips", "user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
 	if err != nil {
 		t.Error(err)
 	}
 
+	checkResult(string(body), `{"data":{"name":"checkout-v2-tips","value":"off","data":"true","source":"user-1","prerequisite":"checkout-v2"}}`, t)
+}
+
+func TestCheckScopeFeaturesHandler_PrerequisiteOff(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetPrerequisites: func(ctx context.Context, feature []byte) ([]string, error) {
+			if string(feature) == "checkout-v2-tips" {
+				return []string{"checkout-v2"}, nil
+			}
+			return nil, nil
+		},
+		OnGetScopeFeaturesFull: func(ctx context.Context, scope []byte) (store.FlipadelphiaFeatures, error) {
+			return store.FlipadelphiaFeatures{
+				store.NewFlipadelphiaFeature([]byte("checkout-v2"), []byte("off")),
+				store.NewFlipadelphiaFeature([]byte("checkout-v2-tips"), []byte("on")),
+			}, nil
+		},
+		OnGetScopeParents: func(ctx context.Context, scope []byte) ([]string, error) {
+			return nil, nil
+		},
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return nil, nil
+		},
+		OnGetOverrides: func(ctx context.Context) (store.FlipadelphiaFeatures, error) {
+			return nil, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	for url, target := range map[string]string{
+		getCheckScopeFeaturesForValueURL(server.URL, "user-1", "on"):  `{"data":[]}`,
+		getCheckScopeFeaturesForValueURL(server.URL, "user-1", "off"): `{"data":["checkout-v2","checkout-v2-tips"]}`,
+		server.URL + "/v2/scopes/user-1/features?value=on":            `{"data":[]}`,
+	} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		checkResult(string(body), target, t)
+	}
+}
+
+func TestCheckScopeFeaturesHandler_PrerequisiteOverriddenOutsideScope(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride: func(ctx context.Context, feature []byte) (store.FlipadelphiaFeature, error) {
+			if string(feature) == "payments" {
+				return store.NewFlipadelphiaFeature(feature, []byte("on")), nil
+			}
+			return store.FlipadelphiaFeature{}, store.ErrOverrideNotFound
+		},
+		OnGetPrerequisites: func(ctx context.Context, feature []byte) ([]string, error) {
+			if string(feature) == "checkout-v2" {
+				return []string{"payments"}, nil
+			}
+			return nil, nil
+		},
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			if string(key) == "checkout-v2" {
+				return store.NewFlipadelphiaFeature(key, []byte("on")), nil
+			}
+			return store.FlipadelphiaFeature{}, store.ErrFeatureNotFound
+		},
+		OnGetScopeFeaturesFull: func(ctx context.Context, scope []byte) (store.FlipadelphiaFeatures, error) {
+			return store.FlipadelphiaFeatures{store.NewFlipadelphiaFeature([]byte("checkout-v2"), []byte("on"))}, nil
+		},
+		OnGetScopeParents: func(ctx context.Context, scope []byte) ([]string, error) {
+			return nil, nil
+		},
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return nil, nil
+		},
+		OnGetOverrides: func(ctx context.Context) (store.FlipadelphiaFeatures, error) {
+			return store.FlipadelphiaFeatures{store.NewFlipadelphiaFeature([]byte("payments"), []byte("on"))}, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	// payments is only set elsewhere, but its override turns it on, so the single check and the
+	// listing agree that checkout-v2 is on.
+	for url, target := range map[string]string{
+		getCheckFeatureURL(server.URL, "checkout-v2", "user-1"):      `{"data":{"name":"checkout-v2","value":"on","data":"true","source":"user-1"}}`,
+		getCheckScopeFeaturesForValueURL(server.URL, "user-1", "on"): `{"data":["checkout-v2"]}`,
+	} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		checkResult(string(body), target, t)
+	}
+}
+
+func TestSetPrerequisitesHandler_Cycle(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnSetPrerequisites: func(ctx context.Context, feature []byte, prerequisites []string) error {
+			return store.ErrPrerequisiteCycle
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	reqBody := strings.NewReader(`{"prerequisites": ["checkout-v2-tips"]}`)
+	resp, err := http.Post(fmt.Sprintf("%s/admin/features/checkout-v2/prerequisites", server.URL), "application/json", reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusConflict), t)
+}
+
+func TestAssignVariantHandler_ValidRequest(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnAssignVariant: func(ctx context.Context, feature, scope []byte) (store.Assignment, error) {
+			return store.Assignment{
+				Feature: string(feature),
+				Scope:   string(scope),
+				Variant: "b",
+				Payload: []byte(`{"color":"blue"}`),
+			}, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/experiments/checkout?scope=user-1", server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
 		t.Error(err)
 	}
 
-	checkResult(string(body), fmt.Sprintf(`{"data":%s}`, string(testScopes.Serialize())), t)
+	checkResult(string(body), `{"data":{"feature":"checkout","scope":"user-1","variant":"b","payload":{"color":"blue"}}}`, t)
+}
+
+func TestSetExperimentHandler_InvalidWeights(t *testing.T) {
+	server := httptest.NewServer(App(store.MockPersistenceStoreV2{}, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	reqBody := strings.NewReader(`{"variants": [{"name": "a", "weight": 0}, {"name": "b", "weight": 0}]}`)
+	resp, err := http.Post(fmt.Sprintf("%s/admin/experiments/checkout", server.URL), "application/json", reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusUnprocessableEntity), t)
+}
+
+func TestGetScopesPaginatedWithoutOffset_ValidRequest(t *testing.T) {
+	testScopes := store.StringSlice{"scope0", "scope1", "scope2", "scope3", "scope4"}
+	fdb := store.MockPersistenceStoreV2{
+		OnGetScopesPaginated: func(ctx context.Context, offset, count int) ([]string, error) {
+			return testScopes, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/scopes?count=5", server.URL))
+	if err != nil {
+		t.Error(err)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), fmt.Sprintf(`{"data":%s}`, string(testScopes.Serialize())), t)
+}
+
+func TestCheckFeatureHandler_FeatureNotFound(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			return store.FlipadelphiaFeature{}, store.ErrFeatureNotFound
+		},
+		OnGetScopeParents: func(ctx context.Context, scope []byte) ([]string, error) {
+			return nil, nil
+		},
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return nil, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(getCheckFeatureURL(server.URL, "feature1", "user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusNotFound), t)
+}
+
+func TestCheckFeatureHandler_StoreUnavailable(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			return store.FlipadelphiaFeature{}, store.ErrStoreUnavailable
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(getCheckFeatureURL(server.URL, "feature1", "user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusServiceUnavailable), t)
+}
+
+func TestGetScopesPageHandler_ValidRequest(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetScopesPage: func(ctx context.Context, after string, limit int) (store.Page, error) {
+			if after != "c2NvcGUx" || limit != 2 {
+				t.Errorf("Unexpected page request: after=%q limit=%d", after, limit)
+			}
+			return store.Page{Items: []string{"scope2", "scope3"}, Next: "c2NvcGUz"}, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/scopes?limit=2&after=c2NvcGUx", server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), `{"data":["scope2","scope3"],"next":"c2NvcGUz"}`, t)
+}
+
+func TestSearchFeaturesHandler_ValidRequest(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnSearchFeatures: func(ctx context.Context, q store.SearchQuery, after string, limit int) (store.Page, error) {
+			target := store.SearchQuery{Glob: "checkout-*", Value: "on", ScopePrefix: "venue-"}
+			if q != target || limit != defaultSearchLimit {
+				t.Errorf("Unexpected search: %+v limit=%d", q, limit)
+			}
+			return store.Page{Items: []string{"checkout-v2"}}, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/features?match=checkout-*&value=on&scope_prefix=venue-", server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), `{"data":["checkout-v2"]}`, t)
+}
+
+func TestFeatureSummaryHandler_ValidRequest(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetFeatureSummary: func(ctx context.Context, feature []byte) (store.FeatureSummary, error) {
+			summary := store.NewFeatureSummary(feature)
+			summary.Scopes = 2
+			summary.Values["on"] = 2
+			return summary, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/features/checkout-v2/summary", server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), `{"data":{"name":"checkout-v2","scopes":2,"values":{"on":2},"first_modified":null,"last_modified":null}}`, t)
+}
+
+func TestStaleFeaturesHandler_ValidRequest(t *testing.T) {
+	checked := time.Now().UTC()
+	fdb := store.MockPersistenceStoreV2{
+		OnGetFeatures: func(ctx context.Context) ([]string, error) {
+			return []string{"checked", "stale"}, nil
+		},
+		OnGetUsages: func(ctx context.Context) (store.FeatureUsages, error) {
+			return store.FeatureUsages{{Name: "checked", Checks: 1, LastChecked: &checked}}, nil
+		},
+		OnGetFeatureSummary: func(ctx context.Context, feature []byte) (store.FeatureSummary, error) {
+			return store.NewFeatureSummary(feature), nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/features/stale?days=7", server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), `{"data":[{"name":"stale","checks":0,"last_checked":null,"last_modified":null}]}`, t)
+}
+
+func TestStaleFeaturesHandler_InvalidDays(t *testing.T) {
+	server := httptest.NewServer(App(store.MockPersistenceStoreV2{}, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/features/stale?days=soon", server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusBadRequest), t)
+}
+
+func TestHealthzHandler(t *testing.T) {
+	server := httptest.NewServer(App(store.MockPersistenceStoreV2{}, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusOK), t)
+	checkResult(string(body), `{"status":"ok"}`, t)
+}
+
+func TestReadyzHandler_StoreUnavailable(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnPing: func(ctx context.Context) error {
+			return store.ErrStoreUnavailable
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusServiceUnavailable), t)
+	checkResult(string(body), `{"status":"unavailable","error":"persistence store unavailable"}`, t)
+}
+
+func TestReadyzHandler_StoreReachable(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnPing: func(ctx context.Context) error {
+			return nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusOK), t)
+}
+
+func TestCopyScopeHandler_ValidRequest(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnCopyScope: func(ctx context.Context, source []byte, opts store.CopyScopeOptions) (store.FlipadelphiaFeatures, error) {
+			if string(source) != "template" || opts.Target != "venue-1" || !opts.Overwrite || fmt.Sprint(opts.Features) != "[feature1]" {
+				t.Errorf("Unexpected copy: %s %+v", source, opts)
+			}
+			return store.FlipadelphiaFeatures{store.NewFlipadelphiaFeature([]byte("feature1"), []byte("on"))}, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	reqBody := strings.NewReader(`{"target": "venue-1", "overwrite": true, "features": ["feature1"]}`)
+	resp, err := http.Post(fmt.Sprintf("%s/admin/scopes/template/copy", server.URL), "application/json", reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), `{"data":[{"name":"feature1","value":"on","data":"true"}]}`, t)
+}
+
+func TestCopyScopeHandler_InvalidTarget(t *testing.T) {
+	server := httptest.NewServer(App(store.MockPersistenceStoreV2{}, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	resp, err := http.Post(fmt.Sprintf("%s/admin/scopes/template/copy", server.URL), "application/json", strings.NewReader(`{"target": "template"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusUnprocessableEntity), t)
+}
+
+func TestAddScheduleHandler_ValidRequest(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnAddSchedule: func(ctx context.Context, change store.ScheduledChange) (store.ScheduledChange, error) {
+			change.ID = "1"
+			return change, nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	reqBody := strings.NewReader(`{"scope": "venue-1", "feature": "launch", "value": "on", "run_at": "2017-06-01T09:00:00Z"}`)
+	resp, err := http.Post(fmt.Sprintf("%s/admin/schedules", server.URL), "application/json", reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	checkResult(string(body), `{"data":{"id":"1","scope":"venue-1","feature":"launch","value":"on","run_at":"2017-06-01T09:00:00Z"}}`, t)
+}
+
+func TestAddScheduleHandler_MissingRunAt(t *testing.T) {
+	server := httptest.NewServer(App(store.MockPersistenceStoreV2{}, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	reqBody := strings.NewReader(`{"scope": "venue-1", "feature": "launch", "value": "on"}`)
+	resp, err := http.Post(fmt.Sprintf("%s/admin/schedules", server.URL), "application/json", reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(http.StatusUnprocessableEntity), t)
+}
+
+func TestNewAuthSettings(t *testing.T) {
+	auth, err := NewAuthSettings("", "", "", "")
+	if _, ok := auth.(NoAuth); !ok || err != nil {
+		t.Errorf("Expected NoAuth without settings, got %v, %v", auth, err)
+	}
+	auth, err = NewAuthSettings("http://auth.example.com", "GET", "Authorization", "200")
+	if _, ok := auth.(AuthSettings); !ok || err != nil {
+		t.Errorf("Expected AuthSettings, got %v, %v", auth, err)
+	}
+	auth, err = NewAuthSettings("http://auth.example.com", "DELETE", "Authorization", "200")
+	if auth != nil || err == nil {
+		t.Errorf("Expected an error for an invalid method, got %v, %v", auth, err)
+	}
+}
+
+// newTestCert returns a PEM certificate and key for the template, signed by the parent, or self-signed
+// if the parent is nil.
+func newTestCert(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestRequireAdminClientCert(t *testing.T) {
+	var logged bytes.Buffer
+	out, formatter := utils.Log.Out, utils.Log.Formatter
+	utils.Log.Out, utils.Log.Formatter = &logged, &logrus.JSONFormatter{}
+	defer func() { utils.Log.Out, utils.Log.Formatter = out, formatter }()
+
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey, caPEM, _ := newTestCert(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Flipadelphia Test CA"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil, t)
+	_, _, serverPEM, serverKeyPEM := newTestCert(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey, t)
+	_, _, clientPEM, clientKeyPEM := newTestCert(&x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey, t)
+
+	dir, err := ioutil.TempDir("", "flipadelphia_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string][]byte{"ca.pem": caPEM, "server.pem": serverPEM, "server-key.pem": serverKeyPEM}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tlsConfig, err := NewTLSConfig(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fdb := store.MockPersistenceStoreV2{
+		OnDeleteOverride: func(ctx context.Context, feature []byte) error {
+			return nil
+		},
+	}
+	server := httptest.NewUnstartedServer(NewApp(fdb, negroni.New(negroni.NewRecovery()), Options{RequireAdminClientCert: true}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticated := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert},
+	}}}
+
+	for _, test := range []struct {
+		client *http.Client
+		method string
+		path   string
+		status int
+	}{
+		{anonymous, "GET", "/healthz", http.StatusOK},
+		{anonymous, "DELETE", "/admin/features/feature1/override", http.StatusForbidden},
+		{authenticated, "DELETE", "/admin/features/feature1/override", http.StatusNoContent},
+	} {
+		req, _ := http.NewRequest(test.method, server.URL+test.path, nil)
+		resp, err := test.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(test.status), t)
+	}
+
+	var audited bool
+	for _, line := range strings.Split(strings.TrimSpace(logged.String()), "\n") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err == nil && fields["audit"] == true {
+			audited = true
+			checkResult(fmt.Sprint(fields["actor"]), "alice", t)
+		}
+	}
+	if !audited {
+		t.Error("Expected the override deletion to be audited")
+	}
+}
+
+func TestNewTLSConfig_MissingFiles(t *testing.T) {
+	if _, err := NewTLSConfig("/nonexistent/cert.pem", "/nonexistent/key.pem", ""); err == nil || !strings.HasPrefix(err.Error(), "Unable to load TLS certificate") {
+		t.Errorf("Expected an error loading the certificate, got %v", err)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			return store.NewFlipadelphiaFeature(key, []byte("on")), nil
+		},
+	}
+	cors := NewCORS(CORSPolicies{
+		Public: CORSPolicy{AllowedOrigins: []string{"https://*.example.com"}, AllowedHeaders: []string{"*"}},
+		Admin: CORSPolicy{
+			AllowedOrigins:   []string{"https://admin.example.com"},
+			AllowedHeaders:   []string{"Content-Type", "Authorization"},
+			AllowCredentials: true,
+			MaxAge:           600,
+		},
+	})
+	server := httptest.NewServer(NewApp(fdb, negroni.New(negroni.NewRecovery()), Options{CORS: cors}))
+	defer server.Close()
+
+	request := func(method, url, origin string, headers map[string]string) *http.Response {
+		req, _ := http.NewRequest(method, url, nil)
+		req.Header.Set("Origin", origin)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := request("GET", getCheckFeatureURL(server.URL, "feature1", "user-1"), "https://app.example.com", nil)
+	checkResult(resp.Header.Get("Access-Control-Allow-Origin"), "https://app.example.com", t)
+	checkResult(resp.Header.Get("Access-Control-Allow-Credentials"), "", t)
+
+	resp = request("GET", getCheckFeatureURL(server.URL, "feature1", "user-1"), "https://evil.com", nil)
+	checkResult(resp.Header.Get("Access-Control-Allow-Origin"), "", t)
+
+	resp = request("OPTIONS", getCheckFeatureURL(server.URL, "feature1", "user-1"), "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  "GET",
+		"Access-Control-Request-Headers": "X-Custom",
+	})
+	checkResult(resp.Header.Get("Access-Control-Allow-Headers"), "X-Custom", t)
+	checkResult(resp.Header.Get("Access-Control-Allow-Methods"), "GET, OPTIONS", t)
+
+	resp = request("OPTIONS", server.URL+"/admin/features/feature1/override", "https://admin.example.com", map[string]string{
+		"Access-Control-Request-Method":  "DELETE",
+		"Access-Control-Request-Headers": "Content-Type",
+	})
+	checkResult(resp.Header.Get("Access-Control-Allow-Origin"), "https://admin.example.com", t)
+	checkResult(resp.Header.Get("Access-Control-Allow-Credentials"), "true", t)
+	checkResult(resp.Header.Get("Access-Control-Allow-Headers"), "Content-Type, Authorization", t)
+	checkResult(resp.Header.Get("Access-Control-Max-Age"), "600", t)
+	checkResult(resp.Header.Get("Access-Control-Allow-Methods"), "GET, POST, DELETE, OPTIONS", t)
+
+	resp = request("OPTIONS", server.URL+"/admin/features/feature1/override", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method": "DELETE",
+	})
+	checkResult(resp.Header.Get("Access-Control-Allow-Origin"), "", t)
+
+	resp = request("OPTIONS", server.URL+"/no/such/route", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method": "GET",
+	})
+	checkResult(strconv.Itoa(resp.StatusCode), "404", t)
+	checkResult(resp.Header.Get("Access-Control-Allow-Methods"), "", t)
+
+	cors.Update(CORSPolicies{})
+	resp = request("GET", getCheckFeatureURL(server.URL, "feature1", "user-1"), "https://app.example.com", nil)
+	checkResult(resp.Header.Get("Access-Control-Allow-Origin"), "", t)
+}
+
+func TestRateLimiting(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			return store.NewFlipadelphiaFeature(key, []byte("on")), nil
+		},
+	}
+	limiter := NewRateLimiter(ratelimit.NewMemoryStore(), RateLimitPolicies{
+		Public: RateLimitPolicy{Limit: ratelimit.Limit{PerMinute: 1, Burst: 2}, KeyBy: "api_key"},
+		Admin:  RateLimitPolicy{Limit: ratelimit.Limit{PerMinute: 1}},
+	})
+	server := httptest.NewServer(NewApp(fdb, negroni.New(negroni.NewRecovery()), Options{RateLimiter: limiter}))
+	defer server.Close()
+
+	get := func(url, apiKey string) *http.Response {
+		req, _ := http.NewRequest("GET", url, nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	checkURL := getCheckFeatureURL(server.URL, "feature1", "user-1")
+	for i := 0; i < 2; i++ {
+		checkResult(strconv.Itoa(get(checkURL, "key1").StatusCode), "200", t)
+	}
+	resp := get(checkURL, "key1")
+	checkResult(strconv.Itoa(resp.StatusCode), "429", t)
+	checkResult(resp.Header.Get("Retry-After"), "60", t)
+	checkResult(strconv.Itoa(get(checkURL, "key2").StatusCode), "200", t)
+
+	if get(server.URL+"/admin/unknown", "").StatusCode == http.StatusTooManyRequests {
+		t.Error("First admin request was rate limited")
+	}
+	checkResult(strconv.Itoa(get(server.URL+"/admin/unknown", "").StatusCode), "429", t)
+	checkResult(strconv.Itoa(get(server.URL+"/healthz", "").StatusCode), "200", t)
+	checkResult(strconv.Itoa(get(server.URL+"/healthz", "").StatusCode), "200", t)
+
+	limiter.Update(RateLimitPolicies{})
+	if get(server.URL+"/admin/unknown", "").StatusCode == http.StatusTooManyRequests {
+		t.Error("Admin request was rate limited after the limit was removed")
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			if string(scope) == "down" {
+				return store.FlipadelphiaFeature{}, store.ErrStoreUnavailable
+			}
+			return store.FlipadelphiaFeature{}, store.ErrFeatureNotFound
+		},
+		OnSetPrerequisites: func(ctx context.Context, feature []byte, prerequisites []string) error {
+			return store.ErrPrerequisiteCycle
+		},
+		OnGetScopeParents: func(ctx context.Context, scope []byte) ([]string, error) {
+			return nil, nil
+		},
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return nil, nil
+		},
+		OnGetSchedules: func(ctx context.Context) (store.ScheduledChanges, error) {
+			return nil, errors.New("disk on fire")
+		},
+	}
+	server := httptest.NewServer(NewApp(fdb, negroni.New(negroni.NewRecovery()), Options{}))
+	defer server.Close()
+
+	tests := []struct {
+		name, method, url, body string
+		status                  int
+		code, message           string
+	}{
+		{"not found", "GET", getCheckFeatureURL(server.URL, "feature1", "user-1"), "", http.StatusNotFound, ErrCodeNotFound, "feature not found"},
+		{"store unavailable", "GET", getCheckFeatureURL(server.URL, "feature1", "down"), "", http.StatusServiceUnavailable, ErrCodeUnavailable, "persistence store unavailable"},
+		{"conflict", "POST", server.URL + "/admin/features/checkout/prerequisites", `{"prerequisites": ["checkout"]}`, http.StatusConflict, ErrCodeConflict, "feature prerequisite cycle"},
+		{"store error", "GET", server.URL + "/admin/schedules", "", http.StatusInternalServerError, ErrCodeInternal, "Internal server error"},
+		{"unrecognized query", "GET", server.URL + "/admin/segments?sort=name", "", http.StatusBadRequest, ErrCodeInvalidQuery, `Unrecognized query: "sort=name"`},
+		{"malformed json", "POST", server.URL + "/admin/features/checkout/prerequisites", `{"prerequisites": [`, http.StatusBadRequest, ErrCodeMalformedJSON, "Malformed JSON: unexpected end of JSON input"},
+		{"wrong json type", "POST", server.URL + "/admin/features/checkout/prerequisites", `{"prerequisites": "checkout"}`, http.StatusUnprocessableEntity, ErrCodeInvalidEntity, ""},
+		{"invalid value", "POST", server.URL + "/admin/features/checkout/prerequisites", `{"prerequisites": [""]}`, http.StatusUnprocessableEntity, ErrCodeInvalidEntity, `Invalid prerequisite: ""`},
+		{"unknown route", "GET", server.URL + "/nowhere", "", http.StatusNotFound, ErrCodeNotFound, "No route for GET /nowhere"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		req.Header.Set("X-Request-Id", "request-"+test.name)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		checkResult(fmt.Sprint(resp.StatusCode), fmt.Sprint(test.status), t)
+		checkResult(resp.Header.Get("Content-Type"), "application/json; charset=utf-8", t)
+		checkResult(body.Error.Code, test.code, t)
+		if test.message != "" {
+			checkResult(body.Error.Message, test.message, t)
+		}
+		checkResult(body.Error.RequestID, "request-"+test.name, t)
+	}
+}
+
+func TestWriteStoreError(t *testing.T) {
+	for err, status := range map[error]int{
+		fmt.Errorf("checking user-1: %w", store.ErrScopeNotFound):   http.StatusNotFound,
+		fmt.Errorf("reading scopes: %w", store.ErrStoreUnavailable): http.StatusServiceUnavailable,
+		fmt.Errorf("reading scopes: %w", context.Canceled):          statusClientClosedRequest,
+		fmt.Errorf("setting parents: %w", store.ErrScopeCycle):      http.StatusConflict,
+		fmt.Errorf("searching: %w", store.ErrInvalidPageLimit):      http.StatusBadRequest,
+		errors.New("Bucket does not exist: \"scopes\""):             http.StatusInternalServerError,
+	} {
+		w := httptest.NewRecorder()
+		WriteStoreError(err, w, httptest.NewRequest("GET", "/features?scope=user-1", nil))
+		if w.Code != status {
+			t.Errorf("Expected %q to be a %d, got %d", err, status, w.Code)
+		}
+	}
+}
+
+func TestV2Routes(t *testing.T) {
+	var searched store.SearchQuery
+	var set []string
+	fdb := store.MockPersistenceStoreV2{
+		OnGetOverride:      noOverride,
+		OnGetPrerequisites: noPrerequisites,
+		OnGetScopeParents: func(ctx context.Context, scope []byte) ([]string, error) {
+			return nil, nil
+		},
+		OnGetSegments: func(ctx context.Context) (store.Segments, error) {
+			return nil, nil
+		},
+		OnGet: func(ctx context.Context, scope, key []byte) (store.FlipadelphiaFeature, error) {
+			return store.NewFlipadelphiaFeature(key, []byte("on")), nil
+		},
+		OnGetScopeFeaturesFull: func(ctx context.Context, scope []byte) (store.FlipadelphiaFeatures, error) {
+			return store.FlipadelphiaFeatures{
+				store.NewFlipadelphiaFeature([]byte("feature1"), []byte("on")),
+				store.NewFlipadelphiaFeature([]byte("feature2"), []byte("off")),
+			}, nil
+		},
+		OnGetOverrides: func(ctx context.Context) (store.FlipadelphiaFeatures, error) {
+			return nil, nil
+		},
+		OnSearchFeatures: func(ctx context.Context, q store.SearchQuery, after string, limit int) (store.Page, error) {
+			searched = q
+			return store.Page{Items: []string{"checkout-v2"}, Next: "next"}, nil
+		},
+		OnSearchScopes: func(ctx context.Context, q store.SearchQuery, after string, limit int) (store.Page, error) {
+			searched = q
+			return store.Page{Items: []string{"venue-1"}}, nil
+		},
+		OnCheckFeatureExists: func(ctx context.Context, feature []byte) (bool, error) {
+			return string(feature) == "checkout-v2", nil
+		},
+		OnGetScopesWithFeaturePage: func(ctx context.Context, feature []byte, after string, limit int) (store.Page, error) {
+			if string(feature) != "checkout-v2" {
+				return store.Page{}, store.ErrFeatureNotFound
+			}
+			return store.Page{Items: []string{"venue-1", "venue-2"}}, nil
+		},
+		OnSet: func(ctx context.Context, scope, key, value []byte) (store.FlipadelphiaFeature, error) {
+			set = []string{string(scope), string(key), string(value)}
+			return store.NewFlipadelphiaFeature(key, value), nil
+		},
+	}
+	server := httptest.NewServer(App(fdb, negroni.New(negroni.NewRecovery())))
+	defer server.Close()
+
+	request := func(method, path, body string) (int, string) {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(respBody)
+	}
+
+	status, body := request("GET", "/v2/features/feature1/scopes/user-1", "")
+	checkResult(fmt.Sprint(status), "200", t)
+	checkResult(body, `{"data":{"name":"feature1","value":"on","data":"true","source":"user-1"}}`, t)
+
+	status, body = request("GET", "/v2/scopes/user-1/features", "")
+	checkResult(fmt.Sprint(status), "200", t)
+	checkResult(body, `{"data":[{"name":"feature1","value":"on","data":"true","source":"user-1"},`+
+		`{"name":"feature2","value":"off","data":"true","source":"user-1"}]}`, t)
+	_, body = request("GET", "/v2/scopes/user-1/features?value=off", "")
+	checkResult(body, `{"data":[{"name":"feature2","value":"off","data":"true","source":"user-1"}]}`, t)
+
+	status, body = request("GET", "/v2/admin/features?prefix=checkout-&value=on", "")
+	checkResult(fmt.Sprint(status), "200", t)
+	checkResult(body, `{"data":["checkout-v2"],"next":"next"}`, t)
+	checkResult(fmt.Sprintf("%+v", searched), fmt.Sprintf("%+v", store.SearchQuery{Prefix: "checkout-", Value: "on"}), t)
+
+	_, body = request("GET", "/v2/admin/features/checkout-v2/scopes?prefix=venue-", "")
+	checkResult(body, `{"data":["venue-1"]}`, t)
+	checkResult(fmt.Sprintf("%+v", searched), fmt.Sprintf("%+v", store.SearchQuery{Prefix: "venue-", Feature: "checkout-v2"}), t)
+	status, _ = request("GET", "/v2/admin/features/checkout-v2/scopes?feature=other", "")
+	checkResult(fmt.Sprint(status), "400", t)
+	_, body = request("GET", "/v2/admin/features/checkout-v2/scopes", "")
+	checkResult(body, `{"data":["venue-1","venue-2"]}`, t)
+	status, _ = request("GET", "/v2/admin/features/missing/scopes", "")
+	checkResult(fmt.Sprint(status), "404", t)
+	status, _ = request("GET", "/v2/admin/features/missing/scopes?prefix=venue-", "")
+	checkResult(fmt.Sprint(status), "404", t)
+
+	status, _ = request("PUT", "/v2/admin/features/checkout-v2/scopes/venue-1", `{"value": "off"}`)
+	checkResult(fmt.Sprint(status), "200", t)
+	checkResult(fmt.Sprint(set), "[venue-1 checkout-v2 off]", t)
+
+	status, body = request("GET", "/v2/scopes/user-1/features?sort=name", "")
+	checkResult(fmt.Sprint(status), "400", t)
+	if !strings.Contains(body, `"code":"invalid_query"`) {
+		t.Errorf("Expected an invalid_query error, got %s", body)
+	}
+
+	status, _ = request("GET", "/features/feature1?scope=user-1", "")
+	checkResult(fmt.Sprint(status), "200", t)
+
+	for path, admin := range map[string]bool{
+		"/v2/admin/scopes":                    true,
+		"/v2/admin":                           true,
+		"/v2/features/feature1/scopes/user-1": false,
+		"/v2/administrators":                  false,
+	} {
+		if isAdminRoute(path) != admin {
+			t.Errorf("Expected isAdminRoute(%q) to be %t", path, admin)
+		}
+	}
 }
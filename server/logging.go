@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/gorilla/mux"
+	"github.com/samdfonseca/flipadelphia/utils"
+	"github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// requestIDHeader carries the request id. One is generated for requests that arrive without it, and
+// it is echoed back on every response.
+const requestIDHeader = "X-Request-Id"
+
+type contextKey int
+
+// loggerKey is the request context key of the request's logger.
+const loggerKey contextKey = iota
+
+// logger returns the logger of the request, carrying its request id, route, scope and feature.
+func logger(r *http.Request) *logrus.Entry {
+	if entry, ok := r.Context().Value(loggerKey).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(utils.Log)
+}
+
+// matchRoute returns the path template of the route the request matches, and the route's variables.
+func matchRoute(router *mux.Router, r *http.Request) (string, map[string]string) {
+	var match mux.RouteMatch
+	if router.Match(r, &match) && match.Route != nil {
+		if template, err := match.Route.GetPathTemplate(); err == nil {
+			return template, match.Vars
+		}
+	}
+	return unmatchedRoute, nil
+}
+
+// requestLogging returns middleware giving each request a logger with its request id, route, scope and
+// feature, and logging each request once it has been served, with its status and latency.
+func requestLogging(router *mux.Router) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		start := time.Now()
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" {
+			requestID = uuid.NewV4().String()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		route, vars := matchRoute(router, r)
+		fields := logrus.Fields{
+			"request_id": requestID,
+			"method":     r.Method,
+			"route":      route,
+		}
+		if scope := vars["scope"]; scope != "" {
+			fields["scope"] = scope
+		} else if scope := vars["scope_name"]; scope != "" {
+			fields["scope"] = scope
+		}
+		if feature := vars["feature_name"]; feature != "" {
+			fields["feature"] = feature
+		}
+		entry := utils.Log.WithFields(fields)
+		next(w, r.WithContext(context.WithValue(r.Context(), loggerKey, entry)))
+		status := http.StatusOK
+		if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+			status = rw.Status()
+		}
+		entry.WithFields(logrus.Fields{
+			"status":     status,
+			"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+		}).Info("Request served")
+	}
+}
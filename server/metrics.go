@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/gorilla/mux"
+	"github.com/samdfonseca/flipadelphia/metrics"
+)
+
+// unmatchedRoute is the route label of requests that match no route.
+const unmatchedRoute = "unmatched"
+
+// requestMetrics returns middleware counting and timing each request under the path template of the
+// route it matches, so the labels stay bounded however many features and scopes there are.
+func requestMetrics(router *mux.Router, m *metrics.Metrics) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		start := time.Now()
+		next(w, r)
+		route, _ := matchRoute(router, r)
+		status := http.StatusOK
+		if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+			status = rw.Status()
+		}
+		m.ObserveRequest(route, r.Method, status, time.Since(start))
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/samdfonseca/flipadelphia/ratelimit"
+)
+
+// apiKeyHeader is the header clients send their API key in, for rate limits keyed by api_key.
+const apiKeyHeader = "X-API-Key"
+
+// RateLimitPolicy says how often a client may make requests to a group of routes.
+type RateLimitPolicy struct {
+	Limit ratelimit.Limit
+	// KeyBy says what identifies a client: "ip" for the address the request came from, "api_key" for
+	// the X-API-Key header, or "header:<name>" for any other header. Requests without the header are
+	// identified by their address. Empty is "ip".
+	KeyBy string
+}
+
+// clientKey returns what identifies the client making the request under the policy. Header values are
+// hashed, so API keys are not kept in the store.
+func (policy RateLimitPolicy) clientKey(r *http.Request) string {
+	var header string
+	switch {
+	case policy.KeyBy == "api_key":
+		header = apiKeyHeader
+	case strings.HasPrefix(policy.KeyBy, "header:"):
+		header = strings.TrimPrefix(policy.KeyBy, "header:")
+	}
+	if header != "" {
+		if value := r.Header.Get(header); value != "" {
+			sum := sha256.Sum256([]byte(value))
+			return "header:" + http.CanonicalHeaderKey(header) + ":" + hex.EncodeToString(sum[:16])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// RateLimitPolicies are the policies of the public check routes and of the /admin routes. The health
+// check and metrics routes are never limited.
+type RateLimitPolicies struct {
+	Public RateLimitPolicy
+	Admin  RateLimitPolicy
+}
+
+// RateLimiter refuses requests from clients that have used up the limit of the route's policy. The
+// policies can be replaced while serving.
+type RateLimiter struct {
+	store    ratelimit.Store
+	policies atomic.Value
+}
+
+// NewRateLimiter returns a RateLimiter applying the policies, with buckets kept in the store.
+func NewRateLimiter(store ratelimit.Store, policies RateLimitPolicies) *RateLimiter {
+	limiter := &RateLimiter{store: store}
+	limiter.Update(policies)
+	return limiter
+}
+
+// Update replaces the policies. Clients keep the tokens they have left.
+func (limiter *RateLimiter) Update(policies RateLimitPolicies) {
+	limiter.policies.Store(policies)
+}
+
+// policy returns the route group the request is in and its policy, or "" if it is not limited.
+func (limiter *RateLimiter) policy(r *http.Request) (string, RateLimitPolicy) {
+	policies := limiter.policies.Load().(RateLimitPolicies)
+	switch {
+	case r.Method == "OPTIONS":
+		return "", RateLimitPolicy{}
+	case isAdminRoute(r.URL.Path):
+		return "admin", policies.Admin
+	case r.URL.Path == "/features" || strings.HasPrefix(r.URL.Path, "/features/"),
+		strings.HasPrefix(r.URL.Path, "/scopes/"), strings.HasPrefix(r.URL.Path, "/experiments/"),
+		strings.HasPrefix(r.URL.Path, "/v2/"):
+		return "public", policies.Public
+	}
+	return "", RateLimitPolicy{}
+}
+
+// ServeHTTP is middleware taking a token from the client's bucket for the route's group. A request
+// without one gets a 429 with a Retry-After of the whole seconds until a token is available. When the
+// store can not be reached, requests are let through.
+func (limiter *RateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	group, policy := limiter.policy(r)
+	if group == "" || policy.Limit.Unlimited() {
+		next(w, r)
+		return
+	}
+	key := group + ":" + policy.clientKey(r)
+	result, err := limiter.store.Take(r.Context(), key, policy.Limit)
+	if err != nil {
+		logger(r).WithError(err).Warn("Unable to check rate limit, request let through")
+		next(w, r)
+		return
+	}
+	if !result.Allowed {
+		retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		logger(r).WithField("rate_limit_key", key).Info("Rate limit exceeded")
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		WriteError(w, http.StatusTooManyRequests, ErrCodeRateLimited,
+			fmt.Sprintf("Rate limit exceeded, retry in %d seconds", retryAfter))
+		return
+	}
+	next(w, r)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// NewTLSConfig returns the TLS config serving the certificate and key. When a client CA file is given,
+// clients may present a certificate, which must be signed by one of the CAs in the file. The
+// certificate is only required where the App asks for it, see Options.RequireAdminClientCert.
+func NewTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load TLS certificate: %s", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile == "" {
+		return tlsConfig, nil
+	}
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read client CA file: %s", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in client CA file %q", clientCAFile)
+	}
+	tlsConfig.ClientCAs = clientCAs
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
+// isAdminRoute reports whether the path is under /admin or /v2/admin.
+func isAdminRoute(path string) bool {
+	for _, prefix := range []string{"/admin", "/v2/admin"} {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClientCert reports whether the request was made with a verified client certificate.
+func hasClientCert(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0
+}
+
+// clientCertSubject returns the common name of the subject of the verified client certificate the
+// request was made with, or "" if there is none.
+func clientCertSubject(r *http.Request) string {
+	if !hasClientCert(r) {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// requireAdminClientCert is middleware refusing requests to /admin routes made without a verified
+// client certificate. CORS preflights are let through, as browsers send them without one.
+func requireAdminClientCert(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.Method != "OPTIONS" && isAdminRoute(r.URL.Path) && !hasClientCert(r) {
+		logger(r).Warn("Admin request without a client certificate refused")
+		WriteError(w, http.StatusForbidden, ErrCodeForbidden, "A client certificate is required")
+		return
+	}
+	next(w, r)
+}
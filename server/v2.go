@@ -0,0 +1,265 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/samdfonseca/flipadelphia/impressions"
+	"github.com/samdfonseca/flipadelphia/store"
+)
+
+// addV2Routes adds the /v2 API to the router. Every resource has a path of its own, rather than being
+// told apart by its query params, and filters are optional query params. Like v1, the check routes are
+// public and the rest are under /v2/admin.
+func addV2Routes(router *mux.Router, db store.PersistenceStoreV2, rec ImpressionRecorder) {
+	// GET /v2/features/{feature_name}/scopes/{scope}
+	router.HandleFunc("/v2/features/{feature_name}/scopes/{scope:[0-9A-Za-z_-]+}", checkFeatureV2Handler(db, rec)).
+		Methods("GET")
+	// GET /v2/scopes/{scope}/features?value=...
+	router.HandleFunc("/v2/scopes/{scope:[0-9A-Za-z_-]+}/features", checkScopeFeaturesV2Handler(db, rec)).
+		Methods("GET")
+	// GET /v2/experiments/{feature_name}/scopes/{scope}
+	router.HandleFunc("/v2/experiments/{feature_name}/scopes/{scope:[0-9A-Za-z_-]+}", assignVariantV2Handler(db, rec)).
+		Methods("GET")
+
+	// GET /v2/admin/features?prefix=...&match=...&regex=...&contains=...&value=...&scope_prefix=...&limit=...&after=...
+	router.HandleFunc("/v2/admin/features", searchFeaturesV2Handler(db)).
+		Methods("GET")
+	// GET /v2/admin/features/stale?days=...
+	router.HandleFunc("/v2/admin/features/stale", staleFeaturesHandler(db)).
+		Methods("GET")
+	// GET /v2/admin/features/{feature_name}
+	router.HandleFunc("/v2/admin/features/{feature_name}", featureSummaryHandler(db)).
+		Methods("GET")
+	// GET /v2/admin/features/{feature_name}/usage
+	router.HandleFunc("/v2/admin/features/{feature_name}/usage", featureUsageHandler(db)).
+		Methods("GET")
+	// GET /v2/admin/features/{feature_name}/scopes?prefix=...&match=...&regex=...&contains=...&value=...&limit=...&after=...
+	router.HandleFunc("/v2/admin/features/{feature_name}/scopes", searchScopesV2Handler(db)).
+		Methods("GET")
+	// PUT /v2/admin/features/{feature_name}/scopes/{scope}
+	router.HandleFunc("/v2/admin/features/{feature_name}/scopes/{scope:[0-9A-Za-z_-]+}", setFeatureHandler(db)).
+		Methods("PUT")
+	// GET /v2/admin/features/{feature_name}/override
+	router.HandleFunc("/v2/admin/features/{feature_name}/override", getOverrideHandler(db)).
+		Methods("GET")
+	// PUT /v2/admin/features/{feature_name}/override
+	router.HandleFunc("/v2/admin/features/{feature_name}/override", setOverrideHandler(db)).
+		Methods("PUT")
+	// DELETE /v2/admin/features/{feature_name}/override
+	router.HandleFunc("/v2/admin/features/{feature_name}/override", deleteOverrideHandler(db)).
+		Methods("DELETE")
+	// GET /v2/admin/features/{feature_name}/prerequisites
+	router.HandleFunc("/v2/admin/features/{feature_name}/prerequisites", getPrerequisitesHandler(db)).
+		Methods("GET")
+	// PUT /v2/admin/features/{feature_name}/prerequisites
+	router.HandleFunc("/v2/admin/features/{feature_name}/prerequisites", setPrerequisitesHandler(db)).
+		Methods("PUT")
+
+	// GET /v2/admin/scopes?prefix=...&match=...&regex=...&contains=...&feature=...&value=...&limit=...&after=...
+	router.HandleFunc("/v2/admin/scopes", searchScopesV2Handler(db)).
+		Methods("GET")
+	// GET /v2/admin/scopes/{scope}/features?limit=...&after=...
+	router.HandleFunc("/v2/admin/scopes/{scope:[0-9A-Za-z_-]+}/features", getScopeFeaturesV2Handler(db)).
+		Methods("GET")
+	// GET /v2/admin/scopes/{scope}/effective
+	router.HandleFunc("/v2/admin/scopes/{scope:[0-9A-Za-z_-]+}/effective", getEffectiveScopeFeaturesHandler(db)).
+		Methods("GET")
+	// GET /v2/admin/scopes/{scope}/parents
+	router.HandleFunc("/v2/admin/scopes/{scope:[0-9A-Za-z_-]+}/parents", getScopeParentsHandler(db)).
+		Methods("GET")
+	// PUT /v2/admin/scopes/{scope}/parents
+	router.HandleFunc("/v2/admin/scopes/{scope:[0-9A-Za-z_-]+}/parents", setScopeParentsHandler(db)).
+		Methods("PUT")
+	// POST /v2/admin/scopes/{scope}/copy
+	router.HandleFunc("/v2/admin/scopes/{scope:[0-9A-Za-z_-]+}/copy", copyScopeHandler(db)).
+		Methods("POST")
+
+	// GET /v2/admin/segments
+	router.HandleFunc("/v2/admin/segments", getSegmentsHandler(db)).
+		Methods("GET")
+	// GET /v2/admin/segments/{segment}
+	router.HandleFunc("/v2/admin/segments/{segment:[0-9A-Za-z_-]+}", getSegmentHandler(db)).
+		Methods("GET")
+	// PUT /v2/admin/segments/{segment}
+	router.HandleFunc("/v2/admin/segments/{segment:[0-9A-Za-z_-]+}", setSegmentHandler(db)).
+		Methods("PUT")
+	// DELETE /v2/admin/segments/{segment}
+	router.HandleFunc("/v2/admin/segments/{segment:[0-9A-Za-z_-]+}", deleteSegmentHandler(db)).
+		Methods("DELETE")
+	// PUT /v2/admin/segments/{segment}/features/{feature_name}
+	router.HandleFunc("/v2/admin/segments/{segment:[0-9A-Za-z_-]+}/features/{feature_name}", setSegmentFeatureHandler(db)).
+		Methods("PUT")
+
+	// GET /v2/admin/schedules
+	router.HandleFunc("/v2/admin/schedules", getSchedulesHandler(db)).
+		Methods("GET")
+	// POST /v2/admin/schedules
+	router.HandleFunc("/v2/admin/schedules", addScheduleHandler(db)).
+		Methods("POST")
+	// DELETE /v2/admin/schedules/{schedule_id}
+	router.HandleFunc("/v2/admin/schedules/{schedule_id}", deleteScheduleHandler(db)).
+		Methods("DELETE")
+
+	// GET /v2/admin/experiments/{feature_name}
+	router.HandleFunc("/v2/admin/experiments/{feature_name}", getExperimentHandler(db)).
+		Methods("GET")
+	// PUT /v2/admin/experiments/{feature_name}
+	router.HandleFunc("/v2/admin/experiments/{feature_name}", setExperimentHandler(db)).
+		Methods("PUT")
+	// DELETE /v2/admin/experiments/{feature_name}
+	router.HandleFunc("/v2/admin/experiments/{feature_name}", deleteExperimentHandler(db)).
+		Methods("DELETE")
+	// POST /v2/admin/experiments/{feature_name}/reshuffle
+	router.HandleFunc("/v2/admin/experiments/{feature_name}/reshuffle", reshuffleExperimentHandler(db)).
+		Methods("POST")
+}
+
+// Handler for GET to "/v2/features/{feature_name}/scopes/{scope}"
+func checkFeatureV2Handler(db store.PersistenceStoreV2, rec ImpressionRecorder) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if len(r.Form) != 0 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		vars := mux.Vars(r)
+		feature, err := store.ResolveFeature(r.Context(), db, []byte(vars["scope"]), []byte(vars["feature_name"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		rec.Record(impressions.Impression{Feature: vars["feature_name"], Scope: vars["scope"], Value: feature.Value, Source: feature.Source})
+		WriteResponseBody(feature, w)
+	})
+}
+
+// Handler for GET to "/v2/scopes/{scope}/features?value=..."
+func checkScopeFeaturesV2Handler(db store.PersistenceStoreV2, rec ImpressionRecorder) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if !hasOnlyParams(r, "value") {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		scope := mux.Vars(r)["scope"]
+		value := r.FormValue("value")
+		features, err := resolveScopeFeatures(r.Context(), db, scope, value)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		for _, feature := range features {
+			rec.Record(impressions.Impression{Feature: feature.Name, Scope: scope, Value: feature.Value, Source: feature.Source})
+		}
+		WriteResponseBody(features, w)
+	})
+}
+
+// Handler for GET to "/v2/experiments/{feature_name}/scopes/{scope}"
+func assignVariantV2Handler(db store.PersistenceStoreV2, rec ImpressionRecorder) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if len(r.Form) != 0 {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		vars := mux.Vars(r)
+		assignment, err := db.AssignVariant(r.Context(), []byte(vars["feature_name"]), []byte(vars["scope"]))
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		rec.Record(impressions.Impression{Feature: assignment.Feature, Scope: assignment.Scope, Variant: assignment.Variant})
+		WriteResponseBody(assignment, w)
+	})
+}
+
+// Handler for GET to "/v2/admin/features". Every filter is optional, and without any every feature is
+// listed.
+func searchFeaturesV2Handler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if !hasOnlyParams(r, "prefix", "match", "regex", "contains", "value", "scope_prefix", "limit", "after") {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		q, after, limit, err := parseSearchParams(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, err.Error())
+			return
+		}
+		page, err := db.SearchFeatures(r.Context(), q, after, limit)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WritePageResponseBody(store.FlipadelphiaScopeFeatures(page.Items), page.Next, w)
+	})
+}
+
+// Handler for GET to "/v2/admin/scopes" and "/v2/admin/features/{feature_name}/scopes". Every filter
+// is optional, and without any every scope, or every scope the feature is set on, is listed. Listing
+// the scopes of a feature that is not set on any is a 404, as in v1.
+func searchScopesV2Handler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		params := []string{"prefix", "match", "regex", "contains", "value", "limit", "after"}
+		feature, featureRoute := mux.Vars(r)["feature_name"]
+		if !featureRoute {
+			params = append(params, "feature")
+		}
+		if !hasOnlyParams(r, params...) {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		q, after, limit, err := parseSearchParams(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, err.Error())
+			return
+		}
+		var page store.Page
+		switch {
+		case featureRoute && q == (store.SearchQuery{}):
+			page, err = db.GetScopesWithFeaturePage(r.Context(), []byte(feature), after, limit)
+		case featureRoute:
+			q.Feature = feature
+			var exists bool
+			if exists, err = db.CheckFeatureExists(r.Context(), []byte(feature)); err == nil && !exists {
+				err = store.ErrFeatureNotFound
+			}
+			if err == nil {
+				page, err = db.SearchScopes(r.Context(), q, after, limit)
+			}
+		default:
+			page, err = db.SearchScopes(r.Context(), q, after, limit)
+		}
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WritePageResponseBody(store.FlipadelphiaScopeList(page.Items), page.Next, w)
+	})
+}
+
+// Handler for GET to "/v2/admin/scopes/{scope}/features?limit=...&after=..."
+func getScopeFeaturesV2Handler(db store.PersistenceStoreV2) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if !hasOnlyParams(r, "limit", "after") {
+			writeUnrecognizedQuery(w, r)
+			return
+		}
+		after, limit, err := parseOptionalPageParams(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrCodeInvalidQuery, err.Error())
+			return
+		}
+		page, err := db.GetScopeFeaturesFullPage(r.Context(), []byte(mux.Vars(r)["scope"]), after, limit)
+		if err != nil {
+			WriteStoreError(err, w, r)
+			return
+		}
+		WritePageResponseBody(page.Items, page.Next, w)
+	})
+}
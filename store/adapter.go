@@ -0,0 +1,578 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// persistenceStoreAdapter exposes a PersistenceStore through the PersistenceStoreV2 interface.
+type persistenceStoreAdapter struct {
+	ps PersistenceStore
+}
+
+// legacyPersistenceStore exposes a PersistenceStoreV2 through the PersistenceStore interface.
+type legacyPersistenceStore struct {
+	ps PersistenceStoreV2
+}
+
+// NewPersistenceStoreAdapter wraps a PersistenceStore so it can be used where a PersistenceStoreV2 is
+// expected. The wrapped store does not support cancellation, so the context is only checked before
+// each call.
+func NewPersistenceStoreAdapter(ps PersistenceStore) PersistenceStoreV2 {
+	return persistenceStoreAdapter{ps: ps}
+}
+
+// NewLegacyPersistenceStore wraps a PersistenceStoreV2 so it can be used where a PersistenceStore is
+// expected. Calls are made with context.Background().
+func NewLegacyPersistenceStore(ps PersistenceStoreV2) PersistenceStore {
+	return legacyPersistenceStore{ps: ps}
+}
+
+func serializableToFeature(s Serializable) (FlipadelphiaFeature, error) {
+	var feature FlipadelphiaFeature
+	switch f := s.(type) {
+	case FlipadelphiaFeature:
+		return f, nil
+	case nil:
+		return feature, nil
+	}
+	err := json.Unmarshal(s.Serialize(), &feature)
+	return feature, err
+}
+
+func serializableToFeatures(s Serializable) (FlipadelphiaFeatures, error) {
+	var features FlipadelphiaFeatures
+	switch f := s.(type) {
+	case FlipadelphiaFeatures:
+		return f, nil
+	case nil:
+		return features, nil
+	}
+	err := json.Unmarshal(s.Serialize(), &features)
+	return features, err
+}
+
+func serializableToStrings(s Serializable) ([]string, error) {
+	var strs []string
+	switch ss := s.(type) {
+	case FlipadelphiaScopeFeatures:
+		return ss, nil
+	case FlipadelphiaScopeList:
+		return ss, nil
+	case StringSlice:
+		return ss, nil
+	case nil:
+		return strs, nil
+	}
+	err := json.Unmarshal(s.Serialize(), &strs)
+	return strs, err
+}
+
+// notFoundError works out which of the scope or feature is missing after a PersistenceStore call fails.
+func (a persistenceStoreAdapter) notFoundError(scope, feature []byte, err error) error {
+	if scope != nil && !a.ps.CheckScopeExists(scope) {
+		return ErrScopeNotFound
+	}
+	if feature != nil && !a.ps.CheckFeatureExists(feature) {
+		return ErrFeatureNotFound
+	}
+	if scope != nil && feature != nil && !a.ps.CheckScopeHasFeature(scope, feature) {
+		return ErrFeatureNotFound
+	}
+	return err
+}
+
+func (a persistenceStoreAdapter) Get(ctx context.Context, scope, feature []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	s, err := a.ps.Get(scope, feature)
+	if err != nil {
+		return FlipadelphiaFeature{}, a.notFoundError(scope, feature, err)
+	}
+	return serializableToFeature(s)
+}
+
+func (a persistenceStoreAdapter) GetScopeFeatures(ctx context.Context, scope []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s, err := a.ps.GetScopeFeatures(scope)
+	if err != nil {
+		return nil, a.notFoundError(scope, nil, err)
+	}
+	return serializableToStrings(s)
+}
+
+func (a persistenceStoreAdapter) GetScopeFeaturesFilterByValue(ctx context.Context, scope, value []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s, err := a.ps.GetScopeFeaturesFilterByValue(scope, value)
+	if err != nil {
+		return nil, a.notFoundError(scope, nil, err)
+	}
+	return serializableToStrings(s)
+}
+
+func (a persistenceStoreAdapter) Set(ctx context.Context, scope, feature, value []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	s, err := a.ps.Set(scope, feature, value)
+	if err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	return serializableToFeature(s)
+}
+
+func (a persistenceStoreAdapter) SetWithExpiry(ctx context.Context, scope, feature, value []byte, expiresAt time.Time) (FlipadelphiaFeature, error) {
+	return FlipadelphiaFeature{}, ErrUnimplemented
+}
+
+func (a persistenceStoreAdapter) GetScopes(ctx context.Context) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s, err := a.ps.GetScopes()
+	if err != nil {
+		return nil, err
+	}
+	return serializableToStrings(s)
+}
+
+func (a persistenceStoreAdapter) GetScopesWithPrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s, err := a.ps.GetScopesWithPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return serializableToStrings(s)
+}
+
+func (a persistenceStoreAdapter) GetScopesWithFeature(ctx context.Context, feature []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s, err := a.ps.GetScopesWithFeature(feature)
+	if err != nil {
+		return nil, a.notFoundError(nil, feature, err)
+	}
+	return serializableToStrings(s)
+}
+
+func (a persistenceStoreAdapter) GetScopesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s, err := a.ps.GetScopesPaginated(offset, count)
+	if err != nil {
+		return nil, err
+	}
+	return serializableToStrings(s)
+}
+
+func (a persistenceStoreAdapter) GetFeaturesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s, err := a.ps.GetFeaturesPaginated(offset, count)
+	if err != nil {
+		return nil, err
+	}
+	return serializableToStrings(s)
+}
+
+func (a persistenceStoreAdapter) GetFeatures(ctx context.Context) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s, err := a.ps.GetFeatures()
+	if err != nil {
+		return nil, err
+	}
+	return serializableToStrings(s)
+}
+
+func (a persistenceStoreAdapter) GetScopeFeaturesFull(ctx context.Context, scope []byte) (FlipadelphiaFeatures, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s, err := a.ps.GetScopeFeaturesFull(scope)
+	if err != nil {
+		return nil, a.notFoundError(scope, nil, err)
+	}
+	return serializableToFeatures(s)
+}
+
+func (a persistenceStoreAdapter) GetScopesPage(ctx context.Context, after string, limit int) (Page, error) {
+	scopes, err := a.GetScopes(ctx)
+	if err != nil {
+		return Page{}, err
+	}
+	return pageStrings(scopes, after, limit)
+}
+
+func (a persistenceStoreAdapter) GetFeaturesPage(ctx context.Context, after string, limit int) (Page, error) {
+	features, err := a.GetFeatures(ctx)
+	if err != nil {
+		return Page{}, err
+	}
+	return pageStrings(features, after, limit)
+}
+
+func (a persistenceStoreAdapter) GetScopesWithFeaturePage(ctx context.Context, feature []byte, after string, limit int) (Page, error) {
+	scopes, err := a.GetScopesWithFeature(ctx, feature)
+	if err != nil {
+		return Page{}, err
+	}
+	return pageStrings(scopes, after, limit)
+}
+
+func (a persistenceStoreAdapter) GetScopeFeaturesFullPage(ctx context.Context, scope []byte, after string, limit int) (FeaturePage, error) {
+	features, err := a.GetScopeFeaturesFull(ctx, scope)
+	if err != nil {
+		return FeaturePage{}, err
+	}
+	return pageFeatures(features, after, limit)
+}
+
+// SearchFeatures filters the full feature list, since a PersistenceStore has no way to search.
+func (a persistenceStoreAdapter) SearchFeatures(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	if err := checkPageLimit(limit); err != nil {
+		return Page{}, err
+	}
+	m, err := q.nameMatcher()
+	if err != nil {
+		return Page{}, err
+	}
+	features, err := a.GetFeatures(ctx)
+	if err != nil {
+		return Page{}, err
+	}
+	var matches []string
+	for _, feature := range features {
+		if !m.match([]byte(feature)) {
+			continue
+		}
+		if q.Value == "" && q.ScopePrefix == "" {
+			matches = append(matches, feature)
+			continue
+		}
+		scopes, err := a.GetScopesWithFeature(ctx, []byte(feature))
+		if err != nil {
+			return Page{}, err
+		}
+		for _, scope := range scopes {
+			if !strings.HasPrefix(scope, q.ScopePrefix) {
+				continue
+			}
+			if f, err := a.Get(ctx, []byte(scope), []byte(feature)); err == nil && (q.Value == "" || f.Value == q.Value) {
+				matches = append(matches, feature)
+				break
+			}
+		}
+	}
+	return pageStrings(matches, after, limit)
+}
+
+// SearchScopes filters the full scope list, since a PersistenceStore has no way to search.
+func (a persistenceStoreAdapter) SearchScopes(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	if err := checkPageLimit(limit); err != nil {
+		return Page{}, err
+	}
+	m, err := q.nameMatcher()
+	if err != nil {
+		return Page{}, err
+	}
+	scopes, err := a.GetScopes(ctx)
+	if err != nil {
+		return Page{}, err
+	}
+	var matches []string
+	for _, scope := range scopes {
+		if !m.match([]byte(scope)) {
+			continue
+		}
+		if q.Value == "" && q.Feature == "" {
+			matches = append(matches, scope)
+			continue
+		}
+		features, err := a.GetScopeFeaturesFull(ctx, []byte(scope))
+		if err != nil {
+			return Page{}, err
+		}
+		for _, f := range features {
+			if (q.Feature == "" || f.Name == q.Feature) && (q.Value == "" || f.Value == q.Value) {
+				matches = append(matches, scope)
+				break
+			}
+		}
+	}
+	return pageStrings(matches, after, limit)
+}
+
+// GetFeatureSummary counts the feature's values scope by scope. A PersistenceStore keeps no
+// modification times, so the summary has none.
+func (a persistenceStoreAdapter) GetFeatureSummary(ctx context.Context, feature []byte) (FeatureSummary, error) {
+	scopes, err := a.GetScopesWithFeature(ctx, feature)
+	if err != nil {
+		return FeatureSummary{}, err
+	}
+	summary := NewFeatureSummary(feature)
+	for _, scope := range scopes {
+		f, err := a.Get(ctx, []byte(scope), feature)
+		if err != nil {
+			return FeatureSummary{}, err
+		}
+		summary.add(f.Value, nil)
+	}
+	return summary, nil
+}
+
+// CopyScope sets the source scope's features on the target one at a time. A PersistenceStore has no
+// transactions, so a failed copy can leave the target partly updated.
+func (a persistenceStoreAdapter) CopyScope(ctx context.Context, source []byte, opts CopyScopeOptions) (FlipadelphiaFeatures, error) {
+	features, err := a.GetScopeFeaturesFull(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	only := make(map[string]bool, len(opts.Features))
+	for _, feature := range opts.Features {
+		only[feature] = true
+	}
+	copied := FlipadelphiaFeatures{}
+	for _, feature := range features {
+		if len(only) > 0 && !only[feature.Name] {
+			continue
+		}
+		if !opts.Overwrite && a.ps.CheckScopeHasFeature([]byte(opts.Target), []byte(feature.Name)) {
+			continue
+		}
+		f, err := a.Set(ctx, []byte(opts.Target), []byte(feature.Name), []byte(feature.Value))
+		if err != nil {
+			return nil, err
+		}
+		copied = append(copied, f)
+	}
+	return copied, nil
+}
+
+// GetScopeParents returns no parents, a PersistenceStore has no scope hierarchy.
+func (a persistenceStoreAdapter) GetScopeParents(ctx context.Context, scope []byte) ([]string, error) {
+	return nil, checkContext(ctx)
+}
+
+func (a persistenceStoreAdapter) SetScopeParents(ctx context.Context, scope []byte, parents []string) error {
+	return ErrUnimplemented
+}
+
+// GetPrerequisites returns no prerequisites, a PersistenceStore has nowhere to keep them.
+func (a persistenceStoreAdapter) GetPrerequisites(ctx context.Context, feature []byte) ([]string, error) {
+	return nil, checkContext(ctx)
+}
+
+func (a persistenceStoreAdapter) SetPrerequisites(ctx context.Context, feature []byte, prerequisites []string) error {
+	return ErrUnimplemented
+}
+
+// GetSegments returns no segments, a PersistenceStore has nowhere to keep them.
+func (a persistenceStoreAdapter) GetSegments(ctx context.Context) (Segments, error) {
+	return Segments{}, checkContext(ctx)
+}
+
+func (a persistenceStoreAdapter) GetSegment(ctx context.Context, name []byte) (Segment, error) {
+	return Segment{}, ErrSegmentNotFound
+}
+
+func (a persistenceStoreAdapter) SetSegment(ctx context.Context, segment Segment) error {
+	return ErrUnimplemented
+}
+
+func (a persistenceStoreAdapter) DeleteSegment(ctx context.Context, name []byte) error {
+	return ErrUnimplemented
+}
+
+func (a persistenceStoreAdapter) SetSegmentFeature(ctx context.Context, name, feature, value []byte) (FlipadelphiaFeature, error) {
+	return FlipadelphiaFeature{}, ErrUnimplemented
+}
+
+// GetSchedules returns no scheduled changes, a PersistenceStore has nowhere to keep them.
+func (a persistenceStoreAdapter) GetSchedules(ctx context.Context) (ScheduledChanges, error) {
+	return ScheduledChanges{}, checkContext(ctx)
+}
+
+func (a persistenceStoreAdapter) AddSchedule(ctx context.Context, change ScheduledChange) (ScheduledChange, error) {
+	return ScheduledChange{}, ErrUnimplemented
+}
+
+func (a persistenceStoreAdapter) DeleteSchedule(ctx context.Context, id []byte) error {
+	return ErrScheduleNotFound
+}
+
+func (a persistenceStoreAdapter) GetExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	return Experiment{}, ErrExperimentNotFound
+}
+
+func (a persistenceStoreAdapter) SetExperiment(ctx context.Context, experiment Experiment) (Experiment, error) {
+	return Experiment{}, ErrUnimplemented
+}
+
+func (a persistenceStoreAdapter) DeleteExperiment(ctx context.Context, feature []byte) error {
+	return ErrExperimentNotFound
+}
+
+func (a persistenceStoreAdapter) ReshuffleExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	return Experiment{}, ErrExperimentNotFound
+}
+
+func (a persistenceStoreAdapter) AssignVariant(ctx context.Context, feature, scope []byte) (Assignment, error) {
+	return Assignment{}, ErrExperimentNotFound
+}
+
+// GetOverrides returns no overrides, a PersistenceStore has nowhere to keep them.
+func (a persistenceStoreAdapter) GetOverrides(ctx context.Context) (FlipadelphiaFeatures, error) {
+	return FlipadelphiaFeatures{}, checkContext(ctx)
+}
+
+func (a persistenceStoreAdapter) GetOverride(ctx context.Context, feature []byte) (FlipadelphiaFeature, error) {
+	return FlipadelphiaFeature{}, ErrOverrideNotFound
+}
+
+func (a persistenceStoreAdapter) SetOverride(ctx context.Context, feature, value []byte) (FlipadelphiaFeature, error) {
+	return FlipadelphiaFeature{}, ErrUnimplemented
+}
+
+func (a persistenceStoreAdapter) DeleteOverride(ctx context.Context, feature []byte) error {
+	return ErrOverrideNotFound
+}
+
+// GetUsage returns no checks, a PersistenceStore has nowhere to record them.
+func (a persistenceStoreAdapter) GetUsage(ctx context.Context, feature []byte) (FeatureUsage, error) {
+	return FeatureUsage{Name: string(feature)}, checkContext(ctx)
+}
+
+func (a persistenceStoreAdapter) GetUsages(ctx context.Context) (FeatureUsages, error) {
+	return FeatureUsages{}, checkContext(ctx)
+}
+
+func (a persistenceStoreAdapter) AddUsage(ctx context.Context, usages FeatureUsages) error {
+	return ErrUnimplemented
+}
+
+// Ping only checks the context, a PersistenceStore can not be pinged.
+func (a persistenceStoreAdapter) Ping(ctx context.Context) error {
+	return checkContext(ctx)
+}
+
+func (a persistenceStoreAdapter) CheckScopeExists(ctx context.Context, scope []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	return a.ps.CheckScopeExists(scope), nil
+}
+
+func (a persistenceStoreAdapter) CheckFeatureExists(ctx context.Context, feature []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	return a.ps.CheckFeatureExists(feature), nil
+}
+
+func (a persistenceStoreAdapter) CheckScopeHasFeature(ctx context.Context, scope, feature []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	return a.ps.CheckScopeHasFeature(scope, feature), nil
+}
+
+func (a persistenceStoreAdapter) CheckFeatureHasScope(ctx context.Context, scope, feature []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	return a.ps.CheckFeatureHasScope(scope, feature), nil
+}
+
+func (a persistenceStoreAdapter) Close() error {
+	return a.ps.Close()
+}
+
+func (l legacyPersistenceStore) Get(scope, feature []byte) (Serializable, error) {
+	return l.ps.Get(context.Background(), scope, feature)
+}
+
+func (l legacyPersistenceStore) GetScopeFeatures(scope []byte) (Serializable, error) {
+	features, err := l.ps.GetScopeFeatures(context.Background(), scope)
+	return FlipadelphiaScopeFeatures(features), err
+}
+
+func (l legacyPersistenceStore) GetScopeFeaturesFilterByValue(scope, value []byte) (Serializable, error) {
+	features, err := l.ps.GetScopeFeaturesFilterByValue(context.Background(), scope, value)
+	return FlipadelphiaScopeFeatures(features), err
+}
+
+func (l legacyPersistenceStore) Set(scope, feature, value []byte) (Serializable, error) {
+	return l.ps.Set(context.Background(), scope, feature, value)
+}
+
+func (l legacyPersistenceStore) GetScopes() (Serializable, error) {
+	scopes, err := l.ps.GetScopes(context.Background())
+	return FlipadelphiaScopeList(scopes), err
+}
+
+func (l legacyPersistenceStore) GetScopesWithPrefix(prefix []byte) (Serializable, error) {
+	scopes, err := l.ps.GetScopesWithPrefix(context.Background(), prefix)
+	return FlipadelphiaScopeList(scopes), err
+}
+
+func (l legacyPersistenceStore) GetScopesWithFeature(feature []byte) (Serializable, error) {
+	scopes, err := l.ps.GetScopesWithFeature(context.Background(), feature)
+	return FlipadelphiaScopeList(scopes), err
+}
+
+func (l legacyPersistenceStore) GetScopesPaginated(offset, count int) (Serializable, error) {
+	scopes, err := l.ps.GetScopesPaginated(context.Background(), offset, count)
+	return StringSlice(scopes), err
+}
+
+func (l legacyPersistenceStore) GetFeaturesPaginated(offset, count int) (Serializable, error) {
+	features, err := l.ps.GetFeaturesPaginated(context.Background(), offset, count)
+	return StringSlice(features), err
+}
+
+func (l legacyPersistenceStore) GetFeatures() (Serializable, error) {
+	features, err := l.ps.GetFeatures(context.Background())
+	return FlipadelphiaScopeFeatures(features), err
+}
+
+func (l legacyPersistenceStore) GetScopeFeaturesFull(scope []byte) (Serializable, error) {
+	return l.ps.GetScopeFeaturesFull(context.Background(), scope)
+}
+
+func (l legacyPersistenceStore) CheckScopeExists(scope []byte) bool {
+	exists, err := l.ps.CheckScopeExists(context.Background(), scope)
+	return err == nil && exists
+}
+
+func (l legacyPersistenceStore) CheckFeatureExists(feature []byte) bool {
+	exists, err := l.ps.CheckFeatureExists(context.Background(), feature)
+	return err == nil && exists
+}
+
+func (l legacyPersistenceStore) CheckScopeHasFeature(scope, feature []byte) bool {
+	exists, err := l.ps.CheckScopeHasFeature(context.Background(), scope, feature)
+	return err == nil && exists
+}
+
+func (l legacyPersistenceStore) CheckFeatureHasScope(scope, feature []byte) bool {
+	exists, err := l.ps.CheckFeatureHasScope(context.Background(), scope, feature)
+	return err == nil && exists
+}
+
+func (l legacyPersistenceStore) Close() error {
+	return l.ps.Close()
+}
@@ -2,7 +2,11 @@ package store
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/satori/go.uuid"
@@ -22,12 +26,10 @@ type BucketCreator interface {
 func createBuckets(bc BucketCreator, bucketNames ...[]byte) error {
 	for _, bktname := range bucketNames {
 		if _, err := bc.CreateBucketIfNotExists(bktname); err != nil {
-			msg := "Failed to create bucket: %q"
-			utils.LogOnError(err, fmt.Sprintf(msg, bktname), true)
+			utils.Log.WithError(err).WithField("bucket", string(bktname)).Error("Failed to create bucket")
 			return err
 		}
-		msg := fmt.Sprintf("CREATED BUCKET - Name: %q", bktname)
-		utils.LogOnSuccess(nil, msg)
+		utils.Log.WithField("bucket", string(bktname)).Debug("Created bucket")
 	}
 	return nil
 }
@@ -39,14 +41,41 @@ func NewFlipadelphiaBoltDB(db *bolt.DB) FlipadelphiaBoltDB {
 		[]byte("features"),
 		[]byte("scopes"),
 		[]byte("values"),
+		[]byte("modified"),
+		[]byte("parents"),
+		[]byte("segments"),
+		[]byte("schedules"),
+		[]byte("expires"),
+		[]byte("overrides"),
+		[]byte("prerequisites"),
+		[]byte("experiments"),
+		[]byte("assignments"),
+		[]byte("usage"),
 	}
-	db.Update(func(tx *bolt.Tx) error {
-		err := createBuckets(tx, requiredBuckets...)
-		return err
+	err := db.Update(func(tx *bolt.Tx) error {
+		return createBuckets(tx, requiredBuckets...)
 	})
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to create buckets")
+	}
 	return FlipadelphiaBoltDB{db: db}
 }
 
+// Ping opens and closes a read transaction, which fails once the database is closed.
+func (fdb FlipadelphiaBoltDB) Ping(ctx context.Context) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return fdb.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+// Stats returns the transaction and page statistics of the underlying BoltDB.
+func (fdb FlipadelphiaBoltDB) Stats() bolt.Stats {
+	return fdb.db.Stats()
+}
+
 func (fdb FlipadelphiaBoltDB) Close() error {
 	return fdb.db.Close()
 }
@@ -64,11 +93,13 @@ func (fdb FlipadelphiaBoltDB) getScopeFeatureValues(scope []byte) (map[string][]
 		}
 		scopeBkt := scopesBkt.Bucket(scope)
 		if scopeBkt == nil {
-			return fmt.Errorf(`Bucket does not exist: "scopes/%q"`, scope)
+			return ErrScopeNotFound
 		}
+		now := time.Now()
 		if err := scopeBkt.ForEach(func(k, v []byte) error {
-			value := valuesBkt.Get(v)
-			values[utils.Btos(k)] = value
+			if value := liveValue(tx, valuesBkt, v, now); value != nil {
+				values[utils.Btos(k)] = value
+			}
 			return nil
 		}); err != nil {
 			return err
@@ -102,8 +133,11 @@ func (fdb FlipadelphiaBoltDB) getAllScopes() (FlipadelphiaScopeList, error) {
 		if scopesBkt == nil {
 			return fmt.Errorf(`Bucket does not exist: "scopes"`)
 		}
+		now := time.Now()
 		if err := scopesBkt.ForEach(func(scope, valueUUID []byte) error {
-			scopes = append(scopes, fmt.Sprintf("%s", scope))
+			if hasLivePair(tx, scopesBkt.Bucket(scope), now) {
+				scopes = append(scopes, fmt.Sprintf("%s", scope))
+			}
 			return nil
 		}); err != nil {
 			return err
@@ -121,9 +155,12 @@ func (fdb FlipadelphiaBoltDB) getAllScopesWithPrefix(prefix []byte) (Flipadelphi
 		if scopesBkt == nil {
 			return fmt.Errorf(`Bucket does not exist: "scopes"`)
 		}
+		now := time.Now()
 		cursor := scopesBkt.Cursor()
-		for scope, _ := cursor.Seek(prefix); bytes.HasPrefix(scope, prefix); scope, _ = cursor.Next() {
-			scopes = append(scopes, fmt.Sprintf("%s", scope))
+		for scope, _ := cursor.Seek(prefix); scope != nil && bytes.HasPrefix(scope, prefix); scope, _ = cursor.Next() {
+			if hasLivePair(tx, scopesBkt.Bucket(scope), now) {
+				scopes = append(scopes, fmt.Sprintf("%s", scope))
+			}
 		}
 		return nil
 	})
@@ -138,17 +175,21 @@ func (fdb FlipadelphiaBoltDB) getScopesPaginated(offset, count int) (StringSlice
 		if scopesBkt == nil {
 			return fmt.Errorf(`Bucket does not exist: "scopes"`)
 		}
+		now := time.Now()
 		cursor := scopesBkt.Cursor()
 		scope, _ := cursor.First()
-		// Advance the cursor to the desired offset
-		for counter := 0; scope != nil && offset != 0 && counter < offset; scope, _ = cursor.Next() {
-			counter++
+		// Advance the cursor to the desired offset, counting only scopes with a value that has not expired
+		for counter := 0; scope != nil && counter < offset; scope, _ = cursor.Next() {
+			if hasLivePair(tx, scopesBkt.Bucket(scope), now) {
+				counter++
+			}
 		}
 		// Retrieve the next n scopes, where n=count
 		// Checks for key != nil to handle overflow, i.e. a bucket with 10 items, offset=5 and count=10
-		for scope != nil && len(scopes) < count {
-			scopes = append(scopes, string(scope))
-			scope, _ = cursor.Next()
+		for ; scope != nil && len(scopes) < count; scope, _ = cursor.Next() {
+			if hasLivePair(tx, scopesBkt.Bucket(scope), now) {
+				scopes = append(scopes, string(scope))
+			}
 		}
 		return nil
 	})
@@ -163,17 +204,21 @@ func (fdb FlipadelphiaBoltDB) getFeaturesPaginated(offset, count int) (StringSli
 		if featuresBkt == nil {
 			return fmt.Errorf(`Bucket does not exist: "features"`)
 		}
+		now := time.Now()
 		cursor := featuresBkt.Cursor()
 		feature, _ := cursor.First()
-		// Advance the cursor to the desired offset
-		for counter := 0; feature != nil && offset != 0 && counter < offset; feature, _ = cursor.Next() {
-			counter++
+		// Advance the cursor to the desired offset, counting only features with a value that has not expired
+		for counter := 0; feature != nil && counter < offset; feature, _ = cursor.Next() {
+			if hasLivePair(tx, featuresBkt.Bucket(feature), now) {
+				counter++
+			}
 		}
 		// Retrieve the next n features, where n=count
 		// Checks for key != nil to handle overflow, i.e. a bucket with 10 items, offset=5 and count=10
-		for feature != nil && len(features) < count {
-			features = append(features, string(feature))
-			feature, _ = cursor.Next()
+		for ; feature != nil && len(features) < count; feature, _ = cursor.Next() {
+			if hasLivePair(tx, featuresBkt.Bucket(feature), now) {
+				features = append(features, string(feature))
+			}
 		}
 		return nil
 	})
@@ -190,10 +235,13 @@ func (fdb FlipadelphiaBoltDB) getAllScopesWithFeature(feature []byte) (Flipadelp
 		}
 		featureBkt := featuresBkt.Bucket(feature)
 		if featureBkt == nil {
-			return fmt.Errorf(`Bucket does not exist: "features/%q"`, feature)
+			return ErrFeatureNotFound
 		}
+		now := time.Now()
 		if err := featureBkt.ForEach(func(scope, valueUUID []byte) error {
-			scopes = append(scopes, fmt.Sprintf("%s", scope))
+			if !isExpired(tx, valueUUID, now) {
+				scopes = append(scopes, fmt.Sprintf("%s", scope))
+			}
 			return nil
 		}); err != nil {
 			return err
@@ -213,15 +261,17 @@ func (fdb FlipadelphiaBoltDB) getAllFeaturesWithScope(scope []byte) (Flipadelphi
 		}
 		scopeBkt := scopesBkt.Bucket(scope)
 		if scopeBkt == nil {
-			return fmt.Errorf(`Bucket does not exist: "scopes/%q"`, scope)
+			return ErrScopeNotFound
 		}
 		valuesBkt := tx.Bucket([]byte("values"))
 		if valuesBkt == nil {
 			return fmt.Errorf(`Bucket does not exist: "values"`)
 		}
+		now := time.Now()
 		if err := scopeBkt.ForEach(func(feature, valueUUID []byte) error {
-			value := valuesBkt.Get(valueUUID)
-			features = append(features, NewFlipadelphiaFeature(feature, value))
+			if value := liveValue(tx, valuesBkt, valueUUID, now); value != nil {
+				features = append(features, NewFlipadelphiaFeature(feature, value))
+			}
 			return nil
 		}); err != nil {
 			return err
@@ -236,8 +286,14 @@ func (fdb FlipadelphiaBoltDB) getAllFeatures() (FlipadelphiaScopeFeatures, error
 
 	err := fdb.db.View(func(tx *bolt.Tx) error {
 		featuresBkt := tx.Bucket([]byte("features"))
+		if featuresBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "features"`)
+		}
+		now := time.Now()
 		if err := featuresBkt.ForEach(func(feature, value []byte) error {
-			features = append(features, fmt.Sprintf("%s", feature))
+			if hasLivePair(tx, featuresBkt.Bucket(feature), now) {
+				features = append(features, fmt.Sprintf("%s", feature))
+			}
 			return nil
 		}); err != nil {
 			return err
@@ -303,43 +359,340 @@ func (fdb FlipadelphiaBoltDB) setScopeFeatureUUIDValue(tx *bolt.Tx, scopeFeatUUI
 	return nil
 }
 
+// setModified records when the scope/feature pair with the uuid was last set.
+func (fdb FlipadelphiaBoltDB) setModified(tx *bolt.Tx, scopeFeatUUID []byte, modified time.Time) error {
+	modifiedBkt := tx.Bucket([]byte("modified"))
+	if modifiedBkt == nil {
+		if err := createBuckets(tx, []byte("modified")); err != nil {
+			return err
+		}
+		return fdb.setModified(tx, scopeFeatUUID, modified)
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(modified.UnixNano()))
+	return modifiedBkt.Put(scopeFeatUUID, b)
+}
+
+// getModified returns when the scope/feature pair with the uuid was last set. Values set before
+// modification times were recorded return nil.
+func getModified(tx *bolt.Tx, scopeFeatUUID []byte) *time.Time {
+	modifiedBkt := tx.Bucket([]byte("modified"))
+	if modifiedBkt == nil {
+		return nil
+	}
+	b := modifiedBkt.Get(scopeFeatUUID)
+	if len(b) != 8 {
+		return nil
+	}
+	modified := time.Unix(0, int64(binary.BigEndian.Uint64(b))).UTC()
+	return &modified
+}
+
+// boltExpiry records when a scope/feature pair expires. The pair is kept alongside so the sweeper can
+// remove it without scanning the scopes.
+type boltExpiry struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Scope     string    `json:"scope"`
+	Feature   string    `json:"feature"`
+}
+
+// getExpiry returns the expiry of the scope/feature pair with the uuid, or nil if it does not expire.
+func getExpiry(tx *bolt.Tx, scopeFeatUUID []byte) *boltExpiry {
+	expiresBkt := tx.Bucket([]byte("expires"))
+	if expiresBkt == nil {
+		return nil
+	}
+	b := expiresBkt.Get(scopeFeatUUID)
+	if b == nil {
+		return nil
+	}
+	var expiry boltExpiry
+	if err := json.Unmarshal(b, &expiry); err != nil {
+		return nil
+	}
+	return &expiry
+}
+
+// isExpired reports whether the scope/feature pair with the uuid expired before now.
+func isExpired(tx *bolt.Tx, scopeFeatUUID []byte, now time.Time) bool {
+	expiry := getExpiry(tx, scopeFeatUUID)
+	return expiry != nil && !expiry.ExpiresAt.After(now)
+}
+
+// liveValue returns the value of the scope/feature pair with the uuid, or nil if it has expired.
+func liveValue(tx *bolt.Tx, valuesBkt *bolt.Bucket, scopeFeatUUID []byte, now time.Time) []byte {
+	if isExpired(tx, scopeFeatUUID, now) {
+		return nil
+	}
+	return valuesBkt.Get(scopeFeatUUID)
+}
+
+// hasLivePair reports whether any scope/feature pair in a scope's or feature's bucket has not expired.
+// Scopes and features are only listed while they have one.
+func hasLivePair(tx *bolt.Tx, bkt *bolt.Bucket, now time.Time) bool {
+	if bkt == nil {
+		return false
+	}
+	cursor := bkt.Cursor()
+	for k, scopeFeatUUID := cursor.First(); k != nil; k, scopeFeatUUID = cursor.Next() {
+		if !isExpired(tx, scopeFeatUUID, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// setExpiry records when the scope/feature pair with the uuid expires. A zero time removes the expiry.
+func (fdb FlipadelphiaBoltDB) setExpiry(tx *bolt.Tx, scope, feature, scopeFeatUUID []byte, expiresAt time.Time) error {
+	expiresBkt := tx.Bucket([]byte("expires"))
+	if expiresBkt == nil {
+		if expiresAt.IsZero() {
+			return nil
+		}
+		if err := createBuckets(tx, []byte("expires")); err != nil {
+			return err
+		}
+		expiresBkt = tx.Bucket([]byte("expires"))
+	}
+	if expiresAt.IsZero() {
+		return expiresBkt.Delete(scopeFeatUUID)
+	}
+	b, err := json.Marshal(boltExpiry{ExpiresAt: expiresAt, Scope: string(scope), Feature: string(feature)})
+	if err != nil {
+		return err
+	}
+	return expiresBkt.Put(scopeFeatUUID, b)
+}
+
+// set stores the value of the feature on the scope within an update transaction. The uuid of an
+// existing scope/feature pair is reused so its old value is overwritten. The value expires at
+// expiresAt, or never if it is the zero time.
+func (fdb FlipadelphiaBoltDB) set(tx *bolt.Tx, scope, feature, value []byte, expiresAt time.Time) error {
+	var scopeFeatUUID []byte
+	if scopesBkt := tx.Bucket([]byte("scopes")); scopesBkt != nil {
+		if scopeBkt := scopesBkt.Bucket(scope); scopeBkt != nil {
+			scopeFeatUUID = scopeBkt.Get(feature)
+		}
+	}
+	if scopeFeatUUID == nil {
+		scopeFeatUUID = uuid.NewV4().Bytes()
+	} else {
+		scopeFeatUUID = append([]byte(nil), scopeFeatUUID...)
+	}
+
+	if err := fdb.setScopeFeature(tx, scope, feature, scopeFeatUUID); err != nil {
+		return err
+	}
+
+	if err := fdb.setFeatureScope(tx, scope, feature, scopeFeatUUID); err != nil {
+		return err
+	}
+
+	if err := fdb.setScopeFeatureUUIDValue(tx, scopeFeatUUID, value); err != nil {
+		return err
+	}
+
+	if err := fdb.setExpiry(tx, scope, feature, scopeFeatUUID, expiresAt); err != nil {
+		return err
+	}
+
+	return fdb.setModified(tx, scopeFeatUUID, time.Now())
+}
+
 // Set stores the feature in the database and returns an instance of FlipadelphiaFeature.
-func (fdb FlipadelphiaBoltDB) Set(scope []byte, feature []byte, value []byte) (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) Set(ctx context.Context, scope []byte, feature []byte, value []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	err := fdb.db.Update(func(tx *bolt.Tx) error {
+		return fdb.set(tx, scope, feature, value, time.Time{})
+	})
+	return NewFlipadelphiaFeature(feature, value), err
+}
+
+// SetWithExpiry stores the feature like Set. The value is treated as unset from expiresAt, and is
+// removed by DeleteExpired.
+func (fdb FlipadelphiaBoltDB) SetWithExpiry(ctx context.Context, scope, feature, value []byte, expiresAt time.Time) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
 	err := fdb.db.Update(func(tx *bolt.Tx) error {
-		scopeFeatUUID := uuid.NewV4().Bytes()
-		if err := fdb.setScopeFeature(tx, scope, feature, scopeFeatUUID); err != nil {
+		return fdb.set(tx, scope, feature, value, expiresAt)
+	})
+	return NewFlipadelphiaFeature(feature, value), err
+}
+
+// deletePair removes the scope/feature pair with the uuid, and the scope and feature buckets it
+// leaves empty.
+func (fdb FlipadelphiaBoltDB) deletePair(tx *bolt.Tx, scope, feature, scopeFeatUUID []byte) error {
+	for _, pair := range [][3][]byte{
+		{[]byte("scopes"), scope, feature},
+		{[]byte("features"), feature, scope},
+	} {
+		parentBkt := tx.Bucket(pair[0])
+		if parentBkt == nil {
+			continue
+		}
+		bkt := parentBkt.Bucket(pair[1])
+		if bkt == nil {
+			continue
+		}
+		if err := bkt.Delete(pair[2]); err != nil {
 			return err
 		}
+		if k, _ := bkt.Cursor().First(); k == nil {
+			if err := parentBkt.DeleteBucket(pair[1]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range []string{"values", "modified", "expires"} {
+		if bkt := tx.Bucket([]byte(name)); bkt != nil {
+			if err := bkt.Delete(scopeFeatUUID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteExpired removes every scope/feature pair whose value has expired, and returns how many were
+// removed.
+func (fdb FlipadelphiaBoltDB) DeleteExpired(ctx context.Context) (int, error) {
+	var deleted int
 
-		if err := fdb.setFeatureScope(tx, scope, feature, scopeFeatUUID); err != nil {
+	if err := checkContext(ctx); err != nil {
+		return 0, err
+	}
+	err := fdb.db.Update(func(tx *bolt.Tx) error {
+		expiresBkt := tx.Bucket([]byte("expires"))
+		if expiresBkt == nil {
+			return nil
+		}
+		now := time.Now()
+		var expired [][]byte
+		err := expiresBkt.ForEach(func(scopeFeatUUID, _ []byte) error {
+			if isExpired(tx, scopeFeatUUID, now) {
+				expired = append(expired, append([]byte(nil), scopeFeatUUID...))
+			}
+			return nil
+		})
+		if err != nil {
 			return err
 		}
+		for _, scopeFeatUUID := range expired {
+			expiry := getExpiry(tx, scopeFeatUUID)
+			if err := fdb.deletePair(tx, []byte(expiry.Scope), []byte(expiry.Feature), scopeFeatUUID); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// CopyScope copies the features of the source scope onto opts.Target in a single transaction and
+// returns the features that were copied. Values that expire on the source expire at the same time on
+// the target, and those that have expired are not copied.
+func (fdb FlipadelphiaBoltDB) CopyScope(ctx context.Context, source []byte, opts CopyScopeOptions) (FlipadelphiaFeatures, error) {
+	copied := FlipadelphiaFeatures{}
 
-		if err := fdb.setScopeFeatureUUIDValue(tx, scopeFeatUUID, value); err != nil {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	only := make(map[string]bool, len(opts.Features))
+	for _, feature := range opts.Features {
+		only[feature] = true
+	}
+	err := fdb.db.Update(func(tx *bolt.Tx) error {
+		scopesBkt := tx.Bucket([]byte("scopes"))
+		if scopesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "scopes"`)
+		}
+		valuesBkt := tx.Bucket([]byte("values"))
+		if valuesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "values"`)
+		}
+		sourceBkt := scopesBkt.Bucket(source)
+		if sourceBkt == nil {
+			return ErrScopeNotFound
+		}
+		targetBkt := scopesBkt.Bucket([]byte(opts.Target))
+		var expiries []time.Time
+		now := time.Now()
+		// Collect the features first, writes to the scopes bucket invalidate the cursor.
+		err := sourceBkt.ForEach(func(feature, scopeFeatUUID []byte) error {
+			value := liveValue(tx, valuesBkt, scopeFeatUUID, now)
+			if value == nil {
+				return nil
+			}
+			if len(only) > 0 && !only[string(feature)] {
+				return nil
+			}
+			if !opts.Overwrite && targetBkt != nil && targetBkt.Get(feature) != nil {
+				return nil
+			}
+			var expiresAt time.Time
+			if expiry := getExpiry(tx, scopeFeatUUID); expiry != nil {
+				expiresAt = expiry.ExpiresAt
+			}
+			copied = append(copied, NewFlipadelphiaFeature(feature, value))
+			expiries = append(expiries, expiresAt)
+			return nil
+		})
+		if err != nil {
 			return err
 		}
-
+		for i, feature := range copied {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fdb.set(tx, []byte(opts.Target), []byte(feature.Name), []byte(feature.Value), expiries[i]); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
-	return NewFlipadelphiaFeature(feature, value), err
+	if err != nil {
+		return nil, err
+	}
+	return copied, nil
 }
 
 // Get retrieves the feature from the database and returns an instance of FlipadelphiaFeature.
-func (fdb FlipadelphiaBoltDB) Get(scope []byte, feature []byte) (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) Get(ctx context.Context, scope []byte, feature []byte) (FlipadelphiaFeature, error) {
 	var value []byte
 
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
 	err := fdb.db.View(func(tx *bolt.Tx) error {
+		if overridesBkt := tx.Bucket([]byte("overrides")); overridesBkt != nil {
+			if value = overridesBkt.Get(feature); value != nil {
+				return nil
+			}
+		}
 		scopesBkt := tx.Bucket([]byte("scopes"))
+		if scopesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "scopes"`)
+		}
 		scopeBkt := scopesBkt.Bucket(scope)
+		if scopeBkt == nil {
+			return ErrScopeNotFound
+		}
 		scopeFeatUUID := scopeBkt.Get(feature)
 		if scopeFeatUUID == nil {
-			return fmt.Errorf("Feature %q not set for scope %q", feature, scope)
+			return ErrFeatureNotFound
 		}
 
 		valuesBkt := tx.Bucket([]byte("values"))
-		value = valuesBkt.Get(scopeFeatUUID)
+		if valuesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "values"`)
+		}
+		value = liveValue(tx, valuesBkt, scopeFeatUUID, time.Now())
 		if value == nil {
-			return fmt.Errorf("Feature %q not set for scope %q", feature, scope)
+			return ErrFeatureNotFound
 		}
 		return nil
 	})
@@ -347,9 +700,12 @@ func (fdb FlipadelphiaBoltDB) Get(scope []byte, feature []byte) (Serializable, e
 }
 
 // GetScopeFeatures returns all features set on the given scope.
-func (fdb FlipadelphiaBoltDB) GetScopeFeatures(scope []byte) (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) GetScopeFeatures(ctx context.Context, scope []byte) ([]string, error) {
 	var featureList FlipadelphiaScopeFeatures
 
+	if err := checkContext(ctx); err != nil {
+		return featureList, err
+	}
 	scopeKeys, err := fdb.getScopeFeatureValues(scope)
 	if err != nil {
 		return featureList, err
@@ -361,9 +717,12 @@ func (fdb FlipadelphiaBoltDB) GetScopeFeatures(scope []byte) (Serializable, erro
 }
 
 // GetScopeFeaturesFilterByValue returns all features on the given scope with a certain value.
-func (fdb FlipadelphiaBoltDB) GetScopeFeaturesFilterByValue(scope []byte, value []byte) (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) GetScopeFeaturesFilterByValue(ctx context.Context, scope []byte, value []byte) ([]string, error) {
 	var featureList FlipadelphiaScopeFeatures
 
+	if err := checkContext(ctx); err != nil {
+		return featureList, err
+	}
 	scopeKeys, err := fdb.getScopeKeyValuesWithCertainValue(scope, value)
 	if err != nil {
 		return featureList, err
@@ -375,43 +734,64 @@ func (fdb FlipadelphiaBoltDB) GetScopeFeaturesFilterByValue(scope []byte, value
 }
 
 // GetScopes returns all scopes.
-func (fdb FlipadelphiaBoltDB) GetScopes() (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) GetScopes(ctx context.Context) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
 	scopes, err := fdb.getAllScopes()
 	return scopes, err
 }
 
 // GetScopesWithPrefix returns all scopes with a certain prefix.
-func (fdb FlipadelphiaBoltDB) GetScopesWithPrefix(prefix []byte) (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) GetScopesWithPrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
 	scopes, err := fdb.getAllScopesWithPrefix(prefix)
 	return scopes, err
 }
 
 // GetScopesWithFeature returns all scopes that have a certain feature set.
-func (fdb FlipadelphiaBoltDB) GetScopesWithFeature(feature []byte) (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) GetScopesWithFeature(ctx context.Context, feature []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
 	scopes, err := fdb.getAllScopesWithFeature(feature)
 	return scopes, err
 }
 
-func (fdb FlipadelphiaBoltDB) GetScopesPaginated(offset, count int) (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) GetScopesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
 	scopes, err := fdb.getScopesPaginated(offset, count)
 	return scopes, err
 }
 
-func (fdb FlipadelphiaBoltDB) GetFeaturesPaginated(offset, count int) (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) GetFeaturesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
 	features, err := fdb.getFeaturesPaginated(offset, count)
 	return features, err
 }
 
 // GetFeatures returns a list of all features set on all scopes.
-func (fdb FlipadelphiaBoltDB) GetFeatures() (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) GetFeatures(ctx context.Context) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
 	features, err := fdb.getAllFeatures()
 	return features, err
 }
 
 // GetScopeFeaturesFull returns a list of FlipadelphiaFeature objects for the given scope.
-func (fdb FlipadelphiaBoltDB) GetScopeFeaturesFull(scope []byte) (Serializable, error) {
+func (fdb FlipadelphiaBoltDB) GetScopeFeaturesFull(ctx context.Context, scope []byte) (FlipadelphiaFeatures, error) {
 	var features FlipadelphiaFeatures
 
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeatures{}, err
+	}
 	keyVals, err := fdb.getScopeFeatureValues(scope)
 	if err != nil {
 		return FlipadelphiaFeatures{}, err
@@ -422,78 +802,1036 @@ func (fdb FlipadelphiaBoltDB) GetScopeFeaturesFull(scope []byte) (Serializable,
 	return features, nil
 }
 
-func (fdb FlipadelphiaBoltDB) CheckScopeExists(scope []byte) bool {
+// CheckScopeExists reports whether any feature has been set on the scope.
+func (fdb FlipadelphiaBoltDB) CheckScopeExists(ctx context.Context, scope []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	var exists bool
 	err := fdb.db.View(func(tx *bolt.Tx) error {
 		scopesBkt := tx.Bucket([]byte("scopes"))
 		if scopesBkt == nil {
 			return fmt.Errorf(`Bucket does not exist: "scopes"`)
 		}
-		scopeBkt := scopesBkt.Bucket(scope)
-		if scopeBkt == nil {
-			return fmt.Errorf(`Bucket does not exist: "scopes/%q"`, scope)
+		exists = hasLivePair(tx, scopesBkt.Bucket(scope), time.Now())
+		return nil
+	})
+	return exists, err
+}
+
+// CheckFeatureExists reports whether the feature has been set on any scope.
+func (fdb FlipadelphiaBoltDB) CheckFeatureExists(ctx context.Context, feature []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	var exists bool
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		featuresBkt := tx.Bucket([]byte("features"))
+		if featuresBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "features"`)
 		}
+		exists = hasLivePair(tx, featuresBkt.Bucket(feature), time.Now())
 		return nil
 	})
-	if err != nil {
-		return false
+	return exists, err
+}
+
+// CheckScopeHasFeature reports whether the feature is set on the scope, looked up through the "scopes" bucket.
+func (fdb FlipadelphiaBoltDB) CheckScopeHasFeature(ctx context.Context, scope, feature []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
 	}
-	return true
+	var exists bool
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		scopesBkt := tx.Bucket([]byte("scopes"))
+		if scopesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "scopes"`)
+		}
+		if scopeBkt := scopesBkt.Bucket(scope); scopeBkt != nil {
+			scopeFeatUUID := scopeBkt.Get(feature)
+			exists = scopeFeatUUID != nil && !isExpired(tx, scopeFeatUUID, time.Now())
+		}
+		return nil
+	})
+	return exists, err
 }
 
-func (fdb FlipadelphiaBoltDB) CheckFeatureExists(feature []byte) bool {
+// CheckFeatureHasScope reports whether the feature is set on the scope, looked up through the "features" bucket.
+func (fdb FlipadelphiaBoltDB) CheckFeatureHasScope(ctx context.Context, scope, feature []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	var exists bool
 	err := fdb.db.View(func(tx *bolt.Tx) error {
 		featuresBkt := tx.Bucket([]byte("features"))
 		if featuresBkt == nil {
 			return fmt.Errorf(`Bucket does not exist: "features"`)
 		}
-		featureBkt := featuresBkt.Bucket(feature)
-		if featureBkt == nil {
-			return fmt.Errorf(`Bucket does not exist: "features/%q"`, feature)
+		if featureBkt := featuresBkt.Bucket(feature); featureBkt != nil {
+			scopeFeatUUID := featureBkt.Get(scope)
+			exists = scopeFeatUUID != nil && !isExpired(tx, scopeFeatUUID, time.Now())
 		}
 		return nil
 	})
+	return exists, err
+}
+
+// seekAfter positions the cursor on the first key after the one held in the page token.
+func seekAfter(cursor *bolt.Cursor, after string) ([]byte, []byte, error) {
+	key, err := DecodePageToken(after)
 	if err != nil {
-		return false
+		return nil, nil, err
 	}
-	return true
+	if key == nil {
+		k, v := cursor.First()
+		return k, v, nil
+	}
+	k, v := cursor.Seek(key)
+	if bytes.Equal(k, key) {
+		k, v = cursor.Next()
+	}
+	return k, v, nil
 }
 
-func (fdb FlipadelphiaBoltDB) CheckScopeHasFeature(scope, feature []byte) bool {
-	if scopeExists := fdb.CheckScopeExists(scope); !scopeExists {
-		return scopeExists
+// readPage calls fn with the keys following the page token until it has accepted limit of them, and
+// returns the token for the next page. The token is empty if the cursor is exhausted.
+func readPage(ctx context.Context, cursor *bolt.Cursor, after string, limit int, fn func(k, v []byte) bool) (string, error) {
+	k, v, err := seekAfter(cursor, after)
+	if err != nil {
+		return "", err
+	}
+	var last []byte
+	for n := 0; k != nil && n < limit; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if fn(k, v) {
+			n++
+		}
+		last = k
 	}
-	if featureExists := fdb.CheckFeatureExists(feature); !featureExists {
-		return featureExists
+	if k == nil {
+		return "", nil
+	}
+	return EncodePageToken(last), nil
+}
+
+// GetScopesPage returns a page of scopes, in key order, following the page token.
+func (fdb FlipadelphiaBoltDB) GetScopesPage(ctx context.Context, after string, limit int) (Page, error) {
+	var page Page
+
+	if err := checkContext(ctx); err != nil {
+		return page, err
 	}
 	err := fdb.db.View(func(tx *bolt.Tx) error {
-		scopeBkt := tx.Bucket([]byte("scopes")).Bucket(scope)
-		if val := scopeBkt.Get(feature); val == nil {
-			return fmt.Errorf(`Bucket key does not exist: "scopes/%q/%q"`, scope, feature)
+		scopesBkt := tx.Bucket([]byte("scopes"))
+		if scopesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "scopes"`)
 		}
-		return nil
+		now := time.Now()
+		next, err := readPage(ctx, scopesBkt.Cursor(), after, limit, func(scope, _ []byte) bool {
+			if !hasLivePair(tx, scopesBkt.Bucket(scope), now) {
+				return false
+			}
+			page.Items = append(page.Items, string(scope))
+			return true
+		})
+		page.Next = next
+		return err
 	})
-	if err != nil {
-		return false
+	return page, err
+}
+
+// GetFeaturesPage returns a page of features, in key order, following the page token.
+func (fdb FlipadelphiaBoltDB) GetFeaturesPage(ctx context.Context, after string, limit int) (Page, error) {
+	var page Page
+
+	if err := checkContext(ctx); err != nil {
+		return page, err
 	}
-	return true
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		featuresBkt := tx.Bucket([]byte("features"))
+		if featuresBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "features"`)
+		}
+		now := time.Now()
+		next, err := readPage(ctx, featuresBkt.Cursor(), after, limit, func(feature, _ []byte) bool {
+			if !hasLivePair(tx, featuresBkt.Bucket(feature), now) {
+				return false
+			}
+			page.Items = append(page.Items, string(feature))
+			return true
+		})
+		page.Next = next
+		return err
+	})
+	return page, err
 }
 
-func (fdb FlipadelphiaBoltDB) CheckFeatureHasScope(scope, feature []byte) bool {
-	if scopeExists := fdb.CheckScopeExists(scope); !scopeExists {
-		return scopeExists
+// GetScopesWithFeaturePage returns a page of the scopes that have the feature set, following the page token.
+func (fdb FlipadelphiaBoltDB) GetScopesWithFeaturePage(ctx context.Context, feature []byte, after string, limit int) (Page, error) {
+	var page Page
+
+	if err := checkContext(ctx); err != nil {
+		return page, err
 	}
-	if featureExists := fdb.CheckFeatureExists(feature); !featureExists {
-		return featureExists
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		featuresBkt := tx.Bucket([]byte("features"))
+		if featuresBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "features"`)
+		}
+		featureBkt := featuresBkt.Bucket(feature)
+		if featureBkt == nil {
+			return ErrFeatureNotFound
+		}
+		now := time.Now()
+		next, err := readPage(ctx, featureBkt.Cursor(), after, limit, func(scope, valueUUID []byte) bool {
+			if isExpired(tx, valueUUID, now) {
+				return false
+			}
+			page.Items = append(page.Items, string(scope))
+			return true
+		})
+		page.Next = next
+		return err
+	})
+	return page, err
+}
+
+// GetScopeFeaturesFullPage returns a page of the features set on the scope with their values,
+// following the page token.
+func (fdb FlipadelphiaBoltDB) GetScopeFeaturesFullPage(ctx context.Context, scope []byte, after string, limit int) (FeaturePage, error) {
+	var page FeaturePage
+
+	if err := checkContext(ctx); err != nil {
+		return page, err
 	}
 	err := fdb.db.View(func(tx *bolt.Tx) error {
-		featureBkt := tx.Bucket([]byte("features")).Bucket(feature)
-		if val := featureBkt.Get(scope); val == nil {
-			return fmt.Errorf(`Bucket key does not exist: "features/%q/%q"`, feature, scope)
+		scopesBkt := tx.Bucket([]byte("scopes"))
+		if scopesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "scopes"`)
 		}
-		return nil
+		valuesBkt := tx.Bucket([]byte("values"))
+		if valuesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "values"`)
+		}
+		scopeBkt := scopesBkt.Bucket(scope)
+		if scopeBkt == nil {
+			return ErrScopeNotFound
+		}
+		now := time.Now()
+		next, err := readPage(ctx, scopeBkt.Cursor(), after, limit, func(feature, valueUUID []byte) bool {
+			value := liveValue(tx, valuesBkt, valueUUID, now)
+			if value == nil {
+				return false
+			}
+			page.Items = append(page.Items, NewFlipadelphiaFeature(feature, value))
+			return true
+		})
+		page.Next = next
+		return err
 	})
+	return page, err
+}
+
+// searchPage returns up to limit keys of the bucket, following the page token, that match the name
+// matcher and are accepted by the filter. The scan starts at the matcher's literal prefix and stops
+// at the first key without it.
+func searchPage(ctx context.Context, bkt *bolt.Bucket, m nameMatcher, after string, limit int, accept func(k []byte) bool) (Page, error) {
+	var page Page
+	cursor := bkt.Cursor()
+	k, _, err := seekAfter(cursor, after)
 	if err != nil {
-		return false
+		return page, err
 	}
-	return true
+	if k != nil && bytes.Compare(k, m.prefix) < 0 {
+		k, _ = cursor.Seek(m.prefix)
+	}
+	for ; k != nil && bytes.HasPrefix(k, m.prefix); k, _ = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return Page{}, err
+		}
+		if len(page.Items) == limit {
+			page.Next = EncodePageToken([]byte(page.Items[limit-1]))
+			break
+		}
+		if m.match(k) && accept(k) {
+			page.Items = append(page.Items, string(k))
+		}
+	}
+	return page, nil
+}
+
+// SearchFeatures returns a page of the features matching the query, following the page token.
+func (fdb FlipadelphiaBoltDB) SearchFeatures(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	var page Page
+
+	if err := checkPageLimit(limit); err != nil {
+		return page, err
+	}
+	if err := checkContext(ctx); err != nil {
+		return page, err
+	}
+	m, err := q.nameMatcher()
+	if err != nil {
+		return page, err
+	}
+	err = fdb.db.View(func(tx *bolt.Tx) error {
+		featuresBkt := tx.Bucket([]byte("features"))
+		if featuresBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "features"`)
+		}
+		valuesBkt := tx.Bucket([]byte("values"))
+		if valuesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "values"`)
+		}
+		prefix := []byte(q.ScopePrefix)
+		value := []byte(q.Value)
+		now := time.Now()
+		page, err = searchPage(ctx, featuresBkt, m, after, limit, func(feature []byte) bool {
+			if q.ScopePrefix == "" && q.Value == "" {
+				return hasLivePair(tx, featuresBkt.Bucket(feature), now)
+			}
+			cursor := featuresBkt.Bucket(feature).Cursor()
+			for scope, valueUUID := cursor.Seek(prefix); scope != nil && bytes.HasPrefix(scope, prefix); scope, valueUUID = cursor.Next() {
+				v := liveValue(tx, valuesBkt, valueUUID, now)
+				if v != nil && (q.Value == "" || bytes.Equal(v, value)) {
+					return true
+				}
+			}
+			return false
+		})
+		return err
+	})
+	return page, err
+}
+
+// SearchScopes returns a page of the scopes matching the query, following the page token.
+func (fdb FlipadelphiaBoltDB) SearchScopes(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	var page Page
+
+	if err := checkPageLimit(limit); err != nil {
+		return page, err
+	}
+	if err := checkContext(ctx); err != nil {
+		return page, err
+	}
+	m, err := q.nameMatcher()
+	if err != nil {
+		return page, err
+	}
+	err = fdb.db.View(func(tx *bolt.Tx) error {
+		scopesBkt := tx.Bucket([]byte("scopes"))
+		if scopesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "scopes"`)
+		}
+		valuesBkt := tx.Bucket([]byte("values"))
+		if valuesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "values"`)
+		}
+		value := []byte(q.Value)
+		now := time.Now()
+		page, err = searchPage(ctx, scopesBkt, m, after, limit, func(scope []byte) bool {
+			scopeBkt := scopesBkt.Bucket(scope)
+			if q.Feature != "" {
+				valueUUID := scopeBkt.Get([]byte(q.Feature))
+				if valueUUID == nil {
+					return false
+				}
+				v := liveValue(tx, valuesBkt, valueUUID, now)
+				return v != nil && (q.Value == "" || bytes.Equal(v, value))
+			}
+			if q.Value == "" {
+				return hasLivePair(tx, scopeBkt, now)
+			}
+			cursor := scopeBkt.Cursor()
+			for feature, valueUUID := cursor.First(); feature != nil; feature, valueUUID = cursor.Next() {
+				if bytes.Equal(liveValue(tx, valuesBkt, valueUUID, now), value) {
+					return true
+				}
+			}
+			return false
+		})
+		return err
+	})
+	return page, err
+}
+
+// GetFeatureSummary counts the scopes the feature is set on by value, in a single read transaction.
+func (fdb FlipadelphiaBoltDB) GetFeatureSummary(ctx context.Context, feature []byte) (FeatureSummary, error) {
+	summary := NewFeatureSummary(feature)
+
+	if err := checkContext(ctx); err != nil {
+		return summary, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		featuresBkt := tx.Bucket([]byte("features"))
+		if featuresBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "features"`)
+		}
+		valuesBkt := tx.Bucket([]byte("values"))
+		if valuesBkt == nil {
+			return fmt.Errorf(`Bucket does not exist: "values"`)
+		}
+		featureBkt := featuresBkt.Bucket(feature)
+		if featureBkt == nil {
+			return ErrFeatureNotFound
+		}
+		now := time.Now()
+		return featureBkt.ForEach(func(scope, scopeFeatUUID []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if value := liveValue(tx, valuesBkt, scopeFeatUUID, now); value != nil {
+				summary.add(string(value), getModified(tx, scopeFeatUUID))
+			}
+			return nil
+		})
+	})
+	return summary, err
+}
+
+// getParents returns the parents declared for the scope, stored as a json array.
+func getParents(tx *bolt.Tx, scope []byte) ([]string, error) {
+	parentsBkt := tx.Bucket([]byte("parents"))
+	if parentsBkt == nil {
+		return nil, nil
+	}
+	var parents []string
+	if b := parentsBkt.Get(scope); b != nil {
+		if err := json.Unmarshal(b, &parents); err != nil {
+			return nil, err
+		}
+	}
+	return parents, nil
+}
+
+// GetScopeParents returns the parents declared for the scope, nearest first.
+func (fdb FlipadelphiaBoltDB) GetScopeParents(ctx context.Context, scope []byte) ([]string, error) {
+	var parents []string
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		var err error
+		parents, err = getParents(tx, scope)
+		return err
+	})
+	return parents, err
+}
+
+// SetScopeParents replaces the parents declared for the scope. An empty list removes them. Parents
+// that would make the scope its own ancestor are rejected with ErrScopeCycle.
+func (fdb FlipadelphiaBoltDB) SetScopeParents(ctx context.Context, scope []byte, parents []string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return fdb.db.Update(func(tx *bolt.Tx) error {
+		err := checkScopeCycle(string(scope), parents, func(s string) ([]string, error) {
+			return getParents(tx, []byte(s))
+		})
+		if err != nil {
+			return err
+		}
+		parentsBkt := tx.Bucket([]byte("parents"))
+		if parentsBkt == nil {
+			if err := createBuckets(tx, []byte("parents")); err != nil {
+				return err
+			}
+			parentsBkt = tx.Bucket([]byte("parents"))
+		}
+		if len(parents) == 0 {
+			return parentsBkt.Delete(scope)
+		}
+		b, err := json.Marshal(parents)
+		if err != nil {
+			return err
+		}
+		return parentsBkt.Put(scope, b)
+	})
+}
+
+// getPrerequisites returns the prerequisites declared for the feature, stored as a json array.
+func getPrerequisites(tx *bolt.Tx, feature []byte) ([]string, error) {
+	prerequisitesBkt := tx.Bucket([]byte("prerequisites"))
+	if prerequisitesBkt == nil {
+		return nil, nil
+	}
+	var prerequisites []string
+	if b := prerequisitesBkt.Get(feature); b != nil {
+		if err := json.Unmarshal(b, &prerequisites); err != nil {
+			return nil, err
+		}
+	}
+	return prerequisites, nil
+}
+
+// GetPrerequisites returns the prerequisites declared for the feature.
+func (fdb FlipadelphiaBoltDB) GetPrerequisites(ctx context.Context, feature []byte) ([]string, error) {
+	var prerequisites []string
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		var err error
+		prerequisites, err = getPrerequisites(tx, feature)
+		return err
+	})
+	return prerequisites, err
+}
+
+// SetPrerequisites replaces the prerequisites declared for the feature. An empty list removes them.
+// Prerequisites that would make the feature depend on itself are rejected with ErrPrerequisiteCycle.
+func (fdb FlipadelphiaBoltDB) SetPrerequisites(ctx context.Context, feature []byte, prerequisites []string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return fdb.db.Update(func(tx *bolt.Tx) error {
+		err := checkPrerequisiteCycle(string(feature), prerequisites, func(f string) ([]string, error) {
+			return getPrerequisites(tx, []byte(f))
+		})
+		if err != nil {
+			return err
+		}
+		prerequisitesBkt, err := tx.CreateBucketIfNotExists([]byte("prerequisites"))
+		if err != nil {
+			return err
+		}
+		if len(prerequisites) == 0 {
+			return prerequisitesBkt.Delete(feature)
+		}
+		b, err := json.Marshal(prerequisites)
+		if err != nil {
+			return err
+		}
+		return prerequisitesBkt.Put(feature, b)
+	})
+}
+
+// getSegment returns the segment stored under the name, as json, or nil if it is not defined.
+func getSegment(tx *bolt.Tx, name []byte) (*Segment, error) {
+	segmentsBkt := tx.Bucket([]byte("segments"))
+	if segmentsBkt == nil {
+		return nil, nil
+	}
+	b := segmentsBkt.Get(name)
+	if b == nil {
+		return nil, nil
+	}
+	segment := NewSegment(string(name), nil, "")
+	if err := json.Unmarshal(b, &segment); err != nil {
+		return nil, err
+	}
+	return &segment, nil
+}
+
+// putSegment stores the segment under its name.
+func putSegment(tx *bolt.Tx, segment Segment) error {
+	segmentsBkt := tx.Bucket([]byte("segments"))
+	if segmentsBkt == nil {
+		if err := createBuckets(tx, []byte("segments")); err != nil {
+			return err
+		}
+		segmentsBkt = tx.Bucket([]byte("segments"))
+	}
+	b, err := json.Marshal(segment)
+	if err != nil {
+		return err
+	}
+	return segmentsBkt.Put([]byte(segment.Name), b)
+}
+
+// GetSegments returns every defined segment, sorted by name.
+func (fdb FlipadelphiaBoltDB) GetSegments(ctx context.Context) (Segments, error) {
+	segments := Segments{}
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		segmentsBkt := tx.Bucket([]byte("segments"))
+		if segmentsBkt == nil {
+			return nil
+		}
+		return segmentsBkt.ForEach(func(name, _ []byte) error {
+			segment, err := getSegment(tx, name)
+			if err != nil {
+				return err
+			}
+			segments = append(segments, *segment)
+			return nil
+		})
+	})
+	return segments, err
+}
+
+// GetSegment returns the named segment.
+func (fdb FlipadelphiaBoltDB) GetSegment(ctx context.Context, name []byte) (Segment, error) {
+	var segment *Segment
+
+	if err := checkContext(ctx); err != nil {
+		return Segment{}, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		var err error
+		segment, err = getSegment(tx, name)
+		if err == nil && segment == nil {
+			return ErrSegmentNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return Segment{}, err
+	}
+	return *segment, nil
+}
+
+// SetSegment creates the segment, or replaces the members and prefix of an existing one. Features
+// already set on the segment are kept.
+func (fdb FlipadelphiaBoltDB) SetSegment(ctx context.Context, segment Segment) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return fdb.db.Update(func(tx *bolt.Tx) error {
+		updated := NewSegment(segment.Name, segment.Members, segment.Prefix)
+		existing, err := getSegment(tx, []byte(segment.Name))
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			updated.Features = existing.Features
+		}
+		return putSegment(tx, updated)
+	})
+}
+
+// DeleteSegment removes the named segment and the features set on it.
+func (fdb FlipadelphiaBoltDB) DeleteSegment(ctx context.Context, name []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return fdb.db.Update(func(tx *bolt.Tx) error {
+		segmentsBkt := tx.Bucket([]byte("segments"))
+		if segmentsBkt == nil || segmentsBkt.Get(name) == nil {
+			return ErrSegmentNotFound
+		}
+		return segmentsBkt.Delete(name)
+	})
+}
+
+// SetSegmentFeature sets the value of the feature on the named segment.
+func (fdb FlipadelphiaBoltDB) SetSegmentFeature(ctx context.Context, name, feature, value []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	err := fdb.db.Update(func(tx *bolt.Tx) error {
+		segment, err := getSegment(tx, name)
+		if err != nil {
+			return err
+		}
+		if segment == nil {
+			return ErrSegmentNotFound
+		}
+		segment.Features[string(feature)] = string(value)
+		return putSegment(tx, *segment)
+	})
+	return NewFlipadelphiaFeature(feature, value), err
+}
+
+// GetSchedules returns the pending scheduled changes, soonest first.
+func (fdb FlipadelphiaBoltDB) GetSchedules(ctx context.Context) (ScheduledChanges, error) {
+	changes := ScheduledChanges{}
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		schedulesBkt := tx.Bucket([]byte("schedules"))
+		if schedulesBkt == nil {
+			return nil
+		}
+		return schedulesBkt.ForEach(func(id, b []byte) error {
+			var change ScheduledChange
+			if err := json.Unmarshal(b, &change); err != nil {
+				return err
+			}
+			changes = append(changes, change)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortScheduledChanges(changes)
+	return changes, nil
+}
+
+// AddSchedule stores a scheduled change under a new id, and returns it with the id set.
+func (fdb FlipadelphiaBoltDB) AddSchedule(ctx context.Context, change ScheduledChange) (ScheduledChange, error) {
+	if err := checkContext(ctx); err != nil {
+		return ScheduledChange{}, err
+	}
+	change.ID = uuid.NewV4().String()
+	err := fdb.db.Update(func(tx *bolt.Tx) error {
+		schedulesBkt := tx.Bucket([]byte("schedules"))
+		if schedulesBkt == nil {
+			if err := createBuckets(tx, []byte("schedules")); err != nil {
+				return err
+			}
+			schedulesBkt = tx.Bucket([]byte("schedules"))
+		}
+		b, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		return schedulesBkt.Put([]byte(change.ID), b)
+	})
+	if err != nil {
+		return ScheduledChange{}, err
+	}
+	return change, nil
+}
+
+// DeleteSchedule removes a pending scheduled change.
+func (fdb FlipadelphiaBoltDB) DeleteSchedule(ctx context.Context, id []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return fdb.db.Update(func(tx *bolt.Tx) error {
+		schedulesBkt := tx.Bucket([]byte("schedules"))
+		if schedulesBkt == nil || schedulesBkt.Get(id) == nil {
+			return ErrScheduleNotFound
+		}
+		return schedulesBkt.Delete(id)
+	})
+}
+
+// GetOverrides returns every feature override, sorted by feature name.
+func (fdb FlipadelphiaBoltDB) GetOverrides(ctx context.Context) (FlipadelphiaFeatures, error) {
+	overrides := FlipadelphiaFeatures{}
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		overridesBkt := tx.Bucket([]byte("overrides"))
+		if overridesBkt == nil {
+			return nil
+		}
+		return overridesBkt.ForEach(func(feature, value []byte) error {
+			overrides = append(overrides, NewFlipadelphiaFeature(feature, value))
+			return nil
+		})
+	})
+	return overrides, err
+}
+
+// GetOverride returns the override of the feature.
+func (fdb FlipadelphiaBoltDB) GetOverride(ctx context.Context, feature []byte) (FlipadelphiaFeature, error) {
+	var value []byte
+
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		overridesBkt := tx.Bucket([]byte("overrides"))
+		if overridesBkt == nil {
+			return ErrOverrideNotFound
+		}
+		if value = overridesBkt.Get(feature); value == nil {
+			return ErrOverrideNotFound
+		}
+		return nil
+	})
+	return NewFlipadelphiaFeature(feature, value), err
+}
+
+// SetOverride forces the feature to the value on every scope, until the override is deleted.
+func (fdb FlipadelphiaBoltDB) SetOverride(ctx context.Context, feature, value []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	err := fdb.db.Update(func(tx *bolt.Tx) error {
+		overridesBkt, err := tx.CreateBucketIfNotExists([]byte("overrides"))
+		if err != nil {
+			return err
+		}
+		return overridesBkt.Put(feature, value)
+	})
+	return NewFlipadelphiaFeature(feature, value), err
+}
+
+// DeleteOverride removes the feature's override, so its per-scope values apply again.
+func (fdb FlipadelphiaBoltDB) DeleteOverride(ctx context.Context, feature []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return fdb.db.Update(func(tx *bolt.Tx) error {
+		overridesBkt := tx.Bucket([]byte("overrides"))
+		if overridesBkt == nil || overridesBkt.Get(feature) == nil {
+			return ErrOverrideNotFound
+		}
+		return overridesBkt.Delete(feature)
+	})
+}
+
+// getUsage returns the checks recorded for the feature.
+func getUsage(tx *bolt.Tx, feature []byte) (FeatureUsage, error) {
+	usage := FeatureUsage{Name: string(feature)}
+	usageBkt := tx.Bucket([]byte("usage"))
+	if usageBkt == nil {
+		return usage, nil
+	}
+	if b := usageBkt.Get(feature); b != nil {
+		if err := json.Unmarshal(b, &usage); err != nil {
+			return usage, err
+		}
+	}
+	return usage, nil
+}
+
+// GetUsage returns the checks recorded for the feature, which are none if it has not been checked.
+func (fdb FlipadelphiaBoltDB) GetUsage(ctx context.Context, feature []byte) (FeatureUsage, error) {
+	var usage FeatureUsage
+
+	if err := checkContext(ctx); err != nil {
+		return usage, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		var err error
+		usage, err = getUsage(tx, feature)
+		return err
+	})
+	return usage, err
+}
+
+// GetUsages returns the checks recorded for every feature that has been checked, sorted by feature
+// name.
+func (fdb FlipadelphiaBoltDB) GetUsages(ctx context.Context) (FeatureUsages, error) {
+	usages := FeatureUsages{}
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		usageBkt := tx.Bucket([]byte("usage"))
+		if usageBkt == nil {
+			return nil
+		}
+		return usageBkt.ForEach(func(feature, b []byte) error {
+			usage := FeatureUsage{Name: string(feature)}
+			if err := json.Unmarshal(b, &usage); err != nil {
+				return err
+			}
+			usages = append(usages, usage)
+			return nil
+		})
+	})
+	return usages, err
+}
+
+// AddUsage adds the checks to those recorded for each feature in a single transaction, keeping the
+// latest LastChecked.
+func (fdb FlipadelphiaBoltDB) AddUsage(ctx context.Context, usages FeatureUsages) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return fdb.db.Update(func(tx *bolt.Tx) error {
+		usageBkt, err := tx.CreateBucketIfNotExists([]byte("usage"))
+		if err != nil {
+			return err
+		}
+		for _, checked := range usages {
+			usage, err := getUsage(tx, []byte(checked.Name))
+			if err != nil {
+				return err
+			}
+			usage.add(checked)
+			if err := usageBkt.Put([]byte(checked.Name), usage.Serialize()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// getExperiment returns the experiment defined on the feature, or nil if there is none.
+func getExperiment(tx *bolt.Tx, feature []byte) (*Experiment, error) {
+	experimentsBkt := tx.Bucket([]byte("experiments"))
+	if experimentsBkt == nil {
+		return nil, nil
+	}
+	b := experimentsBkt.Get(feature)
+	if b == nil {
+		return nil, nil
+	}
+	var experiment Experiment
+	if err := json.Unmarshal(b, &experiment); err != nil {
+		return nil, err
+	}
+	return &experiment, nil
+}
+
+// putExperiment stores the experiment as json under its feature.
+func putExperiment(tx *bolt.Tx, experiment Experiment) error {
+	experimentsBkt, err := tx.CreateBucketIfNotExists([]byte("experiments"))
+	if err != nil {
+		return err
+	}
+	return experimentsBkt.Put([]byte(experiment.Feature), experiment.Serialize())
+}
+
+// getAssigned returns the name of the variant the scope was assigned, or "" if it has not been.
+func getAssigned(tx *bolt.Tx, feature, scope []byte) string {
+	assignmentsBkt := tx.Bucket([]byte("assignments"))
+	if assignmentsBkt == nil {
+		return ""
+	}
+	featureBkt := assignmentsBkt.Bucket(feature)
+	if featureBkt == nil {
+		return ""
+	}
+	return string(featureBkt.Get(scope))
+}
+
+// GetExperiment returns the experiment defined on the feature.
+func (fdb FlipadelphiaBoltDB) GetExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	var experiment *Experiment
+
+	if err := checkContext(ctx); err != nil {
+		return Experiment{}, err
+	}
+	err := fdb.db.View(func(tx *bolt.Tx) error {
+		var err error
+		if experiment, err = getExperiment(tx, feature); err != nil {
+			return err
+		}
+		if experiment == nil {
+			return ErrExperimentNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return Experiment{}, err
+	}
+	return *experiment, nil
+}
+
+// SetExperiment defines the experiment on its feature, replacing its variants if it is already
+// defined. Scopes keep the variants they were assigned, as long as the variants still exist.
+func (fdb FlipadelphiaBoltDB) SetExperiment(ctx context.Context, experiment Experiment) (Experiment, error) {
+	if err := checkContext(ctx); err != nil {
+		return Experiment{}, err
+	}
+	err := fdb.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getExperiment(tx, []byte(experiment.Feature))
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			experiment.Salt = existing.Salt
+		} else {
+			experiment.Salt = uuid.NewV4().String()
+		}
+		return putExperiment(tx, experiment)
+	})
+	return experiment, err
+}
+
+// DeleteExperiment removes the experiment defined on the feature, and its assignments.
+func (fdb FlipadelphiaBoltDB) DeleteExperiment(ctx context.Context, feature []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return fdb.db.Update(func(tx *bolt.Tx) error {
+		experimentsBkt := tx.Bucket([]byte("experiments"))
+		if experimentsBkt == nil || experimentsBkt.Get(feature) == nil {
+			return ErrExperimentNotFound
+		}
+		if err := deleteAssignments(tx, feature); err != nil {
+			return err
+		}
+		return experimentsBkt.Delete(feature)
+	})
+}
+
+// deleteAssignments removes every assignment of the experiment on the feature.
+func deleteAssignments(tx *bolt.Tx, feature []byte) error {
+	assignmentsBkt := tx.Bucket([]byte("assignments"))
+	if assignmentsBkt == nil || assignmentsBkt.Bucket(feature) == nil {
+		return nil
+	}
+	return assignmentsBkt.DeleteBucket(feature)
+}
+
+// ReshuffleExperiment gives the experiment on the feature a new salt and drops its assignments, so
+// every scope is assigned afresh under the current weights.
+func (fdb FlipadelphiaBoltDB) ReshuffleExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	var experiment *Experiment
+
+	if err := checkContext(ctx); err != nil {
+		return Experiment{}, err
+	}
+	err := fdb.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if experiment, err = getExperiment(tx, feature); err != nil {
+			return err
+		}
+		if experiment == nil {
+			return ErrExperimentNotFound
+		}
+		experiment.Salt = uuid.NewV4().String()
+		if err := deleteAssignments(tx, feature); err != nil {
+			return err
+		}
+		return putExperiment(tx, *experiment)
+	})
+	if err != nil {
+		return Experiment{}, err
+	}
+	return *experiment, nil
+}
+
+// AssignVariant returns the variant of the feature's experiment the scope is assigned. A scope is
+// assigned by hash the first time it is checked, and keeps that variant until the experiment is
+// reshuffled or the variant is removed.
+func (fdb FlipadelphiaBoltDB) AssignVariant(ctx context.Context, feature, scope []byte) (Assignment, error) {
+	var assignment Assignment
+	var isNew bool
+
+	if err := checkContext(ctx); err != nil {
+		return Assignment{}, err
+	}
+	assign := func(tx *bolt.Tx) error {
+		experiment, err := getExperiment(tx, feature)
+		if err != nil {
+			return err
+		}
+		if experiment == nil {
+			return ErrExperimentNotFound
+		}
+		assignment, isNew = experiment.assign(string(scope), getAssigned(tx, feature, scope))
+		return nil
+	}
+	// Most checks are for scopes that are already assigned, which only need a read.
+	if err := fdb.db.View(assign); err != nil || !isNew {
+		return assignment, err
+	}
+	err := fdb.db.Update(func(tx *bolt.Tx) error {
+		if err := assign(tx); err != nil || !isNew {
+			return err
+		}
+		assignmentsBkt, err := tx.CreateBucketIfNotExists([]byte("assignments"))
+		if err != nil {
+			return err
+		}
+		featureBkt, err := assignmentsBkt.CreateBucketIfNotExists(feature)
+		if err != nil {
+			return err
+		}
+		return featureBkt.Put(scope, []byte(assignment.Variant))
+	})
+	return assignment, err
 }
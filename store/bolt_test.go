@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -8,9 +9,12 @@ import (
 	"os"
 	"path"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/samdfonseca/flipadelphia/config"
 )
 
 func RunTestWithTempDB(t *testing.T, test func(db FlipadelphiaBoltDB, t *testing.T)) {
@@ -162,13 +166,13 @@ func TestGetScopesPaginatedWithOffset(t *testing.T) {
 		testFeatures := []string{"feature1", "feature2", "feature3"}
 		for _, scope := range testScopes {
 			for _, feature := range testFeatures {
-				db.Set([]byte(scope), []byte(feature), []byte("on"))
+				db.Set(context.Background(), []byte(scope), []byte(feature), []byte("on"))
 			}
 		}
 		paginatedScopes, _ := db.getScopesPaginated(5, 10)
 		if len(paginatedScopes) != 10 {
 			t.Logf("Target Length: 10")
-			t.Logf("Actual Length: %s", len(paginatedScopes))
+			t.Logf("Actual Length: %d", len(paginatedScopes))
 			t.Errorf("Length of paginatedScopes did not equal 10")
 		}
 		for i := range paginatedScopes {
@@ -204,13 +208,13 @@ func TestGetScopesPaginatedWithoutOffset(t *testing.T) {
 		testFeatures := []string{"feature1", "feature2", "feature3"}
 		for _, scope := range testScopes {
 			for _, feature := range testFeatures {
-				db.Set([]byte(scope), []byte(feature), []byte("on"))
+				db.Set(context.Background(), []byte(scope), []byte(feature), []byte("on"))
 			}
 		}
 		paginatedScopes, _ := db.getScopesPaginated(0, 10)
 		if len(paginatedScopes) != 10 {
 			t.Logf("Target Length: 10")
-			t.Logf("Actual Length: %s", len(paginatedScopes))
+			t.Logf("Actual Length: %d", len(paginatedScopes))
 			t.Errorf("Length of paginatedScopes did not equal 10")
 		}
 		for i := range paginatedScopes {
@@ -246,13 +250,13 @@ func TestGetScopesPaginatedWithoutOffsetCountGreaterThanAvailable(t *testing.T)
 		testFeatures := []string{"feature1", "feature2", "feature3"}
 		for _, scope := range testScopes {
 			for _, feature := range testFeatures {
-				db.Set([]byte(scope), []byte(feature), []byte("on"))
+				db.Set(context.Background(), []byte(scope), []byte(feature), []byte("on"))
 			}
 		}
 		paginatedScopes, _ := db.getScopesPaginated(0, 100)
 		if len(paginatedScopes) != 20 {
 			t.Logf("Target Length: 20")
-			t.Logf("Actual Length: %s", len(paginatedScopes))
+			t.Logf("Actual Length: %d", len(paginatedScopes))
 			t.Errorf("Length of paginatedScopes did not equal 20")
 		}
 		for i := range paginatedScopes {
@@ -288,13 +292,13 @@ func TestGetScopesPaginatedWithOffsetCountGreaterThanAvailable(t *testing.T) {
 		testFeatures := []string{"feature1", "feature2", "feature3"}
 		for _, scope := range testScopes {
 			for _, feature := range testFeatures {
-				db.Set([]byte(scope), []byte(feature), []byte("on"))
+				db.Set(context.Background(), []byte(scope), []byte(feature), []byte("on"))
 			}
 		}
 		paginatedScopes, _ := db.getScopesPaginated(10, 100)
 		if len(paginatedScopes) != 10 {
 			t.Logf("Target Length: 10")
-			t.Logf("Actual Length: %s", len(paginatedScopes))
+			t.Logf("Actual Length: %d", len(paginatedScopes))
 			t.Errorf("Length of paginatedScopes did not equal 10")
 		}
 		for i := range paginatedScopes {
@@ -330,13 +334,13 @@ func TestGetFeaturesPaginatedWithOffset(t *testing.T) {
 		testScopes := []string{"scope1", "scope2", "scope3"}
 		for _, feature := range testFeatures {
 			for _, scope := range testScopes {
-				db.Set([]byte(scope), []byte(feature), []byte("on"))
+				db.Set(context.Background(), []byte(scope), []byte(feature), []byte("on"))
 			}
 		}
 		paginatedFeatures, _ := db.getFeaturesPaginated(5, 10)
 		if len(paginatedFeatures) != 10 {
 			t.Logf("Target Length: 10")
-			t.Logf("Actual Length: %s", len(paginatedFeatures))
+			t.Logf("Actual Length: %d", len(paginatedFeatures))
 			t.Errorf("Length of paginatedFeatures did not equal 10")
 		}
 		for i := range paginatedFeatures {
@@ -372,17 +376,681 @@ func TestGetAllFeatures(t *testing.T) {
 		testScopes := []string{"scope1", "scope2", "scope3"}
 		for _, feature := range testFeatures {
 			for _, scope := range testScopes {
-				db.Set([]byte(scope), []byte(feature), []byte("on"))
+				db.Set(context.Background(), []byte(scope), []byte(feature), []byte("on"))
 			}
 		}
 		allFeatures, _ := db.getAllFeatures()
 		if len(allFeatures) != len(testFeatures) {
-			t.Logf("Target Length: %s", len(testFeatures))
-			t.Logf("Actual Length: %s", len(allFeatures))
-			t.Errorf("Length of allFeatures did not equal %s", len(testFeatures))
+			t.Logf("Target Length: %d", len(testFeatures))
+			t.Logf("Actual Length: %d", len(allFeatures))
+			t.Errorf("Length of allFeatures did not equal %d", len(testFeatures))
 		}
 		for i := range allFeatures {
 			assertEqual(allFeatures[i], testFeatures[i], t)
 		}
 	})
 }
+
+func TestGetReturnsNotFoundErrors(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		db.Set(ctx, []byte("scope1"), []byte("feature1"), []byte("on"))
+
+		_, err := db.Get(ctx, []byte("scope2"), []byte("feature1"))
+		assertErrorEqual(err, ErrScopeNotFound, t)
+
+		_, err = db.Get(ctx, []byte("scope1"), []byte("feature2"))
+		assertErrorEqual(err, ErrFeatureNotFound, t)
+
+		_, err = db.GetScopesWithFeature(ctx, []byte("feature2"))
+		assertErrorEqual(err, ErrFeatureNotFound, t)
+
+		feature, err := db.Get(ctx, []byte("scope1"), []byte("feature1"))
+		assertNil(err, t)
+		assertEqual(feature.Value, "on", t)
+	})
+}
+
+func TestGetRespectsCancelledContext(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := db.Get(ctx, []byte("scope1"), []byte("feature1"))
+		assertErrorEqual(err, context.Canceled, t)
+	})
+}
+
+func TestLegacyPersistenceStore(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		legacy := NewLegacyPersistenceStore(db)
+		legacy.Set([]byte("scope1"), []byte("feature1"), []byte("on"))
+		feature, err := legacy.Get([]byte("scope1"), []byte("feature1"))
+		assertNil(err, t)
+		assertEqual(string(feature.Serialize()), `{"name":"feature1","value":"on","data":"true"}`, t)
+		if !legacy.CheckScopeHasFeature([]byte("scope1"), []byte("feature1")) {
+			t.Errorf("Expected scope1 to have feature1")
+		}
+
+		adapted := NewPersistenceStoreAdapter(legacy)
+		_, err = adapted.Get(context.Background(), []byte("scope2"), []byte("feature1"))
+		assertErrorEqual(err, ErrScopeNotFound, t)
+	})
+}
+
+func TestGetScopesPageWalksAllScopes(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		testScopes := []string{"a", "ab", "amet", "at", "cupiditate", "ea", "eum"}
+		for _, scope := range testScopes {
+			db.Set(ctx, []byte(scope), []byte("feature1"), []byte("on"))
+		}
+		var actual []string
+		var pages int
+		after := ""
+		for {
+			page, err := db.GetScopesPage(ctx, after, 3)
+			assertNil(err, t)
+			actual = append(actual, page.Items...)
+			pages++
+			if page.Next == "" {
+				break
+			}
+			after = page.Next
+		}
+		assertEqual(fmt.Sprint(pages), "3", t)
+		assertEqual(fmt.Sprint(actual), fmt.Sprint(testScopes), t)
+	})
+}
+
+func TestGetScopesPageAfterInsert(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		for _, scope := range []string{"b", "d", "f", "h"} {
+			db.Set(ctx, []byte(scope), []byte("feature1"), []byte("on"))
+		}
+		page, _ := db.GetScopesPage(ctx, "", 2)
+		assertEqual(fmt.Sprint(page.Items), "[b d]", t)
+		// A scope inserted before the cursor must not shift the next page.
+		db.Set(ctx, []byte("a"), []byte("feature1"), []byte("on"))
+		page, _ = db.GetScopesPage(ctx, page.Next, 2)
+		assertEqual(fmt.Sprint(page.Items), "[f h]", t)
+		assertEqual(page.Next, "", t)
+	})
+}
+
+func TestGetScopeFeaturesFullPage(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		for _, f := range testFeatures {
+			db.Set(ctx, []byte(f.Key), []byte(f.Scope), []byte(f.Value))
+		}
+		page, err := db.GetScopeFeaturesFullPage(ctx, []byte("scope1"), "", 4)
+		assertNil(err, t)
+		assertEqual(string(page.Items.Serialize()), `[{"name":"feature1","value":"on","data":"true"},{"name":"feature2","value":"on","data":"true"},{"name":"feature3","value":"off","data":"true"},{"name":"feature4","value":"on","data":"true"}]`, t)
+		page, err = db.GetScopeFeaturesFullPage(ctx, []byte("scope1"), page.Next, 4)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(page.Items)), "2", t)
+		assertEqual(page.Next, "", t)
+
+		_, err = db.GetScopeFeaturesFullPage(ctx, []byte("scope1"), "not a token!", 4)
+		assertErrorEqual(err, ErrInvalidPageToken, t)
+	})
+}
+
+func TestSearchFeatures(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		db.Set(ctx, []byte("venue-1"), []byte("checkout-v2"), []byte("on"))
+		db.Set(ctx, []byte("venue-2"), []byte("checkout-tips"), []byte("off"))
+		db.Set(ctx, []byte("user-1"), []byte("checkout-tips"), []byte("on"))
+		db.Set(ctx, []byte("venue-1"), []byte("search"), []byte("on"))
+
+		page, err := db.SearchFeatures(ctx, SearchQuery{Glob: "checkout-*"}, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[checkout-tips checkout-v2]", t)
+
+		page, err = db.SearchFeatures(ctx, SearchQuery{Glob: "checkout-*", Value: "on", ScopePrefix: "venue-"}, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[checkout-v2]", t)
+
+		page, err = db.SearchFeatures(ctx, SearchQuery{Value: "off"}, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[checkout-tips]", t)
+
+		page, err = db.SearchFeatures(ctx, SearchQuery{Regexp: "^check.*tips$"}, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[checkout-tips]", t)
+
+		page, err = db.SearchFeatures(ctx, SearchQuery{Contains: "ar"}, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[search]", t)
+
+		page, err = db.SearchFeatures(ctx, SearchQuery{Glob: "checkout-*"}, "", 1)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[checkout-tips]", t)
+		page, err = db.SearchFeatures(ctx, SearchQuery{Glob: "checkout-*"}, page.Next, 1)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[checkout-v2]", t)
+
+		_, err = db.SearchFeatures(ctx, SearchQuery{Regexp: "("}, "", 10)
+		assertErrorEqual(err, ErrInvalidSearch, t)
+		_, err = db.SearchFeatures(ctx, SearchQuery{}, "", 0)
+		assertErrorEqual(err, ErrInvalidPageLimit, t)
+		_, err = db.SearchScopes(ctx, SearchQuery{}, "", -1)
+		assertErrorEqual(err, ErrInvalidPageLimit, t)
+	})
+}
+
+func TestSearchScopes(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		db.Set(ctx, []byte("venue-1"), []byte("checkout-v2"), []byte("on"))
+		db.Set(ctx, []byte("venue-2"), []byte("checkout-v2"), []byte("off"))
+		db.Set(ctx, []byte("user-1"), []byte("checkout-v2"), []byte("on"))
+
+		page, err := db.SearchScopes(ctx, SearchQuery{Glob: "venue-*", Feature: "checkout-v2", Value: "on"}, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[venue-1]", t)
+
+		page, err = db.SearchScopes(ctx, SearchQuery{Value: "on"}, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[user-1 venue-1]", t)
+
+		page, err = db.SearchScopes(ctx, SearchQuery{Prefix: "venue-"}, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[venue-1 venue-2]", t)
+	})
+}
+
+func TestGetFeatureSummary(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		start := time.Now()
+		db.Set(ctx, []byte("venue-1"), []byte("checkout-v2"), []byte("on"))
+		db.Set(ctx, []byte("venue-2"), []byte("checkout-v2"), []byte("off"))
+		db.Set(ctx, []byte("venue-3"), []byte("checkout-v2"), []byte("on"))
+		db.Set(ctx, []byte("venue-2"), []byte("checkout-v2"), []byte("on"))
+
+		summary, err := db.GetFeatureSummary(ctx, []byte("checkout-v2"))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(summary.Scopes), "3", t)
+		assertEqual(fmt.Sprint(summary.Values), "map[on:3]", t)
+		if summary.FirstModified == nil || summary.LastModified == nil {
+			t.Fatalf("Expected modification times, got %+v", summary)
+		}
+		if summary.FirstModified.Before(start) || summary.LastModified.Before(*summary.FirstModified) {
+			t.Errorf("Unexpected modification times: %v, %v", summary.FirstModified, summary.LastModified)
+		}
+
+		_, err = db.GetFeatureSummary(ctx, []byte("missing"))
+		assertErrorEqual(err, ErrFeatureNotFound, t)
+	})
+}
+
+func TestCopyScope(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		db.Set(ctx, []byte("template"), []byte("feature1"), []byte("on"))
+		db.Set(ctx, []byte("template"), []byte("feature2"), []byte("on"))
+		db.Set(ctx, []byte("template"), []byte("feature3"), []byte("off"))
+		db.Set(ctx, []byte("venue-1"), []byte("feature2"), []byte("off"))
+
+		copied, err := db.CopyScope(ctx, []byte("template"), CopyScopeOptions{Target: "venue-1"})
+		assertNil(err, t)
+		assertEqual(string(copied.Serialize()), `[{"name":"feature1","value":"on","data":"true"},{"name":"feature3","value":"off","data":"true"}]`, t)
+		feature, _ := db.Get(ctx, []byte("venue-1"), []byte("feature2"))
+		assertEqual(feature.Value, "off", t)
+
+		copied, err = db.CopyScope(ctx, []byte("template"), CopyScopeOptions{Target: "venue-1", Overwrite: true, Features: []string{"feature2"}})
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(copied)), "1", t)
+		feature, _ = db.Get(ctx, []byte("venue-1"), []byte("feature2"))
+		assertEqual(feature.Value, "on", t)
+		scopes, _ := db.GetScopesWithFeature(ctx, []byte("feature1"))
+		assertEqual(fmt.Sprint(scopes), "[template venue-1]", t)
+
+		_, err = db.CopyScope(ctx, []byte("missing"), CopyScopeOptions{Target: "venue-2"})
+		assertErrorEqual(err, ErrScopeNotFound, t)
+
+		// Expiring values keep their expiry on the target, and expired ones are not copied.
+		expiresAt := time.Now().Add(time.Hour).UTC()
+		db.SetWithExpiry(ctx, []byte("debug"), []byte("verbose-logs"), []byte("on"), expiresAt)
+		db.SetWithExpiry(ctx, []byte("debug"), []byte("trace"), []byte("on"), time.Now().Add(-time.Second))
+		copied, err = db.CopyScope(ctx, []byte("debug"), CopyScopeOptions{Target: "venue-3"})
+		assertNil(err, t)
+		assertEqual(string(copied.Serialize()), `[{"name":"verbose-logs","value":"on","data":"true"}]`, t)
+		db.db.View(func(tx *bolt.Tx) error {
+			scopeFeatUUID := tx.Bucket([]byte("scopes")).Bucket([]byte("venue-3")).Get([]byte("verbose-logs"))
+			expiry := getExpiry(tx, scopeFeatUUID)
+			if expiry == nil || !expiry.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("Expected the copy to expire at %s, got %+v", expiresAt, expiry)
+			}
+			return nil
+		})
+	})
+}
+
+func TestScopeHierarchy(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		ps := PersistenceStoreV2(db)
+		db.Set(ctx, []byte("org-1"), []byte("feature1"), []byte("on"))
+		db.Set(ctx, []byte("org-1"), []byte("feature2"), []byte("on"))
+		db.Set(ctx, []byte("venue-3"), []byte("feature2"), []byte("off"))
+		db.Set(ctx, []byte("user-42"), []byte("feature3"), []byte("on"))
+		assertNil(db.SetScopeParents(ctx, []byte("venue-3"), []string{"org-1"}), t)
+		assertNil(db.SetScopeParents(ctx, []byte("user-42"), []string{"venue-3"}), t)
+
+		parents, err := db.GetScopeParents(ctx, []byte("user-42"))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(parents), "[venue-3]", t)
+
+		feature, err := ResolveFeature(ctx, ps, []byte("user-42"), []byte("feature1"))
+		assertNil(err, t)
+		assertEqual(feature.Value+" "+feature.Source, "on org-1", t)
+		feature, err = ResolveFeature(ctx, ps, []byte("user-42"), []byte("feature2"))
+		assertNil(err, t)
+		assertEqual(feature.Value+" "+feature.Source, "off venue-3", t)
+		_, err = ResolveFeature(ctx, ps, []byte("user-42"), []byte("missing"))
+		assertErrorEqual(err, ErrFeatureNotFound, t)
+
+		features, err := ResolveScopeFeatures(ctx, ps, []byte("user-42"))
+		assertNil(err, t)
+		assertEqual(string(features.Serialize()), `[{"name":"feature1","value":"on","data":"true","source":"org-1"},{"name":"feature2","value":"off","data":"true","source":"venue-3"},{"name":"feature3","value":"on","data":"true","source":"user-42"}]`, t)
+
+		err = db.SetScopeParents(ctx, []byte("org-1"), []string{"user-42"})
+		assertErrorEqual(err, ErrScopeCycle, t)
+		err = db.SetScopeParents(ctx, []byte("org-1"), []string{"org-1"})
+		assertErrorEqual(err, ErrScopeCycle, t)
+
+		assertNil(db.SetScopeParents(ctx, []byte("user-42"), nil), t)
+		_, err = ResolveFeature(ctx, ps, []byte("user-42"), []byte("feature1"))
+		assertErrorEqual(err, ErrFeatureNotFound, t)
+	})
+}
+
+func TestSegments(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		ps := PersistenceStoreV2(db)
+		db.Set(ctx, []byte("user-1"), []byte("feature1"), []byte("off"))
+		assertNil(db.SetSegment(ctx, NewSegment("beta", []string{"user-1", "user-2"}, "")), t)
+		assertNil(db.SetSegment(ctx, NewSegment("venues", nil, "venue-")), t)
+		_, err := db.SetSegmentFeature(ctx, []byte("beta"), []byte("feature1"), []byte("on"))
+		assertNil(err, t)
+		_, err = db.SetSegmentFeature(ctx, []byte("missing"), []byte("feature1"), []byte("on"))
+		assertErrorEqual(err, ErrSegmentNotFound, t)
+
+		// Redefining a segment keeps its features.
+		assertNil(db.SetSegment(ctx, NewSegment("beta", []string{"user-1", "user-2", "user-3"}, "")), t)
+		segment, err := db.GetSegment(ctx, []byte("beta"))
+		assertNil(err, t)
+		assertEqual(string(segment.Serialize()), `{"name":"beta","members":["user-1","user-2","user-3"],"prefix":"","features":{"feature1":"on"}}`, t)
+		segments, err := db.GetSegments(ctx)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(segments)), "2", t)
+
+		feature, err := ResolveFeature(ctx, ps, []byte("user-1"), []byte("feature1"))
+		assertNil(err, t)
+		assertEqual(feature.Value+" "+feature.Segment, "off ", t)
+		feature, err = ResolveFeature(ctx, ps, []byte("user-3"), []byte("feature1"))
+		assertNil(err, t)
+		assertEqual(feature.Value+" "+feature.Segment, "on beta", t)
+		_, err = ResolveFeature(ctx, ps, []byte("venue-1"), []byte("feature1"))
+		assertErrorEqual(err, ErrScopeNotFound, t)
+
+		assertNil(db.DeleteSegment(ctx, []byte("beta")), t)
+		assertErrorEqual(db.DeleteSegment(ctx, []byte("beta")), ErrSegmentNotFound, t)
+		_, err = ResolveFeature(ctx, ps, []byte("user-3"), []byte("feature1"))
+		assertErrorEqual(err, ErrScopeNotFound, t)
+	})
+}
+
+func TestSchedules(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		launch := time.Date(2017, 6, 1, 9, 0, 0, 0, time.UTC)
+		later, err := db.AddSchedule(ctx, ScheduledChange{Scope: "venue-1", Feature: "promo", Value: "off", RunAt: launch.Add(time.Hour)})
+		assertNil(err, t)
+		sooner, err := db.AddSchedule(ctx, ScheduledChange{Scope: "venue-1", Feature: "promo", Value: "on", RunAt: launch})
+		assertNil(err, t)
+		if sooner.ID == "" || sooner.ID == later.ID {
+			t.Errorf("Expected distinct ids, got %q and %q", sooner.ID, later.ID)
+		}
+
+		changes, err := db.GetSchedules(ctx)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(changes)), "2", t)
+		assertEqual(changes[0].ID, sooner.ID, t)
+		assertEqual(changes[0].RunAt.String(), launch.String(), t)
+
+		assertNil(db.DeleteSchedule(ctx, []byte(sooner.ID)), t)
+		assertErrorEqual(db.DeleteSchedule(ctx, []byte(sooner.ID)), ErrScheduleNotFound, t)
+		changes, _ = db.GetSchedules(ctx)
+		assertEqual(fmt.Sprint(len(changes)), "1", t)
+	})
+}
+
+func TestSetWithExpiry(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		_, err := db.Set(ctx, []byte("user-1"), []byte("kept"), []byte("on"))
+		assertNil(err, t)
+		_, err = db.SetWithExpiry(ctx, []byte("user-1"), []byte("expired"), []byte("on"), time.Now().Add(-time.Second))
+		assertNil(err, t)
+		_, err = db.SetWithExpiry(ctx, []byte("user-1"), []byte("pending"), []byte("on"), time.Now().Add(time.Hour))
+		assertNil(err, t)
+
+		_, err = db.Get(ctx, []byte("user-1"), []byte("expired"))
+		assertErrorEqual(err, ErrFeatureNotFound, t)
+		feature, err := db.Get(ctx, []byte("user-1"), []byte("pending"))
+		assertNil(err, t)
+		assertEqual(feature.Value, "on", t)
+		features, err := db.GetScopeFeatures(ctx, []byte("user-1"))
+		assertNil(err, t)
+		sort.Strings(features)
+		assertEqual(fmt.Sprint(features), "[kept pending]", t)
+		scopes, err := db.GetScopesWithFeature(ctx, []byte("expired"))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(scopes)), "0", t)
+
+		// Setting the value again without an expiry keeps it.
+		_, err = db.SetWithExpiry(ctx, []byte("user-1"), []byte("reset"), []byte("on"), time.Now().Add(-time.Second))
+		assertNil(err, t)
+		_, err = db.Set(ctx, []byte("user-1"), []byte("reset"), []byte("off"))
+		assertNil(err, t)
+		feature, err = db.Get(ctx, []byte("user-1"), []byte("reset"))
+		assertNil(err, t)
+		assertEqual(feature.Value, "off", t)
+
+		// Listings leave out expired values before they are swept.
+		_, err = db.SetWithExpiry(ctx, []byte("user-2"), []byte("expired"), []byte("on"), time.Now().Add(-time.Second))
+		assertNil(err, t)
+		scopes, err = db.GetScopes(ctx)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(scopes), "[user-1]", t)
+		features, err = db.GetFeatures(ctx)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(features), "[kept pending reset]", t)
+		features, err = db.GetFeaturesPaginated(ctx, 1, 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(features), "[pending reset]", t)
+		page, err := db.GetScopesPage(ctx, "", 1)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[user-1]", t)
+		page, err = db.GetFeaturesPage(ctx, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[kept pending reset]", t)
+		page, err = db.SearchScopes(ctx, SearchQuery{}, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(page.Items), "[user-1]", t)
+		page, err = db.SearchFeatures(ctx, SearchQuery{Prefix: "exp"}, "", 10)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(page.Items)), "0", t)
+		for _, check := range []func() (bool, error){
+			func() (bool, error) { return db.CheckScopeExists(ctx, []byte("user-2")) },
+			func() (bool, error) { return db.CheckFeatureExists(ctx, []byte("expired")) },
+			func() (bool, error) { return db.CheckScopeHasFeature(ctx, []byte("user-1"), []byte("expired")) },
+			func() (bool, error) { return db.CheckFeatureHasScope(ctx, []byte("user-1"), []byte("expired")) },
+		} {
+			exists, err := check()
+			assertNil(err, t)
+			assertEqual(fmt.Sprint(exists), "false", t)
+		}
+
+		deleted, err := db.DeleteExpired(ctx)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(deleted), "2", t)
+		exists, err := db.CheckFeatureExists(ctx, []byte("expired"))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(exists), "false", t)
+		deleted, err = db.DeleteExpired(ctx)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(deleted), "0", t)
+	})
+}
+
+func TestOverrides(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		_, err := db.Set(ctx, []byte("user-1"), []byte("checkout-v2"), []byte("on"))
+		assertNil(err, t)
+		_, err = db.GetOverride(ctx, []byte("checkout-v2"))
+		assertErrorEqual(err, ErrOverrideNotFound, t)
+
+		_, err = db.SetOverride(ctx, []byte("checkout-v2"), []byte("off"))
+		assertNil(err, t)
+		feature, err := db.Get(ctx, []byte("user-1"), []byte("checkout-v2"))
+		assertNil(err, t)
+		assertEqual(feature.Value, "off", t)
+		feature, err = db.Get(ctx, []byte("user-2"), []byte("checkout-v2"))
+		assertNil(err, t)
+		assertEqual(feature.Value, "off", t)
+		resolved, err := ResolveFeature(ctx, db, []byte("user-1"), []byte("checkout-v2"))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(resolved.Override), "true", t)
+		overrides, err := db.GetOverrides(ctx)
+		assertNil(err, t)
+		assertEqual(string(overrides.Serialize()), `[{"name":"checkout-v2","value":"off","data":"true"}]`, t)
+
+		assertNil(db.DeleteOverride(ctx, []byte("checkout-v2")), t)
+		assertErrorEqual(db.DeleteOverride(ctx, []byte("checkout-v2")), ErrOverrideNotFound, t)
+		feature, err = db.Get(ctx, []byte("user-1"), []byte("checkout-v2"))
+		assertNil(err, t)
+		assertEqual(feature.Value, "on", t)
+	})
+}
+
+func TestPrerequisites(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		assertNil(db.SetPrerequisites(ctx, []byte("checkout-v2-tips"), []string{"checkout-v2"}), t)
+		assertNil(db.SetPrerequisites(ctx, []byte("checkout-v2"), []string{"payments"}), t)
+		assertErrorEqual(db.SetPrerequisites(ctx, []byte("payments"), []string{"checkout-v2-tips"}), ErrPrerequisiteCycle, t)
+		assertErrorEqual(db.SetPrerequisites(ctx, []byte("payments"), []string{"payments"}), ErrPrerequisiteCycle, t)
+		prerequisites, err := db.GetPrerequisites(ctx, []byte("checkout-v2-tips"))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(prerequisites), "[checkout-v2]", t)
+
+		db.Set(ctx, []byte("user-1"), []byte("checkout-v2-tips"), []byte("on"))
+		db.Set(ctx, []byte("user-1"), []byte("checkout-v2"), []byte("on"))
+
+		// payments is not set on user-1, so it turns off checkout-v2, which turns off checkout-v2-tips.
+		resolved, err := ResolveFeature(ctx, db, []byte("user-1"), []byte("checkout-v2-tips"))
+		assertNil(err, t)
+		assertEqual(resolved.Value, "off", t)
+		assertEqual(resolved.Prerequisite, "checkout-v2", t)
+		effective, err := ResolveScopeFeatures(ctx, db, []byte("user-1"))
+		assertNil(err, t)
+		assertEqual(effective[0].Value+" "+effective[1].Value, "off off", t)
+
+		db.Set(ctx, []byte("user-1"), []byte("payments"), []byte("on"))
+		resolved, err = ResolveFeature(ctx, db, []byte("user-1"), []byte("checkout-v2-tips"))
+		assertNil(err, t)
+		assertEqual(resolved.Value, "on", t)
+		assertEqual(resolved.Prerequisite, "", t)
+		effective, err = ResolveScopeFeatures(ctx, db, []byte("user-1"))
+		assertNil(err, t)
+		assertEqual(effective[0].Value+" "+effective[1].Value+" "+effective[2].Value, "on on on", t)
+
+		assertNil(db.SetPrerequisites(ctx, []byte("checkout-v2"), nil), t)
+		prerequisites, err = db.GetPrerequisites(ctx, []byte("checkout-v2"))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(prerequisites)), "0", t)
+	})
+}
+
+func TestPrerequisites_OverriddenOutsideScope(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		db.Set(ctx, []byte("user-1"), []byte("checkout-v2"), []byte("on"))
+		db.Set(ctx, []byte("user-2"), []byte("payments"), []byte("off"))
+		assertNil(db.SetPrerequisites(ctx, []byte("checkout-v2"), []string{"payments"}), t)
+		_, err := db.SetOverride(ctx, []byte("payments"), []byte("on"))
+		assertNil(err, t)
+
+		// payments is not set on user-1, but its override turns it on everywhere.
+		resolved, err := ResolveFeature(ctx, db, []byte("user-1"), []byte("checkout-v2"))
+		assertNil(err, t)
+		assertEqual(resolved.Value, "on", t)
+		assertEqual(resolved.Prerequisite, "", t)
+		effective, err := ResolveScopeFeatures(ctx, db, []byte("user-1"))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(effective)), "1", t)
+		assertEqual(effective[0].Value, resolved.Value, t)
+		assertEqual(effective[0].Prerequisite, resolved.Prerequisite, t)
+
+		_, err = db.SetOverride(ctx, []byte("payments"), []byte("off"))
+		assertNil(err, t)
+		effective, err = ResolveScopeFeatures(ctx, db, []byte("user-1"))
+		assertNil(err, t)
+		assertEqual(effective[0].Value, "off", t)
+		assertEqual(effective[0].Prerequisite, "payments", t)
+	})
+}
+
+func TestExperiments(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		_, err := db.AssignVariant(ctx, []byte("checkout"), []byte("user-1"))
+		assertErrorEqual(err, ErrExperimentNotFound, t)
+
+		experiment, err := db.SetExperiment(ctx, Experiment{
+			Feature: "checkout",
+			Variants: []Variant{
+				{Name: "a", Weight: 50, Payload: json.RawMessage(`{"color":"red"}`)},
+				{Name: "b", Weight: 50},
+			},
+		})
+		assertNil(err, t)
+		salt := experiment.Salt
+		if salt == "" {
+			t.Errorf("Expected the experiment to be given a salt")
+		}
+
+		assigned := make(map[string]string)
+		counts := make(map[string]int)
+		for i := 0; i < 100; i++ {
+			scope := fmt.Sprintf("user-%d", i)
+			assignment, err := db.AssignVariant(ctx, []byte("checkout"), []byte(scope))
+			assertNil(err, t)
+			assigned[scope] = assignment.Variant
+			counts[assignment.Variant]++
+		}
+		if counts["a"] == 0 || counts["b"] == 0 {
+			t.Errorf("Expected scopes in both variants, got %v", counts)
+		}
+		assignment, _ := db.AssignVariant(ctx, []byte("checkout"), []byte("user-1"))
+		assertEqual(assignment.Variant, assigned["user-1"], t)
+		if assignment.Variant == "a" {
+			assertEqual(string(assignment.Payload), `{"color":"red"}`, t)
+		}
+
+		// Changing the weights keeps existing assignments, and only affects new scopes.
+		experiment, err = db.SetExperiment(ctx, Experiment{
+			Feature:  "checkout",
+			Variants: []Variant{{Name: "a", Weight: 0}, {Name: "b", Weight: 100}},
+		})
+		assertNil(err, t)
+		assertEqual(experiment.Salt, salt, t)
+		for scope, variant := range assigned {
+			assignment, err := db.AssignVariant(ctx, []byte("checkout"), []byte(scope))
+			assertNil(err, t)
+			assertEqual(assignment.Variant, variant, t)
+		}
+		assignment, _ = db.AssignVariant(ctx, []byte("checkout"), []byte("user-new"))
+		assertEqual(assignment.Variant, "b", t)
+
+		// Reshuffling reassigns every scope under the current weights.
+		experiment, err = db.ReshuffleExperiment(ctx, []byte("checkout"))
+		assertNil(err, t)
+		if experiment.Salt == salt {
+			t.Errorf("Expected reshuffling to change the salt")
+		}
+		for scope := range assigned {
+			assignment, _ := db.AssignVariant(ctx, []byte("checkout"), []byte(scope))
+			assertEqual(assignment.Variant, "b", t)
+		}
+
+		assertNil(db.DeleteExperiment(ctx, []byte("checkout")), t)
+		assertErrorEqual(db.DeleteExperiment(ctx, []byte("checkout")), ErrExperimentNotFound, t)
+	})
+}
+
+func TestUsage(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		ctx := context.Background()
+		_, err := db.Set(ctx, []byte("user-1"), []byte("unchecked"), []byte("on"))
+		assertNil(err, t)
+		_, err = db.Set(ctx, []byte("user-1"), []byte("checked"), []byte("on"))
+		assertNil(err, t)
+
+		now := time.Now().UTC()
+		later := now.Add(time.Hour)
+		assertNil(db.AddUsage(ctx, FeatureUsages{{Name: "checked", Checks: 3, LastChecked: &later}}), t)
+		assertNil(db.AddUsage(ctx, FeatureUsages{{Name: "checked", Checks: 2, LastChecked: &now}}), t)
+		usage, err := db.GetUsage(ctx, []byte("checked"))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(usage.Checks), "5", t)
+		assertEqual(usage.LastChecked.String(), later.String(), t)
+		usages, err := db.GetUsages(ctx)
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(usages)), "1", t)
+
+		usage, err = GetFeatureUsage(ctx, db, []byte("unchecked"))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(usage.Checks), "0", t)
+		if usage.LastChecked != nil || usage.LastModified == nil {
+			t.Errorf("Expected only a last modified time, got %v, %v", usage.LastChecked, usage.LastModified)
+		}
+		_, err = GetFeatureUsage(ctx, db, []byte("missing"))
+		assertErrorEqual(err, ErrFeatureNotFound, t)
+
+		// Both features were set before the cutoff, but only one was checked after it.
+		stale, err := GetStaleFeatures(ctx, db, now.Add(30*time.Minute))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(stale)), "1", t)
+		assertEqual(stale[0].Name, "unchecked", t)
+		stale, err = GetStaleFeatures(ctx, db, now.Add(-time.Minute))
+		assertNil(err, t)
+		assertEqual(fmt.Sprint(len(stale)), "0", t)
+	})
+}
+
+func TestPing(t *testing.T) {
+	RunTestWithTempDB(t, func(db FlipadelphiaBoltDB, t *testing.T) {
+		assertNil(db.Ping(context.Background()), t)
+		assertNil(db.Close(), t)
+		if err := db.Ping(context.Background()); err == nil {
+			t.Error("Expected an error pinging a closed database")
+		}
+	})
+}
+
+func TestNewPersistenceStoreV2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flipadelphia_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ps, err := NewPersistenceStoreV2(config.FlipadelphiaConfig{PersistenceStoreType: "bolt", DBFile: path.Join(dir, "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+
+	for _, test := range []struct {
+		config  config.FlipadelphiaConfig
+		problem string
+	}{
+		{config.FlipadelphiaConfig{PersistenceStoreType: "mongo"}, `Unknown persistence_store_type: "mongo"`},
+		{config.FlipadelphiaConfig{}, "persistence_store_type not set"},
+		{config.FlipadelphiaConfig{PersistenceStoreType: "redis"}, "redis_host not set"},
+		{config.FlipadelphiaConfig{PersistenceStoreType: "bolt", DBFile: path.Join(dir, "missing", "test.db")}, "Unable to open db file"},
+	} {
+		ps, err := NewPersistenceStoreV2(test.config)
+		if ps != nil || err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("Expected %q in the error, got %v", test.problem, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// ErrExperimentNotFound is returned when the feature has no experiment defined.
+var ErrExperimentNotFound = errors.New("experiment not found")
+
+// Variant is one arm of an experiment. Scopes are assigned to variants in proportion to their weights,
+// and are given the variant's payload.
+type Variant struct {
+	Name    string          `json:"name"`
+	Weight  int             `json:"weight"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Experiment splits the scopes checking a feature between weighted variants. Salt seeds the hash that
+// assigns scopes to variants, and is replaced when the experiment is reshuffled.
+type Experiment struct {
+	Feature  string    `json:"feature"`
+	Variants []Variant `json:"variants"`
+	Salt     string    `json:"salt"`
+}
+
+// Assignment is the variant of an experiment a scope is assigned to.
+type Assignment struct {
+	Feature string          `json:"feature"`
+	Scope   string          `json:"scope"`
+	Variant string          `json:"variant"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Serialize returns the Experiment as json.
+func (experiment Experiment) Serialize() []byte {
+	serializedExperiment, err := json.Marshal(experiment)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize experiment")
+		return []byte("")
+	}
+	return serializedExperiment
+}
+
+// Serialize returns the Assignment as json.
+func (assignment Assignment) Serialize() []byte {
+	serializedAssignment, err := json.Marshal(assignment)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize assignment")
+		return []byte("")
+	}
+	return serializedAssignment
+}
+
+// Validate returns an error describing the first problem with the experiment's variants, if any.
+func (experiment Experiment) Validate() error {
+	if len(experiment.Variants) == 0 {
+		return fmt.Errorf("experiment has no variants")
+	}
+	names := make(map[string]bool, len(experiment.Variants))
+	total := 0
+	for _, variant := range experiment.Variants {
+		if variant.Name == "" {
+			return fmt.Errorf("variant has no name")
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("duplicate variant: %q", variant.Name)
+		}
+		names[variant.Name] = true
+		if variant.Weight < 0 {
+			return fmt.Errorf("invalid weight for variant %q: %d", variant.Name, variant.Weight)
+		}
+		total += variant.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("variant weights add up to 0")
+	}
+	return nil
+}
+
+// variant returns the named variant, and whether the experiment has it.
+func (experiment Experiment) variant(name string) (Variant, bool) {
+	for _, variant := range experiment.Variants {
+		if variant.Name == name {
+			return variant, true
+		}
+	}
+	return Variant{}, false
+}
+
+// pick deterministically chooses the scope's variant by hashing the salt and scope into the variants'
+// cumulative weights.
+func (experiment Experiment) pick(scope string) Variant {
+	total := 0
+	for _, variant := range experiment.Variants {
+		total += variant.Weight
+	}
+	h := fnv.New32a()
+	h.Write([]byte(experiment.Salt + ":" + scope))
+	point := int(h.Sum32() % uint32(total))
+	for _, variant := range experiment.Variants {
+		if point < variant.Weight {
+			return variant
+		}
+		point -= variant.Weight
+	}
+	return experiment.Variants[len(experiment.Variants)-1]
+}
+
+// assign returns the scope's assignment. A scope keeps the variant it was previously assigned, named by
+// assigned, as long as the experiment still has it. Otherwise the variant is picked by hash, and
+// assign reports that it is new so the caller can store it.
+func (experiment Experiment) assign(scope, assigned string) (Assignment, bool) {
+	variant, ok := experiment.variant(assigned)
+	if !ok {
+		variant = experiment.pick(scope)
+	}
+	return Assignment{
+		Feature: experiment.Feature,
+		Scope:   scope,
+		Variant: variant.Name,
+		Payload: variant.Payload,
+	}, !ok
+}
@@ -3,12 +3,12 @@ package store
 import "fmt"
 
 var testFeatures = []FlipadelphiaSetFeatureOptions{
-	{"scope1", "feature1", "on"},
-	{"scope1", "feature2", "on"},
-	{"scope1", "feature3", "off"},
-	{"scope1", "feature4", "on"},
-	{"scope1", "feature5", "0"},
-	{"scope1", "feature6", "ON"},
+	{Key: "scope1", Scope: "feature1", Value: "on"},
+	{Key: "scope1", Scope: "feature2", Value: "on"},
+	{Key: "scope1", Scope: "feature3", Value: "off"},
+	{Key: "scope1", Scope: "feature4", Value: "on"},
+	{Key: "scope1", Scope: "feature5", Value: "0"},
+	{Key: "scope1", Scope: "feature6", Value: "ON"},
 }
 
 func ValidActivatedFeature(scope, key []byte) (Serializable, error) {
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// ErrScopeCycle is returned when declaring a scope's parents would make the scope its own ancestor.
+var ErrScopeCycle = errors.New("scope hierarchy cycle")
+
+// ResolvedFeature is a feature's effective value on a scope. Source is the scope the value was set
+// on, which is an ancestor of the requested scope when the value is inherited. Segment names the
+// segment of Source the value was set on, if any. Override is true when the value is the feature's
+// override, which applies to every scope. Prerequisite names the prerequisite that turned the feature
+// off, if any.
+type ResolvedFeature struct {
+	FlipadelphiaFeature
+	Source       string `json:"source"`
+	Segment      string `json:"segment,omitempty"`
+	Override     bool   `json:"override,omitempty"`
+	Prerequisite string `json:"prerequisite,omitempty"`
+}
+
+// ResolvedFeatures is a type alias for []ResolvedFeature.
+type ResolvedFeatures []ResolvedFeature
+
+// Serialize returns the ResolvedFeature as json.
+func (feature ResolvedFeature) Serialize() []byte {
+	serializedFeature, err := json.Marshal(feature)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize resolved feature")
+		return []byte("")
+	}
+	return serializedFeature
+}
+
+// Serialize returns the ResolvedFeatures as json.
+func (features ResolvedFeatures) Serialize() []byte {
+	serializedFeatures, err := json.Marshal(features)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize resolved features")
+		return []byte("")
+	}
+	return serializedFeatures
+}
+
+// lineage returns the scope followed by its ancestors, nearest first. Parents at the same depth keep
+// the order they were declared in. Each scope appears once, even if it is reachable more than once.
+func lineage(ctx context.Context, ps PersistenceStoreV2, scope []byte) ([]string, error) {
+	scopes := []string{string(scope)}
+	seen := map[string]bool{string(scope): true}
+	for i := 0; i < len(scopes); i++ {
+		parents, err := ps.GetScopeParents(ctx, []byte(scopes[i]))
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range parents {
+			if !seen[parent] {
+				seen[parent] = true
+				scopes = append(scopes, parent)
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// checkScopeCycle returns ErrScopeCycle if the scope is one of the parents or one of their ancestors.
+// getParents returns the currently declared parents of a scope.
+func checkScopeCycle(scope string, parents []string, getParents func(string) ([]string, error)) error {
+	cycle, err := reaches(scope, parents, getParents)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrScopeCycle
+	}
+	return nil
+}
+
+// reaches reports whether target is one of the nodes, or is reachable from them through next.
+func reaches(target string, nodes []string, next func(string) ([]string, error)) (bool, error) {
+	queue := append([]string(nil), nodes...)
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node == target {
+			return true, nil
+		}
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		edges, err := next(node)
+		if err != nil {
+			return false, err
+		}
+		queue = append(queue, edges...)
+	}
+	return false, nil
+}
+
+// ResolveFeature returns the feature's value on the scope. The feature's override, if set, takes
+// precedence over everything else. A scope that does not set the feature itself takes the value from
+// the segments it is a member of, then from its nearest ancestor or the ancestor's segments.
+//
+// A feature whose prerequisites are not all on for the scope resolves to "off", with Prerequisite
+// naming the first one that is not.
+func ResolveFeature(ctx context.Context, ps PersistenceStoreV2, scope, feature []byte) (ResolvedFeature, error) {
+	return resolveWithPrerequisites(ctx, ps, scope, feature, make(map[string]bool))
+}
+
+// resolveFeature returns the feature's value on the scope, without checking its prerequisites.
+func resolveFeature(ctx context.Context, ps PersistenceStoreV2, scope, feature []byte) (ResolvedFeature, error) {
+	override, err := ps.GetOverride(ctx, feature)
+	if err == nil {
+		return ResolvedFeature{FlipadelphiaFeature: override, Source: string(scope), Override: true}, nil
+	}
+	if err != ErrOverrideNotFound {
+		return ResolvedFeature{}, err
+	}
+	f, err := ps.Get(ctx, scope, feature)
+	if err == nil {
+		return ResolvedFeature{FlipadelphiaFeature: f, Source: string(scope)}, nil
+	}
+	if err != ErrScopeNotFound && err != ErrFeatureNotFound {
+		return ResolvedFeature{}, err
+	}
+	segments, segmentsErr := ps.GetSegments(ctx)
+	if segmentsErr != nil {
+		return ResolvedFeature{}, segmentsErr
+	}
+	if resolved, ok := resolveFromSegments(segments, string(scope), feature); ok {
+		return resolved, nil
+	}
+	scopes, lineageErr := lineage(ctx, ps, scope)
+	if lineageErr != nil {
+		return ResolvedFeature{}, lineageErr
+	}
+	if len(scopes) == 1 {
+		return ResolvedFeature{}, err
+	}
+	for _, ancestor := range scopes[1:] {
+		f, err := ps.Get(ctx, []byte(ancestor), feature)
+		switch err {
+		case nil:
+			return ResolvedFeature{FlipadelphiaFeature: f, Source: ancestor}, nil
+		case ErrScopeNotFound, ErrFeatureNotFound:
+		default:
+			return ResolvedFeature{}, err
+		}
+		if resolved, ok := resolveFromSegments(segments, ancestor, feature); ok {
+			return resolved, nil
+		}
+	}
+	return ResolvedFeature{}, ErrFeatureNotFound
+}
+
+// ResolveScopeFeatures returns the effective value of every feature set on the scope, its ancestors or
+// their segments, sorted by feature name. Overridden features take their override's value, and
+// features whose prerequisites are not all on resolve to "off".
+func ResolveScopeFeatures(ctx context.Context, ps PersistenceStoreV2, scope []byte) (ResolvedFeatures, error) {
+	scopes, err := lineage(ctx, ps, scope)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := ps.GetSegments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var resolved ResolvedFeatures
+	found := false
+	seen := make(map[string]bool)
+	for _, s := range scopes {
+		features, err := ps.GetScopeFeaturesFull(ctx, []byte(s))
+		if err != nil && err != ErrScopeNotFound {
+			return nil, err
+		}
+		found = found || err == nil
+		for _, f := range features {
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				resolved = append(resolved, ResolvedFeature{FlipadelphiaFeature: f, Source: s})
+			}
+		}
+		for _, segment := range segments {
+			if !segment.Contains(s) {
+				continue
+			}
+			found = true
+			for name, value := range segment.Features {
+				if !seen[name] {
+					seen[name] = true
+					resolved = append(resolved, ResolvedFeature{
+						FlipadelphiaFeature: NewFlipadelphiaFeature([]byte(name), []byte(value)),
+						Source:              s,
+						Segment:             segment.Name,
+					})
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, ErrScopeNotFound
+	}
+	overrides, err := ps.GetOverrides(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applyOverrides(resolved, overrides)
+	if err := applyPrerequisites(ctx, ps, scope, resolved); err != nil {
+		return nil, err
+	}
+	sort.Slice(resolved, func(i, j int) bool { return resolved[i].Name < resolved[j].Name })
+	return resolved, nil
+}
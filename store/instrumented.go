@@ -0,0 +1,367 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// OperationObserver is told the duration of each store operation, and the error it returned if any.
+type OperationObserver func(method string, duration time.Duration, err error)
+
+// instrumentedStore passes every call on to a PersistenceStoreV2, timing it.
+type instrumentedStore struct {
+	ps      PersistenceStoreV2
+	observe OperationObserver
+}
+
+// NewInstrumentedStore wraps a PersistenceStoreV2 so the observer is told about every call made to it.
+func NewInstrumentedStore(ps PersistenceStoreV2, observe OperationObserver) PersistenceStoreV2 {
+	return instrumentedStore{ps: ps, observe: observe}
+}
+
+func (db instrumentedStore) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := db.ps.Ping(ctx)
+	db.observe("Ping", time.Since(start), err)
+	return err
+}
+
+func (db instrumentedStore) Close() error {
+	return db.ps.Close()
+}
+
+func (db instrumentedStore) Get(ctx context.Context, scope, key []byte) (FlipadelphiaFeature, error) {
+	start := time.Now()
+	result, err := db.ps.Get(ctx, scope, key)
+	db.observe("Get", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopeFeatures(ctx context.Context, scope []byte) ([]string, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopeFeatures(ctx, scope)
+	db.observe("GetScopeFeatures", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopeFeaturesFilterByValue(ctx context.Context, scope, value []byte) ([]string, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopeFeaturesFilterByValue(ctx, scope, value)
+	db.observe("GetScopeFeaturesFilterByValue", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) Set(ctx context.Context, scope, key, value []byte) (FlipadelphiaFeature, error) {
+	start := time.Now()
+	result, err := db.ps.Set(ctx, scope, key, value)
+	db.observe("Set", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) SetWithExpiry(ctx context.Context, scope, key, value []byte, expiresAt time.Time) (FlipadelphiaFeature, error) {
+	start := time.Now()
+	result, err := db.ps.SetWithExpiry(ctx, scope, key, value, expiresAt)
+	db.observe("SetWithExpiry", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopes(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopes(ctx)
+	db.observe("GetScopes", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopesWithPrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopesWithPrefix(ctx, prefix)
+	db.observe("GetScopesWithPrefix", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopesWithFeature(ctx context.Context, feature []byte) ([]string, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopesWithFeature(ctx, feature)
+	db.observe("GetScopesWithFeature", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopesPaginated(ctx, offset, count)
+	db.observe("GetScopesPaginated", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetFeaturesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	start := time.Now()
+	result, err := db.ps.GetFeaturesPaginated(ctx, offset, count)
+	db.observe("GetFeaturesPaginated", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetFeatures(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	result, err := db.ps.GetFeatures(ctx)
+	db.observe("GetFeatures", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopeFeaturesFull(ctx context.Context, scope []byte) (FlipadelphiaFeatures, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopeFeaturesFull(ctx, scope)
+	db.observe("GetScopeFeaturesFull", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopesPage(ctx context.Context, after string, limit int) (Page, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopesPage(ctx, after, limit)
+	db.observe("GetScopesPage", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetFeaturesPage(ctx context.Context, after string, limit int) (Page, error) {
+	start := time.Now()
+	result, err := db.ps.GetFeaturesPage(ctx, after, limit)
+	db.observe("GetFeaturesPage", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopesWithFeaturePage(ctx context.Context, feature []byte, after string, limit int) (Page, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopesWithFeaturePage(ctx, feature, after, limit)
+	db.observe("GetScopesWithFeaturePage", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopeFeaturesFullPage(ctx context.Context, scope []byte, after string, limit int) (FeaturePage, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopeFeaturesFullPage(ctx, scope, after, limit)
+	db.observe("GetScopeFeaturesFullPage", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) SearchFeatures(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	start := time.Now()
+	result, err := db.ps.SearchFeatures(ctx, q, after, limit)
+	db.observe("SearchFeatures", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) SearchScopes(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	start := time.Now()
+	result, err := db.ps.SearchScopes(ctx, q, after, limit)
+	db.observe("SearchScopes", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetFeatureSummary(ctx context.Context, feature []byte) (FeatureSummary, error) {
+	start := time.Now()
+	result, err := db.ps.GetFeatureSummary(ctx, feature)
+	db.observe("GetFeatureSummary", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) CopyScope(ctx context.Context, source []byte, opts CopyScopeOptions) (FlipadelphiaFeatures, error) {
+	start := time.Now()
+	result, err := db.ps.CopyScope(ctx, source, opts)
+	db.observe("CopyScope", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetScopeParents(ctx context.Context, scope []byte) ([]string, error) {
+	start := time.Now()
+	result, err := db.ps.GetScopeParents(ctx, scope)
+	db.observe("GetScopeParents", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) SetScopeParents(ctx context.Context, scope []byte, parents []string) error {
+	start := time.Now()
+	err := db.ps.SetScopeParents(ctx, scope, parents)
+	db.observe("SetScopeParents", time.Since(start), err)
+	return err
+}
+
+func (db instrumentedStore) GetPrerequisites(ctx context.Context, feature []byte) ([]string, error) {
+	start := time.Now()
+	result, err := db.ps.GetPrerequisites(ctx, feature)
+	db.observe("GetPrerequisites", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) SetPrerequisites(ctx context.Context, feature []byte, prerequisites []string) error {
+	start := time.Now()
+	err := db.ps.SetPrerequisites(ctx, feature, prerequisites)
+	db.observe("SetPrerequisites", time.Since(start), err)
+	return err
+}
+
+func (db instrumentedStore) GetSegments(ctx context.Context) (Segments, error) {
+	start := time.Now()
+	result, err := db.ps.GetSegments(ctx)
+	db.observe("GetSegments", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetSegment(ctx context.Context, name []byte) (Segment, error) {
+	start := time.Now()
+	result, err := db.ps.GetSegment(ctx, name)
+	db.observe("GetSegment", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) SetSegment(ctx context.Context, segment Segment) error {
+	start := time.Now()
+	err := db.ps.SetSegment(ctx, segment)
+	db.observe("SetSegment", time.Since(start), err)
+	return err
+}
+
+func (db instrumentedStore) DeleteSegment(ctx context.Context, name []byte) error {
+	start := time.Now()
+	err := db.ps.DeleteSegment(ctx, name)
+	db.observe("DeleteSegment", time.Since(start), err)
+	return err
+}
+
+func (db instrumentedStore) SetSegmentFeature(ctx context.Context, name, feature, value []byte) (FlipadelphiaFeature, error) {
+	start := time.Now()
+	result, err := db.ps.SetSegmentFeature(ctx, name, feature, value)
+	db.observe("SetSegmentFeature", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetSchedules(ctx context.Context) (ScheduledChanges, error) {
+	start := time.Now()
+	result, err := db.ps.GetSchedules(ctx)
+	db.observe("GetSchedules", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) AddSchedule(ctx context.Context, change ScheduledChange) (ScheduledChange, error) {
+	start := time.Now()
+	result, err := db.ps.AddSchedule(ctx, change)
+	db.observe("AddSchedule", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) DeleteSchedule(ctx context.Context, id []byte) error {
+	start := time.Now()
+	err := db.ps.DeleteSchedule(ctx, id)
+	db.observe("DeleteSchedule", time.Since(start), err)
+	return err
+}
+
+func (db instrumentedStore) GetExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	start := time.Now()
+	result, err := db.ps.GetExperiment(ctx, feature)
+	db.observe("GetExperiment", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) SetExperiment(ctx context.Context, experiment Experiment) (Experiment, error) {
+	start := time.Now()
+	result, err := db.ps.SetExperiment(ctx, experiment)
+	db.observe("SetExperiment", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) DeleteExperiment(ctx context.Context, feature []byte) error {
+	start := time.Now()
+	err := db.ps.DeleteExperiment(ctx, feature)
+	db.observe("DeleteExperiment", time.Since(start), err)
+	return err
+}
+
+func (db instrumentedStore) ReshuffleExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	start := time.Now()
+	result, err := db.ps.ReshuffleExperiment(ctx, feature)
+	db.observe("ReshuffleExperiment", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) AssignVariant(ctx context.Context, feature, scope []byte) (Assignment, error) {
+	start := time.Now()
+	result, err := db.ps.AssignVariant(ctx, feature, scope)
+	db.observe("AssignVariant", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetOverrides(ctx context.Context) (FlipadelphiaFeatures, error) {
+	start := time.Now()
+	result, err := db.ps.GetOverrides(ctx)
+	db.observe("GetOverrides", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetOverride(ctx context.Context, feature []byte) (FlipadelphiaFeature, error) {
+	start := time.Now()
+	result, err := db.ps.GetOverride(ctx, feature)
+	db.observe("GetOverride", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) SetOverride(ctx context.Context, feature, value []byte) (FlipadelphiaFeature, error) {
+	start := time.Now()
+	result, err := db.ps.SetOverride(ctx, feature, value)
+	db.observe("SetOverride", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) DeleteOverride(ctx context.Context, feature []byte) error {
+	start := time.Now()
+	err := db.ps.DeleteOverride(ctx, feature)
+	db.observe("DeleteOverride", time.Since(start), err)
+	return err
+}
+
+func (db instrumentedStore) GetUsage(ctx context.Context, feature []byte) (FeatureUsage, error) {
+	start := time.Now()
+	result, err := db.ps.GetUsage(ctx, feature)
+	db.observe("GetUsage", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) GetUsages(ctx context.Context) (FeatureUsages, error) {
+	start := time.Now()
+	result, err := db.ps.GetUsages(ctx)
+	db.observe("GetUsages", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) AddUsage(ctx context.Context, usages FeatureUsages) error {
+	start := time.Now()
+	err := db.ps.AddUsage(ctx, usages)
+	db.observe("AddUsage", time.Since(start), err)
+	return err
+}
+
+func (db instrumentedStore) CheckScopeExists(ctx context.Context, scope []byte) (bool, error) {
+	start := time.Now()
+	result, err := db.ps.CheckScopeExists(ctx, scope)
+	db.observe("CheckScopeExists", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) CheckFeatureExists(ctx context.Context, feature []byte) (bool, error) {
+	start := time.Now()
+	result, err := db.ps.CheckFeatureExists(ctx, feature)
+	db.observe("CheckFeatureExists", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) CheckScopeHasFeature(ctx context.Context, scope, feature []byte) (bool, error) {
+	start := time.Now()
+	result, err := db.ps.CheckScopeHasFeature(ctx, scope, feature)
+	db.observe("CheckScopeHasFeature", time.Since(start), err)
+	return result, err
+}
+
+func (db instrumentedStore) CheckFeatureHasScope(ctx context.Context, scope, feature []byte) (bool, error) {
+	start := time.Now()
+	result, err := db.ps.CheckFeatureHasScope(ctx, scope, feature)
+	db.observe("CheckFeatureHasScope", time.Since(start), err)
+	return result, err
+}
@@ -1,5 +1,10 @@
 package store
 
+import (
+	"context"
+	"time"
+)
+
 type MockPersistenceStore struct {
 	OnGet                           func([]byte, []byte) (Serializable, error)
 	OnGetScopeFeatures              func([]byte) (Serializable, error)
@@ -12,10 +17,10 @@ type MockPersistenceStore struct {
 	OnGetFeaturesPaginated          func(int, int) (Serializable, error)
 	OnGetFeatures                   func() (Serializable, error)
 	OnGetScopeFeaturesFull          func([]byte) (Serializable, error)
-	OnCheckScopeExists		func([]byte) bool
-	OnCheckFeatureExists		func([]byte) bool
-	OnCheckScopeHasFeature		func([]byte, []byte) bool
-	OnCheckFeatureHasScope		func([]byte, []byte) bool
+	OnCheckScopeExists              func([]byte) bool
+	OnCheckFeatureExists            func([]byte) bool
+	OnCheckScopeHasFeature          func([]byte, []byte) bool
+	OnCheckFeatureHasScope          func([]byte, []byte) bool
 }
 
 func (mStore MockPersistenceStore) Get(scope, key []byte) (Serializable, error) {
@@ -81,3 +86,255 @@ func (mStore MockPersistenceStore) CheckFeatureHasScope(scope, feature []byte) b
 func (mStore MockPersistenceStore) Close() error {
 	return nil
 }
+
+type MockPersistenceStoreV2 struct {
+	OnGet                           func(context.Context, []byte, []byte) (FlipadelphiaFeature, error)
+	OnGetScopeFeatures              func(context.Context, []byte) ([]string, error)
+	OnGetScopeFeaturesFilterByValue func(context.Context, []byte, []byte) ([]string, error)
+	OnSet                           func(context.Context, []byte, []byte, []byte) (FlipadelphiaFeature, error)
+	OnSetWithExpiry                 func(context.Context, []byte, []byte, []byte, time.Time) (FlipadelphiaFeature, error)
+	OnGetScopes                     func(context.Context) ([]string, error)
+	OnGetScopesWithPrefix           func(context.Context, []byte) ([]string, error)
+	OnGetScopesWithFeature          func(context.Context, []byte) ([]string, error)
+	OnGetScopesPaginated            func(context.Context, int, int) ([]string, error)
+	OnGetFeaturesPaginated          func(context.Context, int, int) ([]string, error)
+	OnGetFeatures                   func(context.Context) ([]string, error)
+	OnGetScopeFeaturesFull          func(context.Context, []byte) (FlipadelphiaFeatures, error)
+	OnGetScopesPage                 func(context.Context, string, int) (Page, error)
+	OnGetFeaturesPage               func(context.Context, string, int) (Page, error)
+	OnGetScopesWithFeaturePage      func(context.Context, []byte, string, int) (Page, error)
+	OnGetScopeFeaturesFullPage      func(context.Context, []byte, string, int) (FeaturePage, error)
+	OnSearchFeatures                func(context.Context, SearchQuery, string, int) (Page, error)
+	OnSearchScopes                  func(context.Context, SearchQuery, string, int) (Page, error)
+	OnGetFeatureSummary             func(context.Context, []byte) (FeatureSummary, error)
+	OnCopyScope                     func(context.Context, []byte, CopyScopeOptions) (FlipadelphiaFeatures, error)
+	OnGetScopeParents               func(context.Context, []byte) ([]string, error)
+	OnSetScopeParents               func(context.Context, []byte, []string) error
+	OnGetPrerequisites              func(context.Context, []byte) ([]string, error)
+	OnSetPrerequisites              func(context.Context, []byte, []string) error
+	OnGetSegments                   func(context.Context) (Segments, error)
+	OnGetSegment                    func(context.Context, []byte) (Segment, error)
+	OnSetSegment                    func(context.Context, Segment) error
+	OnDeleteSegment                 func(context.Context, []byte) error
+	OnSetSegmentFeature             func(context.Context, []byte, []byte, []byte) (FlipadelphiaFeature, error)
+	OnGetSchedules                  func(context.Context) (ScheduledChanges, error)
+	OnAddSchedule                   func(context.Context, ScheduledChange) (ScheduledChange, error)
+	OnDeleteSchedule                func(context.Context, []byte) error
+	OnGetExperiment                 func(context.Context, []byte) (Experiment, error)
+	OnSetExperiment                 func(context.Context, Experiment) (Experiment, error)
+	OnDeleteExperiment              func(context.Context, []byte) error
+	OnReshuffleExperiment           func(context.Context, []byte) (Experiment, error)
+	OnAssignVariant                 func(context.Context, []byte, []byte) (Assignment, error)
+	OnGetOverrides                  func(context.Context) (FlipadelphiaFeatures, error)
+	OnGetOverride                   func(context.Context, []byte) (FlipadelphiaFeature, error)
+	OnSetOverride                   func(context.Context, []byte, []byte) (FlipadelphiaFeature, error)
+	OnDeleteOverride                func(context.Context, []byte) error
+	OnGetUsage                      func(context.Context, []byte) (FeatureUsage, error)
+	OnGetUsages                     func(context.Context) (FeatureUsages, error)
+	OnAddUsage                      func(context.Context, FeatureUsages) error
+	OnPing                          func(context.Context) error
+	OnCheckScopeExists              func(context.Context, []byte) (bool, error)
+	OnCheckFeatureExists            func(context.Context, []byte) (bool, error)
+	OnCheckScopeHasFeature          func(context.Context, []byte, []byte) (bool, error)
+	OnCheckFeatureHasScope          func(context.Context, []byte, []byte) (bool, error)
+}
+
+func (mStore MockPersistenceStoreV2) Get(ctx context.Context, scope, key []byte) (FlipadelphiaFeature, error) {
+	return mStore.OnGet(ctx, scope, key)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopeFeatures(ctx context.Context, scope []byte) ([]string, error) {
+	return mStore.OnGetScopeFeatures(ctx, scope)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopeFeaturesFilterByValue(ctx context.Context, scope, value []byte) ([]string, error) {
+	return mStore.OnGetScopeFeaturesFilterByValue(ctx, scope, value)
+}
+
+func (mStore MockPersistenceStoreV2) Set(ctx context.Context, scope, key, value []byte) (FlipadelphiaFeature, error) {
+	return mStore.OnSet(ctx, scope, key, value)
+}
+
+func (mStore MockPersistenceStoreV2) SetWithExpiry(ctx context.Context, scope, key, value []byte, expiresAt time.Time) (FlipadelphiaFeature, error) {
+	return mStore.OnSetWithExpiry(ctx, scope, key, value, expiresAt)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopes(ctx context.Context) ([]string, error) {
+	return mStore.OnGetScopes(ctx)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopesWithPrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	return mStore.OnGetScopesWithPrefix(ctx, prefix)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopesWithFeature(ctx context.Context, feature []byte) ([]string, error) {
+	return mStore.OnGetScopesWithFeature(ctx, feature)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	return mStore.OnGetScopesPaginated(ctx, offset, count)
+}
+
+func (mStore MockPersistenceStoreV2) GetFeaturesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	return mStore.OnGetFeaturesPaginated(ctx, offset, count)
+}
+
+func (mStore MockPersistenceStoreV2) GetFeatures(ctx context.Context) ([]string, error) {
+	return mStore.OnGetFeatures(ctx)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopeFeaturesFull(ctx context.Context, scope []byte) (FlipadelphiaFeatures, error) {
+	return mStore.OnGetScopeFeaturesFull(ctx, scope)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopesPage(ctx context.Context, after string, limit int) (Page, error) {
+	return mStore.OnGetScopesPage(ctx, after, limit)
+}
+
+func (mStore MockPersistenceStoreV2) GetFeaturesPage(ctx context.Context, after string, limit int) (Page, error) {
+	return mStore.OnGetFeaturesPage(ctx, after, limit)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopesWithFeaturePage(ctx context.Context, feature []byte, after string, limit int) (Page, error) {
+	return mStore.OnGetScopesWithFeaturePage(ctx, feature, after, limit)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopeFeaturesFullPage(ctx context.Context, scope []byte, after string, limit int) (FeaturePage, error) {
+	return mStore.OnGetScopeFeaturesFullPage(ctx, scope, after, limit)
+}
+
+func (mStore MockPersistenceStoreV2) SearchFeatures(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	return mStore.OnSearchFeatures(ctx, q, after, limit)
+}
+
+func (mStore MockPersistenceStoreV2) SearchScopes(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	return mStore.OnSearchScopes(ctx, q, after, limit)
+}
+
+func (mStore MockPersistenceStoreV2) GetFeatureSummary(ctx context.Context, feature []byte) (FeatureSummary, error) {
+	return mStore.OnGetFeatureSummary(ctx, feature)
+}
+
+func (mStore MockPersistenceStoreV2) CopyScope(ctx context.Context, source []byte, opts CopyScopeOptions) (FlipadelphiaFeatures, error) {
+	return mStore.OnCopyScope(ctx, source, opts)
+}
+
+func (mStore MockPersistenceStoreV2) GetScopeParents(ctx context.Context, scope []byte) ([]string, error) {
+	return mStore.OnGetScopeParents(ctx, scope)
+}
+
+func (mStore MockPersistenceStoreV2) SetScopeParents(ctx context.Context, scope []byte, parents []string) error {
+	return mStore.OnSetScopeParents(ctx, scope, parents)
+}
+
+func (mStore MockPersistenceStoreV2) GetPrerequisites(ctx context.Context, feature []byte) ([]string, error) {
+	return mStore.OnGetPrerequisites(ctx, feature)
+}
+
+func (mStore MockPersistenceStoreV2) SetPrerequisites(ctx context.Context, feature []byte, prerequisites []string) error {
+	return mStore.OnSetPrerequisites(ctx, feature, prerequisites)
+}
+
+func (mStore MockPersistenceStoreV2) GetSegments(ctx context.Context) (Segments, error) {
+	return mStore.OnGetSegments(ctx)
+}
+
+func (mStore MockPersistenceStoreV2) GetSegment(ctx context.Context, name []byte) (Segment, error) {
+	return mStore.OnGetSegment(ctx, name)
+}
+
+func (mStore MockPersistenceStoreV2) SetSegment(ctx context.Context, segment Segment) error {
+	return mStore.OnSetSegment(ctx, segment)
+}
+
+func (mStore MockPersistenceStoreV2) DeleteSegment(ctx context.Context, name []byte) error {
+	return mStore.OnDeleteSegment(ctx, name)
+}
+
+func (mStore MockPersistenceStoreV2) SetSegmentFeature(ctx context.Context, name, feature, value []byte) (FlipadelphiaFeature, error) {
+	return mStore.OnSetSegmentFeature(ctx, name, feature, value)
+}
+
+func (mStore MockPersistenceStoreV2) GetSchedules(ctx context.Context) (ScheduledChanges, error) {
+	return mStore.OnGetSchedules(ctx)
+}
+
+func (mStore MockPersistenceStoreV2) AddSchedule(ctx context.Context, change ScheduledChange) (ScheduledChange, error) {
+	return mStore.OnAddSchedule(ctx, change)
+}
+
+func (mStore MockPersistenceStoreV2) DeleteSchedule(ctx context.Context, id []byte) error {
+	return mStore.OnDeleteSchedule(ctx, id)
+}
+
+func (mStore MockPersistenceStoreV2) GetExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	return mStore.OnGetExperiment(ctx, feature)
+}
+
+func (mStore MockPersistenceStoreV2) SetExperiment(ctx context.Context, experiment Experiment) (Experiment, error) {
+	return mStore.OnSetExperiment(ctx, experiment)
+}
+
+func (mStore MockPersistenceStoreV2) DeleteExperiment(ctx context.Context, feature []byte) error {
+	return mStore.OnDeleteExperiment(ctx, feature)
+}
+
+func (mStore MockPersistenceStoreV2) ReshuffleExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	return mStore.OnReshuffleExperiment(ctx, feature)
+}
+
+func (mStore MockPersistenceStoreV2) AssignVariant(ctx context.Context, feature, scope []byte) (Assignment, error) {
+	return mStore.OnAssignVariant(ctx, feature, scope)
+}
+
+func (mStore MockPersistenceStoreV2) GetOverrides(ctx context.Context) (FlipadelphiaFeatures, error) {
+	return mStore.OnGetOverrides(ctx)
+}
+
+func (mStore MockPersistenceStoreV2) GetOverride(ctx context.Context, feature []byte) (FlipadelphiaFeature, error) {
+	return mStore.OnGetOverride(ctx, feature)
+}
+
+func (mStore MockPersistenceStoreV2) SetOverride(ctx context.Context, feature, value []byte) (FlipadelphiaFeature, error) {
+	return mStore.OnSetOverride(ctx, feature, value)
+}
+
+func (mStore MockPersistenceStoreV2) DeleteOverride(ctx context.Context, feature []byte) error {
+	return mStore.OnDeleteOverride(ctx, feature)
+}
+
+func (mStore MockPersistenceStoreV2) GetUsage(ctx context.Context, feature []byte) (FeatureUsage, error) {
+	return mStore.OnGetUsage(ctx, feature)
+}
+
+func (mStore MockPersistenceStoreV2) GetUsages(ctx context.Context) (FeatureUsages, error) {
+	return mStore.OnGetUsages(ctx)
+}
+
+func (mStore MockPersistenceStoreV2) AddUsage(ctx context.Context, usages FeatureUsages) error {
+	return mStore.OnAddUsage(ctx, usages)
+}
+
+func (mStore MockPersistenceStoreV2) Ping(ctx context.Context) error {
+	return mStore.OnPing(ctx)
+}
+
+func (mStore MockPersistenceStoreV2) CheckScopeExists(ctx context.Context, scope []byte) (bool, error) {
+	return mStore.OnCheckScopeExists(ctx, scope)
+}
+
+func (mStore MockPersistenceStoreV2) CheckFeatureExists(ctx context.Context, feature []byte) (bool, error) {
+	return mStore.OnCheckFeatureExists(ctx, feature)
+}
+
+func (mStore MockPersistenceStoreV2) CheckScopeHasFeature(ctx context.Context, scope, feature []byte) (bool, error) {
+	return mStore.OnCheckScopeHasFeature(ctx, scope, feature)
+}
+
+func (mStore MockPersistenceStoreV2) CheckFeatureHasScope(ctx context.Context, scope, feature []byte) (bool, error) {
+	return mStore.OnCheckFeatureHasScope(ctx, scope, feature)
+}
+
+func (mStore MockPersistenceStoreV2) Close() error {
+	return nil
+}
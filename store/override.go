@@ -0,0 +1,21 @@
+package store
+
+import "errors"
+
+// ErrOverrideNotFound is returned when the feature has no override set.
+var ErrOverrideNotFound = errors.New("override not found")
+
+// applyOverrides replaces the value of every resolved feature that has an override. Overridden features
+// keep their source, and are marked with Override.
+func applyOverrides(features ResolvedFeatures, overrides FlipadelphiaFeatures) {
+	values := make(map[string]FlipadelphiaFeature, len(overrides))
+	for _, override := range overrides {
+		values[override.Name] = override
+	}
+	for i, feature := range features {
+		if override, ok := values[feature.Name]; ok {
+			features[i].FlipadelphiaFeature = override
+			features[i].Override = true
+		}
+	}
+}
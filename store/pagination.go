@@ -0,0 +1,94 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+)
+
+// ErrInvalidPageToken is returned when a page token can not be decoded.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// ErrInvalidPageLimit is returned when a page is asked for with a limit below one.
+var ErrInvalidPageLimit = errors.New("invalid page limit")
+
+// Page is a single page of a cursor paginated listing of scope or feature names. Next is the token
+// for the following page, and is empty on the last page.
+type Page struct {
+	Items []string
+	Next  string
+}
+
+// FeaturePage is a single page of a cursor paginated listing of features with their values.
+type FeaturePage struct {
+	Items FlipadelphiaFeatures
+	Next  string
+}
+
+// EncodePageToken returns the opaque token for a store specific cursor.
+func EncodePageToken(cursor []byte) string {
+	return base64.RawURLEncoding.EncodeToString(cursor)
+}
+
+// DecodePageToken returns the store specific cursor held in a page token. An empty token decodes to
+// a nil cursor, meaning the start of the listing.
+func DecodePageToken(token string) ([]byte, error) {
+	if token == "" {
+		return nil, nil
+	}
+	cursor, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(cursor) == 0 {
+		return nil, ErrInvalidPageToken
+	}
+	return cursor, nil
+}
+
+// checkPageLimit returns ErrInvalidPageLimit if a page can not hold limit items.
+func checkPageLimit(limit int) error {
+	if limit < 1 {
+		return ErrInvalidPageLimit
+	}
+	return nil
+}
+
+// pageStrings returns the page of items that sort after the token. It is used by stores that have no
+// ordered index to seek into.
+func pageStrings(items []string, after string, limit int) (Page, error) {
+	cursor, err := DecodePageToken(after)
+	if err != nil {
+		return Page{}, err
+	}
+	sorted := append([]string(nil), items...)
+	sort.Strings(sorted)
+	start := 0
+	if cursor != nil {
+		start = sort.SearchStrings(sorted, string(cursor))
+		if start < len(sorted) && sorted[start] == string(cursor) {
+			start++
+		}
+	}
+	end := start + limit
+	if end >= len(sorted) {
+		return Page{Items: sorted[start:]}, nil
+	}
+	return Page{Items: sorted[start:end], Next: EncodePageToken([]byte(sorted[end-1]))}, nil
+}
+
+// pageFeatures returns the page of features whose names sort after the token.
+func pageFeatures(features FlipadelphiaFeatures, after string, limit int) (FeaturePage, error) {
+	byName := make(map[string]FlipadelphiaFeature, len(features))
+	names := make([]string, 0, len(features))
+	for _, feature := range features {
+		byName[feature.Name] = feature
+		names = append(names, feature.Name)
+	}
+	page, err := pageStrings(names, after, limit)
+	if err != nil {
+		return FeaturePage{}, err
+	}
+	featurePage := FeaturePage{Next: page.Next}
+	for _, name := range page.Items {
+		featurePage.Items = append(featurePage.Items, byName[name])
+	}
+	return featurePage, nil
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ErrPrerequisiteCycle is returned when declaring a feature's prerequisites would make the feature
+// depend on itself.
+var ErrPrerequisiteCycle = errors.New("feature prerequisite cycle")
+
+// IsOn reports whether a feature value counts as on when it is checked as a prerequisite: "on" or
+// "true" in any case, or "1".
+func IsOn(value string) bool {
+	return strings.EqualFold(value, "on") || strings.EqualFold(value, "true") || value == "1"
+}
+
+// checkPrerequisiteCycle returns ErrPrerequisiteCycle if the feature is one of the prerequisites or
+// one of theirs. getPrerequisites returns the currently declared prerequisites of a feature.
+func checkPrerequisiteCycle(feature string, prerequisites []string, getPrerequisites func(string) ([]string, error)) error {
+	cycle, err := reaches(feature, prerequisites, getPrerequisites)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrPrerequisiteCycle
+	}
+	return nil
+}
+
+// offForPrerequisite returns the feature resolved to "off" because of the prerequisite.
+func offForPrerequisite(resolved ResolvedFeature, prerequisite string) ResolvedFeature {
+	resolved.FlipadelphiaFeature = NewFlipadelphiaFeature([]byte(resolved.Name), []byte("off"))
+	resolved.Segment = ""
+	resolved.Prerequisite = prerequisite
+	return resolved
+}
+
+// resolveWithPrerequisites resolves the feature on the scope, then resolves each of its prerequisites
+// on the same scope. visiting holds the features being resolved further up, so a cycle that slipped
+// into the store is reported rather than followed forever.
+func resolveWithPrerequisites(ctx context.Context, ps PersistenceStoreV2, scope, feature []byte, visiting map[string]bool) (ResolvedFeature, error) {
+	resolved, err := resolveFeature(ctx, ps, scope, feature)
+	if err != nil || resolved.Override {
+		return resolved, err
+	}
+	prerequisites, err := ps.GetPrerequisites(ctx, feature)
+	if err != nil {
+		return ResolvedFeature{}, err
+	}
+	visiting[string(feature)] = true
+	defer delete(visiting, string(feature))
+	for _, prerequisite := range prerequisites {
+		if visiting[prerequisite] {
+			return ResolvedFeature{}, ErrPrerequisiteCycle
+		}
+		p, err := resolveWithPrerequisites(ctx, ps, scope, []byte(prerequisite), visiting)
+		switch err {
+		case nil:
+		case ErrScopeNotFound, ErrFeatureNotFound:
+			return offForPrerequisite(resolved, prerequisite), nil
+		default:
+			return ResolvedFeature{}, err
+		}
+		if !IsOn(p.Value) {
+			return offForPrerequisite(resolved, prerequisite), nil
+		}
+	}
+	return resolved, nil
+}
+
+// applyPrerequisites turns off every resolved feature of the scope with a prerequisite that is not on.
+// Prerequisites are looked up among the resolved features first; one that is not among them is
+// resolved on the scope like a single check, so a global override still turns it on.
+func applyPrerequisites(ctx context.Context, ps PersistenceStoreV2, scope []byte, features ResolvedFeatures) error {
+	index := make(map[string]int, len(features))
+	for i, feature := range features {
+		index[feature.Name] = i
+	}
+	// blocking holds, for each feature checked so far, the prerequisite that turns it off, or "".
+	blocking := make(map[string]string, len(features))
+	visiting := make(map[string]bool)
+	// outside holds whether each prerequisite resolved outside the features is on.
+	outside := make(map[string]bool)
+	// check reports whether the named feature is on once its own prerequisites are applied.
+	var check func(name string) (bool, error)
+	check = func(name string) (bool, error) {
+		i, ok := index[name]
+		if !ok {
+			if on, done := outside[name]; done {
+				return on, nil
+			}
+			p, err := resolveWithPrerequisites(ctx, ps, scope, []byte(name), visiting)
+			switch err {
+			case nil:
+			case ErrScopeNotFound, ErrFeatureNotFound:
+			default:
+				return false, err
+			}
+			outside[name] = err == nil && IsOn(p.Value)
+			return outside[name], nil
+		}
+		if prerequisite, done := blocking[name]; done {
+			return prerequisite == "" && IsOn(features[i].Value), nil
+		}
+		if visiting[name] {
+			return false, ErrPrerequisiteCycle
+		}
+		var prerequisites []string
+		if !features[i].Override {
+			var err error
+			if prerequisites, err = ps.GetPrerequisites(ctx, []byte(name)); err != nil {
+				return false, err
+			}
+		}
+		visiting[name] = true
+		blocked := ""
+		for _, prerequisite := range prerequisites {
+			on, err := check(prerequisite)
+			if err != nil {
+				return false, err
+			}
+			if !on {
+				blocked = prerequisite
+				break
+			}
+		}
+		delete(visiting, name)
+		blocking[name] = blocked
+		return blocked == "" && IsOn(features[i].Value), nil
+	}
+	for _, feature := range features {
+		if _, err := check(feature.Name); err != nil {
+			return err
+		}
+	}
+	for i, feature := range features {
+		if prerequisite := blocking[feature.Name]; prerequisite != "" {
+			features[i] = offForPrerequisite(feature, prerequisite)
+		}
+	}
+	return nil
+}
@@ -1,8 +1,16 @@
 package store
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"time"
 
+	"github.com/samdfonseca/flipadelphia/utils"
+	"github.com/satori/go.uuid"
 	"gopkg.in/redis.v5"
 )
 
@@ -20,34 +28,162 @@ func NewFlipadelphiaRedisDB(host, password string, db int) FlipadelphiaRedisDB {
 	}
 }
 
-func (rdb FlipadelphiaRedisDB) Get(scope, key []byte) (Serializable, error) {
-	value, err := rdb.client.HGet(string(scope), string(key)).Bytes()
+// Ping sends a PING to the Redis server.
+func (rdb FlipadelphiaRedisDB) Ping(ctx context.Context) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return redisError(rdb.client.Ping().Err())
+}
+
+// PoolStats counts the connections in the client's pool. Active connections include the idle ones.
+func (rdb FlipadelphiaRedisDB) PoolStats() ConnectionPoolStats {
+	stats := rdb.client.PoolStats()
+	return ConnectionPoolStats{Active: int(stats.TotalConns), Idle: int(stats.FreeConns)}
+}
+
+// redisError replaces connection failures with ErrStoreUnavailable so callers can tell them apart
+// from missing data. The original error is logged.
+func redisError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(net.Error); ok || err == io.EOF {
+		utils.Log.WithError(err).Error("Redis connection failed")
+		return ErrStoreUnavailable
+	}
+	return err
+}
+
+// liveFields returns the named fields of the scope hash that are set and have not expired, with their
+// values, or every such field when none are named.
+func (rdb FlipadelphiaRedisDB) liveFields(scope string, fields ...string) (map[string]string, error) {
+	args := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		args = append(args, field)
+	}
+	reply, err := rdb.client.Eval(redisLiveFieldsScript, []string{scope}, args...).Result()
 	if err != nil {
-		return nil, err
+		return nil, redisError(err)
 	}
-	return NewFlipadelphiaFeature(key, value), nil
+	values, _ := reply.([]interface{})
+	live := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		live[fmt.Sprint(values[i])] = fmt.Sprint(values[i+1])
+	}
+	return live, nil
+}
+
+// hasLiveField reports whether the feature is set on the scope and has not expired.
+func (rdb FlipadelphiaRedisDB) hasLiveField(scope, feature string) (bool, error) {
+	live, err := rdb.liveFields(scope, feature)
+	_, ok := live[feature]
+	return ok, err
 }
 
-func (rdb FlipadelphiaRedisDB) Set(scope, key, value []byte) (Serializable, error) {
-	err := rdb.client.HSet(string(scope), string(key), string(value)).Err()
-	return NewFlipadelphiaFeature(key, value), err
+// liveScanFields returns the fields of an HSCAN batch that have not expired, with their values.
+func (rdb FlipadelphiaRedisDB) liveScanFields(scope string, batch []string) (map[string]string, error) {
+	if len(batch) == 0 {
+		return nil, nil
+	}
+	fields := make([]string, 0, len(batch)/2)
+	for i := 0; i+1 < len(batch); i += 2 {
+		fields = append(fields, batch[i])
+	}
+	return rdb.liveFields(scope, fields...)
 }
 
-func (rdb FlipadelphiaRedisDB) GetScopeFeatures(scope []byte) (Serializable, error) {
-	var keys FlipadelphiaScopeFeatures
-	keys, err := rdb.client.HKeys(string(scope)).Result()
+func (rdb FlipadelphiaRedisDB) Get(ctx context.Context, scope, key []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	override, err := rdb.client.HGet(redisOverridesKey, string(key)).Bytes()
+	if err == nil {
+		return NewFlipadelphiaFeature(key, override), nil
+	}
+	if err != redis.Nil {
+		return FlipadelphiaFeature{}, redisError(err)
+	}
+	live, err := rdb.liveFields(string(scope), string(key))
+	if err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	value, ok := live[string(key)]
+	if !ok {
+		if exists, err := rdb.CheckScopeExists(ctx, scope); err != nil {
+			return FlipadelphiaFeature{}, err
+		} else if !exists {
+			return FlipadelphiaFeature{}, ErrScopeNotFound
+		}
+		return FlipadelphiaFeature{}, ErrFeatureNotFound
+	}
+	return NewFlipadelphiaFeature(key, []byte(value)), nil
+}
+
+func (rdb FlipadelphiaRedisDB) Set(ctx context.Context, scope, key, value []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	keys := []string{string(scope)}
+	err := rdb.client.Eval(redisSetFeatureScript, keys, string(key), string(value), time.Now().UnixNano()).Err()
+	return NewFlipadelphiaFeature(key, value), redisError(err)
+}
+
+func (rdb FlipadelphiaRedisDB) SetWithExpiry(ctx context.Context, scope, key, value []byte, expiresAt time.Time) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	keys := []string{string(scope)}
+	err := rdb.client.Eval(redisSetFeatureWithExpiryScript, keys, string(key), string(value), time.Now().UnixNano(),
+		expiresAt.UnixNano()/int64(time.Millisecond)).Err()
+	return NewFlipadelphiaFeature(key, value), redisError(err)
+}
+
+// DeleteExpired takes the values that have expired out of their feature's summary counters, and
+// returns how many there were. Redis 7.4 and later remove the fields themselves, and on older
+// servers they are removed here.
+func (rdb FlipadelphiaRedisDB) DeleteExpired(ctx context.Context) (int, error) {
+	if err := checkContext(ctx); err != nil {
+		return 0, err
+	}
+	settled, err := rdb.client.Eval(redisSweepExpiredScript, nil).Result()
+	if err != nil {
+		return 0, redisError(err)
+	}
+	n, _ := settled.(int64)
+	return int(n), nil
+}
+
+func (rdb FlipadelphiaRedisDB) GetScopeFeatures(ctx context.Context, scope []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	live, err := rdb.liveFields(string(scope))
 	if err != nil {
 		return nil, err
 	}
+	if len(live) == 0 {
+		return nil, ErrScopeNotFound
+	}
+	keys := make([]string, 0, len(live))
+	for k := range live {
+		keys = append(keys, k)
+	}
 	return keys, nil
 }
 
-func (rdb FlipadelphiaRedisDB) GetScopeFeaturesFilterByValue(scope []byte, targetValue []byte) (Serializable, error) {
+func (rdb FlipadelphiaRedisDB) GetScopeFeaturesFilterByValue(ctx context.Context, scope []byte, targetValue []byte) ([]string, error) {
 	var features FlipadelphiaScopeFeatures
-	res, err := rdb.client.HGetAll(string(scope)).Result()
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	res, err := rdb.liveFields(string(scope))
 	if err != nil {
 		return nil, err
 	}
+	if len(res) == 0 {
+		return nil, ErrScopeNotFound
+	}
 	for k, v := range res {
 		if v == string(targetValue) {
 			features = append(features, k)
@@ -56,32 +192,20 @@ func (rdb FlipadelphiaRedisDB) GetScopeFeaturesFilterByValue(scope []byte, targe
 	return features, nil
 }
 
-func (rdb FlipadelphiaRedisDB) GetScopes() (Serializable, error) {
+func (rdb FlipadelphiaRedisDB) scan(ctx context.Context, match string) ([]string, error) {
 	var scopes FlipadelphiaScopeList
 	var cursor uint64
 	for {
-		keys, cursor, err := rdb.client.Scan(cursor, "", 10).Result()
-		if err != nil {
+		if err := checkContext(ctx); err != nil {
 			return nil, err
 		}
-		scopes = append(scopes, keys...)
-		if cursor == 0 {
-			break
-		}
-	}
-	return scopes, nil
-}
-
-func (rdb FlipadelphiaRedisDB) GetScopesWithPrefix(prefix []byte) (Serializable, error) {
-	var scopes FlipadelphiaScopeList
-	var cursor uint64
-	match := string(prefix) + "*"
-	for {
-		keys, cursor, err := rdb.client.Scan(cursor, match, 10).Result()
+		var keys []string
+		var err error
+		keys, cursor, err = rdb.client.Scan(cursor, match, 10).Result()
 		if err != nil {
-			return nil, err
+			return nil, redisError(err)
 		}
-		scopes = append(scopes, keys...)
+		scopes = append(scopes, withoutReservedKeys(keys)...)
 		if cursor == 0 {
 			break
 		}
@@ -89,66 +213,83 @@ func (rdb FlipadelphiaRedisDB) GetScopesWithPrefix(prefix []byte) (Serializable,
 	return scopes, nil
 }
 
-func (rdb FlipadelphiaRedisDB) GetScopesWithFeature(key []byte) (Serializable, error) {
+func (rdb FlipadelphiaRedisDB) GetScopes(ctx context.Context) ([]string, error) {
+	return rdb.scan(ctx, "")
+}
+
+func (rdb FlipadelphiaRedisDB) GetScopesWithPrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	return rdb.scan(ctx, redisPrefixPattern(string(prefix)))
+}
+
+func (rdb FlipadelphiaRedisDB) GetScopesWithFeature(ctx context.Context, key []byte) ([]string, error) {
 	var scopesWithFeature FlipadelphiaScopeList
-	scopes, err := rdb.GetScopes()
+	scopes, err := rdb.GetScopes(ctx)
 	if err != nil {
 		return nil, err
 	}
-	for _, scope := range scopes.(FlipadelphiaScopeList) {
-		if res, err := rdb.client.HExists(scope, string(key)).Result(); err != nil && res == true {
+	for _, scope := range scopes {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+		res, err := rdb.hasLiveField(scope, string(key))
+		if err != nil {
+			return nil, err
+		}
+		if res {
 			scopesWithFeature = append(scopesWithFeature, scope)
 		}
 	}
+	if len(scopesWithFeature) == 0 {
+		return nil, ErrFeatureNotFound
+	}
 	return scopesWithFeature, nil
 }
 
-func (rdb FlipadelphiaRedisDB) GetScopesPaginated(offset, count int) (Serializable, error) {
-	var scopes StringSlice
-	return scopes, fmt.Errorf("Unimplemented method")
+func (rdb FlipadelphiaRedisDB) GetScopesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	return nil, ErrUnimplemented
 }
 
-func (rdb FlipadelphiaRedisDB) GetFeaturesPaginated(offset, count int) (Serializable, error) {
-	return nil, fmt.Errorf("Unimplemented method")
+func (rdb FlipadelphiaRedisDB) GetFeaturesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	return nil, ErrUnimplemented
 }
 
-func (rdb FlipadelphiaRedisDB) GetFeatures() (Serializable, error) {
-	scopes, err := rdb.GetScopes()
-	fch := make(chan FlipadelphiaScopeFeatures)
-	ech := make(chan error)
-	for _, scope := range scopes.(FlipadelphiaScopeList) {
-		go func() {
-			features, err := rdb.GetScopeFeatures([]byte(scope))
-			if err != nil {
-				ech <- err
-				return
-			}
-			fch <- features.(FlipadelphiaScopeFeatures)
-		}()
+func (rdb FlipadelphiaRedisDB) GetFeatures(ctx context.Context) ([]string, error) {
+	scopes, err := rdb.GetScopes(ctx)
+	if err != nil {
+		return nil, err
 	}
 	var featuresMap = make(map[string]interface{})
 	var uniqueFeatures FlipadelphiaScopeFeatures
-	for F := range fch {
-		for _, f := range F {
+	for _, scope := range scopes {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+		features, err := rdb.liveFields(scope)
+		if err != nil {
+			return nil, err
+		}
+		for f := range features {
 			if _, ok := featuresMap[f]; !ok {
 				featuresMap[f] = nil
 				uniqueFeatures = append(uniqueFeatures, f)
 			}
 		}
 	}
-	err, ok := <-ech
-	if ok {
-		return nil, err
-	}
 	return uniqueFeatures, nil
 }
 
-func (rdb FlipadelphiaRedisDB) GetScopeFeaturesFull(scope []byte) (Serializable, error) {
+func (rdb FlipadelphiaRedisDB) GetScopeFeaturesFull(ctx context.Context, scope []byte) (FlipadelphiaFeatures, error) {
 	var features FlipadelphiaFeatures
-	res, err := rdb.client.HGetAll(string(scope)).Result()
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	res, err := rdb.liveFields(string(scope))
 	if err != nil {
 		return nil, err
 	}
+	if len(res) == 0 {
+		return nil, ErrScopeNotFound
+	}
 	for k, v := range res {
 		features = append(features, NewFlipadelphiaFeature([]byte(k), []byte(v)))
 	}
@@ -159,18 +300,698 @@ func (rdb FlipadelphiaRedisDB) Close() error {
 	return rdb.client.Close()
 }
 
-func (rdb FlipadelphiaRedisDB) CheckScopeExists(scope []byte) bool {
-	return true
+func (rdb FlipadelphiaRedisDB) CheckScopeExists(ctx context.Context, scope []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	exists, err := rdb.client.Exists(string(scope)).Result()
+	return exists, redisError(err)
+}
+
+func (rdb FlipadelphiaRedisDB) CheckFeatureExists(ctx context.Context, feature []byte) (bool, error) {
+	_, err := rdb.GetScopesWithFeature(ctx, feature)
+	if err == ErrFeatureNotFound {
+		return false, nil
+	}
+	return err == nil, err
 }
 
-func (rdb FlipadelphiaRedisDB) CheckFeatureExists(feature []byte) bool {
-	return true
+func (rdb FlipadelphiaRedisDB) CheckScopeHasFeature(ctx context.Context, scope, feature []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	return rdb.hasLiveField(string(scope), string(feature))
 }
 
-func (rdb FlipadelphiaRedisDB) CheckScopeHasFeature(scope, feature []byte) bool {
-	return true
+func (rdb FlipadelphiaRedisDB) CheckFeatureHasScope(ctx context.Context, scope, feature []byte) (bool, error) {
+	return rdb.CheckScopeHasFeature(ctx, scope, feature)
 }
 
-func (rdb FlipadelphiaRedisDB) CheckFeatureHasScope(scope, feature []byte) bool {
-	return true
+// decodeScanToken returns the SCAN cursor held in a page token.
+func decodeScanToken(after string) (uint64, error) {
+	raw, err := DecodePageToken(after)
+	if err != nil || raw == nil {
+		return 0, err
+	}
+	cursor, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil || cursor == 0 {
+		return 0, ErrInvalidPageToken
+	}
+	return cursor, nil
+}
+
+// encodeScanToken returns the page token for a SCAN cursor. A zero cursor ends the iteration.
+func encodeScanToken(cursor uint64) string {
+	if cursor == 0 {
+		return ""
+	}
+	return EncodePageToken([]byte(strconv.FormatUint(cursor, 10)))
+}
+
+// scanPage runs SCAN until at least limit keys have been returned or the iteration ends. SCAN returns
+// keys in batches, so a page may hold slightly more than limit keys.
+func (rdb FlipadelphiaRedisDB) scanPage(ctx context.Context, match, after string, limit int, filter func(string) (bool, error)) (Page, error) {
+	var page Page
+	cursor, err := decodeScanToken(after)
+	if err != nil {
+		return page, err
+	}
+	for {
+		if err := checkContext(ctx); err != nil {
+			return Page{}, err
+		}
+		var keys []string
+		keys, cursor, err = rdb.client.Scan(cursor, match, int64(limit)).Result()
+		if err != nil {
+			return Page{}, redisError(err)
+		}
+		for _, key := range withoutReservedKeys(keys) {
+			ok, err := filter(key)
+			if err != nil {
+				return Page{}, err
+			}
+			if ok {
+				page.Items = append(page.Items, key)
+			}
+		}
+		if cursor == 0 || len(page.Items) >= limit {
+			break
+		}
+	}
+	page.Next = encodeScanToken(cursor)
+	return page, nil
+}
+
+// GetScopesPage returns a page of scopes following the page token. Scopes are returned in SCAN order.
+func (rdb FlipadelphiaRedisDB) GetScopesPage(ctx context.Context, after string, limit int) (Page, error) {
+	return rdb.scanPage(ctx, "*", after, limit, func(string) (bool, error) {
+		return true, nil
+	})
+}
+
+// GetFeaturesPage returns a page of features in name order following the page token, paging through
+// the features index like SearchFeatures.
+func (rdb FlipadelphiaRedisDB) GetFeaturesPage(ctx context.Context, after string, limit int) (Page, error) {
+	return rdb.SearchFeatures(ctx, SearchQuery{}, after, limit)
+}
+
+// GetScopesWithFeaturePage returns a page of the scopes that have the feature set, following the page token.
+func (rdb FlipadelphiaRedisDB) GetScopesWithFeaturePage(ctx context.Context, feature []byte, after string, limit int) (Page, error) {
+	return rdb.scanPage(ctx, "*", after, limit, func(scope string) (bool, error) {
+		return rdb.hasLiveField(scope, string(feature))
+	})
+}
+
+// GetScopeFeaturesFullPage returns a page of the features set on the scope with their values,
+// following the page token.
+func (rdb FlipadelphiaRedisDB) GetScopeFeaturesFullPage(ctx context.Context, scope []byte, after string, limit int) (FeaturePage, error) {
+	var page FeaturePage
+	cursor, err := decodeScanToken(after)
+	if err != nil {
+		return page, err
+	}
+	for {
+		if err := checkContext(ctx); err != nil {
+			return FeaturePage{}, err
+		}
+		var fields []string
+		fields, cursor, err = rdb.client.HScan(string(scope), cursor, "", int64(limit)).Result()
+		if err != nil {
+			return FeaturePage{}, redisError(err)
+		}
+		live, err := rdb.liveScanFields(string(scope), fields)
+		if err != nil {
+			return FeaturePage{}, err
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			if value, ok := live[fields[i]]; ok {
+				page.Items = append(page.Items, NewFlipadelphiaFeature([]byte(fields[i]), []byte(value)))
+			}
+		}
+		if cursor == 0 || len(page.Items) >= limit {
+			break
+		}
+	}
+	if after == "" && len(page.Items) == 0 {
+		return FeaturePage{}, ErrScopeNotFound
+	}
+	page.Next = encodeScanToken(cursor)
+	return page, nil
+}
+
+// scopeHasValue reports whether the scope has the feature, or any feature when feature is empty, set
+// to the value. An empty value matches any value.
+func (rdb FlipadelphiaRedisDB) scopeHasValue(scope, feature, value string) (bool, error) {
+	if feature != "" {
+		live, err := rdb.liveFields(scope, feature)
+		v, ok := live[feature]
+		return ok && (value == "" || v == value), err
+	}
+	if value == "" {
+		return true, nil
+	}
+	res, err := rdb.liveFields(scope)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range res {
+		if v == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SearchScopes returns a page of the scopes matching the query, following the page token. Name
+// patterns are passed to SCAN as a MATCH filter.
+func (rdb FlipadelphiaRedisDB) SearchScopes(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	if err := checkPageLimit(limit); err != nil {
+		return Page{}, err
+	}
+	m, err := q.nameMatcher()
+	if err != nil {
+		return Page{}, err
+	}
+	return rdb.scanPage(ctx, q.redisMatchPattern(m), after, limit, func(scope string) (bool, error) {
+		if !m.match([]byte(scope)) {
+			return false, nil
+		}
+		return rdb.scopeHasValue(scope, q.Feature, q.Value)
+	})
+}
+
+// indexFeatures adds the features set before the features index was kept to it, unless that has been
+// done already.
+func (rdb FlipadelphiaRedisDB) indexFeatures(ctx context.Context) error {
+	indexed, err := rdb.client.Exists(redisFeaturesIndexedKey).Result()
+	if err != nil || indexed {
+		return redisError(err)
+	}
+	var cursor uint64
+	for {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		var scopes []string
+		scopes, cursor, err = rdb.client.Scan(cursor, "", 100).Result()
+		if err != nil {
+			return redisError(err)
+		}
+		for _, scope := range withoutReservedKeys(scopes) {
+			if err := rdb.client.Eval(redisIndexScopeScript, []string{scope}).Err(); err != nil {
+				return redisError(err)
+			}
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	return redisError(rdb.client.Set(redisFeaturesIndexedKey, 1, 0).Err())
+}
+
+// featureSetOn reports whether the feature is set, and has not expired, on a scope with the query's
+// scope prefix, to the query's value if it has one.
+func (rdb FlipadelphiaRedisDB) featureSetOn(ctx context.Context, feature string, q SearchQuery) (bool, error) {
+	min, max := redisLexRange(q.ScopePrefix)
+	key := featureScopesKey([]byte(feature))
+	for {
+		if err := checkContext(ctx); err != nil {
+			return false, err
+		}
+		scopes, err := rdb.client.ZRangeByLex(key, redis.ZRangeBy{Min: min, Max: max, Count: 100}).Result()
+		if err != nil {
+			return false, redisError(err)
+		}
+		for _, scope := range scopes {
+			live, err := rdb.liveFields(scope, feature)
+			if err != nil {
+				return false, err
+			}
+			if v, ok := live[feature]; ok && (q.Value == "" || v == q.Value) {
+				return true, nil
+			}
+		}
+		if len(scopes) < 100 {
+			return false, nil
+		}
+		min = "(" + scopes[len(scopes)-1]
+	}
+}
+
+// SearchFeatures returns a page of the features matching the query, following the page token. The
+// features index is paged through in name order with ZRANGEBYLEX, and each feature is kept if it is
+// set on a scope matching the query.
+func (rdb FlipadelphiaRedisDB) SearchFeatures(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	if err := checkPageLimit(limit); err != nil {
+		return Page{}, err
+	}
+	m, err := q.nameMatcher()
+	if err != nil {
+		return Page{}, err
+	}
+	min, max, err := redisSearchRange(m.prefix, after)
+	if err != nil {
+		return Page{}, err
+	}
+	if err := rdb.indexFeatures(ctx); err != nil {
+		return Page{}, err
+	}
+	var page Page
+	for {
+		if err := checkContext(ctx); err != nil {
+			return Page{}, err
+		}
+		features, err := rdb.client.ZRangeByLex(redisFeaturesKey, redis.ZRangeBy{Min: min, Max: max, Count: int64(limit)}).Result()
+		if err != nil {
+			return Page{}, redisError(err)
+		}
+		for _, feature := range features {
+			if len(page.Items) == limit {
+				page.Next = EncodePageToken([]byte(page.Items[limit-1]))
+				return page, nil
+			}
+			if !m.match([]byte(feature)) {
+				continue
+			}
+			ok, err := rdb.featureSetOn(ctx, feature, q)
+			if err != nil {
+				return Page{}, err
+			}
+			if ok {
+				page.Items = append(page.Items, feature)
+			}
+		}
+		if len(features) < limit {
+			return page, nil
+		}
+		min = "(" + features[len(features)-1]
+	}
+}
+
+// GetFeatureSummary returns the counters kept for the feature by Set. FirstModified is the first time
+// the feature was set on any scope. Pairs last set before the counters were introduced are not
+// counted.
+func (rdb FlipadelphiaRedisDB) GetFeatureSummary(ctx context.Context, feature []byte) (FeatureSummary, error) {
+	if err := checkContext(ctx); err != nil {
+		return FeatureSummary{}, err
+	}
+	fields, err := rdb.client.HGetAll(featureSummaryKey(feature)).Result()
+	if err != nil {
+		return FeatureSummary{}, redisError(err)
+	}
+	if len(fields) == 0 {
+		return FeatureSummary{}, ErrFeatureNotFound
+	}
+	return parseFeatureSummary(feature, fields), nil
+}
+
+// CopyScope copies the features of the source scope onto opts.Target with a single script, so the
+// copy is atomic.
+func (rdb FlipadelphiaRedisDB) CopyScope(ctx context.Context, source []byte, opts CopyScopeOptions) (FlipadelphiaFeatures, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	keys := []string{string(source), opts.Target}
+	reply, err := rdb.client.Eval(redisCopyScopeScript, keys, redisCopyScopeArgs(opts)...).Result()
+	if err == redis.Nil {
+		return nil, ErrScopeNotFound
+	}
+	if err != nil {
+		return nil, redisError(err)
+	}
+	values, _ := reply.([]interface{})
+	copied := make([]string, 0, len(values))
+	for _, v := range values {
+		copied = append(copied, fmt.Sprint(v))
+	}
+	return parseCopiedFeatures(copied), nil
+}
+
+// GetScopeParents returns the parents declared for the scope, nearest first.
+func (rdb FlipadelphiaRedisDB) GetScopeParents(ctx context.Context, scope []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	parents, err := rdb.client.LRange(scopeParentsKey(scope), 0, -1).Result()
+	return parents, redisError(err)
+}
+
+// SetScopeParents replaces the parents declared for the scope. An empty list removes them. Parents
+// that would make the scope its own ancestor are rejected with ErrScopeCycle.
+func (rdb FlipadelphiaRedisDB) SetScopeParents(ctx context.Context, scope []byte, parents []string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	err := checkScopeCycle(string(scope), parents, func(s string) ([]string, error) {
+		return rdb.GetScopeParents(ctx, []byte(s))
+	})
+	if err != nil {
+		return err
+	}
+	args := make([]interface{}, 0, len(parents))
+	for _, parent := range parents {
+		args = append(args, parent)
+	}
+	return redisError(rdb.client.Eval(redisReplaceListScript, []string{scopeParentsKey(scope)}, args...).Err())
+}
+
+// GetPrerequisites returns the prerequisites declared for the feature.
+func (rdb FlipadelphiaRedisDB) GetPrerequisites(ctx context.Context, feature []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	prerequisites, err := rdb.client.LRange(prerequisitesKey(feature), 0, -1).Result()
+	return prerequisites, redisError(err)
+}
+
+// SetPrerequisites replaces the prerequisites declared for the feature. An empty list removes them.
+// Prerequisites that would make the feature depend on itself are rejected with ErrPrerequisiteCycle.
+func (rdb FlipadelphiaRedisDB) SetPrerequisites(ctx context.Context, feature []byte, prerequisites []string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	err := checkPrerequisiteCycle(string(feature), prerequisites, func(f string) ([]string, error) {
+		return rdb.GetPrerequisites(ctx, []byte(f))
+	})
+	if err != nil {
+		return err
+	}
+	args := make([]interface{}, 0, len(prerequisites))
+	for _, prerequisite := range prerequisites {
+		args = append(args, prerequisite)
+	}
+	return redisError(rdb.client.Eval(redisReplaceListScript, []string{prerequisitesKey(feature)}, args...).Err())
+}
+
+// GetSegments returns every defined segment, sorted by name.
+func (rdb FlipadelphiaRedisDB) GetSegments(ctx context.Context) (Segments, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	definitions, err := rdb.client.HGetAll(redisSegmentsKey).Result()
+	if err != nil {
+		return nil, redisError(err)
+	}
+	names := make([]string, 0, len(definitions))
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	segments := Segments{}
+	for _, name := range names {
+		features, err := rdb.client.HGetAll(segmentFeaturesKey([]byte(name))).Result()
+		if err != nil {
+			return nil, redisError(err)
+		}
+		segment, err := parseSegment(name, definitions[name], features)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// GetSegment returns the named segment.
+func (rdb FlipadelphiaRedisDB) GetSegment(ctx context.Context, name []byte) (Segment, error) {
+	if err := checkContext(ctx); err != nil {
+		return Segment{}, err
+	}
+	definition, err := rdb.client.HGet(redisSegmentsKey, string(name)).Result()
+	if err == redis.Nil {
+		return Segment{}, ErrSegmentNotFound
+	}
+	if err != nil {
+		return Segment{}, redisError(err)
+	}
+	features, err := rdb.client.HGetAll(segmentFeaturesKey(name)).Result()
+	if err != nil {
+		return Segment{}, redisError(err)
+	}
+	return parseSegment(string(name), definition, features)
+}
+
+// SetSegment creates the segment, or replaces the members and prefix of an existing one. Features
+// already set on the segment are kept.
+func (rdb FlipadelphiaRedisDB) SetSegment(ctx context.Context, segment Segment) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	definition, err := marshalSegmentDefinition(segment)
+	if err != nil {
+		return err
+	}
+	return redisError(rdb.client.HSet(redisSegmentsKey, segment.Name, definition).Err())
+}
+
+// DeleteSegment removes the named segment and the features set on it.
+func (rdb FlipadelphiaRedisDB) DeleteSegment(ctx context.Context, name []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	keys := []string{redisSegmentsKey, segmentFeaturesKey(name)}
+	err := rdb.client.Eval(redisDeleteEntryScript, keys, string(name)).Err()
+	if err == redis.Nil {
+		return ErrSegmentNotFound
+	}
+	return redisError(err)
+}
+
+// SetSegmentFeature sets the value of the feature on the named segment.
+func (rdb FlipadelphiaRedisDB) SetSegmentFeature(ctx context.Context, name, feature, value []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	keys := []string{redisSegmentsKey, segmentFeaturesKey(name)}
+	err := rdb.client.Eval(redisSetSegmentFeatureScript, keys, string(name), string(feature), string(value)).Err()
+	if err == redis.Nil {
+		return FlipadelphiaFeature{}, ErrSegmentNotFound
+	}
+	return NewFlipadelphiaFeature(feature, value), redisError(err)
+}
+
+// GetSchedules returns the pending scheduled changes, soonest first.
+func (rdb FlipadelphiaRedisDB) GetSchedules(ctx context.Context) (ScheduledChanges, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	values, err := rdb.client.HGetAll(redisSchedulesKey).Result()
+	if err != nil {
+		return nil, redisError(err)
+	}
+	return parseScheduledChanges(values)
+}
+
+// AddSchedule stores a scheduled change under a new id, and returns it with the id set.
+func (rdb FlipadelphiaRedisDB) AddSchedule(ctx context.Context, change ScheduledChange) (ScheduledChange, error) {
+	if err := checkContext(ctx); err != nil {
+		return ScheduledChange{}, err
+	}
+	change.ID = uuid.NewV4().String()
+	err := rdb.client.HSet(redisSchedulesKey, change.ID, string(change.Serialize())).Err()
+	if err != nil {
+		return ScheduledChange{}, redisError(err)
+	}
+	return change, nil
+}
+
+// DeleteSchedule removes a pending scheduled change.
+func (rdb FlipadelphiaRedisDB) DeleteSchedule(ctx context.Context, id []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	deleted, err := rdb.client.HDel(redisSchedulesKey, string(id)).Result()
+	if err != nil {
+		return redisError(err)
+	}
+	if deleted == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// GetOverrides returns every feature override, sorted by feature name.
+func (rdb FlipadelphiaRedisDB) GetOverrides(ctx context.Context) (FlipadelphiaFeatures, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	values, err := rdb.client.HGetAll(redisOverridesKey).Result()
+	if err != nil {
+		return nil, redisError(err)
+	}
+	return parseOverrides(values), nil
+}
+
+// GetOverride returns the override of the feature.
+func (rdb FlipadelphiaRedisDB) GetOverride(ctx context.Context, feature []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	value, err := rdb.client.HGet(redisOverridesKey, string(feature)).Bytes()
+	if err == redis.Nil {
+		return FlipadelphiaFeature{}, ErrOverrideNotFound
+	}
+	if err != nil {
+		return FlipadelphiaFeature{}, redisError(err)
+	}
+	return NewFlipadelphiaFeature(feature, value), nil
+}
+
+// SetOverride forces the feature to the value on every scope, until the override is deleted.
+func (rdb FlipadelphiaRedisDB) SetOverride(ctx context.Context, feature, value []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	err := rdb.client.HSet(redisOverridesKey, string(feature), string(value)).Err()
+	return NewFlipadelphiaFeature(feature, value), redisError(err)
+}
+
+// DeleteOverride removes the feature's override, so its per-scope values apply again.
+func (rdb FlipadelphiaRedisDB) DeleteOverride(ctx context.Context, feature []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	deleted, err := rdb.client.HDel(redisOverridesKey, string(feature)).Result()
+	if err != nil {
+		return redisError(err)
+	}
+	if deleted == 0 {
+		return ErrOverrideNotFound
+	}
+	return nil
+}
+
+// GetUsage returns the checks recorded for the feature, which are none if it has not been checked.
+func (rdb FlipadelphiaRedisDB) GetUsage(ctx context.Context, feature []byte) (FeatureUsage, error) {
+	if err := checkContext(ctx); err != nil {
+		return FeatureUsage{}, err
+	}
+	checks, err := rdb.client.HGet(redisUsageChecksKey, string(feature)).Result()
+	if err != nil && err != redis.Nil {
+		return FeatureUsage{}, redisError(err)
+	}
+	checked, err := rdb.client.HGet(redisUsageCheckedKey, string(feature)).Result()
+	if err != nil && err != redis.Nil {
+		return FeatureUsage{}, redisError(err)
+	}
+	return parseUsage(string(feature), checks, checked), nil
+}
+
+// GetUsages returns the checks recorded for every feature that has been checked, sorted by feature
+// name.
+func (rdb FlipadelphiaRedisDB) GetUsages(ctx context.Context) (FeatureUsages, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	checks, err := rdb.client.HGetAll(redisUsageChecksKey).Result()
+	if err != nil {
+		return nil, redisError(err)
+	}
+	checked, err := rdb.client.HGetAll(redisUsageCheckedKey).Result()
+	if err != nil {
+		return nil, redisError(err)
+	}
+	return parseUsages(checks, checked), nil
+}
+
+// AddUsage adds the checks to those recorded for each feature with a single script, keeping the latest
+// LastChecked.
+func (rdb FlipadelphiaRedisDB) AddUsage(ctx context.Context, usages FeatureUsages) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if len(usages) == 0 {
+		return nil
+	}
+	keys := []string{redisUsageChecksKey, redisUsageCheckedKey}
+	err := rdb.client.Eval(redisAddUsageScript, keys, redisAddUsageArgs(usages)...).Err()
+	if err == redis.Nil {
+		err = nil
+	}
+	return redisError(err)
+}
+
+// GetExperiment returns the experiment defined on the feature.
+func (rdb FlipadelphiaRedisDB) GetExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	if err := checkContext(ctx); err != nil {
+		return Experiment{}, err
+	}
+	value, err := rdb.client.HGet(redisExperimentsKey, string(feature)).Result()
+	if err == redis.Nil {
+		return Experiment{}, ErrExperimentNotFound
+	}
+	if err != nil {
+		return Experiment{}, redisError(err)
+	}
+	return parseExperiment(value)
+}
+
+// SetExperiment defines the experiment on its feature, replacing its variants if it is already
+// defined. Scopes keep the variants they were assigned, as long as the variants still exist.
+func (rdb FlipadelphiaRedisDB) SetExperiment(ctx context.Context, experiment Experiment) (Experiment, error) {
+	existing, err := rdb.GetExperiment(ctx, []byte(experiment.Feature))
+	switch err {
+	case nil:
+		experiment.Salt = existing.Salt
+	case ErrExperimentNotFound:
+		experiment.Salt = uuid.NewV4().String()
+	default:
+		return Experiment{}, err
+	}
+	err = rdb.client.HSet(redisExperimentsKey, experiment.Feature, string(experiment.Serialize())).Err()
+	return experiment, redisError(err)
+}
+
+// DeleteExperiment removes the experiment defined on the feature, and its assignments.
+func (rdb FlipadelphiaRedisDB) DeleteExperiment(ctx context.Context, feature []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	keys := []string{redisExperimentsKey, assignmentsKey(feature)}
+	err := rdb.client.Eval(redisDeleteEntryScript, keys, string(feature)).Err()
+	if err == redis.Nil {
+		return ErrExperimentNotFound
+	}
+	return redisError(err)
+}
+
+// ReshuffleExperiment gives the experiment on the feature a new salt and drops its assignments, so
+// every scope is assigned afresh under the current weights.
+func (rdb FlipadelphiaRedisDB) ReshuffleExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	experiment, err := rdb.GetExperiment(ctx, feature)
+	if err != nil {
+		return Experiment{}, err
+	}
+	experiment.Salt = uuid.NewV4().String()
+	keys := []string{redisExperimentsKey, assignmentsKey(feature)}
+	err = rdb.client.Eval(redisReshuffleScript, keys, string(feature), string(experiment.Serialize())).Err()
+	if err == redis.Nil {
+		return Experiment{}, ErrExperimentNotFound
+	}
+	if err != nil {
+		return Experiment{}, redisError(err)
+	}
+	return experiment, nil
+}
+
+// AssignVariant returns the variant of the feature's experiment the scope is assigned. A scope is
+// assigned by hash the first time it is checked, and keeps that variant until the experiment is
+// reshuffled or the variant is removed.
+func (rdb FlipadelphiaRedisDB) AssignVariant(ctx context.Context, feature, scope []byte) (Assignment, error) {
+	experiment, err := rdb.GetExperiment(ctx, feature)
+	if err != nil {
+		return Assignment{}, err
+	}
+	assigned, err := rdb.client.HGet(assignmentsKey(feature), string(scope)).Result()
+	if err != nil && err != redis.Nil {
+		return Assignment{}, redisError(err)
+	}
+	assignment, isNew := experiment.assign(string(scope), assigned)
+	if !isNew {
+		return assignment, nil
+	}
+	if err := rdb.client.HSet(assignmentsKey(feature), string(scope), assignment.Variant).Err(); err != nil {
+		return Assignment{}, redisError(err)
+	}
+	return assignment, nil
 }
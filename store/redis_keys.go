@@ -0,0 +1,522 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisKeyPrefix namespaces the keys Flipadelphia keeps in Redis next to the scope hashes. Scope
+// listings skip keys with the prefix.
+const redisKeyPrefix = "flipadelphia:"
+
+// redisExpiringKey is the sorted set of the scope/feature pairs set with an expiry, scored by the
+// expiry in unix milliseconds. Redis 7.4 and later remove an expired field without running a script,
+// so the pair and its value, kept in redisExpiringValuesKey, are what lets its summary counters be
+// corrected. Older servers have no field expiry, and the set is what hides an expired field until
+// DeleteExpired removes it.
+const (
+	redisExpiringKey       = redisKeyPrefix + "expiring"
+	redisExpiringValuesKey = redisKeyPrefix + "expiring-values"
+)
+
+// redisFeaturesKey is the features index, a sorted set of the feature names set on any scope, all
+// scored 0 so they sort by name. The scopes each feature is set on are kept the same way in its
+// featureScopesKey. Features set before the index was kept are added by the first search, which sets
+// redisFeaturesIndexedKey once they are.
+const (
+	redisFeaturesKey        = redisKeyPrefix + "features"
+	redisFeaturesIndexedKey = redisKeyPrefix + "features-indexed"
+)
+
+func featureScopesKey(feature []byte) string {
+	return redisKeyPrefix + "feature-scopes:" + string(feature)
+}
+
+// redisLexRange returns the ZRANGEBYLEX bounds of the members of an index starting with the prefix.
+// Names that continue the prefix with a 0xff byte and more sort after the upper bound and are left out.
+func redisLexRange(prefix string) (string, string) {
+	if prefix == "" {
+		return "-", "+"
+	}
+	return "[" + prefix, "[" + prefix + "\xff"
+}
+
+// redisSearchRange returns the ZRANGEBYLEX bounds of the names starting with the prefix that sort
+// after the page token.
+func redisSearchRange(prefix []byte, after string) (string, string, error) {
+	min, max := redisLexRange(string(prefix))
+	cursor, err := DecodePageToken(after)
+	if err != nil {
+		return "", "", err
+	}
+	if cursor != nil && string(cursor) >= string(prefix) {
+		min = "(" + string(cursor)
+	}
+	return min, max, nil
+}
+
+// redisIndexFunc defines index_feature and unindex_feature, which add a scope/feature pair to the
+// features index and take it out again, dropping the feature once no scope has it set.
+const redisIndexFunc = `
+local function feature_scopes_key(feature)
+	return '` + redisKeyPrefix + `feature-scopes:' .. feature
+end
+
+local function index_feature(scope, feature)
+	redis.call('ZADD', '` + redisFeaturesKey + `', 0, feature)
+	redis.call('ZADD', feature_scopes_key(feature), 0, scope)
+end
+
+local function unindex_feature(scope, feature)
+	local scopes = feature_scopes_key(feature)
+	redis.call('ZREM', scopes, scope)
+	if redis.call('ZCARD', scopes) == 0 then
+		redis.call('ZREM', '` + redisFeaturesKey + `', feature)
+	end
+end
+`
+
+// redisIndexScopeScript adds the features set on a scope hash to the features index.
+//
+// KEYS[1] - scope hash
+const redisIndexScopeScript = redisIndexFunc + `
+local features = redis.call('HKEYS', KEYS[1])
+for _, feature in ipairs(features) do
+	index_feature(KEYS[1], feature)
+end
+return #features`
+
+// redisNowFunc defines now_ms, which returns the server's clock in unix milliseconds.
+const redisNowFunc = `
+local function now_ms()
+	local time = redis.call('TIME')
+	return tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+end
+`
+
+// redisSetFeatureFunc defines set_feature, which sets a feature on a scope hash and updates the
+// feature's summary counters and the features index. modified is the time in unix nanoseconds, and
+// expires_at the expiry in unix milliseconds, or nil if the value does not expire. The expiry is
+// recorded in redisExpiringKey, and set on the hash field too when the server supports field expiry.
+//
+// It also defines settle_expiry, which forgets the expiry recorded for a scope/feature pair, taking
+// the value out of the summary counters and the features index, and out of the hash on servers
+// without field expiry, once it has expired. It returns 1 if it did. Unless settle_live is set, pairs
+// that have not expired yet are left alone, and when it is their field's expiry is removed too.
+//
+// The scripts reach the summary, expiring and index keys of the features they touch without declaring
+// them in KEYS, so they do not run on Redis Cluster. Scripts that read the clock and then write ask
+// for their effects to be replicated, which servers before Redis 5 need.
+const redisSetFeatureFunc = redisNowFunc + redisIndexFunc + `
+if redis.replicate_commands then
+	redis.replicate_commands()
+end
+
+local function summary_key(feature)
+	return '` + redisKeyPrefix + `summary:' .. feature
+end
+
+local function decrement_value(summary, value)
+	if redis.call('HINCRBY', summary, 'value:' .. value, -1) <= 0 then
+		redis.call('HDEL', summary, 'value:' .. value)
+	end
+end
+
+local function supports_field_expiry()
+	return redis.REDIS_VERSION_NUM ~= nil and redis.REDIS_VERSION_NUM >= 0x070400
+end
+
+local function settle_expiry(scope, feature, settle_live)
+	local member = cjson.encode({scope, feature})
+	local value = redis.call('HGET', '` + redisExpiringValuesKey + `', member)
+	if not value then
+		return 0
+	end
+	local expires_at = tonumber(redis.call('ZSCORE', '` + redisExpiringKey + `', member))
+	local expired = redis.call('HEXISTS', scope, feature) == 0 or (expires_at ~= nil and expires_at <= now_ms())
+	if not expired and not settle_live then
+		return 0
+	end
+	redis.call('ZREM', '` + redisExpiringKey + `', member)
+	redis.call('HDEL', '` + redisExpiringValuesKey + `', member)
+	if not expired then
+		if supports_field_expiry() then
+			redis.call('HPERSIST', scope, 'FIELDS', 1, feature)
+		end
+		return 0
+	end
+	redis.call('HDEL', scope, feature)
+	unindex_feature(scope, feature)
+	local summary = summary_key(feature)
+	if redis.call('HINCRBY', summary, 'scopes', -1) <= 0 then
+		redis.call('HDEL', summary, 'scopes')
+	end
+	decrement_value(summary, value)
+	return 1
+end
+
+local function set_feature(scope, feature, value, modified, expires_at)
+	local summary = summary_key(feature)
+	settle_expiry(scope, feature, true)
+	local old = redis.call('HGET', scope, feature)
+	redis.call('HSET', scope, feature, value)
+	index_feature(scope, feature)
+	if old then
+		decrement_value(summary, old)
+	else
+		redis.call('HINCRBY', summary, 'scopes', 1)
+	end
+	redis.call('HINCRBY', summary, 'value:' .. value, 1)
+	redis.call('HSETNX', summary, 'first_modified', modified)
+	redis.call('HSET', summary, 'last_modified', modified)
+	if expires_at then
+		local member = cjson.encode({scope, feature})
+		if supports_field_expiry() then
+			redis.call('HPEXPIREAT', scope, expires_at, 'FIELDS', 1, feature)
+		end
+		redis.call('ZADD', '` + redisExpiringKey + `', expires_at, member)
+		redis.call('HSET', '` + redisExpiringValuesKey + `', member, value)
+	end
+end
+`
+
+// redisSetFeatureScript sets a single feature.
+//
+// KEYS[1] - scope hash
+// ARGV[1] - feature, ARGV[2] - value, ARGV[3] - modification time in unix nanoseconds
+const redisSetFeatureScript = redisSetFeatureFunc + `
+set_feature(KEYS[1], ARGV[1], ARGV[2], ARGV[3])
+return 1`
+
+// redisSetFeatureWithExpiryScript sets a single feature that expires. On Redis 7.4 and later the hash
+// field expires by itself, and on older servers the value is hidden from reads once it has expired
+// and removed by redisSweepExpiredScript. Setting the field again without an expiry clears it.
+//
+// KEYS[1] - scope hash
+// ARGV[1] - feature, ARGV[2] - value, ARGV[3] - modification time in unix nanoseconds
+// ARGV[4] - expiry time in unix milliseconds
+const redisSetFeatureWithExpiryScript = redisSetFeatureFunc + `
+set_feature(KEYS[1], ARGV[1], ARGV[2], ARGV[3], ARGV[4])
+return 1`
+
+// redisSweepExpiredScript settles every scope/feature pair whose expiry has passed by the server's
+// clock, returning how many expired values it took out of the summary counters.
+const redisSweepExpiredScript = redisSetFeatureFunc + `
+local settled = 0
+for _, member in ipairs(redis.call('ZRANGEBYSCORE', '` + redisExpiringKey + `', '-inf', now_ms())) do
+	local pair = cjson.decode(member)
+	settled = settled + settle_expiry(pair[1], pair[2], false)
+end
+return settled`
+
+// redisCopyScopeScript copies the features of one scope hash onto another, returning the copied
+// features and values as a flat list. It returns nil when the source scope does not exist. Values that
+// expire on the source expire at the same time on the target, and values that have expired are not
+// copied.
+//
+// KEYS[1] - source scope hash, KEYS[2] - target scope hash
+// ARGV[1] - "1" to overwrite features already set on the target, ARGV[2] - modification time in
+// unix nanoseconds, ARGV[3:] - the features to copy, or none to copy every feature
+const redisCopyScopeScript = redisSetFeatureFunc + `
+local source = redis.call('HGETALL', KEYS[1])
+if #source == 0 then
+	return nil
+end
+local only = {}
+for i = 3, #ARGV do
+	only[ARGV[i]] = true
+end
+local now = now_ms()
+local copied = {}
+for i = 1, #source, 2 do
+	local feature, value = source[i], source[i + 1]
+	local expires_at = tonumber(redis.call('ZSCORE', '` + redisExpiringKey + `', cjson.encode({KEYS[1], feature})))
+	local live = expires_at == nil or expires_at > now
+	if live and (#ARGV < 3 or only[feature]) and (ARGV[1] == '1' or redis.call('HEXISTS', KEYS[2], feature) == 0) then
+		set_feature(KEYS[2], feature, value, ARGV[2], expires_at)
+		table.insert(copied, feature)
+		table.insert(copied, value)
+	end
+end
+return copied`
+
+// redisLiveFieldsScript returns the fields of a scope hash that are set and have not expired, with
+// their values, as a flat list. Servers without field expiry keep an expired value in the hash until
+// it is swept, so it is left out here.
+//
+// KEYS[1] - scope hash
+// ARGV - the fields to look up, or none for every field
+const redisLiveFieldsScript = redisNowFunc + `
+local fields = {}
+if #ARGV == 0 then
+	fields = redis.call('HGETALL', KEYS[1])
+else
+	for _, field in ipairs(ARGV) do
+		local value = redis.call('HGET', KEYS[1], field)
+		if value then
+			table.insert(fields, field)
+			table.insert(fields, value)
+		end
+	end
+end
+if redis.call('ZCARD', '` + redisExpiringKey + `') == 0 then
+	return fields
+end
+local now = now_ms()
+local live = {}
+for i = 1, #fields, 2 do
+	local expires_at = tonumber(redis.call('ZSCORE', '` + redisExpiringKey + `', cjson.encode({KEYS[1], fields[i]})))
+	if expires_at == nil or expires_at > now then
+		table.insert(live, fields[i])
+		table.insert(live, fields[i + 1])
+	end
+end
+return live`
+
+func isReservedKey(key string) bool {
+	return strings.HasPrefix(key, redisKeyPrefix)
+}
+
+// withoutReservedKeys filters the keys that are not scopes out of a SCAN or KEYS reply.
+func withoutReservedKeys(keys []string) []string {
+	scopes := keys[:0]
+	for _, key := range keys {
+		if !isReservedKey(key) {
+			scopes = append(scopes, key)
+		}
+	}
+	return scopes
+}
+
+func scopeParentsKey(scope []byte) string {
+	return redisKeyPrefix + "parents:" + string(scope)
+}
+
+// redisReplaceListScript replaces a list, such as the parents declared for a scope.
+//
+// KEYS[1] - the list
+// ARGV - the new items, in order
+const redisReplaceListScript = `
+redis.call('DEL', KEYS[1])
+for i = 1, #ARGV do
+	redis.call('RPUSH', KEYS[1], ARGV[i])
+end
+return #ARGV`
+
+func prerequisitesKey(feature []byte) string {
+	return redisKeyPrefix + "prerequisites:" + string(feature)
+}
+
+// redisSegmentsKey is the hash of segment definitions, keyed by segment name.
+const redisSegmentsKey = redisKeyPrefix + "segments"
+
+func segmentFeaturesKey(name []byte) string {
+	return redisKeyPrefix + "segment-features:" + string(name)
+}
+
+// redisSetSegmentFeatureScript sets a feature on a segment, returning nil if the segment is not
+// defined.
+//
+// KEYS[1] - segment definitions hash, KEYS[2] - segment features hash
+// ARGV[1] - segment, ARGV[2] - feature, ARGV[3] - value
+const redisSetSegmentFeatureScript = `
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return nil
+end
+redis.call('HSET', KEYS[2], ARGV[2], ARGV[3])
+return 1`
+
+// redisDeleteEntryScript removes a field from a hash along with a key that belongs to it, such as a
+// segment and its features. It returns nil if the field does not exist.
+//
+// KEYS[1] - hash, e.g. segment definitions, KEYS[2] - key belonging to the field, e.g. segment features
+// ARGV[1] - field
+const redisDeleteEntryScript = `
+if redis.call('HDEL', KEYS[1], ARGV[1]) == 0 then
+	return nil
+end
+redis.call('DEL', KEYS[2])
+return 1`
+
+// redisExperimentsKey is the hash of experiment definitions, keyed by feature.
+const redisExperimentsKey = redisKeyPrefix + "experiments"
+
+func assignmentsKey(feature []byte) string {
+	return redisKeyPrefix + "assignments:" + string(feature)
+}
+
+// redisReshuffleScript replaces an experiment's definition and drops its assignments, returning nil if
+// the experiment is not defined.
+//
+// KEYS[1] - experiment definitions hash, KEYS[2] - experiment assignments hash
+// ARGV[1] - feature, ARGV[2] - experiment json
+const redisReshuffleScript = `
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return nil
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('DEL', KEYS[2])
+return 1`
+
+// parseExperiment decodes a value of the experiments hash.
+func parseExperiment(value string) (Experiment, error) {
+	var experiment Experiment
+	err := json.Unmarshal([]byte(value), &experiment)
+	return experiment, err
+}
+
+// marshalSegmentDefinition returns the json stored in the segment definitions hash.
+func marshalSegmentDefinition(segment Segment) (string, error) {
+	b, err := json.Marshal(segmentDefinition{Members: segment.Members, Prefix: segment.Prefix})
+	return string(b), err
+}
+
+// parseSegment builds a Segment from its stored definition and features.
+func parseSegment(name, definition string, features map[string]string) (Segment, error) {
+	var def segmentDefinition
+	if err := json.Unmarshal([]byte(definition), &def); err != nil {
+		return Segment{}, err
+	}
+	segment := NewSegment(name, def.Members, def.Prefix)
+	for feature, value := range features {
+		segment.Features[feature] = value
+	}
+	return segment, nil
+}
+
+// redisOverridesKey is the hash of feature overrides, keyed by feature.
+const redisOverridesKey = redisKeyPrefix + "overrides"
+
+// parseOverrides decodes the overrides hash, sorted by feature name.
+func parseOverrides(values map[string]string) FlipadelphiaFeatures {
+	overrides := FlipadelphiaFeatures{}
+	for feature, value := range values {
+		overrides = append(overrides, NewFlipadelphiaFeature([]byte(feature), []byte(value)))
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Name < overrides[j].Name })
+	return overrides
+}
+
+// redisSchedulesKey is the hash of pending scheduled changes, keyed by id.
+const redisSchedulesKey = redisKeyPrefix + "schedules"
+
+// parseScheduledChanges decodes the values of the schedules hash, soonest first.
+func parseScheduledChanges(values map[string]string) (ScheduledChanges, error) {
+	changes := ScheduledChanges{}
+	for _, v := range values {
+		var change ScheduledChange
+		if err := json.Unmarshal([]byte(v), &change); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	sortScheduledChanges(changes)
+	return changes, nil
+}
+
+// redisUsageChecksKey is the hash of feature check counts, and redisUsageCheckedKey the hash of the
+// times features were last checked in unix nanoseconds, both keyed by feature.
+const (
+	redisUsageChecksKey  = redisKeyPrefix + "usage-checks"
+	redisUsageCheckedKey = redisKeyPrefix + "usage-checked"
+)
+
+// redisAddUsageScript adds the check counts to KEYS[1] and keeps the latest check times in KEYS[2].
+// ARGV is flat feature/checks/time triples.
+const redisAddUsageScript = `
+for i = 1, #ARGV, 3 do
+	redis.call('HINCRBY', KEYS[1], ARGV[i], ARGV[i + 1])
+	local last = tonumber(redis.call('HGET', KEYS[2], ARGV[i]) or '0')
+	if tonumber(ARGV[i + 2]) > last then
+		redis.call('HSET', KEYS[2], ARGV[i], ARGV[i + 2])
+	end
+end
+`
+
+// redisAddUsageArgs returns the ARGV for redisAddUsageScript.
+func redisAddUsageArgs(usages FeatureUsages) []interface{} {
+	args := make([]interface{}, 0, 3*len(usages))
+	for _, usage := range usages {
+		var checked int64
+		if usage.LastChecked != nil {
+			checked = usage.LastChecked.UnixNano()
+		}
+		args = append(args, usage.Name, usage.Checks, checked)
+	}
+	return args
+}
+
+// parseUsage builds a FeatureUsage from the feature's fields of the usage hashes.
+func parseUsage(feature, checks, checked string) FeatureUsage {
+	usage := FeatureUsage{Name: feature}
+	usage.Checks, _ = strconv.ParseInt(checks, 10, 64)
+	if nanos, err := strconv.ParseInt(checked, 10, 64); err == nil && nanos > 0 {
+		t := time.Unix(0, nanos).UTC()
+		usage.LastChecked = &t
+	}
+	return usage
+}
+
+// parseUsages builds the usage of every feature in the usage hashes, sorted by feature name.
+func parseUsages(checks, checked map[string]string) FeatureUsages {
+	usages := FeatureUsages{}
+	for feature, count := range checks {
+		usages = append(usages, parseUsage(feature, count, checked[feature]))
+	}
+	sortUsages(usages)
+	return usages
+}
+
+func featureSummaryKey(feature []byte) string {
+	return redisKeyPrefix + "summary:" + string(feature)
+}
+
+// redisCopyScopeArgs returns the ARGV for redisCopyScopeScript.
+func redisCopyScopeArgs(opts CopyScopeOptions) []interface{} {
+	overwrite := "0"
+	if opts.Overwrite {
+		overwrite = "1"
+	}
+	args := []interface{}{overwrite, time.Now().UnixNano()}
+	for _, feature := range opts.Features {
+		args = append(args, feature)
+	}
+	return args
+}
+
+// parseCopiedFeatures converts the flat feature/value reply of redisCopyScopeScript.
+func parseCopiedFeatures(reply []string) FlipadelphiaFeatures {
+	copied := FlipadelphiaFeatures{}
+	for i := 0; i+1 < len(reply); i += 2 {
+		copied = append(copied, NewFlipadelphiaFeature([]byte(reply[i]), []byte(reply[i+1])))
+	}
+	return copied
+}
+
+// parseFeatureSummary builds a FeatureSummary from the fields of a feature summary hash.
+func parseFeatureSummary(feature []byte, fields map[string]string) FeatureSummary {
+	summary := NewFeatureSummary(feature)
+	parseTime := func(field string) *time.Time {
+		nanos, err := strconv.ParseInt(fields[field], 10, 64)
+		if err != nil {
+			return nil
+		}
+		t := time.Unix(0, nanos).UTC()
+		return &t
+	}
+	for field, raw := range fields {
+		if !strings.HasPrefix(field, "value:") {
+			continue
+		}
+		if count, err := strconv.Atoi(raw); err == nil && count > 0 {
+			summary.Values[strings.TrimPrefix(field, "value:")] = count
+		}
+	}
+	summary.Scopes, _ = strconv.Atoi(fields["scopes"])
+	summary.FirstModified = parseTime("first_modified")
+	summary.LastModified = parseTime("last_modified")
+	return summary
+}
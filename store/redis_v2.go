@@ -1,10 +1,13 @@
 package store
 
 import (
-	"fmt"
+	"context"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/satori/go.uuid"
 )
 
 type RedisConnection interface {
@@ -20,6 +23,7 @@ type RedisConnectionPool interface {
 	Get() redis.Conn
 	Close() error
 	ActiveCount() int
+	IdleCount() int
 }
 
 type FlipadelphiaRedisDBV2 struct {
@@ -27,53 +31,141 @@ type FlipadelphiaRedisDBV2 struct {
 }
 
 func NewFlipadelphiaRedisDBV2(server, password string, db int) FlipadelphiaRedisDBV2 {
-	return FlipadelphiaRedisDBV2{
-		pool: &redis.Pool{
-			MaxIdle:     3,
-			IdleTimeout: 240 * time.Second,
-			Dial: func() (redis.Conn, error) {
-				c, err := redis.Dial("tcp", server, redis.DialDatabase(db))
-				if err != nil {
+	return FlipadelphiaRedisDBV2{pool: NewRedisPool(server, password, db)}
+}
+
+// Ping sends a PING to the Redis server.
+func (rdb FlipadelphiaRedisDBV2) Ping(ctx context.Context) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return redisError(err)
+}
+
+// PoolStats counts the connections in the pool. Active connections include the idle ones.
+func (rdb FlipadelphiaRedisDBV2) PoolStats() ConnectionPoolStats {
+	return ConnectionPoolStats{Active: rdb.pool.ActiveCount(), Idle: rdb.pool.IdleCount()}
+}
+
+// NewRedisPool returns a pool of connections to the Redis server, authenticating with the password if
+// it is set.
+func NewRedisPool(server, password string, db int) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", server, redis.DialDatabase(db))
+			if err != nil {
+				return nil, err
+			}
+			if password != "" {
+				if _, err := c.Do("AUTH", password); err != nil {
+					c.Close()
 					return nil, err
 				}
-				if password != "" {
-					if _, err := c.Do("AUTH", password); err != nil {
-						c.Close()
-						return nil, err
-					}
-				}
-				return c, err
-			},
-			TestOnBorrow: func(c redis.Conn, t time.Time) error {
-				if time.Since(t) < time.Minute {
-					return nil
-				}
-				_, err := c.Do("PING")
-				return err
-			},
+			}
+			return c, err
 		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+}
+
+// liveFields returns the named fields of the scope hash that are set and have not expired, with their
+// values, or every such field when none are named.
+func (rdb FlipadelphiaRedisDBV2) liveFields(conn RedisConnection, scope string, fields ...string) (map[string]string, error) {
+	args := []interface{}{redisLiveFieldsScript, 1, scope}
+	for _, field := range fields {
+		args = append(args, field)
 	}
+	live, err := redis.StringMap(conn.Do("EVAL", args...))
+	return live, redisError(err)
 }
 
-func (rdb FlipadelphiaRedisDBV2) get(conn RedisConnection, scope, key []byte) (Serializable, error) {
-	value, err := redis.String(conn.Do("HGET", string(scope), string(key)))
+// hasLiveField reports whether the feature is set on the scope and has not expired.
+func (rdb FlipadelphiaRedisDBV2) hasLiveField(conn RedisConnection, scope, feature string) (bool, error) {
+	live, err := rdb.liveFields(conn, scope, feature)
+	_, ok := live[feature]
+	return ok, err
+}
+
+func (rdb FlipadelphiaRedisDBV2) get(conn RedisConnection, scope, key []byte) (FlipadelphiaFeature, error) {
+	override, err := redis.String(conn.Do("HGET", redisOverridesKey, string(key)))
+	if err == nil {
+		return NewFlipadelphiaFeature(key, []byte(override)), nil
+	}
+	if err != redis.ErrNil {
+		return FlipadelphiaFeature{}, redisError(err)
+	}
+	live, err := rdb.liveFields(conn, string(scope), string(key))
 	if err != nil {
-		return nil, err
+		return FlipadelphiaFeature{}, err
+	}
+	value, ok := live[string(key)]
+	if !ok {
+		if exists, err := rdb.checkScopeExists(conn, scope); err != nil {
+			return FlipadelphiaFeature{}, err
+		} else if !exists {
+			return FlipadelphiaFeature{}, ErrScopeNotFound
+		}
+		return FlipadelphiaFeature{}, ErrFeatureNotFound
 	}
 	return NewFlipadelphiaFeature(key, []byte(value)), nil
 }
 
-func (rdb FlipadelphiaRedisDBV2) Get(scope, key []byte) (Serializable, error) {
-	return rdb.get(rdb.pool.Get(), scope, key)
+func (rdb FlipadelphiaRedisDBV2) Get(ctx context.Context, scope, key []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.get(conn, scope, key)
+}
+
+func (rdb FlipadelphiaRedisDBV2) set(conn RedisConnection, scope, key, value []byte) (FlipadelphiaFeature, error) {
+	_, err := conn.Do("EVAL", redisSetFeatureScript, 1, string(scope), string(key), string(value), time.Now().UnixNano())
+	return NewFlipadelphiaFeature(key, value), redisError(err)
+}
+
+func (rdb FlipadelphiaRedisDBV2) Set(ctx context.Context, scope, key, value []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.set(conn, scope, key, value)
 }
 
-func (rdb FlipadelphiaRedisDBV2) set(conn RedisConnection, scope, key, value []byte) (Serializable, error) {
-	_, err := conn.Do("HSET", string(scope), string(key), string(value))
-	return NewFlipadelphiaFeature(key, value), err
+func (rdb FlipadelphiaRedisDBV2) SetWithExpiry(ctx context.Context, scope, key, value []byte, expiresAt time.Time) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("EVAL", redisSetFeatureWithExpiryScript, 1, string(scope), string(key), string(value),
+		time.Now().UnixNano(), expiresAt.UnixNano()/int64(time.Millisecond))
+	return NewFlipadelphiaFeature(key, value), redisError(err)
 }
 
-func (rdb FlipadelphiaRedisDBV2) Set(scope, key, value []byte) (Serializable, error) {
-	return rdb.set(rdb.pool.Get(), scope, key, value)
+// DeleteExpired takes the values that have expired out of their feature's summary counters, and
+// returns how many there were. Redis 7.4 and later remove the fields themselves, and on older
+// servers they are removed here.
+func (rdb FlipadelphiaRedisDBV2) DeleteExpired(ctx context.Context) (int, error) {
+	if err := checkContext(ctx); err != nil {
+		return 0, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	settled, err := redis.Int(conn.Do("EVAL", redisSweepExpiredScript, 0))
+	return settled, redisError(err)
 }
 
 func (rdb FlipadelphiaRedisDBV2) checkValueExistsInSet(setKey, value []byte) (bool, error) {
@@ -90,29 +182,45 @@ func (rdb FlipadelphiaRedisDBV2) checkValueExistsInSet(setKey, value []byte) (bo
 		return false
 	end;
 	return existsInTable(features, ARGV[2])`
-	b, err := redis.Bool(rdb.pool.Get().Do("EVAL", luaScript, 0, string(setKey), string(value)))
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	b, err := redis.Bool(conn.Do("EVAL", luaScript, 0, string(setKey), string(value)))
 	return b, err
 }
 
-func (rdb FlipadelphiaRedisDBV2) getScopeFeatures(conn RedisConnection, scope []byte) (Serializable, error) {
-	var keys StringSlice
-	keys, err := redis.Strings(conn.Do("HKEYS", string(scope)))
+func (rdb FlipadelphiaRedisDBV2) getScopeFeatures(conn RedisConnection, scope []byte) ([]string, error) {
+	live, err := rdb.liveFields(conn, string(scope))
 	if err != nil {
 		return nil, err
 	}
+	if len(live) == 0 {
+		return nil, ErrScopeNotFound
+	}
+	keys := make([]string, 0, len(live))
+	for k := range live {
+		keys = append(keys, k)
+	}
 	return keys, nil
 }
 
-func (rdb FlipadelphiaRedisDBV2) GetScopeFeatures(scope []byte) (Serializable, error) {
-	return rdb.getScopeFeatures(rdb.pool.Get(), scope)
+func (rdb FlipadelphiaRedisDBV2) GetScopeFeatures(ctx context.Context, scope []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.getScopeFeatures(conn, scope)
 }
 
-func (rdb FlipadelphiaRedisDBV2) getScopeFeaturesFilterByValue(conn RedisConnection, scope, targetValue []byte) (Serializable, error) {
+func (rdb FlipadelphiaRedisDBV2) getScopeFeaturesFilterByValue(conn RedisConnection, scope, targetValue []byte) ([]string, error) {
 	var features StringSlice
-	res, err := redis.StringMap(conn.Do("HGETALL", string(scope)))
+	res, err := rdb.liveFields(conn, string(scope))
 	if err != nil {
 		return nil, err
 	}
+	if len(res) == 0 {
+		return nil, ErrScopeNotFound
+	}
 	for k, v := range res {
 		if v == string(targetValue) {
 			features = append(features, k)
@@ -121,130 +229,931 @@ func (rdb FlipadelphiaRedisDBV2) getScopeFeaturesFilterByValue(conn RedisConnect
 	return features, nil
 }
 
-func (rdb FlipadelphiaRedisDBV2) GetScopeFeaturesFilterByValue(scope, targetValue []byte) (Serializable, error) {
-	return rdb.getScopeFeaturesFilterByValue(rdb.pool.Get(), scope, targetValue)
+func (rdb FlipadelphiaRedisDBV2) GetScopeFeaturesFilterByValue(ctx context.Context, scope, targetValue []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.getScopeFeaturesFilterByValue(conn, scope, targetValue)
 }
 
-func (rdb FlipadelphiaRedisDBV2) getScopes(conn RedisConnection) (Serializable, error) {
-	var scopes StringSlice
+func (rdb FlipadelphiaRedisDBV2) getScopes(conn RedisConnection) ([]string, error) {
 	scopes, err := redis.Strings(conn.Do("KEYS", "*"))
 	if err != nil {
-		return nil, err
+		return nil, redisError(err)
 	}
-	return scopes, nil
+	return withoutReservedKeys(scopes), nil
 }
 
-func (rdb FlipadelphiaRedisDBV2) GetScopes() (Serializable, error) {
-	return rdb.getScopes(rdb.pool.Get())
+func (rdb FlipadelphiaRedisDBV2) GetScopes(ctx context.Context) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.getScopes(conn)
 }
 
-func (rdb FlipadelphiaRedisDBV2) getScopesWithPrefix(conn RedisConnection, prefix []byte) (Serializable, error) {
-	var scopes StringSlice
-	match := "MATCH " + string(prefix) + "*"
-	scopes, err := redis.Strings(conn.Do("KEYS", match))
+func (rdb FlipadelphiaRedisDBV2) getScopesWithPrefix(conn RedisConnection, prefix []byte) ([]string, error) {
+	scopes, err := redis.Strings(conn.Do("KEYS", redisPrefixPattern(string(prefix))))
 	if err != nil {
-		return nil, err
+		return nil, redisError(err)
 	}
-	return scopes, nil
+	return withoutReservedKeys(scopes), nil
 }
 
-func (rdb FlipadelphiaRedisDBV2) GetScopesWithPrefix(prefix []byte) (Serializable, error) {
-	return rdb.getScopesWithPrefix(rdb.pool.Get(), prefix)
+func (rdb FlipadelphiaRedisDBV2) GetScopesWithPrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.getScopesWithPrefix(conn, prefix)
 }
 
-func (rdb FlipadelphiaRedisDBV2) getScopesWithFeature(conn RedisConnection, key []byte) (Serializable, error) {
+func (rdb FlipadelphiaRedisDBV2) getScopesWithFeature(ctx context.Context, conn RedisConnection, key []byte) ([]string, error) {
+	var scopesWithFeature StringSlice
 	scopes, err := rdb.getScopes(conn)
 	if err != nil {
 		return nil, err
 	}
-	for _, scope := range scopes.(StringSlice) {
-		if res, err := redis.Bool(conn.Do("HEXISTS", string(key))); err != nil && res == true {
-			scopes = append(scopes.(StringSlice), scope)
+	for _, scope := range scopes {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
 		}
+		res, err := rdb.hasLiveField(conn, scope, string(key))
+		if err != nil {
+			return nil, err
+		}
+		if res {
+			scopesWithFeature = append(scopesWithFeature, scope)
+		}
+	}
+	if len(scopesWithFeature) == 0 {
+		return nil, ErrFeatureNotFound
 	}
-	return scopes, nil
+	return scopesWithFeature, nil
 }
 
-func (rdb FlipadelphiaRedisDBV2) GetScopesWithFeature(key []byte) (Serializable, error) {
-	return rdb.getScopeFeatures(rdb.pool.Get(), key)
+func (rdb FlipadelphiaRedisDBV2) GetScopesWithFeature(ctx context.Context, key []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.getScopesWithFeature(ctx, conn, key)
 }
 
-func (rdb FlipadelphiaRedisDBV2) GetScopesPaginated(offset, count int) (Serializable, error) {
-	var scopes StringSlice
-	return scopes, fmt.Errorf("Unimplemented method")
+func (rdb FlipadelphiaRedisDBV2) GetScopesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	return nil, ErrUnimplemented
 }
 
-func (rdb FlipadelphiaRedisDBV2) GetFeaturesPaginated(offset, count int) (Serializable, error) {
-	return nil, fmt.Errorf("Unimplemented method")
+func (rdb FlipadelphiaRedisDBV2) GetFeaturesPaginated(ctx context.Context, offset, count int) ([]string, error) {
+	return nil, ErrUnimplemented
 }
 
-func (rdb FlipadelphiaRedisDBV2) getFeatures(conn RedisConnection) (Serializable, error) {
+func (rdb FlipadelphiaRedisDBV2) getFeatures(ctx context.Context, conn RedisConnection) ([]string, error) {
 	scopes, err := rdb.getScopes(conn)
-	fch := make(chan StringSlice)
-	ech := make(chan error)
-	for _, scope := range scopes.(StringSlice) {
-		go func() {
-			features, err := rdb.GetScopeFeatures([]byte(scope))
-			if err != nil {
-				ech <- err
-				return
-			}
-			fch <- features.(StringSlice)
-		}()
+	if err != nil {
+		return nil, err
 	}
 	var featuresMap = make(map[string]interface{})
 	var uniqueFeatures StringSlice
-	for F := range fch {
-		for _, f := range F {
+	for _, scope := range scopes {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+		features, err := rdb.liveFields(conn, scope)
+		if err != nil {
+			return nil, err
+		}
+		for f := range features {
 			if _, ok := featuresMap[f]; !ok {
 				featuresMap[f] = nil
 				uniqueFeatures = append(uniqueFeatures, f)
 			}
 		}
 	}
-	err, ok := <-ech
-	if ok {
-		return nil, err
-	}
 	return uniqueFeatures, nil
 }
 
-func (rdb FlipadelphiaRedisDBV2) GetFeatures() (Serializable, error) {
-	return rdb.getFeatures(rdb.pool.Get())
+func (rdb FlipadelphiaRedisDBV2) GetFeatures(ctx context.Context) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.getFeatures(ctx, conn)
 }
 
-func (rdb FlipadelphiaRedisDBV2) getScopeFeaturesFull(conn RedisConnection, scope []byte) (Serializable, error) {
+func (rdb FlipadelphiaRedisDBV2) getScopeFeaturesFull(conn RedisConnection, scope []byte) (FlipadelphiaFeatures, error) {
 	var features FlipadelphiaFeatures
-	res, err := redis.StringMap(conn.Do("HGETALL", string(scope)))
+	res, err := rdb.liveFields(conn, string(scope))
 	if err != nil {
 		return nil, err
 	}
+	if len(res) == 0 {
+		return nil, ErrScopeNotFound
+	}
 	for k, v := range res {
 		features = append(features, NewFlipadelphiaFeature([]byte(k), []byte(v)))
 	}
 	return features, nil
 }
 
-func (rdb FlipadelphiaRedisDBV2) GetScopeFeaturesFull(scope []byte) (Serializable, error) {
-	return rdb.getScopeFeaturesFull(rdb.pool.Get(), scope)
+func (rdb FlipadelphiaRedisDBV2) GetScopeFeaturesFull(ctx context.Context, scope []byte) (FlipadelphiaFeatures, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.getScopeFeaturesFull(conn, scope)
 }
 
 func (rdb FlipadelphiaRedisDBV2) Close() error {
 	return rdb.pool.Close()
 }
 
-func (rdb FlipadelphiaRedisDBV2) CheckScopeExists(scope []byte) bool {
-	return true
+func (rdb FlipadelphiaRedisDBV2) checkScopeExists(conn RedisConnection, scope []byte) (bool, error) {
+	exists, err := redis.Bool(conn.Do("EXISTS", string(scope)))
+	return exists, redisError(err)
+}
+
+func (rdb FlipadelphiaRedisDBV2) CheckScopeExists(ctx context.Context, scope []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.checkScopeExists(conn, scope)
+}
+
+func (rdb FlipadelphiaRedisDBV2) CheckFeatureExists(ctx context.Context, feature []byte) (bool, error) {
+	_, err := rdb.GetScopesWithFeature(ctx, feature)
+	if err == ErrFeatureNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (rdb FlipadelphiaRedisDBV2) CheckScopeHasFeature(ctx context.Context, scope, feature []byte) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.hasLiveField(conn, string(scope), string(feature))
+}
+
+func (rdb FlipadelphiaRedisDBV2) CheckFeatureHasScope(ctx context.Context, scope, feature []byte) (bool, error) {
+	return rdb.CheckScopeHasFeature(ctx, scope, feature)
+}
+
+// scanReply runs a SCAN family command and returns the next cursor and the batch of results.
+func (rdb FlipadelphiaRedisDBV2) scanReply(conn RedisConnection, cmd string, args ...interface{}) (uint64, []string, error) {
+	reply, err := redis.Values(conn.Do(cmd, args...))
+	if err != nil {
+		return 0, nil, redisError(err)
+	}
+	var rawCursor string
+	var results []string
+	if _, err := redis.Scan(reply, &rawCursor, &results); err != nil {
+		return 0, nil, err
+	}
+	cursor, err := strconv.ParseUint(rawCursor, 10, 64)
+	return cursor, results, err
+}
+
+func (rdb FlipadelphiaRedisDBV2) scanPage(ctx context.Context, conn RedisConnection, match, after string, limit int, filter func(string) (bool, error)) (Page, error) {
+	var page Page
+	cursor, err := decodeScanToken(after)
+	if err != nil {
+		return page, err
+	}
+	for {
+		if err := checkContext(ctx); err != nil {
+			return Page{}, err
+		}
+		var keys []string
+		cursor, keys, err = rdb.scanReply(conn, "SCAN", cursor, "MATCH", match, "COUNT", limit)
+		if err != nil {
+			return Page{}, err
+		}
+		for _, key := range withoutReservedKeys(keys) {
+			ok, err := filter(key)
+			if err != nil {
+				return Page{}, err
+			}
+			if ok {
+				page.Items = append(page.Items, key)
+			}
+		}
+		if cursor == 0 || len(page.Items) >= limit {
+			break
+		}
+	}
+	page.Next = encodeScanToken(cursor)
+	return page, nil
+}
+
+// GetScopesPage returns a page of scopes following the page token. Scopes are returned in SCAN order,
+// and a page may hold slightly more than limit scopes.
+func (rdb FlipadelphiaRedisDBV2) GetScopesPage(ctx context.Context, after string, limit int) (Page, error) {
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.scanPage(ctx, conn, "*", after, limit, func(string) (bool, error) {
+		return true, nil
+	})
+}
+
+// GetFeaturesPage returns a page of features in name order following the page token, paging through
+// the features index like SearchFeatures.
+func (rdb FlipadelphiaRedisDBV2) GetFeaturesPage(ctx context.Context, after string, limit int) (Page, error) {
+	return rdb.SearchFeatures(ctx, SearchQuery{}, after, limit)
+}
+
+// GetScopesWithFeaturePage returns a page of the scopes that have the feature set, following the page token.
+func (rdb FlipadelphiaRedisDBV2) GetScopesWithFeaturePage(ctx context.Context, feature []byte, after string, limit int) (Page, error) {
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.scanPage(ctx, conn, "*", after, limit, func(scope string) (bool, error) {
+		return rdb.hasLiveField(conn, scope, string(feature))
+	})
+}
+
+// GetScopeFeaturesFullPage returns a page of the features set on the scope with their values,
+// following the page token.
+func (rdb FlipadelphiaRedisDBV2) GetScopeFeaturesFullPage(ctx context.Context, scope []byte, after string, limit int) (FeaturePage, error) {
+	var page FeaturePage
+	cursor, err := decodeScanToken(after)
+	if err != nil {
+		return page, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	for {
+		if err := checkContext(ctx); err != nil {
+			return FeaturePage{}, err
+		}
+		var fields []string
+		cursor, fields, err = rdb.scanReply(conn, "HSCAN", string(scope), cursor, "COUNT", limit)
+		if err != nil {
+			return FeaturePage{}, err
+		}
+		live, err := rdb.liveScanFields(conn, string(scope), fields)
+		if err != nil {
+			return FeaturePage{}, err
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			if value, ok := live[fields[i]]; ok {
+				page.Items = append(page.Items, NewFlipadelphiaFeature([]byte(fields[i]), []byte(value)))
+			}
+		}
+		if cursor == 0 || len(page.Items) >= limit {
+			break
+		}
+	}
+	if after == "" && len(page.Items) == 0 {
+		return FeaturePage{}, ErrScopeNotFound
+	}
+	page.Next = encodeScanToken(cursor)
+	return page, nil
+}
+
+// liveScanFields returns the fields of an HSCAN batch that have not expired, with their values.
+func (rdb FlipadelphiaRedisDBV2) liveScanFields(conn RedisConnection, scope string, batch []string) (map[string]string, error) {
+	if len(batch) == 0 {
+		return nil, nil
+	}
+	fields := make([]string, 0, len(batch)/2)
+	for i := 0; i+1 < len(batch); i += 2 {
+		fields = append(fields, batch[i])
+	}
+	return rdb.liveFields(conn, scope, fields...)
+}
+
+func (rdb FlipadelphiaRedisDBV2) scopeHasValue(conn RedisConnection, scope, feature, value string) (bool, error) {
+	if feature != "" {
+		live, err := rdb.liveFields(conn, scope, feature)
+		v, ok := live[feature]
+		return ok && (value == "" || v == value), err
+	}
+	if value == "" {
+		return true, nil
+	}
+	res, err := rdb.liveFields(conn, scope)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range res {
+		if v == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SearchScopes returns a page of the scopes matching the query, following the page token. Name
+// patterns are passed to SCAN as a MATCH filter.
+func (rdb FlipadelphiaRedisDBV2) SearchScopes(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	if err := checkPageLimit(limit); err != nil {
+		return Page{}, err
+	}
+	m, err := q.nameMatcher()
+	if err != nil {
+		return Page{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.scanPage(ctx, conn, q.redisMatchPattern(m), after, limit, func(scope string) (bool, error) {
+		if !m.match([]byte(scope)) {
+			return false, nil
+		}
+		return rdb.scopeHasValue(conn, scope, q.Feature, q.Value)
+	})
+}
+
+// indexFeatures adds the features set before the features index was kept to it, unless that has been
+// done already.
+func (rdb FlipadelphiaRedisDBV2) indexFeatures(ctx context.Context, conn RedisConnection) error {
+	indexed, err := redis.Bool(conn.Do("EXISTS", redisFeaturesIndexedKey))
+	if err != nil || indexed {
+		return redisError(err)
+	}
+	var cursor uint64
+	for {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		var scopes []string
+		cursor, scopes, err = rdb.scanReply(conn, "SCAN", cursor, "COUNT", 100)
+		if err != nil {
+			return err
+		}
+		for _, scope := range withoutReservedKeys(scopes) {
+			if _, err := conn.Do("EVAL", redisIndexScopeScript, 1, scope); err != nil {
+				return redisError(err)
+			}
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	_, err = conn.Do("SET", redisFeaturesIndexedKey, 1)
+	return redisError(err)
+}
+
+// featureSetOn reports whether the feature is set, and has not expired, on a scope with the query's
+// scope prefix, to the query's value if it has one.
+func (rdb FlipadelphiaRedisDBV2) featureSetOn(ctx context.Context, conn RedisConnection, feature string, q SearchQuery) (bool, error) {
+	min, max := redisLexRange(q.ScopePrefix)
+	key := featureScopesKey([]byte(feature))
+	for {
+		if err := checkContext(ctx); err != nil {
+			return false, err
+		}
+		scopes, err := redis.Strings(conn.Do("ZRANGEBYLEX", key, min, max, "LIMIT", 0, 100))
+		if err != nil {
+			return false, redisError(err)
+		}
+		for _, scope := range scopes {
+			live, err := rdb.liveFields(conn, scope, feature)
+			if err != nil {
+				return false, err
+			}
+			if v, ok := live[feature]; ok && (q.Value == "" || v == q.Value) {
+				return true, nil
+			}
+		}
+		if len(scopes) < 100 {
+			return false, nil
+		}
+		min = "(" + scopes[len(scopes)-1]
+	}
+}
+
+// SearchFeatures returns a page of the features matching the query, following the page token. The
+// features index is paged through in name order with ZRANGEBYLEX, and each feature is kept if it is
+// set on a scope matching the query.
+func (rdb FlipadelphiaRedisDBV2) SearchFeatures(ctx context.Context, q SearchQuery, after string, limit int) (Page, error) {
+	if err := checkPageLimit(limit); err != nil {
+		return Page{}, err
+	}
+	m, err := q.nameMatcher()
+	if err != nil {
+		return Page{}, err
+	}
+	min, max, err := redisSearchRange(m.prefix, after)
+	if err != nil {
+		return Page{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	if err := rdb.indexFeatures(ctx, conn); err != nil {
+		return Page{}, err
+	}
+	var page Page
+	for {
+		if err := checkContext(ctx); err != nil {
+			return Page{}, err
+		}
+		features, err := redis.Strings(conn.Do("ZRANGEBYLEX", redisFeaturesKey, min, max, "LIMIT", 0, limit))
+		if err != nil {
+			return Page{}, redisError(err)
+		}
+		for _, feature := range features {
+			if len(page.Items) == limit {
+				page.Next = EncodePageToken([]byte(page.Items[limit-1]))
+				return page, nil
+			}
+			if !m.match([]byte(feature)) {
+				continue
+			}
+			ok, err := rdb.featureSetOn(ctx, conn, feature, q)
+			if err != nil {
+				return Page{}, err
+			}
+			if ok {
+				page.Items = append(page.Items, feature)
+			}
+		}
+		if len(features) < limit {
+			return page, nil
+		}
+		min = "(" + features[len(features)-1]
+	}
+}
+
+// GetFeatureSummary returns the counters kept for the feature by Set. FirstModified is the first time
+// the feature was set on any scope. Pairs last set before the counters were introduced are not
+// counted.
+func (rdb FlipadelphiaRedisDBV2) GetFeatureSummary(ctx context.Context, feature []byte) (FeatureSummary, error) {
+	if err := checkContext(ctx); err != nil {
+		return FeatureSummary{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	fields, err := redis.StringMap(conn.Do("HGETALL", featureSummaryKey(feature)))
+	if err != nil {
+		return FeatureSummary{}, redisError(err)
+	}
+	if len(fields) == 0 {
+		return FeatureSummary{}, ErrFeatureNotFound
+	}
+	return parseFeatureSummary(feature, fields), nil
+}
+
+// CopyScope copies the features of the source scope onto opts.Target with a single script, so the
+// copy is atomic.
+func (rdb FlipadelphiaRedisDBV2) CopyScope(ctx context.Context, source []byte, opts CopyScopeOptions) (FlipadelphiaFeatures, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	args := append([]interface{}{redisCopyScopeScript, 2, string(source), opts.Target}, redisCopyScopeArgs(opts)...)
+	copied, err := redis.Strings(conn.Do("EVAL", args...))
+	if err == redis.ErrNil {
+		return nil, ErrScopeNotFound
+	}
+	if err != nil {
+		return nil, redisError(err)
+	}
+	return parseCopiedFeatures(copied), nil
+}
+
+// GetScopeParents returns the parents declared for the scope, nearest first.
+func (rdb FlipadelphiaRedisDBV2) GetScopeParents(ctx context.Context, scope []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	parents, err := redis.Strings(conn.Do("LRANGE", scopeParentsKey(scope), 0, -1))
+	return parents, redisError(err)
+}
+
+// SetScopeParents replaces the parents declared for the scope. An empty list removes them. Parents
+// that would make the scope its own ancestor are rejected with ErrScopeCycle.
+func (rdb FlipadelphiaRedisDBV2) SetScopeParents(ctx context.Context, scope []byte, parents []string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	err := checkScopeCycle(string(scope), parents, func(s string) ([]string, error) {
+		return rdb.GetScopeParents(ctx, []byte(s))
+	})
+	if err != nil {
+		return err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	args := []interface{}{redisReplaceListScript, 1, scopeParentsKey(scope)}
+	for _, parent := range parents {
+		args = append(args, parent)
+	}
+	_, err = conn.Do("EVAL", args...)
+	return redisError(err)
 }
 
-func (rdb FlipadelphiaRedisDBV2) CheckFeatureExists(feature []byte) bool {
-	return true
+// GetPrerequisites returns the prerequisites declared for the feature.
+func (rdb FlipadelphiaRedisDBV2) GetPrerequisites(ctx context.Context, feature []byte) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	prerequisites, err := redis.Strings(conn.Do("LRANGE", prerequisitesKey(feature), 0, -1))
+	return prerequisites, redisError(err)
 }
 
-func (rdb FlipadelphiaRedisDBV2) CheckScopeHasFeature(scope, feature []byte) bool {
-	return true
+// SetPrerequisites replaces the prerequisites declared for the feature. An empty list removes them.
+// Prerequisites that would make the feature depend on itself are rejected with ErrPrerequisiteCycle.
+func (rdb FlipadelphiaRedisDBV2) SetPrerequisites(ctx context.Context, feature []byte, prerequisites []string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	err := checkPrerequisiteCycle(string(feature), prerequisites, func(f string) ([]string, error) {
+		return rdb.GetPrerequisites(ctx, []byte(f))
+	})
+	if err != nil {
+		return err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	args := []interface{}{redisReplaceListScript, 1, prerequisitesKey(feature)}
+	for _, prerequisite := range prerequisites {
+		args = append(args, prerequisite)
+	}
+	_, err = conn.Do("EVAL", args...)
+	return redisError(err)
 }
 
-func (rdb FlipadelphiaRedisDBV2) CheckFeatureHasScope(scope, feature []byte) bool {
-	return true
+// GetSegments returns every defined segment, sorted by name.
+func (rdb FlipadelphiaRedisDBV2) GetSegments(ctx context.Context) (Segments, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	definitions, err := redis.StringMap(conn.Do("HGETALL", redisSegmentsKey))
+	if err != nil {
+		return nil, redisError(err)
+	}
+	names := make([]string, 0, len(definitions))
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	segments := Segments{}
+	for _, name := range names {
+		features, err := redis.StringMap(conn.Do("HGETALL", segmentFeaturesKey([]byte(name))))
+		if err != nil {
+			return nil, redisError(err)
+		}
+		segment, err := parseSegment(name, definitions[name], features)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// GetSegment returns the named segment.
+func (rdb FlipadelphiaRedisDBV2) GetSegment(ctx context.Context, name []byte) (Segment, error) {
+	if err := checkContext(ctx); err != nil {
+		return Segment{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	definition, err := redis.String(conn.Do("HGET", redisSegmentsKey, string(name)))
+	if err == redis.ErrNil {
+		return Segment{}, ErrSegmentNotFound
+	}
+	if err != nil {
+		return Segment{}, redisError(err)
+	}
+	features, err := redis.StringMap(conn.Do("HGETALL", segmentFeaturesKey(name)))
+	if err != nil {
+		return Segment{}, redisError(err)
+	}
+	return parseSegment(string(name), definition, features)
+}
+
+// SetSegment creates the segment, or replaces the members and prefix of an existing one. Features
+// already set on the segment are kept.
+func (rdb FlipadelphiaRedisDBV2) SetSegment(ctx context.Context, segment Segment) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	definition, err := marshalSegmentDefinition(segment)
+	if err != nil {
+		return err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("HSET", redisSegmentsKey, segment.Name, definition)
+	return redisError(err)
+}
+
+// DeleteSegment removes the named segment and the features set on it.
+func (rdb FlipadelphiaRedisDBV2) DeleteSegment(ctx context.Context, name []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	reply, err := conn.Do("EVAL", redisDeleteEntryScript, 2, redisSegmentsKey, segmentFeaturesKey(name), string(name))
+	if err != nil {
+		return redisError(err)
+	}
+	if reply == nil {
+		return ErrSegmentNotFound
+	}
+	return nil
+}
+
+// SetSegmentFeature sets the value of the feature on the named segment.
+func (rdb FlipadelphiaRedisDBV2) SetSegmentFeature(ctx context.Context, name, feature, value []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	reply, err := conn.Do("EVAL", redisSetSegmentFeatureScript, 2, redisSegmentsKey, segmentFeaturesKey(name), string(name), string(feature), string(value))
+	if err != nil {
+		return FlipadelphiaFeature{}, redisError(err)
+	}
+	if reply == nil {
+		return FlipadelphiaFeature{}, ErrSegmentNotFound
+	}
+	return NewFlipadelphiaFeature(feature, value), nil
+}
+
+// GetSchedules returns the pending scheduled changes, soonest first.
+func (rdb FlipadelphiaRedisDBV2) GetSchedules(ctx context.Context) (ScheduledChanges, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	values, err := redis.StringMap(conn.Do("HGETALL", redisSchedulesKey))
+	if err != nil {
+		return nil, redisError(err)
+	}
+	return parseScheduledChanges(values)
+}
+
+// AddSchedule stores a scheduled change under a new id, and returns it with the id set.
+func (rdb FlipadelphiaRedisDBV2) AddSchedule(ctx context.Context, change ScheduledChange) (ScheduledChange, error) {
+	if err := checkContext(ctx); err != nil {
+		return ScheduledChange{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	change.ID = uuid.NewV4().String()
+	if _, err := conn.Do("HSET", redisSchedulesKey, change.ID, string(change.Serialize())); err != nil {
+		return ScheduledChange{}, redisError(err)
+	}
+	return change, nil
+}
+
+// DeleteSchedule removes a pending scheduled change.
+func (rdb FlipadelphiaRedisDBV2) DeleteSchedule(ctx context.Context, id []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	deleted, err := redis.Int(conn.Do("HDEL", redisSchedulesKey, string(id)))
+	if err != nil {
+		return redisError(err)
+	}
+	if deleted == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// GetOverrides returns every feature override, sorted by feature name.
+func (rdb FlipadelphiaRedisDBV2) GetOverrides(ctx context.Context) (FlipadelphiaFeatures, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	values, err := redis.StringMap(conn.Do("HGETALL", redisOverridesKey))
+	if err != nil {
+		return nil, redisError(err)
+	}
+	return parseOverrides(values), nil
+}
+
+// GetOverride returns the override of the feature.
+func (rdb FlipadelphiaRedisDBV2) GetOverride(ctx context.Context, feature []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	value, err := redis.String(conn.Do("HGET", redisOverridesKey, string(feature)))
+	if err == redis.ErrNil {
+		return FlipadelphiaFeature{}, ErrOverrideNotFound
+	}
+	if err != nil {
+		return FlipadelphiaFeature{}, redisError(err)
+	}
+	return NewFlipadelphiaFeature(feature, []byte(value)), nil
+}
+
+// SetOverride forces the feature to the value on every scope, until the override is deleted.
+func (rdb FlipadelphiaRedisDBV2) SetOverride(ctx context.Context, feature, value []byte) (FlipadelphiaFeature, error) {
+	if err := checkContext(ctx); err != nil {
+		return FlipadelphiaFeature{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("HSET", redisOverridesKey, string(feature), string(value))
+	return NewFlipadelphiaFeature(feature, value), redisError(err)
+}
+
+// DeleteOverride removes the feature's override, so its per-scope values apply again.
+func (rdb FlipadelphiaRedisDBV2) DeleteOverride(ctx context.Context, feature []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	deleted, err := redis.Int(conn.Do("HDEL", redisOverridesKey, string(feature)))
+	if err != nil {
+		return redisError(err)
+	}
+	if deleted == 0 {
+		return ErrOverrideNotFound
+	}
+	return nil
+}
+
+// GetUsage returns the checks recorded for the feature, which are none if it has not been checked.
+func (rdb FlipadelphiaRedisDBV2) GetUsage(ctx context.Context, feature []byte) (FeatureUsage, error) {
+	if err := checkContext(ctx); err != nil {
+		return FeatureUsage{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	checks, err := redis.String(conn.Do("HGET", redisUsageChecksKey, string(feature)))
+	if err != nil && err != redis.ErrNil {
+		return FeatureUsage{}, redisError(err)
+	}
+	checked, err := redis.String(conn.Do("HGET", redisUsageCheckedKey, string(feature)))
+	if err != nil && err != redis.ErrNil {
+		return FeatureUsage{}, redisError(err)
+	}
+	return parseUsage(string(feature), checks, checked), nil
+}
+
+// GetUsages returns the checks recorded for every feature that has been checked, sorted by feature
+// name.
+func (rdb FlipadelphiaRedisDBV2) GetUsages(ctx context.Context) (FeatureUsages, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	checks, err := redis.StringMap(conn.Do("HGETALL", redisUsageChecksKey))
+	if err != nil {
+		return nil, redisError(err)
+	}
+	checked, err := redis.StringMap(conn.Do("HGETALL", redisUsageCheckedKey))
+	if err != nil {
+		return nil, redisError(err)
+	}
+	return parseUsages(checks, checked), nil
+}
+
+// AddUsage adds the checks to those recorded for each feature with a single script, keeping the latest
+// LastChecked.
+func (rdb FlipadelphiaRedisDBV2) AddUsage(ctx context.Context, usages FeatureUsages) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if len(usages) == 0 {
+		return nil
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	args := append([]interface{}{redisAddUsageScript, 2, redisUsageChecksKey, redisUsageCheckedKey}, redisAddUsageArgs(usages)...)
+	_, err := conn.Do("EVAL", args...)
+	return redisError(err)
+}
+
+// GetExperiment returns the experiment defined on the feature.
+func (rdb FlipadelphiaRedisDBV2) GetExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	if err := checkContext(ctx); err != nil {
+		return Experiment{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	return rdb.getExperiment(conn, feature)
+}
+
+func (rdb FlipadelphiaRedisDBV2) getExperiment(conn RedisConnection, feature []byte) (Experiment, error) {
+	value, err := redis.String(conn.Do("HGET", redisExperimentsKey, string(feature)))
+	if err == redis.ErrNil {
+		return Experiment{}, ErrExperimentNotFound
+	}
+	if err != nil {
+		return Experiment{}, redisError(err)
+	}
+	return parseExperiment(value)
+}
+
+// SetExperiment defines the experiment on its feature, replacing its variants if it is already
+// defined. Scopes keep the variants they were assigned, as long as the variants still exist.
+func (rdb FlipadelphiaRedisDBV2) SetExperiment(ctx context.Context, experiment Experiment) (Experiment, error) {
+	if err := checkContext(ctx); err != nil {
+		return Experiment{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	existing, err := rdb.getExperiment(conn, []byte(experiment.Feature))
+	switch err {
+	case nil:
+		experiment.Salt = existing.Salt
+	case ErrExperimentNotFound:
+		experiment.Salt = uuid.NewV4().String()
+	default:
+		return Experiment{}, err
+	}
+	_, err = conn.Do("HSET", redisExperimentsKey, experiment.Feature, string(experiment.Serialize()))
+	return experiment, redisError(err)
+}
+
+// DeleteExperiment removes the experiment defined on the feature, and its assignments.
+func (rdb FlipadelphiaRedisDBV2) DeleteExperiment(ctx context.Context, feature []byte) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	reply, err := conn.Do("EVAL", redisDeleteEntryScript, 2, redisExperimentsKey, assignmentsKey(feature), string(feature))
+	if err != nil {
+		return redisError(err)
+	}
+	if reply == nil {
+		return ErrExperimentNotFound
+	}
+	return nil
+}
+
+// ReshuffleExperiment gives the experiment on the feature a new salt and drops its assignments, so
+// every scope is assigned afresh under the current weights.
+func (rdb FlipadelphiaRedisDBV2) ReshuffleExperiment(ctx context.Context, feature []byte) (Experiment, error) {
+	if err := checkContext(ctx); err != nil {
+		return Experiment{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	experiment, err := rdb.getExperiment(conn, feature)
+	if err != nil {
+		return Experiment{}, err
+	}
+	experiment.Salt = uuid.NewV4().String()
+	reply, err := conn.Do("EVAL", redisReshuffleScript, 2, redisExperimentsKey, assignmentsKey(feature),
+		string(feature), string(experiment.Serialize()))
+	if err != nil {
+		return Experiment{}, redisError(err)
+	}
+	if reply == nil {
+		return Experiment{}, ErrExperimentNotFound
+	}
+	return experiment, nil
+}
+
+// AssignVariant returns the variant of the feature's experiment the scope is assigned. A scope is
+// assigned by hash the first time it is checked, and keeps that variant until the experiment is
+// reshuffled or the variant is removed.
+func (rdb FlipadelphiaRedisDBV2) AssignVariant(ctx context.Context, feature, scope []byte) (Assignment, error) {
+	if err := checkContext(ctx); err != nil {
+		return Assignment{}, err
+	}
+	conn := rdb.pool.Get()
+	defer conn.Close()
+	experiment, err := rdb.getExperiment(conn, feature)
+	if err != nil {
+		return Assignment{}, err
+	}
+	assigned, err := redis.String(conn.Do("HGET", assignmentsKey(feature), string(scope)))
+	if err != nil && err != redis.ErrNil {
+		return Assignment{}, redisError(err)
+	}
+	assignment, isNew := experiment.assign(string(scope), assigned)
+	if !isNew {
+		return assignment, nil
+	}
+	if _, err := conn.Do("HSET", assignmentsKey(feature), string(scope), assignment.Variant); err != nil {
+		return Assignment{}, redisError(err)
+	}
+	return assignment, nil
 }
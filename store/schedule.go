@@ -0,0 +1,56 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// ErrScheduleNotFound is returned when the requested scheduled change does not exist, or has already
+// been applied.
+var ErrScheduleNotFound = errors.New("scheduled change not found")
+
+// ScheduledChange is a pending Set of the feature's value on the scope, due at RunAt.
+type ScheduledChange struct {
+	ID      string    `json:"id"`
+	Scope   string    `json:"scope"`
+	Feature string    `json:"feature"`
+	Value   string    `json:"value"`
+	RunAt   time.Time `json:"run_at"`
+}
+
+// ScheduledChanges is a type alias for []ScheduledChange.
+type ScheduledChanges []ScheduledChange
+
+// Serialize returns the ScheduledChange as json.
+func (change ScheduledChange) Serialize() []byte {
+	serializedChange, err := json.Marshal(change)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize scheduled change")
+		return []byte("")
+	}
+	return serializedChange
+}
+
+// Serialize returns the ScheduledChanges as json.
+func (changes ScheduledChanges) Serialize() []byte {
+	serializedChanges, err := json.Marshal(changes)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize scheduled changes")
+		return []byte("")
+	}
+	return serializedChanges
+}
+
+// sortScheduledChanges orders changes by when they are due, then by id.
+func sortScheduledChanges(changes ScheduledChanges) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].RunAt.Equal(changes[j].RunAt) {
+			return changes[i].ID < changes[j].ID
+		}
+		return changes[i].RunAt.Before(changes[j].RunAt)
+	})
+}
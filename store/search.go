@@ -0,0 +1,119 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidSearch is returned when a search pattern can not be compiled.
+var ErrInvalidSearch = errors.New("invalid search pattern")
+
+// SearchQuery filters feature or scope names, and the values set on them. Every non empty field must
+// match.
+type SearchQuery struct {
+	// Glob matches names against a shell pattern, e.g. "checkout-*".
+	Glob string
+	// Regexp matches names against a regular expression.
+	Regexp string
+	// Contains matches names containing the substring.
+	Contains string
+	// Prefix matches names starting with the prefix.
+	Prefix string
+	// Value only matches names with at least one scope/feature pair set to the value.
+	Value string
+	// ScopePrefix only matches features set on a scope with the prefix. Ignored when searching scopes.
+	ScopePrefix string
+	// Feature only matches scopes with the feature set. Ignored when searching features.
+	Feature string
+}
+
+// nameMatcher is a compiled SearchQuery name filter. Every matching name starts with prefix, so
+// ordered stores can seek straight to it.
+type nameMatcher struct {
+	prefix []byte
+	match  func([]byte) bool
+}
+
+// globLiteralPrefix returns the part of a glob pattern before its first special character.
+func globLiteralPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+func (q SearchQuery) nameMatcher() (nameMatcher, error) {
+	var matchers []func([]byte) bool
+	var prefix string
+	if q.Glob != "" {
+		if _, err := path.Match(q.Glob, ""); err != nil {
+			return nameMatcher{}, ErrInvalidSearch
+		}
+		glob := q.Glob
+		matchers = append(matchers, func(name []byte) bool {
+			ok, _ := path.Match(glob, string(name))
+			return ok
+		})
+		prefix = globLiteralPrefix(glob)
+	}
+	if q.Regexp != "" {
+		re, err := regexp.Compile(q.Regexp)
+		if err != nil {
+			return nameMatcher{}, ErrInvalidSearch
+		}
+		matchers = append(matchers, re.Match)
+		if p, _ := re.LiteralPrefix(); strings.HasPrefix(q.Regexp, "^") && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	if q.Prefix != "" {
+		namePrefix := []byte(q.Prefix)
+		matchers = append(matchers, func(name []byte) bool {
+			return bytes.HasPrefix(name, namePrefix)
+		})
+		if len(q.Prefix) > len(prefix) {
+			prefix = q.Prefix
+		}
+	}
+	if q.Contains != "" {
+		contains := []byte(q.Contains)
+		matchers = append(matchers, func(name []byte) bool {
+			return bytes.Contains(name, contains)
+		})
+	}
+	return nameMatcher{
+		prefix: []byte(prefix),
+		match: func(name []byte) bool {
+			for _, m := range matchers {
+				if !m(name) {
+					return false
+				}
+			}
+			return true
+		},
+	}, nil
+}
+
+var redisGlobEscaper = strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`)
+
+// redisPrefixPattern returns a SCAN MATCH pattern selecting names that start with prefix.
+func redisPrefixPattern(prefix string) string {
+	return redisGlobEscaper.Replace(prefix) + "*"
+}
+
+// redisMatchPattern returns a SCAN MATCH pattern selecting a superset of the names the query matches.
+// Results still need to be checked with the nameMatcher.
+func (q SearchQuery) redisMatchPattern(m nameMatcher) string {
+	switch {
+	case q.Glob != "":
+		return q.Glob
+	case len(m.prefix) > 0:
+		return redisPrefixPattern(string(m.prefix))
+	case q.Contains != "":
+		return "*" + redisGlobEscaper.Replace(q.Contains) + "*"
+	}
+	return "*"
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// ErrSegmentNotFound is returned when the requested segment has not been defined.
+var ErrSegmentNotFound = errors.New("segment not found")
+
+// Segment is a named group of scopes, listed explicitly in Members and/or matched by Prefix. Features
+// set on a segment apply to every member scope that does not set the feature itself.
+type Segment struct {
+	Name     string            `json:"name"`
+	Members  []string          `json:"members"`
+	Prefix   string            `json:"prefix"`
+	Features map[string]string `json:"features"`
+}
+
+// Segments is a type alias for []Segment.
+type Segments []Segment
+
+// NewSegment returns a Segment with its nil fields initialized, so it serializes as empty lists and
+// objects.
+func NewSegment(name string, members []string, prefix string) Segment {
+	if members == nil {
+		members = []string{}
+	}
+	return Segment{
+		Name:     name,
+		Members:  members,
+		Prefix:   prefix,
+		Features: make(map[string]string),
+	}
+}
+
+// Contains reports whether the scope is a member of the segment.
+func (segment Segment) Contains(scope string) bool {
+	if segment.Prefix != "" && strings.HasPrefix(scope, segment.Prefix) {
+		return true
+	}
+	for _, member := range segment.Members {
+		if member == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Serialize returns the Segment as json.
+func (segment Segment) Serialize() []byte {
+	serializedSegment, err := json.Marshal(segment)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize segment")
+		return []byte("")
+	}
+	return serializedSegment
+}
+
+// Serialize returns the Segments as json.
+func (segments Segments) Serialize() []byte {
+	serializedSegments, err := json.Marshal(segments)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize segments")
+		return []byte("")
+	}
+	return serializedSegments
+}
+
+// segmentDefinition is the part of a Segment stored separately from its features.
+type segmentDefinition struct {
+	Members []string `json:"members"`
+	Prefix  string   `json:"prefix"`
+}
+
+// resolveFromSegments returns the value of the feature on the first segment, in name order, that
+// contains the scope and sets the feature.
+func resolveFromSegments(segments Segments, scope string, feature []byte) (ResolvedFeature, bool) {
+	for _, segment := range segments {
+		if !segment.Contains(scope) {
+			continue
+		}
+		if value, ok := segment.Features[string(feature)]; ok {
+			return ResolvedFeature{
+				FlipadelphiaFeature: NewFlipadelphiaFeature(feature, []byte(value)),
+				Source:              scope,
+				Segment:             segment.Name,
+			}, true
+		}
+	}
+	return ResolvedFeature{}, false
+}
@@ -3,8 +3,8 @@ package store
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/samdfonseca/flipadelphia/config"
 	"github.com/samdfonseca/flipadelphia/utils"
 )
@@ -45,10 +45,24 @@ type FlipadelphiaFeature struct {
 type FlipadelphiaFeatures []FlipadelphiaFeature
 
 // FlipadelphiaSetFeatureOptions is a helper struct to store the values needed to set a feature.
+//
+// TTL, in seconds, and ExpiresAt are optional and mutually exclusive. A value set with either is
+// treated as unset once it expires.
 type FlipadelphiaSetFeatureOptions struct {
-	Key   string
-	Scope string `json:"scope"`
-	Value string `json:"value"`
+	Key       string
+	Scope     string     `json:"scope"`
+	Value     string     `json:"value"`
+	TTL       int        `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CopyScopeOptions controls how CopyScope copies a scope's features onto a target scope.
+type CopyScopeOptions struct {
+	Target string `json:"target"`
+	// Overwrite replaces features already set on the target. Otherwise they keep their values.
+	Overwrite bool `json:"overwrite"`
+	// Features limits the copy to the named features. All features are copied when empty.
+	Features []string `json:"features"`
 }
 
 // FlipadelphiaScopeFeatures is a type alias for []string.
@@ -61,33 +75,14 @@ type StringSlice []string
 
 var validFeatureKeyCharacters = []byte(`abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890-`)
 
-func NewPersistenceStore(c config.FlipadelphiaConfig) PersistenceStore {
-	// var ps PersistenceStore
-	switch c.PersistenceStoreType {
-	case "bolt":
-		db, err := bolt.Open(c.DBFile, 0600, nil)
-		utils.FailOnError(err, "Unable to open db file", true)
-		ps := NewFlipadelphiaBoltDB(db)
-		utils.Output(fmt.Sprintf("Using BoltDB persistence store: %s", c.DBFile))
-		return ps
-	case "redis":
-		err := fmt.Errorf("Unable to connect to Redis")
-		if c.RedisHost == "" {
-			utils.FailOnError(err, "redis_host not set", true)
-		}
-		ps := NewFlipadelphiaRedisDB(c.RedisHost, c.RedisPassword, c.RedisDB)
-		utils.Output(fmt.Sprintf("Using Redis persistence store: %s", c.RedisHost))
-		return ps
-	case "redisv2":
-		err := fmt.Errorf("Unable to connect to Redis")
-		if c.RedisHost == "" {
-			utils.FailOnError(err, "redis_host not set", true)
-		}
-		ps := NewFlipadelphiaRedisDBV2(c.RedisHost, c.RedisPassword, c.RedisDB)
-		utils.Output(fmt.Sprintf("Using RedisV2 persistence store: %s", c.RedisHost))
-		return ps
+// NewPersistenceStore opens the persistence store named by the config's persistence_store_type and
+// wraps it in the PersistenceStore interface.
+func NewPersistenceStore(c config.FlipadelphiaConfig) (PersistenceStore, error) {
+	ps, err := NewPersistenceStoreV2(c)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return NewLegacyPersistenceStore(ps), nil
 }
 
 // NewFlipadelphiaFeature returns a new instance of FlipadelphiaFeature.
@@ -103,7 +98,7 @@ func NewFlipadelphiaFeature(key []byte, value []byte) FlipadelphiaFeature {
 func (ss StringSlice) Serialize() []byte {
 	serializedStringSlice, err := json.Marshal(ss)
 	if err != nil {
-		utils.LogOnError(err, "Unable to serialize string slice", true)
+		utils.Log.WithError(err).Error("Unable to serialize string slice")
 		return []byte("")
 	}
 	return serializedStringSlice
@@ -113,7 +108,7 @@ func (ss StringSlice) Serialize() []byte {
 func (feature FlipadelphiaFeature) Serialize() []byte {
 	serializedFeature, err := json.Marshal(feature)
 	if err != nil {
-		utils.LogOnError(err, "Unable to serialize feature", true)
+		utils.Log.WithError(err).Error("Unable to serialize feature")
 		return []byte("")
 	}
 	return serializedFeature
@@ -126,7 +121,7 @@ func (features FlipadelphiaScopeFeatures) Serialize() []byte {
 	}
 	serializedFeatures, err := json.Marshal(features)
 	if err != nil {
-		utils.LogOnError(err, "Unable to serialize features", true)
+		utils.Log.WithError(err).Error("Unable to serialize features")
 		return []byte("")
 	}
 	return serializedFeatures
@@ -139,7 +134,7 @@ func (scopes FlipadelphiaScopeList) Serialize() []byte {
 	}
 	serializedScopes, err := json.Marshal(scopes)
 	if err != nil {
-		utils.LogOnError(err, "Unable to serialize scopes", true)
+		utils.Log.WithError(err).Error("Unable to serialize scopes")
 		return []byte("")
 	}
 	return serializedScopes
@@ -152,7 +147,7 @@ func (ffs FlipadelphiaFeatures) Serialize() []byte {
 	}
 	serializedFeatures, err := json.Marshal(ffs)
 	if err != nil {
-		utils.LogOnError(err, "Unable to serialize features", true)
+		utils.Log.WithError(err).Error("Unable to serialize features")
 		return []byte("")
 	}
 	return serializedFeatures
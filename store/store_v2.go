@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/samdfonseca/flipadelphia/config"
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+var (
+	// ErrScopeNotFound is returned when the requested scope has no features set.
+	ErrScopeNotFound = errors.New("scope not found")
+	// ErrFeatureNotFound is returned when the requested feature is not set on any scope, or not set
+	// on the requested scope.
+	ErrFeatureNotFound = errors.New("feature not found")
+	// ErrStoreUnavailable is returned when the backing store can not be reached.
+	ErrStoreUnavailable = errors.New("persistence store unavailable")
+	// ErrUnimplemented is returned by stores that do not support an operation.
+	ErrUnimplemented = errors.New("unimplemented method")
+)
+
+// PersistenceStoreV2 is the context aware successor to PersistenceStore. Methods return concrete types
+// and report missing scopes and features with ErrScopeNotFound and ErrFeatureNotFound.
+//
+// Get returns a feature's override, when one is set, for every scope. Ping returns an error if the
+// backing store can not be reached.
+//
+// The *Page methods take a page token, empty for the first page, and a page size. They return the
+// token for the next page with each page. Search* methods page the same way, and may return an empty
+// final page.
+type PersistenceStoreV2 interface {
+	Get(context.Context, []byte, []byte) (FlipadelphiaFeature, error)
+	GetScopeFeatures(context.Context, []byte) ([]string, error)
+	GetScopeFeaturesFilterByValue(context.Context, []byte, []byte) ([]string, error)
+	Set(context.Context, []byte, []byte, []byte) (FlipadelphiaFeature, error)
+	SetWithExpiry(context.Context, []byte, []byte, []byte, time.Time) (FlipadelphiaFeature, error)
+	GetScopes(context.Context) ([]string, error)
+	GetScopesWithPrefix(context.Context, []byte) ([]string, error)
+	GetScopesWithFeature(context.Context, []byte) ([]string, error)
+	GetScopesPaginated(context.Context, int, int) ([]string, error)
+	GetFeaturesPaginated(context.Context, int, int) ([]string, error)
+	GetFeatures(context.Context) ([]string, error)
+	GetScopeFeaturesFull(context.Context, []byte) (FlipadelphiaFeatures, error)
+	GetScopesPage(context.Context, string, int) (Page, error)
+	GetFeaturesPage(context.Context, string, int) (Page, error)
+	GetScopesWithFeaturePage(context.Context, []byte, string, int) (Page, error)
+	GetScopeFeaturesFullPage(context.Context, []byte, string, int) (FeaturePage, error)
+	SearchFeatures(context.Context, SearchQuery, string, int) (Page, error)
+	SearchScopes(context.Context, SearchQuery, string, int) (Page, error)
+	GetFeatureSummary(context.Context, []byte) (FeatureSummary, error)
+	CopyScope(context.Context, []byte, CopyScopeOptions) (FlipadelphiaFeatures, error)
+	GetScopeParents(context.Context, []byte) ([]string, error)
+	SetScopeParents(context.Context, []byte, []string) error
+	GetPrerequisites(context.Context, []byte) ([]string, error)
+	SetPrerequisites(context.Context, []byte, []string) error
+	GetSegments(context.Context) (Segments, error)
+	GetSegment(context.Context, []byte) (Segment, error)
+	SetSegment(context.Context, Segment) error
+	DeleteSegment(context.Context, []byte) error
+	SetSegmentFeature(context.Context, []byte, []byte, []byte) (FlipadelphiaFeature, error)
+	GetSchedules(context.Context) (ScheduledChanges, error)
+	AddSchedule(context.Context, ScheduledChange) (ScheduledChange, error)
+	DeleteSchedule(context.Context, []byte) error
+	GetExperiment(context.Context, []byte) (Experiment, error)
+	SetExperiment(context.Context, Experiment) (Experiment, error)
+	DeleteExperiment(context.Context, []byte) error
+	ReshuffleExperiment(context.Context, []byte) (Experiment, error)
+	AssignVariant(context.Context, []byte, []byte) (Assignment, error)
+	GetOverrides(context.Context) (FlipadelphiaFeatures, error)
+	GetOverride(context.Context, []byte) (FlipadelphiaFeature, error)
+	SetOverride(context.Context, []byte, []byte) (FlipadelphiaFeature, error)
+	DeleteOverride(context.Context, []byte) error
+	GetUsage(context.Context, []byte) (FeatureUsage, error)
+	GetUsages(context.Context) (FeatureUsages, error)
+	AddUsage(context.Context, FeatureUsages) error
+	CheckScopeExists(context.Context, []byte) (bool, error)
+	CheckFeatureExists(context.Context, []byte) (bool, error)
+	CheckScopeHasFeature(context.Context, []byte, []byte) (bool, error)
+	CheckFeatureHasScope(context.Context, []byte, []byte) (bool, error)
+	Ping(context.Context) error
+	Close() error
+}
+
+// ExpirySweeper is implemented by stores that have to tidy up after expired values: those that keep
+// them until they are removed, and those whose counters are not updated when values expire natively.
+type ExpirySweeper interface {
+	// DeleteExpired removes every expired value, and returns how many were removed.
+	DeleteExpired(context.Context) (int, error)
+}
+
+// ConnectionPoolStats counts the connections in a store's connection pool, by whether they are in use.
+type ConnectionPoolStats struct {
+	Active int
+	Idle   int
+}
+
+// ConnectionPooler is implemented by stores that keep a pool of connections to their backend.
+type ConnectionPooler interface {
+	PoolStats() ConnectionPoolStats
+}
+
+// IsNotFound reports whether the error is one returned for a missing scope, feature, segment,
+// scheduled change, override or experiment, rather than a failure of the store.
+func IsNotFound(err error) bool {
+	switch err {
+	case ErrScopeNotFound, ErrFeatureNotFound, ErrSegmentNotFound, ErrScheduleNotFound, ErrOverrideNotFound,
+		ErrExperimentNotFound:
+		return true
+	}
+	return false
+}
+
+// NewPersistenceStoreV2 opens the persistence store named by the config's persistence_store_type.
+func NewPersistenceStoreV2(c config.FlipadelphiaConfig) (PersistenceStoreV2, error) {
+	switch c.PersistenceStoreType {
+	case "bolt":
+		if c.DBFile == "" {
+			return nil, fmt.Errorf("db_file not set")
+		}
+		db, err := bolt.Open(c.DBFile, 0600, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open db file %q: %s", c.DBFile, err)
+		}
+		ps := NewFlipadelphiaBoltDB(db)
+		utils.Log.WithField("db_file", c.DBFile).Info("Using BoltDB persistence store")
+		return ps, nil
+	case "redis":
+		if c.RedisHost == "" {
+			return nil, fmt.Errorf("redis_host not set")
+		}
+		ps := NewFlipadelphiaRedisDB(c.RedisHost, c.RedisPassword, c.RedisDB)
+		utils.Log.WithField("redis_host", c.RedisHost).Info("Using Redis persistence store")
+		return ps, nil
+	case "redisv2":
+		if c.RedisHost == "" {
+			return nil, fmt.Errorf("redis_host not set")
+		}
+		ps := NewFlipadelphiaRedisDBV2(c.RedisHost, c.RedisPassword, c.RedisDB)
+		utils.Log.WithField("redis_host", c.RedisHost).Info("Using RedisV2 persistence store")
+		return ps, nil
+	case "":
+		return nil, fmt.Errorf("persistence_store_type not set")
+	}
+	return nil, fmt.Errorf("Unknown persistence_store_type: %q", c.PersistenceStoreType)
+}
+
+// checkContext returns the context's error if it is already cancelled or past its deadline.
+func checkContext(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// FeatureSummary counts the scopes a feature is set on, by value. FirstModified and LastModified are
+// the earliest and latest times any of those scopes had the feature set.
+type FeatureSummary struct {
+	Name          string         `json:"name"`
+	Scopes        int            `json:"scopes"`
+	Values        map[string]int `json:"values"`
+	FirstModified *time.Time     `json:"first_modified"`
+	LastModified  *time.Time     `json:"last_modified"`
+}
+
+// NewFeatureSummary returns an empty FeatureSummary for the feature.
+func NewFeatureSummary(feature []byte) FeatureSummary {
+	return FeatureSummary{
+		Name:   string(feature),
+		Values: make(map[string]int),
+	}
+}
+
+// add counts one scope with the value, last set at the modified time.
+func (summary *FeatureSummary) add(value string, modified *time.Time) {
+	summary.Scopes++
+	summary.Values[value]++
+	if modified == nil {
+		return
+	}
+	if summary.FirstModified == nil || modified.Before(*summary.FirstModified) {
+		summary.FirstModified = modified
+	}
+	if summary.LastModified == nil || modified.After(*summary.LastModified) {
+		summary.LastModified = modified
+	}
+}
+
+// Serialize returns the FeatureSummary as json.
+func (summary FeatureSummary) Serialize() []byte {
+	serializedSummary, err := json.Marshal(summary)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize feature summary")
+		return []byte("")
+	}
+	return serializedSummary
+}
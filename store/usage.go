@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// FeatureUsage is how many times a feature has been checked, and when it was last checked and last set
+// on a scope.
+type FeatureUsage struct {
+	Name         string     `json:"name"`
+	Checks       int64      `json:"checks"`
+	LastChecked  *time.Time `json:"last_checked"`
+	LastModified *time.Time `json:"last_modified"`
+}
+
+// Serialize returns the FeatureUsage as json.
+func (usage FeatureUsage) Serialize() []byte {
+	serializedUsage, err := json.Marshal(usage)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize feature usage")
+		return []byte("")
+	}
+	return serializedUsage
+}
+
+// add counts the checks in other, and keeps the later of the two LastChecked times.
+func (usage *FeatureUsage) add(other FeatureUsage) {
+	usage.Checks += other.Checks
+	if other.LastChecked != nil && (usage.LastChecked == nil || other.LastChecked.After(*usage.LastChecked)) {
+		usage.LastChecked = other.LastChecked
+	}
+}
+
+// lastUsed returns the later of LastChecked and LastModified, or nil if the feature has been neither
+// checked nor set.
+func (usage FeatureUsage) lastUsed() *time.Time {
+	if usage.LastModified == nil || (usage.LastChecked != nil && usage.LastChecked.After(*usage.LastModified)) {
+		return usage.LastChecked
+	}
+	return usage.LastModified
+}
+
+// FeatureUsages is a list of FeatureUsage.
+type FeatureUsages []FeatureUsage
+
+// Serialize returns the FeatureUsages as json.
+func (usages FeatureUsages) Serialize() []byte {
+	serializedUsages, err := json.Marshal(usages)
+	if err != nil {
+		utils.Log.WithError(err).Error("Unable to serialize feature usages")
+		return []byte("")
+	}
+	return serializedUsages
+}
+
+// sortUsages sorts the usages by feature name.
+func sortUsages(usages FeatureUsages) {
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Name < usages[j].Name })
+}
+
+// GetFeatureUsage returns the recorded checks of the feature, with the last time it was set on any
+// scope. It returns ErrFeatureNotFound for a feature that is neither set nor has been checked.
+func GetFeatureUsage(ctx context.Context, db PersistenceStoreV2, feature []byte) (FeatureUsage, error) {
+	usage, err := db.GetUsage(ctx, feature)
+	if err != nil {
+		return usage, err
+	}
+	summary, err := db.GetFeatureSummary(ctx, feature)
+	if err == ErrFeatureNotFound && usage.LastChecked != nil {
+		return usage, nil
+	}
+	if err != nil {
+		return usage, err
+	}
+	usage.LastModified = summary.LastModified
+	return usage, nil
+}
+
+// GetStaleFeatures returns the usage of every feature set on a scope that has been neither checked nor
+// set since the given time, sorted by feature name.
+func GetStaleFeatures(ctx context.Context, db PersistenceStoreV2, since time.Time) (FeatureUsages, error) {
+	features, err := db.GetFeatures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	usages, err := db.GetUsages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	checked := make(map[string]FeatureUsage, len(usages))
+	for _, usage := range usages {
+		checked[usage.Name] = usage
+	}
+	stale := FeatureUsages{}
+	for _, feature := range features {
+		usage, ok := checked[feature]
+		if !ok {
+			usage = FeatureUsage{Name: feature}
+		}
+		if usage.LastChecked != nil && usage.LastChecked.After(since) {
+			continue
+		}
+		summary, err := db.GetFeatureSummary(ctx, []byte(feature))
+		if err != nil && err != ErrFeatureNotFound {
+			return nil, err
+		}
+		usage.LastModified = summary.LastModified
+		if lastUsed := usage.lastUsed(); lastUsed == nil || lastUsed.Before(since) {
+			stale = append(stale, usage)
+		}
+	}
+	sortUsages(stale)
+	return stale, nil
+}
@@ -0,0 +1,123 @@
+// Package usage counts feature checks in memory and periodically adds them to the usage kept in the
+// persistence store, so stale features can be found.
+package usage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/impressions"
+	"github.com/samdfonseca/flipadelphia/store"
+	"github.com/samdfonseca/flipadelphia/utils"
+)
+
+// Defaults used when the tracker is not configured.
+const (
+	DefaultFlushInterval = 60 * time.Second
+	DefaultSampleRate    = 1
+)
+
+// count is the checks of a feature since the last flush.
+type count struct {
+	checks      int64
+	lastChecked time.Time
+}
+
+// Tracker counts the features of the impressions it is given, and adds the counts to the store every
+// interval.
+//
+// With a sample rate of N, only every Nth check is counted, as N checks. The first check of a feature
+// after each flush is always counted, so a rarely checked feature is never missed and its last checked
+// time is accurate to within the flush interval.
+type Tracker struct {
+	db         store.PersistenceStoreV2
+	sampleRate uint64
+	interval   time.Duration
+	seen       uint64
+	mu         sync.RWMutex
+	pending    map[string]*count
+}
+
+// NewTracker returns a Tracker counting one in sampleRate checks and adding them to the store every
+// interval. A sample rate or interval of zero or less uses the defaults.
+func NewTracker(db store.PersistenceStoreV2, sampleRate int, interval time.Duration) *Tracker {
+	if sampleRate <= 0 {
+		sampleRate = DefaultSampleRate
+	}
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	return &Tracker{
+		db:         db,
+		sampleRate: uint64(sampleRate),
+		interval:   interval,
+		pending:    make(map[string]*count),
+	}
+}
+
+// Record counts a check of the impression's feature.
+func (t *Tracker) Record(impression impressions.Impression) {
+	sampled := atomic.AddUint64(&t.seen, 1)%t.sampleRate == 0
+	if !sampled {
+		t.mu.RLock()
+		_, pending := t.pending[impression.Feature]
+		t.mu.RUnlock()
+		if pending {
+			return
+		}
+	}
+	weight := int64(1)
+	if sampled {
+		weight = int64(t.sampleRate)
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.pending[impression.Feature]
+	if !ok {
+		c = &count{}
+		t.pending[impression.Feature] = c
+	}
+	c.checks += weight
+	c.lastChecked = now
+}
+
+// Run adds the counted checks to the store every interval until the context is done, then adds
+// whatever is left.
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.Flush(ctx)
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), t.interval)
+			t.Flush(flushCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+// Flush adds the checks counted since the last flush to the store. Checks the store fails to add are
+// dropped, to keep memory bounded.
+func (t *Tracker) Flush(ctx context.Context) {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[string]*count, len(pending))
+	t.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	usages := make(store.FeatureUsages, 0, len(pending))
+	for feature, c := range pending {
+		lastChecked := c.lastChecked.UTC()
+		usages = append(usages, store.FeatureUsage{Name: feature, Checks: c.checks, LastChecked: &lastChecked})
+	}
+	if err := t.db.AddUsage(ctx, usages); err != nil {
+		utils.Log.WithError(err).WithField("features", len(usages)).Error("Unable to record usage")
+	}
+}
@@ -0,0 +1,66 @@
+package usage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samdfonseca/flipadelphia/impressions"
+	"github.com/samdfonseca/flipadelphia/store"
+)
+
+func recordingStore(added map[string]store.FeatureUsage) store.MockPersistenceStoreV2 {
+	return store.MockPersistenceStoreV2{
+		OnAddUsage: func(ctx context.Context, usages store.FeatureUsages) error {
+			for _, usage := range usages {
+				total := added[usage.Name]
+				total.Checks += usage.Checks
+				total.LastChecked = usage.LastChecked
+				added[usage.Name] = total
+			}
+			return nil
+		},
+	}
+}
+
+func TestTracker_Flush(t *testing.T) {
+	added := make(map[string]store.FeatureUsage)
+	tracker := NewTracker(recordingStore(added), 1, time.Hour)
+	for i := 0; i < 3; i++ {
+		tracker.Record(impressions.Impression{Feature: "feature1", Scope: "user-1"})
+	}
+	tracker.Record(impressions.Impression{Feature: "feature2", Scope: "user-1"})
+	tracker.Flush(context.Background())
+
+	if added["feature1"].Checks != 3 || added["feature2"].Checks != 1 {
+		t.Errorf("Unexpected checks: %v", added)
+	}
+	if added["feature1"].LastChecked == nil {
+		t.Error("Expected a last checked time")
+	}
+
+	// Nothing is added when nothing was checked since the last flush.
+	delete(added, "feature1")
+	tracker.Flush(context.Background())
+	if _, ok := added["feature1"]; ok {
+		t.Error("Expected no checks to be added")
+	}
+}
+
+func TestTracker_Sampled(t *testing.T) {
+	added := make(map[string]store.FeatureUsage)
+	tracker := NewTracker(recordingStore(added), 10, time.Hour)
+	for i := 0; i < 100; i++ {
+		tracker.Record(impressions.Impression{Feature: "frequent", Scope: "user-1"})
+	}
+	tracker.Record(impressions.Impression{Feature: "rare", Scope: "user-1"})
+	tracker.Flush(context.Background())
+
+	// The first check of each feature is always counted, and every tenth check counts as ten.
+	if checks := added["frequent"].Checks; checks < 100 || checks > 101 {
+		t.Errorf("Expected about 100 checks, got %d", checks)
+	}
+	if added["rare"].Checks != 1 {
+		t.Errorf("Expected the rare feature to be counted, got %d", added["rare"].Checks)
+	}
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Log is the application logger. Until ConfigureLogging is called it writes text to stderr at info
+// level.
+var Log = logrus.New()
+
+func init() {
+	Log.Out = os.Stderr
+}
+
+// LogOptions configures the application logger. Format is "json" or "text", and Level one of debug,
+// info, warning or error. When File is set, the log is written there instead of stderr, and rotated
+// once it reaches MaxSize megabytes, keeping MaxBackups old files for up to MaxAge days. Zeros keep
+// lumberjack's defaults: 100 megabytes, and every old file forever.
+type LogOptions struct {
+	File       string
+	Format     string
+	Level      string
+	MaxSize    int
+	MaxBackups int
+	MaxAge     int
+}
+
+// Validate checks the format and level are known.
+func (opts LogOptions) Validate() error {
+	_, err := opts.formatter()
+	if err == nil {
+		_, err = opts.level()
+	}
+	return err
+}
+
+func (opts LogOptions) formatter() (logrus.Formatter, error) {
+	switch opts.Format {
+	case "", "text":
+		return &logrus.TextFormatter{FullTimestamp: true}, nil
+	case "json":
+		return &logrus.JSONFormatter{}, nil
+	}
+	return nil, fmt.Errorf("Unknown log format: %q", opts.Format)
+}
+
+func (opts LogOptions) level() (logrus.Level, error) {
+	if opts.Level == "" {
+		return logrus.InfoLevel, nil
+	}
+	level, err := logrus.ParseLevel(opts.Level)
+	if err != nil {
+		return level, fmt.Errorf("Unknown log level: %q", opts.Level)
+	}
+	return level, nil
+}
+
+// logFile is the rotated log file Log writes to, if any, closed when ConfigureLogging replaces it.
+var logFile *lumberjack.Logger
+
+// ConfigureLogging applies the options to Log. It can be called again while logging, to apply new
+// options.
+func ConfigureLogging(opts LogOptions) error {
+	formatter, err := opts.formatter()
+	if err != nil {
+		return err
+	}
+	level, err := opts.level()
+	if err != nil {
+		return err
+	}
+	var out io.Writer = os.Stderr
+	var file *lumberjack.Logger
+	if opts.File != "" {
+		file = &lumberjack.Logger{
+			Filename:   opts.File,
+			MaxSize:    opts.MaxSize,
+			MaxBackups: opts.MaxBackups,
+			MaxAge:     opts.MaxAge,
+		}
+		out = file
+	}
+	Log.SetFormatter(formatter)
+	Log.SetLevel(level)
+	Log.SetOutput(out)
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = file
+	return nil
+}